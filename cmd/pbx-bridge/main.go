@@ -0,0 +1,46 @@
+package main
+
+import (
+	ulog "log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nyaruka/ezconf"
+	"github.com/nyaruka/mailroom/services/ivr/pbx/bridge"
+)
+
+func main() {
+	config := bridge.NewDefaultConfig()
+	loader := ezconf.NewLoader(
+		config,
+		"pbx_bridge", "PBX Bridge - connects mailroom PBX channels to Asterisk via ARI",
+		[]string{"pbx-bridge.toml"},
+	)
+	loader.MustLoad()
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		ulog.Fatalf("invalid log level %s", config.LogLevel)
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+
+	if config.Secret == "" || config.Username == "" || config.Password == "" {
+		slog.Error("username, password and secret must all be set")
+		os.Exit(1)
+	}
+
+	b := bridge.NewBridge(config)
+	if err := b.Start(); err != nil {
+		slog.Error("unable to start bridge", "error", err)
+		os.Exit(1)
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	slog.Info("stopping", "signal", <-ch)
+
+	b.Stop()
+}
@@ -15,6 +15,9 @@ func TestContactFieldChanged(t *testing.T) {
 	ctx, rt := testsuite.Runtime()
 
 	defer testsuite.Reset(testsuite.ResetAll)
+	defer func() { rt.Config.MaxFieldHistory = 0 }()
+
+	rt.Config.MaxFieldHistory = 20
 
 	gender := assets.NewFieldReference("gender", "Gender")
 	age := assets.NewFieldReference("age", "Age")
@@ -86,6 +89,30 @@ func TestContactFieldChanged(t *testing.T) {
 					Args:  []any{testdata.Alexandria.ID},
 					Count: 1,
 				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where contact_id = $1 AND field_id = $2 AND new_value = '{"text":"Female"}'::jsonb`,
+					Args:  []any{testdata.Cathy.ID, testdata.GenderField.ID},
+					Count: 1,
+				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where contact_id = $1 AND field_id = $2 AND new_value = '{"text":"Old"}'::jsonb`,
+					Args:  []any{testdata.Bob.ID, testdata.AgeField.ID},
+					Count: 1,
+				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where contact_id = $1 AND field_id = $2 AND old_value = '{"text":"34"}'::jsonb AND new_value IS NULL`,
+					Args:  []any{testdata.Alexandria.ID, testdata.AgeField.ID},
+					Count: 1,
+				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where contact_id = $1 AND field_id = $2 AND old_value = '{"text":"female"}'::jsonb AND new_value IS NULL`,
+					Args:  []any{testdata.Alexandria.ID, testdata.GenderField.ID},
+					Count: 1,
+				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where flow_id IS NULL`,
+					Count: 0,
+				},
 			},
 		},
 	}
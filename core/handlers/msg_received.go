@@ -8,6 +8,7 @@ import (
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/events"
 	"github.com/nyaruka/mailroom/core/hooks"
+	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/runtime"
 )
@@ -16,7 +17,8 @@ func init() {
 	models.RegisterEventHandler(events.TypeMsgReceived, handleMsgReceived)
 }
 
-// handleMsgReceived takes care of creating the incoming message for surveyor flows, it is a noop for all other flows
+// handleMsgReceived takes care of creating the incoming message for surveyor flows and queuing transcription of
+// IVR recordings
 func handleMsgReceived(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa *models.OrgAssets, scene *models.Scene, e flows.Event) error {
 	event := e.(*events.MsgReceivedEvent)
 
@@ -30,6 +32,13 @@ func handleMsgReceived(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa
 		scene.AppendToEventPreCommitHook(hooks.CommitMessagesHook, msg)
 	}
 
+	// for voice sessions, recordings without text can be transcribed once the call has moved on
+	if scene.Session() != nil && scene.Session().SessionType() == models.FlowTypeVoice && ivr.TranscriptionEnabled() {
+		if event.Msg.Text() == "" && len(event.Msg.Attachments()) > 0 {
+			scene.AppendToEventPostCommitHook(hooks.QueueTranscriptionsHook, event)
+		}
+	}
+
 	// update the contact's last seen date
 	scene.AppendToEventPreCommitHook(hooks.ContactLastSeenHook, event)
 	scene.AppendToEventPreCommitHook(hooks.UpdateCampaignEventsHook, event)
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/events"
@@ -21,9 +22,26 @@ type commitFieldChangesHook struct{}
 
 // Apply squashes and writes all the field updates for the contacts
 func (h *commitFieldChangesHook) Apply(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa *models.OrgAssets, scenes map[*models.Scene][]any) error {
+	// if we're keeping field history, we need the values we're about to overwrite
+	var oldValues map[models.ContactID]map[assets.FieldUUID]json.RawMessage
+	if rt.Config.MaxFieldHistory > 0 {
+		contactIDs := make([]models.ContactID, 0, len(scenes))
+		for scene := range scenes {
+			contactIDs = append(contactIDs, scene.ContactID())
+		}
+
+		var err error
+		oldValues, err = models.LoadContactFieldValues(ctx, tx, contactIDs)
+		if err != nil {
+			return errors.Wrap(err, "error loading current field values")
+		}
+	}
+
 	// our list of updates
 	fieldUpdates := make([]any, 0, len(scenes))
 	fieldDeletes := make(map[assets.FieldUUID][]any)
+	fieldChanges := make([]*models.ContactFieldChange, 0, len(scenes))
+
 	for scene, es := range scenes {
 		updates := make(map[assets.FieldUUID]*flows.Value, len(es))
 		for _, e := range es {
@@ -41,6 +59,10 @@ func (h *commitFieldChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 			updates[field.UUID()] = event.Value
 		}
 
+		if oldValues != nil {
+			fieldChanges = append(fieldChanges, sceneFieldChanges(oa, scene, updates, oldValues[scene.ContactID()])...)
+		}
+
 		// trim out deletes, adding to our list of global deletes
 		for k, v := range updates {
 			if v == nil || v.Text.Native() == "" {
@@ -82,9 +104,48 @@ func (h *commitFieldChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 		}
 	}
 
+	// and finally record the history of what changed
+	if err := models.InsertContactFieldHistory(ctx, tx, fieldChanges, rt.Config.MaxFieldHistory); err != nil {
+		return errors.Wrapf(err, "error recording contact field history")
+	}
+
 	return nil
 }
 
+// builds the field history changes for a scene from its squashed updates and the contact's previous values
+func sceneFieldChanges(oa *models.OrgAssets, scene *models.Scene, updates map[assets.FieldUUID]*flows.Value, previous map[assets.FieldUUID]json.RawMessage) []*models.ContactFieldChange {
+	flowID := models.NilFlowID
+	if scene.Session() != nil {
+		flowID = scene.Session().CurrentFlowID()
+	}
+
+	changes := make([]*models.ContactFieldChange, 0, len(updates))
+	for fieldUUID, value := range updates {
+		var newValue json.RawMessage
+		if value != nil && value.Text.Native() != "" {
+			newValue = jsonx.MustMarshal(value)
+		}
+
+		oldValue := previous[fieldUUID]
+
+		// nothing to record if the field was and still is empty
+		if newValue == nil && oldValue == nil {
+			continue
+		}
+
+		changes = append(changes, &models.ContactFieldChange{
+			OrgID:     oa.OrgID(),
+			ContactID: scene.ContactID(),
+			FieldID:   oa.FieldByUUID(fieldUUID).ID(),
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			FlowID:    flowID,
+			UserID:    scene.UserID(),
+		})
+	}
+	return changes
+}
+
 type FieldDelete struct {
 	ContactID models.ContactID `db:"contact_id"`
 	FieldUUID assets.FieldUUID `db:"field_uuid"`
@@ -21,9 +21,10 @@ func (h *commitGroupChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 	adds := make([]*models.GroupAdd, 0, len(scenes))
 	removes := make([]*models.GroupRemove, 0, len(scenes))
 	changed := make(map[models.ContactID]bool, len(scenes))
+	changes := make([]*models.GroupChange, 0, len(scenes))
 
 	// we remove from our groups at once, build up our list
-	for _, events := range scenes {
+	for scene, events := range scenes {
 		// we use these sets to track what our final add or remove should be
 		seenAdds := make(map[models.GroupID]*models.GroupAdd)
 		seenRemoves := make(map[models.GroupID]*models.GroupRemove)
@@ -42,11 +43,13 @@ func (h *commitGroupChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 		for _, add := range seenAdds {
 			adds = append(adds, add)
 			changed[add.ContactID] = true
+			changes = append(changes, &models.GroupChange{ContactID: add.ContactID, GroupID: add.GroupID, Direction: models.GroupChangeAdded, Cause: scene.GroupChangeCause()})
 		}
 
 		for _, remove := range seenRemoves {
 			removes = append(removes, remove)
 			changed[remove.ContactID] = true
+			changes = append(changes, &models.GroupChange{ContactID: remove.ContactID, GroupID: remove.GroupID, Direction: models.GroupChangeRemoved, Cause: scene.GroupChangeCause()})
 		}
 	}
 
@@ -61,5 +64,11 @@ func (h *commitGroupChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 		return errors.Wrapf(err, "error removing contacts from groups")
 	}
 
+	// and publish them to any subscribers
+	err = models.InsertGroupChangeEvents(ctx, tx, oa, changes)
+	if err != nil {
+		return errors.Wrapf(err, "error publishing group changes")
+	}
+
 	return nil
 }
@@ -0,0 +1,46 @@
+package hooks
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/core/tasks/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// QueueTranscriptionsHook is our hook for queuing transcription of IVR recordings
+var QueueTranscriptionsHook models.EventCommitHook = &queueTranscriptionsHook{}
+
+type queueTranscriptionsHook struct{}
+
+// Apply queues a transcription task for each recording
+func (h *queueTranscriptionsHook) Apply(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa *models.OrgAssets, scenes map[*models.Scene][]any) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	for scene, es := range scenes {
+		for _, e := range es {
+			event := e.(*events.MsgReceivedEvent)
+
+			for _, a := range event.Msg.Attachments() {
+				task := &ivr.TranscribeRecordingTask{
+					ContactID:  scene.ContactID(),
+					MsgUUID:    event.Msg.UUID(),
+					Attachment: a,
+					Language:   scene.Contact().Language(),
+				}
+
+				if err := tasks.Queue(rc, queue.BatchQueue, oa.OrgID(), task, queue.DefaultPriority); err != nil {
+					return errors.Wrapf(err, "error queuing transcription task")
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package ivr
+
+import (
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/flows/routers/waits/hints"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// CommandType is the type of a call command
+type CommandType string
+
+// call command types
+const (
+	CommandTypeSay    CommandType = "say"
+	CommandTypePlay   CommandType = "play"
+	CommandTypeGather CommandType = "gather"
+	CommandTypeRecord CommandType = "record"
+	CommandTypeDial   CommandType = "dial"
+	CommandTypeHangup CommandType = "hangup"
+)
+
+// Command is a provider neutral instruction for a call which IVR services convert into their own responses
+type Command struct {
+	Type CommandType
+
+	// say
+	Text   string
+	Locale i18n.Locale
+
+	// play
+	URL string
+
+	// gather
+	NumDigits   int
+	FinishOnKey string
+
+	// dial
+	URN       urns.URN
+	DialLimit int
+	CallLimit int
+}
+
+// CommandsForSprint converts the events of a voice sprint into call commands, ending with a hangup if the flow isn't
+// waiting for anything from the caller. Text is given the locale of its message or the default locale of the org.
+func CommandsForSprint(cfg *runtime.Config, env envs.Environment, es []flows.Event) ([]*Command, error) {
+	commands := make([]*Command, 0)
+	hasWait := false
+
+	for _, e := range es {
+		switch event := e.(type) {
+		case *events.IVRCreatedEvent:
+			if len(event.Msg.Attachments()) == 0 {
+				locale := event.Msg.Locale()
+				if locale == "" {
+					locale = env.DefaultLocale()
+				}
+				commands = append(commands, &Command{Type: CommandTypeSay, Text: event.Msg.Text(), Locale: locale})
+			} else {
+				for _, a := range event.Msg.Attachments() {
+					a = models.NormalizeAttachment(cfg, a)
+					commands = append(commands, &Command{Type: CommandTypePlay, URL: a.URL()})
+				}
+			}
+
+		case *events.MsgWaitEvent:
+			hasWait = true
+			switch hint := event.Hint.(type) {
+			case *hints.DigitsHint:
+				gather := &Command{Type: CommandTypeGather, FinishOnKey: hint.TerminatedBy}
+				if hint.Count != nil {
+					gather.NumDigits = *hint.Count
+				}
+				commands = append(commands, gather)
+
+			case *hints.AudioHint:
+				commands = append(commands, &Command{Type: CommandTypeRecord})
+
+			default:
+				return nil, errors.Errorf("unable to use hint in IVR call, unknown type: %s", event.Hint.Type())
+			}
+
+		case *events.DialWaitEvent:
+			hasWait = true
+			commands = append(commands, &Command{Type: CommandTypeDial, URN: event.URN, DialLimit: event.DialLimitSeconds, CallLimit: event.CallLimitSeconds})
+		}
+	}
+
+	if !hasWait {
+		// no wait? call is over, hang up
+		commands = append(commands, &Command{Type: CommandTypeHangup})
+	}
+
+	return commands, nil
+}
@@ -0,0 +1,141 @@
+package ivr
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// Channels with max_concurrent_events set have an adaptive call budget which starts at that maximum, is halved
+// whenever the provider tells us it's at capacity, and grows back by one with each answered call. Growth is paused
+// while the answer rate is low, as unanswered calls still tie up lines while they ring.
+const (
+	concurrencyKey = "ivr_concurrency:%s"
+
+	// state is forgotten if a channel isn't used for this long, i.e. the budget resets to the maximum
+	concurrencyTTL = 60 * 60 * 24
+
+	// answered/unanswered counts are halved when they reach this so that the answer rate favors recent calls
+	answerRateWindow = 200
+
+	// the minimum number of calls before we consider the answer rate, and the rate below which we don't grow
+	answerRateMinSample = 20
+	answerRateMinGrowth = 20 // percent
+)
+
+var recordOutcomeScript = redis.NewScript(1, `-- KEYS: [StateKey], ARGV: [Maximum, Answered, Window, MinSample, MinRate, TTL]
+local key, maximum, answered = KEYS[1], tonumber(ARGV[1]), ARGV[2] == "1"
+local window, minSample, minRate, ttl = tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6])
+
+local numAnswered = tonumber(redis.call("HGET", key, "answered") or 0)
+local numUnanswered = tonumber(redis.call("HGET", key, "unanswered") or 0)
+if answered then numAnswered = numAnswered + 1 else numUnanswered = numUnanswered + 1 end
+
+local total = numAnswered + numUnanswered
+if total >= window then
+	numAnswered, numUnanswered = math.floor(numAnswered / 2), math.floor(numUnanswered / 2)
+	total = numAnswered + numUnanswered
+end
+
+local limit = math.min(tonumber(redis.call("HGET", key, "limit") or maximum), maximum)
+if answered and (total < minSample or (numAnswered * 100 / total) >= minRate) then
+	limit = math.min(limit + 1, maximum)
+end
+
+redis.call("HSET", key, "limit", limit, "answered", numAnswered, "unanswered", numUnanswered)
+redis.call("EXPIRE", key, ttl)
+return limit
+`)
+
+var recordCapacityErrorScript = redis.NewScript(1, `-- KEYS: [StateKey], ARGV: [Maximum, TTL]
+local key, maximum, ttl = KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2])
+
+local limit = math.min(tonumber(redis.call("HGET", key, "limit") or maximum), maximum)
+limit = math.max(math.floor(limit / 2), 1)
+
+redis.call("HSET", key, "limit", limit)
+redis.call("EXPIRE", key, ttl)
+return limit
+`)
+
+// maximum number of concurrent calls configured on the channel, 0 meaning no limit
+func maxConcurrentCalls(channel *models.Channel) int {
+	max, _ := strconv.Atoi(channel.ConfigValue(models.ChannelConfigMaxConcurrentEvents, ""))
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+// CallLimit returns the current budget of concurrent calls for the given channel, 0 meaning no limit
+func CallLimit(rt *runtime.Runtime, channel *models.Channel) (int, error) {
+	max := maxConcurrentCalls(channel)
+	if max == 0 {
+		return 0, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	limit, err := redis.Int(rc.Do("HGET", fmt.Sprintf(concurrencyKey, channel.UUID()), "limit"))
+	if err == redis.ErrNil {
+		return max, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "error reading call limit")
+	}
+
+	return min(limit, max), nil
+}
+
+// records whether a call was answered, growing the budget of the channel if it was
+func recordCallOutcome(rt *runtime.Runtime, channel *models.Channel, answered bool) error {
+	max := maxConcurrentCalls(channel)
+	if max == 0 {
+		return nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	_, err := recordOutcomeScript.Do(rc, fmt.Sprintf(concurrencyKey, channel.UUID()), max, answered, answerRateWindow, answerRateMinSample, answerRateMinGrowth, concurrencyTTL)
+	return errors.Wrap(err, "error recording call outcome")
+}
+
+// records a call which errored. Calls which were busy, not answered or answered by a machine count as unanswered but
+// other provider errors tell us nothing about whether calls are being answered, or whether the provider is at capacity,
+// as that is only known from the response to a call request.
+func recordCallError(rt *runtime.Runtime, channel *models.Channel, reason models.CallError) {
+	if reason != models.CallErrorBusy && reason != models.CallErrorNoAnswer && reason != models.CallErrorMachine {
+		return
+	}
+
+	if err := recordCallOutcome(rt, channel, false); err != nil {
+		slog.Error("error recording call outcome", "error", err, "channel_id", channel.ID())
+	}
+}
+
+// records that the provider is at capacity, shrinking the budget of the channel
+func recordCapacityError(rt *runtime.Runtime, channel *models.Channel) error {
+	max := maxConcurrentCalls(channel)
+	if max == 0 {
+		return nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	_, err := recordCapacityErrorScript.Do(rc, fmt.Sprintf(concurrencyKey, channel.UUID()), max, concurrencyTTL)
+	return errors.Wrap(err, "error recording capacity error")
+}
+
+// whether the given response status from a provider means it's at capacity and we should try again later
+func isCapacityError(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"net/url"
 	"path"
-	"strconv"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -138,8 +137,9 @@ func HangupCall(ctx context.Context, rt *runtime.Runtime, call *models.Call) (*m
 	return clog, err
 }
 
-// RequestCall creates a new ChannelSession for the passed in flow start and contact, returning the created session
-func RequestCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, start *models.FlowStartBatch, contact *models.Contact) (*models.Call, error) {
+// RequestCall creates a new ChannelSession for the passed in flow start and contact, returning the created session. The
+// given retry policy is that of the flow start, if it has one.
+func RequestCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, start *models.FlowStartBatch, startPolicy *models.CallRetryPolicy, contact *models.Contact) (*models.Call, error) {
 	// find a tel URL for the contact
 	telURN := urns.NilURN
 	for _, u := range contact.URNs() {
@@ -194,6 +194,16 @@ func RequestCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets,
 		return nil, errors.Wrapf(err, "error creating call")
 	}
 
+	// the first attempt of a call is subject to the calling window of its retry policy just like retries are, so if
+	// we're outside of it, defer the call until it opens and let the retry cron request it
+	if start.StartID != models.NilStartID {
+		now := dates.Now()
+		policy := models.ResolveCallRetryPolicy(startPolicy, channel, nil)
+		if next := policy.NextInWindow(now, policy.Timezone(oa.Env())); next.After(now) {
+			return conn, errors.Wrap(conn.MarkDeferred(ctx, rt.DB, next), "error deferring call")
+		}
+	}
+
 	clog, err := RequestStartForCall(ctx, rt, channel, telURN, conn)
 
 	// log any error inserting our channel log, but continue
@@ -210,27 +220,27 @@ func RequestStartForCall(ctx context.Context, rt *runtime.Runtime, channel *mode
 	// the domain that will be used for callbacks, can be specific for channels due to white labeling
 	domain := channel.ConfigValue(models.ChannelConfigCallbackDomain, rt.Config.Domain)
 
-	// get max concurrent events if any
-	maxCalls := channel.ConfigValue(models.ChannelConfigMaxConcurrentEvents, "")
-	if maxCalls != "" {
-		maxCalls, _ := strconv.Atoi(maxCalls)
+	// get our current budget of concurrent calls if any
+	maxCalls, err := CallLimit(rt, channel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding call limit")
+	}
 
-		// max calls is set, lets see how many are currently active on this channel
-		if maxCalls > 0 {
-			count, err := models.ActiveCallCount(ctx, rt.DB, channel.ID())
-			if err != nil {
-				return nil, errors.Wrapf(err, "error finding number of active calls")
-			}
+	// max calls is set, lets see how many are currently active on this channel
+	if maxCalls > 0 {
+		count, err := models.ActiveCallCount(ctx, rt.DB, channel.ID())
+		if err != nil {
+			return nil, errors.Wrapf(err, "error finding number of active calls")
+		}
 
-			// we are at max calls, do not move on
-			if count >= maxCalls {
-				slog.Info("call being queued, max concurrent reached", "channel_id", channel.ID())
-				err := call.MarkThrottled(ctx, rt.DB, time.Now())
-				if err != nil {
-					return nil, errors.Wrapf(err, "error marking call as throttled")
-				}
-				return nil, nil
+		// we are at max calls, do not move on
+		if count >= maxCalls {
+			slog.Info("call being queued, max concurrent reached", "channel_id", channel.ID(), "max_calls", maxCalls)
+			err := call.MarkThrottled(ctx, rt.DB, time.Now())
+			if err != nil {
+				return nil, errors.Wrapf(err, "error marking call as throttled")
 			}
+			return nil, nil
 		}
 	}
 
@@ -262,6 +272,17 @@ func RequestStartForCall(ctx context.Context, rt *runtime.Runtime, channel *mode
 	if err != nil {
 		clog.Error(err)
 
+		// provider is at capacity, shrink our budget and queue this call to be tried again
+		if trace != nil && trace.Response != nil && isCapacityError(trace.Response.StatusCode) {
+			if err := recordCapacityError(rt, channel); err != nil {
+				slog.Error("error recording capacity error", "error", err, "channel_id", channel.ID())
+			}
+			if err := call.MarkThrottled(ctx, rt.DB, time.Now()); err != nil {
+				return clog, errors.Wrapf(err, "error marking call as throttled")
+			}
+			return clog, nil
+		}
+
 		// set our status as errored
 		err := call.UpdateStatus(ctx, rt.DB, models.CallStatusFailed, 0, time.Now())
 		if err != nil {
@@ -313,7 +334,11 @@ func StartIVRFlow(
 
 	// check that call on service side is in the state we need to continue
 	if errorReason := svc.CheckStartRequest(r); errorReason != "" {
-		err := call.MarkErrored(ctx, rt.DB, dates.Now(), flow.IVRRetryWait(), errorReason)
+		recordCallError(rt, channel, errorReason)
+
+		retryPolicy := models.ResolveCallRetryPolicy(start.CallRetryPolicy, channel, flow)
+
+		err := call.MarkErrored(ctx, rt.DB, dates.Now(), retryPolicy, retryPolicy.Timezone(oa.Env()), errorReason)
 		if err != nil {
 			return errors.Wrap(err, "unable to mark call as errored")
 		}
@@ -371,6 +396,12 @@ func StartIVRFlow(
 		return errors.Wrapf(err, "error updating call status")
 	}
 
+	// the provider only asks us to start the flow once the call has been answered, so feed that back into the
+	// concurrency budget of the channel
+	if err := recordCallOutcome(rt, channel, true); err != nil {
+		slog.Error("error recording call outcome", "error", err, "channel_id", channel.ID())
+	}
+
 	// we set the call on the session before our event hooks fire so that IVR messages can be created with the right call reference
 	hook := func(ctx context.Context, tx *sqlx.Tx, rp *redis.Pool, oa *models.OrgAssets, sessions []*models.Session) error {
 		for _, session := range sessions {
@@ -617,7 +648,16 @@ func HandleIVRStatus(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAss
 			return errors.Wrapf(err, "unable to load flow: %d", start.FlowID)
 		}
 
-		call.MarkErrored(ctx, rt.DB, dates.Now(), flow.IVRRetryWait(), errorReason)
+		channel := oa.ChannelByID(call.ChannelID())
+
+		// feed back into the concurrency budget of the channel
+		if channel != nil {
+			recordCallError(rt, channel, errorReason)
+		}
+
+		retryPolicy := models.ResolveCallRetryPolicy(start.CallRetryPolicy, channel, flow)
+
+		call.MarkErrored(ctx, rt.DB, dates.Now(), retryPolicy, retryPolicy.Timezone(oa.Env()), errorReason)
 
 		if call.Status() == models.CallStatusErrored {
 			return svc.WriteEmptyResponse(w, fmt.Sprintf("status updated: %s, next_attempt: %s", call.Status(), call.NextAttempt()))
@@ -0,0 +1,54 @@
+package ivr
+
+import (
+	"context"
+
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// TranscriptionService transcribes audio recorded during IVR calls
+type TranscriptionService interface {
+	Transcribe(ctx context.Context, audio []byte, contentType string, lang i18n.Language) (string, error)
+}
+
+// TranscriptionServiceFactory returns the transcription service for an org, or nil if it doesn't have one
+type TranscriptionServiceFactory func(*models.OrgAssets) (TranscriptionService, error)
+
+var transcriptionFactory func(*runtime.Config) TranscriptionServiceFactory
+
+// RegisterTranscriptionServiceFactory can be used by outside callers to register a transcription factory
+// for use in IVR calls
+func RegisterTranscriptionServiceFactory(f func(*runtime.Config) TranscriptionServiceFactory) {
+	transcriptionFactory = f
+}
+
+// TranscriptionEnabled returns whether a transcription factory has been registered
+func TranscriptionEnabled() bool {
+	return transcriptionFactory != nil
+}
+
+// TranscribeRecording transcribes the given recording if a transcription service is available for the org,
+// returning the empty string if not
+func TranscribeRecording(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, audio []byte, contentType string, lang i18n.Language) (string, error) {
+	if transcriptionFactory == nil {
+		return "", nil
+	}
+
+	svc, err := transcriptionFactory(rt.Config)(oa)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating transcription service")
+	}
+	if svc == nil {
+		return "", nil
+	}
+
+	if lang == i18n.NilLanguage {
+		lang = oa.Env().DefaultLanguage()
+	}
+
+	transcript, err := svc.Transcribe(ctx, audio, contentType, lang)
+	return transcript, errors.Wrap(err, "error transcribing recording")
+}
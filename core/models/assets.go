@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/goflow/flows"
@@ -25,24 +26,25 @@ type Refresh int
 
 // refresh bit masks
 const (
-	RefreshNone        = Refresh(0)
-	RefreshAll         = Refresh(^0)
-	RefreshOrg         = Refresh(1 << 1)
-	RefreshCampaigns   = Refresh(1 << 2)
-	RefreshChannels    = Refresh(1 << 3)
-	RefreshClassifiers = Refresh(1 << 4)
-	RefreshFields      = Refresh(1 << 5)
-	RefreshFlows       = Refresh(1 << 6)
-	RefreshGlobals     = Refresh(1 << 7)
-	RefreshGroups      = Refresh(1 << 8)
-	RefreshLabels      = Refresh(1 << 9)
-	RefreshLocations   = Refresh(1 << 10)
-	RefreshOptIns      = Refresh(1 << 11)
-	RefreshResthooks   = Refresh(1 << 12)
-	RefreshTemplates   = Refresh(1 << 13)
-	RefreshTopics      = Refresh(1 << 14)
-	RefreshTriggers    = Refresh(1 << 15)
-	RefreshUsers       = Refresh(1 << 16)
+	RefreshNone            = Refresh(0)
+	RefreshAll             = Refresh(^0)
+	RefreshOrg             = Refresh(1 << 1)
+	RefreshCampaigns       = Refresh(1 << 2)
+	RefreshChannels        = Refresh(1 << 3)
+	RefreshClassifiers     = Refresh(1 << 4)
+	RefreshFields          = Refresh(1 << 5)
+	RefreshFlows           = Refresh(1 << 6)
+	RefreshGlobals         = Refresh(1 << 7)
+	RefreshGroups          = Refresh(1 << 8)
+	RefreshLabels          = Refresh(1 << 9)
+	RefreshLocations       = Refresh(1 << 10)
+	RefreshOptIns          = Refresh(1 << 11)
+	RefreshResthooks       = Refresh(1 << 12)
+	RefreshTemplates       = Refresh(1 << 13)
+	RefreshTopics          = Refresh(1 << 14)
+	RefreshTriggers        = Refresh(1 << 15)
+	RefreshUsers           = Refresh(1 << 16)
+	RefreshCannedResponses = Refresh(1 << 17)
 )
 
 // OrgAssets is our top level cache of all things contained in an org. It is used to build
@@ -81,6 +83,9 @@ type OrgAssets struct {
 	groupsByID   map[GroupID]*Group
 	groupsByUUID map[assets.GroupUUID]*Group
 
+	engineGroups     []assets.Group
+	engineGroupsDate dates.Date
+
 	labels       []assets.Label
 	labelsByUUID map[assets.LabelUUID]*Label
 
@@ -105,6 +110,9 @@ type OrgAssets struct {
 	users        []assets.User
 	usersByID    map[UserID]*User
 	usersByEmail map[string]*User
+
+	cannedResponses     []*CannedResponse
+	cannedResponsesByID map[CannedResponseID]*CannedResponse
 }
 
 var ErrNotFound = errors.New("not found")
@@ -275,6 +283,7 @@ func NewOrgAssets(ctx context.Context, rt *runtime.Runtime, orgID OrgID, prev *O
 		}
 	} else {
 		oa.optIns = prev.optIns
+		oa.optInsByID = prev.optInsByID
 		oa.optInsByUUID = prev.optInsByUUID
 	}
 
@@ -394,6 +403,30 @@ func NewOrgAssets(ctx context.Context, rt *runtime.Runtime, orgID OrgID, prev *O
 		oa.usersByEmail = prev.usersByEmail
 	}
 
+	if prev == nil || refresh&RefreshCannedResponses > 0 {
+		oa.cannedResponses, err = loadAssetType(ctx, db, orgID, "canned responses", loadCannedResponses)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading canned responses for org %d", orgID)
+		}
+		oa.cannedResponsesByID = make(map[CannedResponseID]*CannedResponse, len(oa.cannedResponses))
+		for _, r := range oa.cannedResponses {
+			oa.cannedResponsesByID[r.ID()] = r
+		}
+	} else {
+		oa.cannedResponses = prev.cannedResponses
+		oa.cannedResponsesByID = prev.cannedResponsesByID
+	}
+
+	// relative dates in group queries are resolved for the engine so need re-resolving when the org's date changes
+	today := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()))
+	if prev == nil || refresh&(RefreshOrg|RefreshGroups|RefreshFields) > 0 || prev.engineGroupsDate != today {
+		oa.engineGroups = resolveGroupQueries(oa, today)
+		oa.engineGroupsDate = today
+	} else {
+		oa.engineGroups = prev.engineGroups
+		oa.engineGroupsDate = prev.engineGroupsDate
+	}
+
 	// intialize our session assets
 	oa.sessionAssets, err = engine.NewSessionAssets(oa.Env(), oa, goflow.MigrationConfig(rt.Config))
 	if err != nil {
@@ -611,8 +644,9 @@ func (a *OrgAssets) CampaignEventByID(eventID CampaignEventID) *CampaignEvent {
 	return a.campaignEventsByID[eventID]
 }
 
+// Groups returns the groups as given to the engine, i.e. with any relative dates in queries resolved
 func (a *OrgAssets) Groups() ([]assets.Group, error) {
-	return a.groups, nil
+	return a.engineGroups, nil
 }
 
 func (a *OrgAssets) GroupByID(groupID GroupID) *Group {
@@ -651,6 +685,16 @@ func (a *OrgAssets) OptInByUUID(uuid assets.OptInUUID) *OptIn {
 	return a.optInsByUUID[uuid]
 }
 
+// OptInByName returns the optin with the passed in name (case insensitive)
+func (a *OrgAssets) OptInByName(name string) *OptIn {
+	for _, o := range a.optIns {
+		if strings.EqualFold(o.Name(), name) {
+			return o.(*OptIn)
+		}
+	}
+	return nil
+}
+
 func (a *OrgAssets) Resthooks() ([]assets.Resthook, error) {
 	return a.resthooks, nil
 }
@@ -700,6 +744,14 @@ func (a *OrgAssets) UserByEmail(email string) *User {
 	return a.usersByEmail[email]
 }
 
+func (a *OrgAssets) CannedResponses() []*CannedResponse {
+	return a.cannedResponses
+}
+
+func (a *OrgAssets) CannedResponseByID(id CannedResponseID) *CannedResponse {
+	return a.cannedResponsesByID[id]
+}
+
 func loadAssetType[A any](ctx context.Context, db *sql.DB, orgID OrgID, name string, f func(ctx context.Context, db *sql.DB, orgID OrgID) ([]A, error)) ([]A, error) {
 	start := time.Now()
 
@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"slices"
 	"time"
 
 	"github.com/lib/pq"
@@ -226,8 +227,21 @@ type BroadcastBatch struct {
 }
 
 func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets) ([]*Msg, error) {
+	contactIDs := b.ContactIDs
+
+	// if this broadcast is for an optin, skip contacts who have opted out of it
+	if b.OptInID != NilOptInID {
+		optedOut, err := GetOptedOutContactIDs(ctx, rt.DB, b.OptInID, contactIDs)
+		if err != nil {
+			return nil, errors.Wrap(err, "error loading opted out contacts for broadcast")
+		}
+		if len(optedOut) > 0 {
+			contactIDs = slices.DeleteFunc(slices.Clone(contactIDs), func(id ContactID) bool { return slices.Contains(optedOut, id) })
+		}
+	}
+
 	// load all our contacts
-	contacts, err := LoadContacts(ctx, rt.DB, oa, b.ContactIDs)
+	contacts, err := LoadContacts(ctx, rt.DB, oa, contactIDs)
 	if err != nil {
 		return nil, errors.Wrap(err, "error loading contacts for broadcast")
 	}
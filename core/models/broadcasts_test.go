@@ -69,6 +69,16 @@ func TestNonPersistentBroadcasts(t *testing.T) {
 	assert.Equal(t, 2, len(msgs))
 
 	assertdb.Query(t, rt.DB, `SELECT count(*) FROM msgs_msg WHERE direction = 'O' AND broadcast_id IS NULL AND text = 'Hi there'`).Returns(2)
+
+	// contacts who have opted out of the broadcast's optin are skipped
+	err = models.SetContactOptInStatus(ctx, rt.DB, testdata.Org1.ID, testdata.Bob.ID, optIn.ID, testdata.VonageChannel.ID, models.OptInStatusOptedOut, time.Now())
+	require.NoError(t, err)
+
+	msgs, err = batch.CreateMessages(ctx, rt, oa)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, testdata.Alexandria.ID, msgs[0].ContactID())
 }
 
 func TestBroadcastTranslations(t *testing.T) {
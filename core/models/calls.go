@@ -3,10 +3,16 @@ package models
 import (
 	"context"
 	"database/sql/driver"
+	"encoding/json"
+	"slices"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/null/v3"
 	"github.com/pkg/errors"
 )
@@ -56,6 +62,132 @@ const (
 	CallThrottleWait = time.Minute * 2
 )
 
+// CallRetryPolicy controls whether and when calls which have errored are retried
+type CallRetryPolicy struct {
+	MaxAttempts int               `json:"max_attempts,omitempty"` // including the first attempt
+	Wait        *int              `json:"wait,omitempty"`         // minutes to wait before retrying, negative means never retry
+	Waits       map[CallError]int `json:"waits,omitempty"`        // overrides of wait for specific error types
+	Window      *CallWindow       `json:"window,omitempty"`
+}
+
+// CallWindow is the time of day during which calls can be made, e.g. 09:00 to 20:00, in the given timezone or the
+// timezone of the org if none is given
+type CallWindow struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// ResolveCallRetryPolicy returns the retry policy to use for a call, which is the policy of its flow start if it has one,
+// otherwise the policy of its channel, with the retry wait of the flow used for any error types which don't specify one
+func ResolveCallRetryPolicy(start *CallRetryPolicy, channel *Channel, flow *Flow) *CallRetryPolicy {
+	var policy CallRetryPolicy
+	if start != nil {
+		policy = *start
+	} else if channel != nil && channel.CallRetryPolicy() != nil {
+		policy = *channel.CallRetryPolicy()
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = CallMaxRetries + 1
+	}
+	if policy.Wait == nil && flow != nil {
+		if wait := flow.IVRRetryWait(); wait != nil {
+			minutes := int(*wait / time.Minute)
+			policy.Wait = &minutes
+		} else {
+			never := -1
+			policy.Wait = &never
+		}
+	}
+
+	return &policy
+}
+
+// NextAttempt returns when a call which has errored for the given reason should next be attempted, or nil if it
+// shouldn't be retried
+func (p *CallRetryPolicy) NextAttempt(now time.Time, tz *time.Location, errorCount int, reason CallError) *time.Time {
+	if errorCount+1 >= p.MaxAttempts {
+		return nil
+	}
+
+	minutes, hasWait := p.Waits[reason]
+	if !hasWait {
+		if p.Wait == nil {
+			return nil
+		}
+		minutes = *p.Wait
+	}
+	if minutes < 0 {
+		return nil
+	}
+
+	next := p.NextInWindow(now.Add(time.Minute*time.Duration(minutes)), tz)
+	return &next
+}
+
+// Timezone returns the timezone of the calling window of this policy, which defaults to the timezone of the org
+func (p *CallRetryPolicy) Timezone(env envs.Environment) *time.Location {
+	if p.Window != nil && p.Window.Timezone != "" {
+		if tz, err := time.LoadLocation(p.Window.Timezone); err == nil {
+			return tz
+		}
+	}
+	return env.Timezone()
+}
+
+// NextInWindow returns the given time if it falls inside the calling window, otherwise the next start of the window
+func (p *CallRetryPolicy) NextInWindow(t time.Time, tz *time.Location) time.Time {
+	if p.Window == nil {
+		return t
+	}
+
+	start, err1 := dates.ParseTimeOfDay("tt:mm", p.Window.Start)
+	end, err2 := dates.ParseTimeOfDay("tt:mm", p.Window.End)
+	if err1 != nil || err2 != nil {
+		return t
+	}
+
+	local := t.In(tz)
+	tod := dates.ExtractTimeOfDay(local)
+	day := dates.ExtractDate(local)
+
+	if start.Compare(end) <= 0 {
+		// window within a single day, e.g. 09:00 to 20:00
+		if tod.Compare(start) >= 0 && tod.Compare(end) < 0 {
+			return t
+		}
+		if tod.Compare(start) < 0 {
+			return start.Combine(day, tz)
+		}
+		return start.Combine(dates.ExtractDate(local.AddDate(0, 0, 1)), tz)
+	}
+
+	// window which crosses midnight, e.g. 20:00 to 02:00
+	if tod.Compare(start) >= 0 || tod.Compare(end) < 0 {
+		return t
+	}
+	return start.Combine(day, tz)
+}
+
+func (p *CallRetryPolicy) Scan(value any) error {
+	if value == nil {
+		return nil
+	}
+	b, isBytes := value.([]byte)
+	if !isBytes {
+		return errors.New("failed type assertion to []byte")
+	}
+	return json.Unmarshal(b, p)
+}
+
+func (p *CallRetryPolicy) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
 // Call models an IVR call
 type Call struct {
 	c struct {
@@ -76,6 +208,9 @@ type Call struct {
 		ContactURNID URNID         `json:"contact_urn_id"  db:"contact_urn_id"`
 		OrgID        OrgID         `json:"org_id"          db:"org_id"`
 		StartID      StartID       `json:"start_id"        db:"start_id"`
+
+		// only loaded for calls being retried
+		RetryPolicy *CallRetryPolicy `json:"retry_policy"    db:"retry_policy"`
 	}
 }
 
@@ -91,6 +226,9 @@ func (c *Call) ErrorReason() CallError  { return CallError(c.c.ErrorReason) }
 func (c *Call) ErrorCount() int         { return c.c.ErrorCount }
 func (c *Call) NextAttempt() *time.Time { return c.c.NextAttempt }
 
+// RetryPolicy returns the retry policy of the flow start of this call, if it was loaded for retrying and has one
+func (c *Call) RetryPolicy() *CallRetryPolicy { return c.c.RetryPolicy }
+
 const sqlInsertCall = `
 INSERT INTO ivr_call
 (
@@ -204,6 +342,55 @@ func GetCallByID(ctx context.Context, db DBorTx, orgID OrgID, id CallID) (*Call,
 	return c, nil
 }
 
+const sqlSelectCallsByID = `
+SELECT
+	cc.id as id, 
+	cc.created_on as created_on, 
+	cc.modified_on as modified_on, 
+	cc.external_id as external_id,  
+	cc.status as status, 
+	cc.direction as direction, 
+	cc.started_on as started_on, 
+	cc.ended_on as ended_on, 
+	cc.duration as duration, 
+	cc.error_reason as error_reason,
+	cc.error_count as error_count,
+	cc.next_attempt as next_attempt, 
+	cc.channel_id as channel_id, 
+	cc.contact_id as contact_id, 
+	cc.contact_urn_id as contact_urn_id, 
+	cc.org_id as org_id, 
+	fsc.flowstart_id as start_id
+FROM
+	ivr_call as cc
+LEFT OUTER JOIN 
+	flows_flowstart_calls fsc ON cc.id = fsc.call_id
+WHERE
+	cc.org_id = $1 AND cc.id = ANY($2)
+ORDER BY
+	cc.id
+`
+
+// LoadCalls loads the calls with the given ids
+func LoadCalls(ctx context.Context, db *sqlx.DB, orgID OrgID, ids []CallID) ([]*Call, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectCallsByID, orgID, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "error selecting calls")
+	}
+	defer rows.Close()
+
+	calls := make([]*Call, 0, len(ids))
+	for rows.Next() {
+		c := &Call{}
+		if err := rows.StructScan(&c.c); err != nil {
+			return nil, errors.Wrap(err, "error scanning call")
+		}
+		calls = append(calls, c)
+	}
+
+	return calls, errors.Wrap(rows.Err(), "error iterating calls")
+}
+
 const sqlSelectCallByExternalID = `
 SELECT
 	cc.id as id, 
@@ -262,11 +449,14 @@ SELECT
 	cc.contact_id as contact_id, 
 	cc.contact_urn_id as contact_urn_id, 
 	cc.org_id as org_id, 
-	fsc.flowstart_id as start_id
+	fsc.flowstart_id as start_id,
+	fs.call_retry_policy as retry_policy
 FROM
 	ivr_call as cc
 LEFT OUTER JOIN 
 	flows_flowstart_calls fsc ON cc.id = fsc.call_id
+LEFT OUTER JOIN
+	flows_flowstart fs ON fs.id = fsc.flowstart_id
 WHERE
 	cc.status IN ('Q', 'E') AND next_attempt < NOW()
 ORDER BY 
@@ -277,9 +467,55 @@ LIMIT
 
 // LoadCallsToRetry returns up to limit calls that need to be retried
 func LoadCallsToRetry(ctx context.Context, db *sqlx.DB, limit int) ([]*Call, error) {
-	rows, err := db.QueryxContext(ctx, sqlSelectRetryCalls, limit)
+	calls, err := loadCalls(ctx, db, sqlSelectRetryCalls, limit)
+	return calls, errors.Wrapf(err, "error selecting calls to retry")
+}
+
+const sqlSelectQueuedCalls = `
+SELECT
+	cc.id as id, 
+	cc.created_on as created_on, 
+	cc.modified_on as modified_on, 
+	cc.external_id as external_id,  
+	cc.status as status, 
+	cc.direction as direction, 
+	cc.started_on as started_on, 
+	cc.ended_on as ended_on, 
+	cc.duration as duration, 
+	cc.error_reason as error_reason,
+	cc.error_count as error_count,
+	cc.next_attempt as next_attempt, 
+	cc.channel_id as channel_id, 
+	cc.contact_id as contact_id, 
+	cc.contact_urn_id as contact_urn_id, 
+	cc.org_id as org_id, 
+	fsc.flowstart_id as start_id,
+	fs.call_retry_policy as retry_policy
+FROM
+	ivr_call as cc
+LEFT OUTER JOIN 
+	flows_flowstart_calls fsc ON cc.id = fsc.call_id
+LEFT OUTER JOIN
+	flows_flowstart fs ON fs.id = fsc.flowstart_id
+WHERE
+	cc.channel_id = $1 AND cc.status = 'Q'
+ORDER BY 
+	cc.next_attempt ASC
+LIMIT
+    $2
+`
+
+// LoadQueuedCalls returns up to limit calls on the given channel that have been throttled, regardless of when they
+// were due to be tried again
+func LoadQueuedCalls(ctx context.Context, db *sqlx.DB, channelID ChannelID, limit int) ([]*Call, error) {
+	calls, err := loadCalls(ctx, db, sqlSelectQueuedCalls, channelID, limit)
+	return calls, errors.Wrapf(err, "error selecting queued calls")
+}
+
+func loadCalls(ctx context.Context, db *sqlx.DB, sql string, params ...any) ([]*Call, error) {
+	rows, err := db.QueryxContext(ctx, sql, params...)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error selecting calls to retry")
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -322,16 +558,15 @@ func (c *Call) MarkStarted(ctx context.Context, db DBorTx, now time.Time) error
 	return nil
 }
 
-// MarkErrored updates the status for this call to errored and schedules a retry if appropriate
-func (c *Call) MarkErrored(ctx context.Context, db DBorTx, now time.Time, retryWait *time.Duration, errorReason CallError) error {
+// MarkErrored updates the status for this call to errored and schedules a retry if the given policy allows it
+func (c *Call) MarkErrored(ctx context.Context, db DBorTx, now time.Time, policy *CallRetryPolicy, tz *time.Location, errorReason CallError) error {
 	c.c.Status = CallStatusErrored
 	c.c.ErrorReason = null.String(errorReason)
 	c.c.EndedOn = &now
 
-	if c.c.ErrorCount < CallMaxRetries && retryWait != nil {
+	if next := policy.NextAttempt(now, tz, c.c.ErrorCount, errorReason); next != nil {
 		c.c.ErrorCount++
-		next := now.Add(*retryWait)
-		c.c.NextAttempt = &next
+		c.c.NextAttempt = next
 	} else {
 		c.c.Status = CallStatusFailed
 		c.c.NextAttempt = nil
@@ -380,6 +615,22 @@ func (c *Call) MarkThrottled(ctx context.Context, db DBorTx, now time.Time) erro
 	return nil
 }
 
+// MarkDeferred reschedules the next attempt of this call, e.g. because it's outside of the calling window. A call
+// which hasn't been attempted yet is queued so that it will be picked up by the retry cron.
+func (c *Call) MarkDeferred(ctx context.Context, db DBorTx, next time.Time) error {
+	if c.c.Status == CallStatusPending {
+		c.c.Status = CallStatusQueued
+	}
+	c.c.NextAttempt = &next
+
+	_, err := db.ExecContext(ctx, `UPDATE ivr_call SET status = $2, next_attempt = $3, modified_on = NOW() WHERE id = $1`, c.c.ID, c.c.Status, c.c.NextAttempt)
+	if err != nil {
+		return errors.Wrapf(err, "error marking call as deferred")
+	}
+
+	return nil
+}
+
 // UpdateStatus updates the status for this call
 func (c *Call) UpdateStatus(ctx context.Context, db DBorTx, status CallStatus, duration int, now time.Time) error {
 	c.c.Status = status
@@ -438,6 +689,107 @@ func ActiveCallCount(ctx context.Context, db DBorTx, id ChannelID) (int, error)
 	return count, nil
 }
 
+// ActiveCall is a wired or in progress call along with where its contact currently is in the flow
+type ActiveCall struct {
+	ID              CallID                  `json:"id"`
+	ExternalID      string                  `json:"external_id"`
+	Status          CallStatus              `json:"status"`
+	Contact         *flows.ContactReference `json:"contact"`
+	Flow            *assets.FlowReference   `json:"flow,omitempty"`
+	CurrentNodeUUID flows.NodeUUID          `json:"current_node_uuid,omitempty"`
+	StartedOn       *time.Time              `json:"started_on"`
+	Duration        int                     `json:"duration"`
+}
+
+const sqlSelectActiveCalls = `
+    SELECT c.id, c.external_id, c.status, c.started_on, ct.uuid AS contact_uuid, ct.name AS contact_name, f.uuid AS flow_uuid, f.name AS flow_name, r.current_node_uuid
+      FROM ivr_call c
+      JOIN contacts_contact ct ON ct.id = c.contact_id
+ LEFT JOIN flows_flowsession s ON s.call_id = c.id AND s.status = 'W'
+ LEFT JOIN flows_flow f ON f.id = s.current_flow_id
+ LEFT JOIN flows_flowrun r ON r.session_id = s.id AND r.flow_id = s.current_flow_id AND r.status IN ('A', 'W')
+     WHERE c.org_id = $1 AND c.channel_id = $2 AND c.status IN ('W', 'I')
+  ORDER BY c.id`
+
+// LoadActiveCalls loads the wired or in progress calls on the given channel
+func LoadActiveCalls(ctx context.Context, db *sqlx.DB, orgID OrgID, channelID ChannelID, now time.Time) ([]*ActiveCall, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectActiveCalls, orgID, channelID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying active calls")
+	}
+	defer rows.Close()
+
+	calls := make([]*ActiveCall, 0, 10)
+
+	for rows.Next() {
+		row := &struct {
+			ID              CallID            `db:"id"`
+			ExternalID      string            `db:"external_id"`
+			Status          CallStatus        `db:"status"`
+			StartedOn       *time.Time        `db:"started_on"`
+			ContactUUID     flows.ContactUUID `db:"contact_uuid"`
+			ContactName     null.String       `db:"contact_name"`
+			FlowUUID        null.String       `db:"flow_uuid"`
+			FlowName        null.String       `db:"flow_name"`
+			CurrentNodeUUID null.String       `db:"current_node_uuid"`
+		}{}
+		if err := rows.StructScan(row); err != nil {
+			return nil, errors.Wrap(err, "error scanning active call")
+		}
+
+		call := &ActiveCall{
+			ID:              row.ID,
+			ExternalID:      row.ExternalID,
+			Status:          row.Status,
+			Contact:         flows.NewContactReference(row.ContactUUID, string(row.ContactName)),
+			CurrentNodeUUID: flows.NodeUUID(row.CurrentNodeUUID),
+			StartedOn:       row.StartedOn,
+		}
+		if row.FlowUUID != "" {
+			call.Flow = assets.NewFlowReference(assets.FlowUUID(row.FlowUUID), string(row.FlowName))
+		}
+		if row.StartedOn != nil {
+			call.Duration = int(now.Sub(*row.StartedOn) / time.Second)
+		}
+
+		calls = append(calls, call)
+	}
+
+	return calls, errors.Wrap(rows.Err(), "error iterating active calls")
+}
+
+const sqlFailActiveCalls = `
+   UPDATE ivr_call
+      SET status = 'F', ended_on = NOW(), modified_on = NOW()
+    WHERE org_id = $1 AND status IN ('W', 'I') AND (channel_id = $2 OR id = ANY($3))
+RETURNING id`
+
+// FailActiveCalls fails the wired or in progress calls on the given channel or with the given ids, returning their ids
+func FailActiveCalls(ctx context.Context, db DBorTx, orgID OrgID, channelID ChannelID, callIDs []CallID) ([]CallID, error) {
+	ids := make([]CallID, 0, len(callIDs))
+	err := db.SelectContext(ctx, &ids, sqlFailActiveCalls, orgID, channelID, pq.Array(callIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "error failing active calls")
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+const sqlFailPendingCallsForChannel = `
+UPDATE ivr_call
+   SET status = 'F', next_attempt = NULL, modified_on = NOW()
+ WHERE org_id = $1 AND channel_id = $2 AND status IN ('P', 'Q', 'E')`
+
+// FailPendingCallsForChannel fails calls on the given channel which haven't been wired yet or are waiting to be retried
+func FailPendingCallsForChannel(ctx context.Context, db DBorTx, orgID OrgID, channelID ChannelID) (int, error) {
+	res, err := db.ExecContext(ctx, sqlFailPendingCallsForChannel, orgID, channelID)
+	if err != nil {
+		return 0, errors.Wrap(err, "error failing pending calls")
+	}
+	rows, _ := res.RowsAffected()
+	return int(rows), nil
+}
+
 func (i *CallID) Scan(value any) error         { return null.ScanInt(value, i) }
 func (i CallID) Value() (driver.Value, error)  { return null.IntValue(i) }
 func (i *CallID) UnmarshalJSON(b []byte) error { return null.UnmarshalInt(b, i) }
@@ -2,8 +2,10 @@ package models_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
@@ -29,3 +31,53 @@ func TestCalls(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "test1", conn2.ExternalID())
 }
+
+func TestCallRetryPolicy(t *testing.T) {
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+	now := time.Date(2023, 10, 5, 10, 0, 0, 0, tz)
+	tenMins, fourHours := 10, 240
+
+	// default policy uses the flow wait for all errors
+	policy := models.ResolveCallRetryPolicy(nil, nil, nil)
+	assert.Equal(t, models.CallMaxRetries+1, policy.MaxAttempts)
+	assert.Nil(t, policy.NextAttempt(now, tz, 0, models.CallErrorBusy))
+
+	policy = &models.CallRetryPolicy{
+		MaxAttempts: 3,
+		Wait:        &fourHours,
+		Waits:       map[models.CallError]int{models.CallErrorBusy: tenMins, models.CallErrorMachine: -1},
+		Window:      &models.CallWindow{Start: "09:00", End: "13:00"},
+	}
+
+	assert.Equal(t, time.Date(2023, 10, 5, 10, 10, 0, 0, tz), *policy.NextAttempt(now, tz, 0, models.CallErrorBusy))
+	assert.Nil(t, policy.NextAttempt(now, tz, 0, models.CallErrorMachine))
+	assert.Nil(t, policy.NextAttempt(now, tz, 2, models.CallErrorBusy)) // no attempts left
+
+	// no answer waits 4 hours which is outside of the window so moves to the next day
+	assert.Equal(t, time.Date(2023, 10, 6, 9, 0, 0, 0, tz), *policy.NextAttempt(now, tz, 0, models.CallErrorNoAnswer))
+
+	assert.Equal(t, time.Date(2023, 10, 5, 9, 0, 0, 0, tz), policy.NextInWindow(time.Date(2023, 10, 5, 7, 30, 0, 0, tz), tz))
+	assert.Equal(t, time.Date(2023, 10, 5, 12, 30, 0, 0, tz), policy.NextInWindow(time.Date(2023, 10, 5, 12, 30, 0, 0, tz), tz))
+
+	// windows can cross midnight
+	policy.Window = &models.CallWindow{Start: "20:00", End: "02:00"}
+	assert.Equal(t, time.Date(2023, 10, 5, 20, 0, 0, 0, tz), policy.NextInWindow(now, tz))
+	assert.Equal(t, time.Date(2023, 10, 6, 1, 0, 0, 0, tz), policy.NextInWindow(time.Date(2023, 10, 6, 1, 0, 0, 0, tz), tz))
+
+	// policies are copied when resolved so defaults don't leak back into the start's policy
+	startPolicy := &models.CallRetryPolicy{Window: policy.Window}
+	resolved := models.ResolveCallRetryPolicy(startPolicy, nil, nil)
+	assert.Equal(t, models.CallMaxRetries+1, resolved.MaxAttempts)
+	assert.Equal(t, 0, startPolicy.MaxAttempts)
+	assert.Equal(t, policy.Window, resolved.Window)
+
+	// windows are in the org's timezone unless they have a valid timezone of their own
+	env := envs.NewBuilder().WithTimezone(tz).Build()
+	assert.Equal(t, tz, policy.Timezone(env))
+
+	policy.Window.Timezone = "Africa/Kigali"
+	assert.Equal(t, "Africa/Kigali", policy.Timezone(env).String())
+
+	policy.Window.Timezone = "Mars/Olympus"
+	assert.Equal(t, tz, policy.Timezone(env))
+}
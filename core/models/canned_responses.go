@@ -0,0 +1,89 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/excellent"
+	"github.com/nyaruka/goflow/excellent/types"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/null/v3"
+	"github.com/pkg/errors"
+)
+
+// CannedResponseID is our type for canned response ids
+type CannedResponseID int
+
+const NilCannedResponseID = CannedResponseID(0)
+
+func (i *CannedResponseID) Scan(value any) error         { return null.ScanInt(value, i) }
+func (i CannedResponseID) Value() (driver.Value, error)  { return null.IntValue(i) }
+func (i *CannedResponseID) UnmarshalJSON(b []byte) error { return null.UnmarshalInt(b, i) }
+func (i CannedResponseID) MarshalJSON() ([]byte, error)  { return null.MarshalInt(i) }
+
+// CannedResponse is a reply template which agents can send to contacts from tickets
+type CannedResponse struct {
+	ID_           CannedResponseID            `json:"id"`
+	UUID_         uuids.UUID                  `json:"uuid"`
+	Name_         string                      `json:"name"`
+	TopicID_      TopicID                     `json:"topic_id"`
+	BaseLanguage_ i18n.Language               `json:"base_language"`
+	Translations_ flows.BroadcastTranslations `json:"translations"`
+}
+
+func (r *CannedResponse) ID() CannedResponseID { return r.ID_ }
+func (r *CannedResponse) UUID() uuids.UUID     { return r.UUID_ }
+func (r *CannedResponse) Name() string         { return r.Name_ }
+func (r *CannedResponse) TopicID() TopicID     { return r.TopicID_ }
+
+// AppliesTo returns whether this canned response can be used for the given ticket
+func (r *CannedResponse) AppliesTo(ticket *Ticket) bool {
+	return r.TopicID_ == NilTopicID || r.TopicID_ == ticket.TopicID()
+}
+
+// Render picks the translation for the given contact and evaluates its text against the contact and ticket
+func (r *CannedResponse) Render(oa *OrgAssets, contact *flows.Contact, ticket *Ticket) (string, []utils.Attachment, []string, i18n.Locale, error) {
+	trans, lang := r.Translations_.ForContact(oa.Env(), contact, r.BaseLanguage_)
+	if trans == nil {
+		return "", nil, nil, i18n.NilLocale, errors.Errorf("canned response #%d has no translation in base language", r.ID_)
+	}
+
+	ctx := types.NewXObject(map[string]types.XValue{
+		"contact": flows.Context(oa.Env(), contact),
+		"fields":  flows.Context(oa.Env(), contact.Fields()),
+		"globals": flows.Context(oa.Env(), oa.SessionAssets().Globals()),
+		"urns":    flows.ContextFunc(oa.Env(), contact.URNs().MapContext),
+		"ticket":  flows.Context(oa.Env(), ticket.FlowTicket(oa)),
+	})
+
+	// like message templates in flows, an expression error doesn't stop us sending what we could evaluate
+	text, err := excellent.EvaluateTemplate(oa.Env(), ctx, trans.Text, nil)
+	if err != nil {
+		slog.Warn("error evaluating canned response", "error", err, "org_id", oa.OrgID(), "canned_response_id", r.ID_)
+	}
+
+	return text, trans.Attachments, trans.QuickReplies, i18n.NewLocale(lang, i18n.NilCountry), nil
+}
+
+const sqlSelectCannedResponsesByOrg = `
+SELECT ROW_TO_JSON(r) FROM (
+      SELECT id, uuid, name, topic_id, base_language, translations
+        FROM tickets_cannedresponse
+       WHERE org_id = $1 AND is_active = TRUE
+    ORDER BY name ASC
+) r;`
+
+// loads the canned responses for the passed in org
+func loadCannedResponses(ctx context.Context, db *sql.DB, orgID OrgID) ([]*CannedResponse, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectCannedResponsesByOrg, orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error querying canned responses for org: %d", orgID)
+	}
+
+	return ScanJSONRows(rows, func() *CannedResponse { return &CannedResponse{} })
+}
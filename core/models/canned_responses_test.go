@@ -0,0 +1,64 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCannedResponses(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	thanks := testdata.InsertCannedResponse(rt, testdata.Org1, nil, "Thanks", "eng", flows.BroadcastTranslations{
+		"eng": {Text: "Thanks @contact.first_name, we're looking into your @ticket.topic.name issue"},
+		"spa": {Text: "Gracias @contact.first_name"},
+	})
+	sales := testdata.InsertCannedResponse(rt, testdata.Org1, testdata.SalesTopic, "Sales", "eng", flows.BroadcastTranslations{
+		"eng": {Text: "Our sales team will be in touch", QuickReplies: []string{"OK"}},
+	})
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshCannedResponses)
+	require.NoError(t, err)
+
+	responses := oa.CannedResponses()
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, sales.ID, responses[0].ID())
+	assert.Equal(t, "Sales", responses[0].Name())
+	assert.Equal(t, testdata.SalesTopic.ID, responses[0].TopicID())
+	assert.Equal(t, thanks.ID, responses[1].ID())
+	assert.Equal(t, models.NilTopicID, responses[1].TopicID())
+
+	assert.Nil(t, oa.CannedResponseByID(123456))
+
+	ticket := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.SupportTopic, "Help", time.Now(), nil).Load(rt)
+	_, cathy, _ := testdata.Cathy.Load(rt, oa)
+
+	// topic-less responses apply to any ticket but others only to tickets with that topic
+	assert.True(t, oa.CannedResponseByID(thanks.ID).AppliesTo(ticket))
+	assert.False(t, oa.CannedResponseByID(sales.ID).AppliesTo(ticket))
+
+	text, attachments, quickReplies, locale, err := oa.CannedResponseByID(thanks.ID).Render(oa, cathy, ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, "Thanks Cathy, we're looking into your Support issue", text)
+	assert.Nil(t, attachments)
+	assert.Nil(t, quickReplies)
+	assert.Equal(t, i18n.Locale("eng"), locale)
+
+	// change Cathy's language to get the Spanish variant
+	rt.DB.MustExec(`UPDATE contacts_contact SET language = 'spa' WHERE id = $1`, testdata.Cathy.ID)
+	_, cathy, _ = testdata.Cathy.Load(rt, oa)
+
+	text, _, _, locale, err = oa.CannedResponseByID(thanks.ID).Render(oa, cathy, ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, "Gracias Cathy", text)
+	assert.Equal(t, i18n.Locale("spa"), locale)
+}
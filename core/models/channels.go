@@ -9,6 +9,7 @@ import (
 
 	"github.com/lib/pq"
 	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/null/v3"
 	"github.com/pkg/errors"
@@ -33,6 +34,7 @@ const (
 	ChannelConfigCallbackDomain      = "callback_domain"
 	ChannelConfigMaxConcurrentEvents = "max_concurrent_events"
 	ChannelConfigFCMID               = "FCM_ID"
+	ChannelConfigCallRetryPolicy     = "call_retry_policy"
 )
 
 // Channel is the mailroom struct that represents channels
@@ -117,6 +119,20 @@ func (c *Channel) ConfigValue(key string, def string) string {
 	return def
 }
 
+// CallRetryPolicy returns the retry policy for IVR calls on this channel if it has one
+func (c *Channel) CallRetryPolicy() *CallRetryPolicy {
+	value, exists := c.Config_[ChannelConfigCallRetryPolicy]
+	if !exists {
+		return nil
+	}
+
+	policy := &CallRetryPolicy{}
+	if err := jsonx.Unmarshal(jsonx.MustMarshal(value), policy); err != nil {
+		return nil
+	}
+	return policy
+}
+
 // Reference return a channel reference for this channel
 func (c *Channel) Reference() *assets.ChannelReference {
 	return assets.NewChannelReference(c.UUID(), c.Name())
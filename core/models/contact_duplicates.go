@@ -0,0 +1,283 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+)
+
+// DuplicateMatcher finds pairs of contacts in an org which are likely to be the same person
+type DuplicateMatcher func(ctx context.Context, db DB, oa *OrgAssets) ([]*DuplicatePair, error)
+
+var duplicateMatchers = map[string]DuplicateMatcher{}
+
+// RegisterDuplicateMatcher registers a new matcher to be used when finding duplicate contacts
+func RegisterDuplicateMatcher(name string, matcher DuplicateMatcher) {
+	duplicateMatchers[name] = matcher
+}
+
+func init() {
+	RegisterDuplicateMatcher("urn", matchDuplicatesByURN)
+	RegisterDuplicateMatcher("name_field", matchDuplicatesByNameAndField)
+}
+
+const (
+	// confidence of a match on URNs which are the same once normalized
+	confidenceURN = 0.9
+
+	// confidence of a match on name plus the value of a field
+	confidenceNameField = 0.7
+
+	// contacts sharing a URN or name with more than this many others aren't considered duplicates of each other,
+	// e.g. a phone number shared by a household
+	maxDuplicateSetSize = 10
+)
+
+// DuplicatePair is a pair of contacts found by a matcher, with a confidence between 0 and 1
+type DuplicatePair struct {
+	ContactID1 ContactID
+	ContactID2 ContactID
+	Confidence float64
+}
+
+// ContactDuplicate is a candidate pair of duplicate contacts
+type ContactDuplicate struct {
+	OrgID      OrgID          `db:"org_id"`
+	ContactID1 ContactID      `db:"contact1_id"`
+	ContactID2 ContactID      `db:"contact2_id"`
+	Matchers   pq.StringArray `db:"matchers"`
+	Confidence float64        `db:"confidence"`
+	CreatedOn  time.Time      `db:"created_on"`
+}
+
+// FindContactDuplicates runs all registered matchers against the given org and combines their results so that there is
+// a single candidate for each pair of contacts. Pairs found by multiple matchers have a higher confidence.
+func FindContactDuplicates(ctx context.Context, db DB, oa *OrgAssets) ([]*ContactDuplicate, error) {
+	names := make([]string, 0, len(duplicateMatchers))
+	for name := range duplicateMatchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type pairKey struct{ id1, id2 ContactID }
+	byPair := make(map[pairKey]*ContactDuplicate)
+	now := dates.Now()
+
+	for _, name := range names {
+		pairs, err := duplicateMatchers[name](ctx, db, oa)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error running duplicate matcher '%s'", name)
+		}
+
+		for _, p := range pairs {
+			id1, id2 := p.ContactID1, p.ContactID2
+			if id1 == id2 {
+				continue
+			}
+			if id1 > id2 {
+				id1, id2 = id2, id1
+			}
+
+			key := pairKey{id1, id2}
+			d := byPair[key]
+			if d == nil {
+				d = &ContactDuplicate{OrgID: oa.OrgID(), ContactID1: id1, ContactID2: id2, CreatedOn: now}
+				byPair[key] = d
+			}
+
+			// each matcher is treated as independent evidence
+			d.Confidence = 1 - (1-d.Confidence)*(1-p.Confidence)
+			d.Matchers = append(d.Matchers, name)
+		}
+	}
+
+	dupes := make([]*ContactDuplicate, 0, len(byPair))
+	for _, d := range byPair {
+		dupes = append(dupes, d)
+	}
+	sort.Slice(dupes, func(i, j int) bool {
+		if dupes[i].ContactID1 != dupes[j].ContactID1 {
+			return dupes[i].ContactID1 < dupes[j].ContactID1
+		}
+		return dupes[i].ContactID2 < dupes[j].ContactID2
+	})
+
+	return dupes, nil
+}
+
+// URNs are grouped in SQL by a key which is the same for URNs that normalize to the same identity, i.e. the trailing
+// digits of phone numbers and the lowercased path of other URNs, so that only candidate groups are loaded
+const sqlSelectURNDuplicateCandidates = `
+  SELECT array_agg(u.contact_id ORDER BY u.id), array_agg(u.identity ORDER BY u.id)
+    FROM contacts_contacturn u
+    JOIN contacts_contact c ON c.id = u.contact_id
+   WHERE u.org_id = $1 AND c.is_active = TRUE
+GROUP BY u.scheme, CASE WHEN u.scheme = 'tel' THEN RIGHT(regexp_replace(u.path, '[^0-9]', '', 'g'), 8) ELSE LOWER(TRIM(LEADING '@' FROM TRIM(u.path))) END
+  HAVING COUNT(DISTINCT u.contact_id) > 1`
+
+// matches contacts with URNs which are the same once normalized the same way as GetOrCreateContact, e.g. the same phone
+// number with and without a country code, or the same email in a different case
+func matchDuplicatesByURN(ctx context.Context, db DB, oa *OrgAssets) ([]*DuplicatePair, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectURNDuplicateCandidates, oa.OrgID())
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying URN duplicate candidates")
+	}
+	defer rows.Close()
+
+	country := string(oa.Env().DefaultCountry())
+	pairs := make([]*DuplicatePair, 0)
+
+	var contactIDs pq.Int64Array
+	var identities pq.StringArray
+	for rows.Next() {
+		if err := rows.Scan(&contactIDs, &identities); err != nil {
+			return nil, errors.Wrap(err, "error scanning URN duplicate candidates")
+		}
+
+		// candidates share a key but that doesn't mean they're the same URN once properly normalized
+		contactsByURN := make(map[urns.URN][]ContactID)
+		for i, identity := range identities {
+			norm := urns.URN(identity).Normalize(country).Identity()
+			contactsByURN[norm] = appendUniqueContactID(contactsByURN[norm], ContactID(contactIDs[i]))
+		}
+
+		for _, ids := range contactsByURN {
+			pairs = appendAllPairs(pairs, ids, confidenceURN)
+		}
+	}
+	return pairs, errors.Wrap(rows.Err(), "error iterating URN duplicate candidates")
+}
+
+// contacts are grouped in SQL by lowercased name so that only contacts in small groups of the same name are compared
+const sqlSelectNameFieldMatches = `
+WITH names AS (
+      SELECT LOWER(name) AS name
+        FROM contacts_contact
+       WHERE org_id = $1 AND is_active = TRUE AND COALESCE(name, '') != ''
+    GROUP BY LOWER(name)
+      HAVING COUNT(*) BETWEEN 2 AND $2
+)
+SELECT c1.id, c2.id
+  FROM names n
+  JOIN contacts_contact c1 ON c1.org_id = $1 AND c1.is_active = TRUE AND LOWER(c1.name) = n.name
+  JOIN contacts_contact c2 ON c2.org_id = $1 AND c2.is_active = TRUE AND LOWER(c2.name) = n.name AND c2.id > c1.id
+ WHERE EXISTS (
+           SELECT 1
+             FROM jsonb_each(c1.fields) f1
+             JOIN jsonb_each(c2.fields) f2 ON f2.key = f1.key AND LOWER(f2.value->>'text') = LOWER(f1.value->>'text')
+       )`
+
+// matches contacts with the same name which also have the same value for a field
+func matchDuplicatesByNameAndField(ctx context.Context, db DB, oa *OrgAssets) ([]*DuplicatePair, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectNameFieldMatches, oa.OrgID(), maxDuplicateSetSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying name and field matches")
+	}
+	defer rows.Close()
+
+	pairs := make([]*DuplicatePair, 0)
+	for rows.Next() {
+		p := &DuplicatePair{Confidence: confidenceNameField}
+		if err := rows.Scan(&p.ContactID1, &p.ContactID2); err != nil {
+			return nil, errors.Wrap(err, "error scanning name and field match")
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, errors.Wrap(rows.Err(), "error iterating name and field matches")
+}
+
+func appendUniqueContactID(ids []ContactID, id ContactID) []ContactID {
+	for _, i := range ids {
+		if i == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// appends a pair for every combination of the given contacts, unless there are too many of them
+func appendAllPairs(pairs []*DuplicatePair, ids []ContactID, confidence float64) []*DuplicatePair {
+	if len(ids) < 2 || len(ids) > maxDuplicateSetSize {
+		return pairs
+	}
+	for i := 0; i < len(ids); i++ {
+		for j := i + 1; j < len(ids); j++ {
+			pairs = append(pairs, &DuplicatePair{ContactID1: ids[i], ContactID2: ids[j], Confidence: confidence})
+		}
+	}
+	return pairs
+}
+
+const sqlDeleteContactDuplicates = `DELETE FROM contacts_contactduplicate WHERE org_id = $1`
+
+const sqlInsertContactDuplicates = `
+INSERT INTO contacts_contactduplicate(org_id, contact1_id, contact2_id, matchers, confidence, created_on)
+     VALUES(:org_id, :contact1_id, :contact2_id, :matchers, :confidence, :created_on)`
+
+// ReplaceContactDuplicates replaces the duplicate candidates for the given org with the given ones
+func ReplaceContactDuplicates(ctx context.Context, db DB, orgID OrgID, dupes []*ContactDuplicate) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error starting transaction")
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlDeleteContactDuplicates, orgID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error deleting existing duplicates")
+	}
+
+	for _, batch := range ChunkSlice(dupes, 1000) {
+		if err := BulkQuery(ctx, "inserted contact duplicates", tx, sqlInsertContactDuplicates, batch); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "error inserting duplicates")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "error committing duplicates")
+}
+
+// DuplicateContact is one of the contacts in a candidate pair of duplicates
+type DuplicateContact struct {
+	ID   ContactID         `json:"id"`
+	UUID flows.ContactUUID `json:"uuid"`
+	Name string            `json:"name"`
+}
+
+// ContactDuplicateInfo is a candidate pair of duplicate contacts with the contacts' details
+type ContactDuplicateInfo struct {
+	Contact1   *DuplicateContact `json:"contact1"`
+	Contact2   *DuplicateContact `json:"contact2"`
+	Matchers   []string          `json:"matchers"`
+	Confidence float64           `json:"confidence"`
+}
+
+const sqlSelectContactDuplicates = `
+SELECT row_to_json(r) FROM (
+    SELECT json_build_object('id', c1.id, 'uuid', c1.uuid, 'name', COALESCE(c1.name, '')) AS contact1,
+           json_build_object('id', c2.id, 'uuid', c2.uuid, 'name', COALESCE(c2.name, '')) AS contact2,
+           d.matchers,
+           d.confidence
+      FROM contacts_contactduplicate d
+      JOIN contacts_contact c1 ON c1.id = d.contact1_id
+      JOIN contacts_contact c2 ON c2.id = d.contact2_id
+     WHERE d.org_id = $1 AND d.confidence >= $2 AND c1.is_active = TRUE AND c2.is_active = TRUE
+  ORDER BY d.confidence DESC, d.contact1_id, d.contact2_id
+     LIMIT $3 OFFSET $4
+) r`
+
+// GetContactDuplicates returns the duplicate candidates for the given org with at least the given confidence, most
+// confident first
+func GetContactDuplicates(ctx context.Context, db DB, orgID OrgID, minConfidence float64, limit, offset int) ([]*ContactDuplicateInfo, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectContactDuplicates, orgID, minConfidence, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying contact duplicates")
+	}
+
+	return ScanJSONRows(rows, func() *ContactDuplicateInfo { return &ContactDuplicateInfo{} })
+}
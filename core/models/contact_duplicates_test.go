@@ -0,0 +1,88 @@
+package models_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactDuplicates(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// another Cathy with the same gender and the same number but formatted differently
+	cathy2 := testdata.InsertContact(rt, testdata.Org1, "e2f6d8a1-3c4b-4f5e-9a7d-8b1c2d3e4f50", "Cathy", "eng", models.ContactStatusActive)
+	testdata.InsertContactURN(rt, testdata.Org1, cathy2, urns.URN("tel:+1 605 574 1111"), 1000, nil)
+	rt.DB.MustExec(`UPDATE contacts_contact SET fields = (SELECT fields FROM contacts_contact WHERE id = $1) WHERE id = $2`, testdata.Cathy.ID, cathy2.ID)
+
+	// a Robert with Bob's email in a different case
+	testdata.InsertContactURN(rt, testdata.Org1, testdata.Bob, urns.URN("mailto:bob@nyaruka.com"), 999, nil)
+	robert := testdata.InsertContact(rt, testdata.Org1, "5a0a8e0b-e8b9-4d6e-9a5c-1f0a1b2c3d4e", "Robert", "eng", models.ContactStatusActive)
+	testdata.InsertContactURN(rt, testdata.Org1, robert, urns.URN("mailto:Bob@Nyaruka.com"), 1000, nil)
+
+	// and 11 contacts sharing a number which are too many to be duplicates of each other
+	household := make(map[models.ContactID]bool)
+	for i := 0; i < 11; i++ {
+		c := testdata.InsertContact(rt, testdata.Org1, flows.ContactUUID(uuids.New()), "", "", models.ContactStatusActive)
+		testdata.InsertContactURN(rt, testdata.Org1, c, urns.URN("tel:+1"+strings.Repeat("-", i)+"2065551212"), 1000-i, nil)
+		household[c.ID] = true
+	}
+
+	oa := testdata.Org1.Load(rt)
+
+	dupes, err := models.FindContactDuplicates(ctx, rt.DB, oa)
+	require.NoError(t, err)
+
+	findDupe := func(id1, id2 models.ContactID) *models.ContactDuplicate {
+		for _, d := range dupes {
+			if d.ContactID1 == id1 && d.ContactID2 == id2 {
+				return d
+			}
+		}
+		return nil
+	}
+
+	cathyDupe := findDupe(testdata.Cathy.ID, cathy2.ID)
+	if assert.NotNil(t, cathyDupe) {
+		assert.Equal(t, []string{"name_field", "urn"}, []string(cathyDupe.Matchers))
+		assert.InDelta(t, 0.97, cathyDupe.Confidence, 0.001)
+	}
+
+	bobDupe := findDupe(testdata.Bob.ID, robert.ID)
+	if assert.NotNil(t, bobDupe) {
+		assert.Equal(t, []string{"urn"}, []string(bobDupe.Matchers))
+		assert.InDelta(t, 0.9, bobDupe.Confidence, 0.001)
+	}
+
+	for _, d := range dupes {
+		assert.Less(t, d.ContactID1, d.ContactID2)
+		assert.False(t, household[d.ContactID1] || household[d.ContactID2])
+	}
+
+	err = models.ReplaceContactDuplicates(ctx, rt.DB, testdata.Org1.ID, dupes)
+	assert.NoError(t, err)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactduplicate WHERE org_id = $1`, testdata.Org1.ID).Returns(len(dupes))
+
+	// replacing again doesn't duplicate the duplicates
+	err = models.ReplaceContactDuplicates(ctx, rt.DB, testdata.Org1.ID, dupes)
+	assert.NoError(t, err)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactduplicate WHERE org_id = $1`, testdata.Org1.ID).Returns(len(dupes))
+
+	infos, err := models.GetContactDuplicates(ctx, rt.DB, testdata.Org1.ID, 0.95, 10, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, testdata.Cathy.UUID, infos[0].Contact1.UUID)
+		assert.Equal(t, cathy2.UUID, infos[0].Contact2.UUID)
+		assert.Equal(t, "Cathy", infos[0].Contact2.Name)
+	}
+}
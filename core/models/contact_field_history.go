@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/pkg/errors"
+)
+
+// ContactFieldChange is a change to the value of a field on a contact. Values are stored as they are on the contact, so
+// a nil value means the field was cleared.
+type ContactFieldChange struct {
+	OrgID     OrgID           `db:"org_id"`
+	ContactID ContactID       `db:"contact_id"`
+	FieldID   FieldID         `db:"field_id"`
+	OldValue  json.RawMessage `db:"old_value"`
+	NewValue  json.RawMessage `db:"new_value"`
+	FlowID    FlowID          `db:"flow_id"`
+	UserID    UserID          `db:"user_id"`
+}
+
+const sqlSelectContactFieldValues = `SELECT id, fields FROM contacts_contact WHERE id = ANY($1)`
+
+// LoadContactFieldValues loads the current raw field values of the given contacts, keyed by field UUID
+func LoadContactFieldValues(ctx context.Context, db Queryer, contactIDs []ContactID) (map[ContactID]map[assets.FieldUUID]json.RawMessage, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectContactFieldValues, pq.Array(contactIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying contact field values")
+	}
+	defer rows.Close()
+
+	values := make(map[ContactID]map[assets.FieldUUID]json.RawMessage, len(contactIDs))
+	for rows.Next() {
+		var contactID ContactID
+		var fieldsJSON []byte
+
+		if err := rows.Scan(&contactID, &fieldsJSON); err != nil {
+			return nil, errors.Wrap(err, "error scanning contact field values")
+		}
+
+		fields := make(map[assets.FieldUUID]json.RawMessage)
+		if len(fieldsJSON) > 0 {
+			if err := json.Unmarshal(fieldsJSON, &fields); err != nil {
+				return nil, errors.Wrap(err, "error unmarshaling contact field values")
+			}
+		}
+		values[contactID] = fields
+	}
+
+	return values, errors.Wrap(rows.Err(), "error iterating contact field values")
+}
+
+const sqlInsertContactFieldHistory = `
+INSERT INTO contacts_contactfieldhistory(org_id, contact_id, field_id, old_value, new_value, flow_id, user_id, created_on)
+     VALUES(:org_id, :contact_id, :field_id, :old_value, :new_value, :flow_id, :user_id, NOW())`
+
+const sqlTrimContactFieldHistory = `
+DELETE FROM contacts_contactfieldhistory h
+      USING (
+          SELECT id, row_number() OVER (PARTITION BY contact_id, field_id ORDER BY created_on DESC, id DESC) AS position
+            FROM contacts_contactfieldhistory
+           WHERE contact_id = ANY($1)
+      ) r
+      WHERE h.id = r.id AND r.position > $2`
+
+// InsertContactFieldHistory records the given field changes, and trims the history of the affected contacts so that
+// only the most recent changes are kept for each field
+func InsertContactFieldHistory(ctx context.Context, tx DBorTx, changes []*ContactFieldChange, maxPerField int) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := BulkQuery(ctx, "inserted contact field history", tx, sqlInsertContactFieldHistory, changes); err != nil {
+		return errors.Wrap(err, "error inserting contact field history")
+	}
+
+	contactIDs := make([]ContactID, 0, len(changes))
+	seen := make(map[ContactID]bool, len(changes))
+	for _, c := range changes {
+		if !seen[c.ContactID] {
+			contactIDs = append(contactIDs, c.ContactID)
+			seen[c.ContactID] = true
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, sqlTrimContactFieldHistory, pq.Array(contactIDs), maxPerField)
+	return errors.Wrap(err, "error trimming contact field history")
+}
+
+// ContactFieldHistoryItem is a change to a field value on a contact with the details of the field and what changed it
+type ContactFieldHistoryItem struct {
+	ID        int64                    `json:"id"`
+	Field     *assets.FieldReference   `json:"field"`
+	OldValue  json.RawMessage          `json:"old_value"`
+	NewValue  json.RawMessage          `json:"new_value"`
+	Flow      *assets.FlowReference    `json:"flow,omitempty"`
+	User      *ContactFieldHistoryUser `json:"user,omitempty"`
+	CreatedOn time.Time                `json:"created_on"`
+}
+
+// ContactFieldHistoryUser is the user who made a change to a field value
+type ContactFieldHistoryUser struct {
+	ID    UserID `json:"id"`
+	Email string `json:"email"`
+}
+
+const sqlSelectContactFieldHistory = `
+SELECT row_to_json(r) FROM (
+    SELECT h.id,
+           json_build_object('key', f.key, 'name', f.name) AS field,
+           h.old_value,
+           h.new_value,
+           CASE WHEN fl.id IS NOT NULL THEN json_build_object('uuid', fl.uuid, 'name', fl.name) END AS flow,
+           CASE WHEN u.id IS NOT NULL THEN json_build_object('id', u.id, 'email', u.email) END AS "user",
+           h.created_on
+      FROM contacts_contactfieldhistory h
+      JOIN contacts_contactfield f ON f.id = h.field_id
+ LEFT JOIN flows_flow fl ON fl.id = h.flow_id
+ LEFT JOIN auth_user u ON u.id = h.user_id
+     WHERE h.org_id = $1 AND h.contact_id = $2 AND ($3 = 0 OR h.field_id = $3) AND ($4 = 0 OR h.id = $4)
+  ORDER BY h.created_on DESC, h.id DESC
+     LIMIT $5
+) r`
+
+// GetContactFieldHistory returns the field history of the given contact, optionally for a single field, most recent first
+func GetContactFieldHistory(ctx context.Context, db Queryer, orgID OrgID, contactID ContactID, fieldID FieldID, limit int) ([]*ContactFieldHistoryItem, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectContactFieldHistory, orgID, contactID, fieldID, 0, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying contact field history")
+	}
+
+	return ScanJSONRows(rows, func() *ContactFieldHistoryItem { return &ContactFieldHistoryItem{} })
+}
+
+// GetContactFieldHistoryItem returns a single change from the field history of the given contact, or nil if it
+// doesn't exist
+func GetContactFieldHistoryItem(ctx context.Context, db Queryer, orgID OrgID, contactID ContactID, id int64) (*ContactFieldHistoryItem, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectContactFieldHistory, orgID, contactID, 0, id, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying contact field history")
+	}
+
+	items, err := ScanJSONRows(rows, func() *ContactFieldHistoryItem { return &ContactFieldHistoryItem{} })
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
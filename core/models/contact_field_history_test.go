@@ -0,0 +1,77 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactFieldHistory(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	newChange := func(contact *testdata.Contact, field *testdata.Field, oldValue, newValue string) *models.ContactFieldChange {
+		c := &models.ContactFieldChange{OrgID: testdata.Org1.ID, ContactID: contact.ID, FieldID: field.ID, UserID: testdata.Admin.ID}
+		if oldValue != "" {
+			c.OldValue = json.RawMessage(oldValue)
+		}
+		if newValue != "" {
+			c.NewValue = json.RawMessage(newValue)
+		}
+		return c
+	}
+
+	err := models.InsertContactFieldHistory(ctx, rt.DB, []*models.ContactFieldChange{
+		newChange(testdata.Cathy, testdata.AgeField, "", `{"text": "30", "number": 30}`),
+		newChange(testdata.Cathy, testdata.GenderField, "", `{"text": "F"}`),
+		newChange(testdata.Bob, testdata.AgeField, "", `{"text": "40", "number": 40}`),
+	}, 2)
+	require.NoError(t, err)
+
+	err = models.InsertContactFieldHistory(ctx, rt.DB, []*models.ContactFieldChange{
+		newChange(testdata.Cathy, testdata.AgeField, `{"text": "30", "number": 30}`, `{"text": "31", "number": 31}`),
+	}, 2)
+	require.NoError(t, err)
+
+	err = models.InsertContactFieldHistory(ctx, rt.DB, []*models.ContactFieldChange{
+		newChange(testdata.Cathy, testdata.AgeField, `{"text": "31", "number": 31}`, ""),
+	}, 2)
+	require.NoError(t, err)
+
+	// history is capped per contact and field
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactfieldhistory WHERE contact_id = $1 AND field_id = $2`, testdata.Cathy.ID, testdata.AgeField.ID).Returns(2)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactfieldhistory WHERE contact_id = $1 AND field_id = $2`, testdata.Cathy.ID, testdata.GenderField.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactfieldhistory WHERE contact_id = $1`, testdata.Bob.ID).Returns(1)
+
+	history, err := models.GetContactFieldHistory(ctx, rt.DB, testdata.Org1.ID, testdata.Cathy.ID, testdata.AgeField.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	// most recent first
+	assert.Equal(t, "age", history[0].Field.Key)
+	assert.JSONEq(t, `{"text": "31", "number": 31}`, string(history[0].OldValue))
+	assert.Equal(t, "null", string(history[0].NewValue))
+	assert.Equal(t, testdata.Admin.ID, history[0].User.ID)
+	assert.Nil(t, history[0].Flow)
+	assert.JSONEq(t, `{"text": "30", "number": 30}`, string(history[1].OldValue))
+
+	history, err = models.GetContactFieldHistory(ctx, rt.DB, testdata.Org1.ID, testdata.Cathy.ID, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, history, 3)
+
+	item, err := models.GetContactFieldHistoryItem(ctx, rt.DB, testdata.Org1.ID, testdata.Cathy.ID, history[2].ID)
+	require.NoError(t, err)
+	assert.Equal(t, history[2].ID, item.ID)
+
+	// items of other contacts can't be fetched
+	item, err = models.GetContactFieldHistoryItem(ctx, rt.DB, testdata.Org1.ID, testdata.Bob.ID, history[2].ID)
+	require.NoError(t, err)
+	assert.Nil(t, item)
+}
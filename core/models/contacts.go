@@ -981,6 +981,12 @@ func CalculateDynamicGroups(ctx context.Context, db DBorTx, oa *OrgAssets, conta
 		return errors.Wrapf(err, "error removing contact from group")
 	}
 
+	changes := append(NewGroupChangesForAdds(groupAdds, GroupChangeCauseSmartGroup), NewGroupChangesForRemoves(groupRemoves, GroupChangeCauseSmartGroup)...)
+	err = InsertGroupChangeEvents(ctx, db, oa, changes)
+	if err != nil {
+		return errors.Wrapf(err, "error publishing group changes")
+	}
+
 	// clear any unfired campaign events for this contact
 	err = DeleteUnfiredContactEvents(ctx, db, contactIDs)
 	if err != nil {
@@ -14,9 +14,10 @@ import (
 
 // Scene represents the context that events are occurring in
 type Scene struct {
-	contact *flows.Contact
-	session *Session
-	userID  UserID
+	contact  *flows.Contact
+	session  *Session
+	userID   UserID
+	isImport bool
 
 	preCommits  map[EventCommitHook][]any
 	postCommits map[EventCommitHook][]any
@@ -62,6 +63,19 @@ func (s *Scene) Session() *Session { return s.session }
 // User returns the user ID for this scene if any
 func (s *Scene) UserID() UserID { return s.userID }
 
+// IsImport returns whether this scene is a contact import
+func (s *Scene) IsImport() bool { return s.isImport }
+
+// GroupChangeCause returns the cause of any group changes in this scene
+func (s *Scene) GroupChangeCause() GroupChangeCause {
+	if s.session != nil {
+		return GroupChangeCauseFlow
+	} else if s.isImport {
+		return GroupChangeCauseImport
+	}
+	return GroupChangeCauseModifier
+}
+
 // AppendToEventPreCommitHook adds a new event to be handled by a pre commit hook
 func (s *Scene) AppendToEventPreCommitHook(hook EventCommitHook, event any) {
 	s.preCommits[hook] = append(s.preCommits[hook], event)
@@ -188,10 +202,15 @@ func ApplyEventPostCommitHooks(ctx context.Context, rt *runtime.Runtime, tx *sql
 
 // HandleAndCommitEvents takes a set of contacts and events, handles the events and applies any hooks, and commits everything
 func HandleAndCommitEvents(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, contactEvents map[*flows.Contact][]flows.Event) error {
+	return handleAndCommitEvents(ctx, rt, oa, userID, contactEvents, false)
+}
+
+func handleAndCommitEvents(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, contactEvents map[*flows.Contact][]flows.Event, isImport bool) error {
 	// create scenes for each contact
 	scenes := make([]*Scene, 0, len(contactEvents))
 	for contact := range contactEvents {
 		scene := NewSceneForContact(contact, userID)
+		scene.isImport = isImport
 		scenes = append(scenes, scene)
 	}
 
@@ -243,6 +262,22 @@ func HandleAndCommitEvents(ctx context.Context, rt *runtime.Runtime, oa *OrgAsse
 // Note that we don't load the user object from org assets because it's possible that the user isn't part
 // of the org, e.g. customer support.
 func ApplyModifiers(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, modifiersByContact map[*flows.Contact][]flows.Modifier) (map[*flows.Contact][]flows.Event, error) {
+	return applyModifiers(ctx, rt, oa, userID, modifiersByContact, false)
+}
+
+func applyModifiers(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, modifiersByContact map[*flows.Contact][]flows.Modifier, isImport bool) (map[*flows.Contact][]flows.Event, error) {
+	eventsByContact := modifyContacts(rt, oa, modifiersByContact)
+
+	err := handleAndCommitEvents(ctx, rt, oa, userID, eventsByContact, isImport)
+	if err != nil {
+		return nil, errors.Wrap(err, "error commiting events")
+	}
+
+	return eventsByContact, nil
+}
+
+// applies the given modifiers to the given contacts in memory only, returning the events generated for each contact
+func modifyContacts(rt *runtime.Runtime, oa *OrgAssets, modifiersByContact map[*flows.Contact][]flows.Modifier) map[*flows.Contact][]flows.Event {
 	// create an environment instance with location support
 	env := flows.NewAssetsEnvironment(oa.Env(), oa.SessionAssets().Locations())
 
@@ -259,12 +294,7 @@ func ApplyModifiers(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, use
 		eventsByContact[contact] = events
 	}
 
-	err := HandleAndCommitEvents(ctx, rt, oa, userID, eventsByContact)
-	if err != nil {
-		return nil, errors.Wrap(err, "error commiting events")
-	}
-
-	return eventsByContact, nil
+	return eventsByContact
 }
 
 // TypeSprintEnded is a pseudo event that lets add hooks for changes to a contacts current flow or flow history
@@ -3,11 +3,15 @@ package models
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/gocommon/stringsx"
+	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/redisx"
@@ -18,8 +22,27 @@ const (
 	recentContactsCap    = 5              // number of recent contacts we keep per segment
 	recentContactsExpire = time.Hour * 24 // how long we keep recent contacts
 	recentContactsKey    = "recent_contacts:%s"
+
+	pathStatsExpire   = time.Hour * 24 * 90           // how long we keep daily path stats
+	pathStatsKey      = "flow_path_stats:%s:%s"       // flow UUID and day, hash of node stats
+	pathOperandsKey   = "flow_path_operands:%s:%s:%s" // flow UUID, node UUID and day, hash of operand counts
+	pathOperandsCap   = 100                           // number of distinct operands we count per node per day
+	pathOperandMaxLen = 100
 )
 
+// increments the count of an operand unless that would exceed the number of distinct operands we count for the node,
+// in which case it's counted as other
+var incrOperandScript = redis.NewScript(2, `-- KEYS: [OperandsKey, StatsKey], ARGV: [Operand, Count, Cap, OtherField, TTL]
+local key = KEYS[1]
+if redis.call("HEXISTS", key, ARGV[1]) == 0 and redis.call("HLEN", key) >= tonumber(ARGV[3]) then
+	key = KEYS[2]
+	ARGV[1] = ARGV[4]
+end
+
+redis.call("HINCRBY", key, ARGV[1], ARGV[2])
+redis.call("EXPIRE", key, ARGV[5])
+`)
+
 var storeOperandsForTypes = map[string]bool{"wait_for_response": true, "split_by_expression": true, "split_by_contact_field": true, "split_by_run_result": true}
 
 type segmentID struct {
@@ -45,6 +68,7 @@ func RecordFlowStatistics(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx,
 	segmentIDs := make([]segmentID, 0, 10)
 	recentBySegment := make(map[segmentID][]*segmentContact, 10)
 	nodeTypeCache := make(map[flows.NodeUUID]string)
+	pathStats := newPathStats()
 
 	for i, sprint := range sprints {
 		session := sessions[i]
@@ -59,6 +83,8 @@ func RecordFlowStatistics(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx,
 				operand = seg.Operand()
 			}
 
+			pathStats.addSegment(session, seg, operand)
+
 			if _, seen := recentBySegment[segID]; !seen {
 				segmentIDs = append(segmentIDs, segID)
 			}
@@ -88,9 +114,96 @@ func RecordFlowStatistics(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx,
 		}
 	}
 
+	if err := pathStats.record(rc); err != nil {
+		return errors.Wrap(err, "error recording path stats")
+	}
+
 	return nil
 }
 
+type pathOperand struct {
+	flowUUID assets.FlowUUID
+	nodeUUID flows.NodeUUID
+	day      string
+	operand  string
+}
+
+// daily path stats accumulated from segments so that they can be written to redis in a single pipeline
+type pathStats struct {
+	counts   map[string]map[string]int // stats key > field > increment
+	operands map[pathOperand]int
+}
+
+func newPathStats() *pathStats {
+	return &pathStats{counts: make(map[string]map[string]int), operands: make(map[pathOperand]int)}
+}
+
+func (p *pathStats) incr(key, field string, n int) {
+	if p.counts[key] == nil {
+		p.counts[key] = make(map[string]int)
+	}
+	p.counts[key][field] += n
+}
+
+func (p *pathStats) addSegment(session flows.Session, seg flows.Segment, operand string) {
+	flowUUID, nodeUUID := seg.Flow().UUID(), seg.Node().UUID()
+	day := seg.Time().UTC().Format(time.DateOnly)
+	key := fmt.Sprintf(pathStatsKey, flowUUID, day)
+
+	// time spent on the node is from when the contact arrived there, which may have been in a previous sprint
+	if arrivedOn := nodeArrivedOn(session, flowUUID, nodeUUID, seg.Time()); !arrivedOn.IsZero() {
+		p.incr(key, "dwell_count:"+string(nodeUUID), 1)
+		p.incr(key, "dwell_ms:"+string(nodeUUID), int(seg.Time().Sub(arrivedOn).Milliseconds()))
+	}
+
+	operand = strings.ToLower(strings.TrimSpace(operand))
+	if operand != "" {
+		p.operands[pathOperand{flowUUID, nodeUUID, day, stringsx.Truncate(operand, pathOperandMaxLen)}]++
+	}
+}
+
+func (p *pathStats) record(rc redis.Conn) error {
+	if len(p.counts) == 0 && len(p.operands) == 0 {
+		return nil
+	}
+
+	expire := int(pathStatsExpire / time.Second)
+
+	for key, fields := range p.counts {
+		for field, n := range fields {
+			rc.Send("HINCRBY", key, field, n)
+		}
+		rc.Send("EXPIRE", key, expire)
+	}
+
+	for o, n := range p.operands {
+		operandsKey := fmt.Sprintf(pathOperandsKey, o.flowUUID, o.nodeUUID, o.day)
+		statsKey := fmt.Sprintf(pathStatsKey, o.flowUUID, o.day)
+		incrOperandScript.Send(rc, operandsKey, statsKey, o.operand, n, pathOperandsCap, "other_operands:"+string(o.nodeUUID), expire)
+	}
+
+	_, err := rc.Do("")
+	return err
+}
+
+// finds when a contact last arrived at the given node before the given time
+func nodeArrivedOn(session flows.Session, flowUUID assets.FlowUUID, nodeUUID flows.NodeUUID, before time.Time) time.Time {
+	var arrivedOn time.Time
+
+	for _, run := range session.Runs() {
+		if run.FlowReference().UUID != flowUUID {
+			continue
+		}
+		for _, step := range run.Path() {
+			if step.NodeUUID() == nodeUUID && !step.ArrivedOn().After(before) && step.ArrivedOn().After(arrivedOn) {
+				arrivedOn = step.ArrivedOn()
+			}
+		}
+	}
+
+	return arrivedOn
+}
+
 func getNodeUIType(flow flows.Flow, node flows.Node, cache map[flows.NodeUUID]string) string {
 	uiType, cached := cache[node.UUID()]
 	if cached {
@@ -102,3 +215,139 @@ func getNodeUIType(flow flows.Flow, node flows.Node, cache map[flows.NodeUUID]st
 	cache[node.UUID()] = value
 	return value
 }
+
+// FlowNodeStats is the path analytics for a single node of a flow on a single day
+type FlowNodeStats struct {
+	Exits         int            `json:"exits"`
+	AverageDwell  float64        `json:"average_dwell"` // seconds between arriving at the node and exiting it
+	Dropoffs      int            `json:"dropoffs"`      // runs which ended on this node without completing
+	Operands      map[string]int `json:"operands,omitempty"`
+	OtherOperands int            `json:"other_operands,omitempty"` // operands not counted individually
+}
+
+// FlowPathDay is the path analytics for a flow on a single day (UTC)
+type FlowPathDay struct {
+	Day   string                            `json:"day"`
+	Nodes map[flows.NodeUUID]*FlowNodeStats `json:"nodes"`
+}
+
+func (d *FlowPathDay) node(uuid flows.NodeUUID) *FlowNodeStats {
+	n := d.Nodes[uuid]
+	if n == nil {
+		n = &FlowNodeStats{}
+		d.Nodes[uuid] = n
+	}
+	return n
+}
+
+const sqlSelectFlowDropoffs = `
+  SELECT current_node_uuid, to_char(exited_on AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*) AS count
+    FROM flows_flowrun
+   WHERE flow_id = $1 AND status IN ('I', 'X', 'F') AND current_node_uuid IS NOT NULL AND exited_on >= $2 AND exited_on < $3
+GROUP BY 1, 2`
+
+// LoadFlowPathStats loads the daily path analytics for the given flow for each day from since to until inclusive,
+// omitting days with no activity. Stats are only kept in redis for 90 days so earlier days will be empty.
+func LoadFlowPathStats(ctx context.Context, rt *runtime.Runtime, flowID FlowID, flow flows.Flow, since, until time.Time) ([]*FlowPathDay, error) {
+	since, until = since.UTC().Truncate(time.Hour*24), until.UTC().Truncate(time.Hour*24)
+
+	days := make([]*FlowPathDay, 0)
+	dayByKey := make(map[string]*FlowPathDay)
+
+	for d := since; !d.After(until); d = d.AddDate(0, 0, 1) {
+		day := &FlowPathDay{Day: d.Format(time.DateOnly), Nodes: make(map[flows.NodeUUID]*FlowNodeStats)}
+		days = append(days, day)
+		dayByKey[day.Day] = day
+	}
+
+	// operands are only counted for nodes with routers
+	routerNodes := make([]flows.NodeUUID, 0)
+	for _, n := range flow.Nodes() {
+		if n.Router() != nil {
+			routerNodes = append(routerNodes, n.UUID())
+		}
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	for _, day := range days {
+		rc.Send("HGETALL", fmt.Sprintf(pathStatsKey, flow.UUID(), day.Day))
+		for _, nodeUUID := range routerNodes {
+			rc.Send("HGETALL", fmt.Sprintf(pathOperandsKey, flow.UUID(), nodeUUID, day.Day))
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return nil, errors.Wrap(err, "error requesting path stats")
+	}
+
+	for _, day := range days {
+		stats, err := redis.StringMap(rc.Receive())
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading path stats")
+		}
+
+		dwellMS := make(map[flows.NodeUUID]int)
+
+		for field, value := range stats {
+			stat, nodeUUID, _ := strings.Cut(field, ":")
+			n, _ := strconv.Atoi(value)
+
+			switch stat {
+			case "dwell_count":
+				day.node(flows.NodeUUID(nodeUUID)).Exits = n
+			case "dwell_ms":
+				dwellMS[flows.NodeUUID(nodeUUID)] = n
+			case "other_operands":
+				day.node(flows.NodeUUID(nodeUUID)).OtherOperands = n
+			}
+		}
+
+		for nodeUUID, ms := range dwellMS {
+			if node := day.node(nodeUUID); node.Exits > 0 {
+				node.AverageDwell = float64(ms) / float64(node.Exits) / 1000
+			}
+		}
+
+		for _, nodeUUID := range routerNodes {
+			operands, err := redis.IntMap(rc.Receive())
+			if err != nil {
+				return nil, errors.Wrap(err, "error reading path operands")
+			}
+			if len(operands) > 0 {
+				day.node(nodeUUID).Operands = operands
+			}
+		}
+	}
+
+	rows, err := rt.ReadonlyDB.QueryContext(ctx, sqlSelectFlowDropoffs, flowID, since, until.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying flow dropoffs")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nodeUUID flows.NodeUUID
+		var dayKey string
+		var count int
+		if err := rows.Scan(&nodeUUID, &dayKey, &count); err != nil {
+			return nil, errors.Wrap(err, "error scanning flow dropoffs")
+		}
+		if day := dayByKey[dayKey]; day != nil {
+			day.node(nodeUUID).Dropoffs = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error querying flow dropoffs")
+	}
+
+	// only include days with activity
+	active := make([]*FlowPathDay, 0, len(days))
+	for _, day := range days {
+		if len(day.Nodes) > 0 {
+			active = append(active, day)
+		}
+	}
+
+	return active, nil
+}
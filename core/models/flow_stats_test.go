@@ -3,13 +3,16 @@ package models_test
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/random"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/test"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/redisx/assertredis"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,6 +24,9 @@ func TestRecordFlowStatistics(t *testing.T) {
 	defer random.SetGenerator(random.DefaultGenerator)
 	random.SetGenerator(random.NewSeededGenerator(123))
 
+	defer dates.SetNowSource(dates.DefaultNowSource)
+	dates.SetNowSource(dates.NewSequentialNowSource(time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)))
+
 	assetsJSON, err := os.ReadFile("testdata/flow_stats_test.json")
 	require.NoError(t, err)
 
@@ -36,6 +42,7 @@ func TestRecordFlowStatistics(t *testing.T) {
 
 	assertredis.Keys(t, rt.RP, "*", []string{
 		"recent_contacts:5fd2e537-0534-4c12-8425-bef87af09d46:072b95b3-61c3-4e0e-8dd1-eb7481083f94", // "what's your fav color" -> color split
+		"flow_path_stats:19eab6aa-4a88-42a1-8882-b9956823c680:2024-03-15",
 	})
 
 	// all 3 contacts went from first msg to the color split - no operands recorded for this segment
@@ -65,6 +72,9 @@ func TestRecordFlowStatistics(t *testing.T) {
 		"recent_contacts:0a4f2ea9-c47f-4e9c-a242-89ae5b38d679:072b95b3-61c3-4e0e-8dd1-eb7481083f94", // "sorry I don't know that color" -> color split
 		"recent_contacts:97cd44ce-dec2-4e19-8ca2-4e20db51dc08:0e1fe072-6f03-4f29-98aa-7bedbe930dab", // "X is a great color" -> split by expression
 		"recent_contacts:614e7451-e0bd-43d9-b317-2aded3c8d790:a1e649db-91e0-47c4-ab14-eba0d1475116", // "you have X tickets" -> group split
+		"flow_path_stats:19eab6aa-4a88-42a1-8882-b9956823c680:2024-03-15",
+		"flow_path_operands:19eab6aa-4a88-42a1-8882-b9956823c680:072b95b3-61c3-4e0e-8dd1-eb7481083f94:2024-03-15", // color split
+		"flow_path_operands:19eab6aa-4a88-42a1-8882-b9956823c680:0e1fe072-6f03-4f29-98aa-7bedbe930dab:2024-03-15", // split by expression
 	})
 
 	// check recent operands for color split :: Blue exit -> next node
@@ -81,4 +91,21 @@ func TestRecordFlowStatistics(t *testing.T) {
 	assertredis.ZRange(t, rt.RP, "recent_contacts:2b698218-87e5-4ab8-922e-e65f91d12c10:88d8bf00-51ce-4e5e-aae8-4f957a0761a0", 0, -1,
 		[]string{"2MsZZ/N3TH|123|0", "KKLrT60Tr9|234|0"},
 	)
+
+	flow, err := sa1.Flows().Get("19eab6aa-4a88-42a1-8882-b9956823c680")
+	require.NoError(t, err)
+
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	days, err := models.LoadFlowPathStats(ctx, rt, models.NilFlowID, flow, day.AddDate(0, 0, -1), day)
+	require.NoError(t, err)
+	require.Len(t, days, 1)
+	assert.Equal(t, "2024-03-15", days[0].Day)
+
+	// all 4 responses to the color question exited the color split, with operands counted case-insensitively
+	colorSplit := days[0].Nodes["072b95b3-61c3-4e0e-8dd1-eb7481083f94"]
+	require.NotNil(t, colorSplit)
+	assert.Equal(t, 4, colorSplit.Exits)
+	assert.Greater(t, colorSplit.AverageDwell, 0.0)
+	assert.Equal(t, map[string]int{"blue": 2, "teal": 1, "azure": 1}, colorSplit.Operands)
+	assert.Equal(t, 0, colorSplit.OtherOperands)
 }
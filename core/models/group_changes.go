@@ -0,0 +1,210 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/null/v3"
+	"github.com/pkg/errors"
+)
+
+// ResthookSlugGroupMembership is the slug of the resthook which, if an org has it, is notified of changes to group
+// membership. Each event POSTed to its subscribers is a GroupChangeEventData payload like:
+//
+//	{
+//	  "group": {"uuid": "c153e265-f7c9-4539-9dbc-9b358714b638", "name": "Doctors"},
+//	  "direction": "added",
+//	  "cause": "flow",
+//	  "contacts": [{"uuid": "6393abc0-283d-4c9b-a1b3-641a035c34bf", "name": "Cathy"}],
+//	  "created_on": "2023-04-03T12:00:00Z"
+//	}
+//
+// where direction is "added" or "removed" and cause is one of "flow", "modifier", "smart_group" or "import".
+const ResthookSlugGroupMembership = "group-membership"
+
+// GroupChangeDirection is whether a contact was added to or removed from a group
+type GroupChangeDirection string
+
+const (
+	GroupChangeAdded   = GroupChangeDirection("added")
+	GroupChangeRemoved = GroupChangeDirection("removed")
+)
+
+// GroupChangeCause is what caused a change to group membership
+type GroupChangeCause string
+
+const (
+	GroupChangeCauseFlow       = GroupChangeCause("flow")
+	GroupChangeCauseModifier   = GroupChangeCause("modifier")
+	GroupChangeCauseSmartGroup = GroupChangeCause("smart_group")
+	GroupChangeCauseImport     = GroupChangeCause("import")
+)
+
+// GroupChange is a contact being added to or removed from a group
+type GroupChange struct {
+	ContactID ContactID            `db:"contact_id"`
+	GroupID   GroupID              `db:"group_id"`
+	Direction GroupChangeDirection `db:"direction"`
+	Cause     GroupChangeCause     `db:"cause"`
+}
+
+// NewGroupChangesForAdds creates group changes for the given group additions
+func NewGroupChangesForAdds(adds []*GroupAdd, cause GroupChangeCause) []*GroupChange {
+	changes := make([]*GroupChange, len(adds))
+	for i, a := range adds {
+		changes[i] = &GroupChange{ContactID: a.ContactID, GroupID: a.GroupID, Direction: GroupChangeAdded, Cause: cause}
+	}
+	return changes
+}
+
+// NewGroupChangesForRemoves creates group changes for the given group removals
+func NewGroupChangesForRemoves(removes []*GroupRemove, cause GroupChangeCause) []*GroupChange {
+	changes := make([]*GroupChange, len(removes))
+	for i, r := range removes {
+		changes[i] = &GroupChange{ContactID: r.ContactID, GroupID: r.GroupID, Direction: GroupChangeRemoved, Cause: cause}
+	}
+	return changes
+}
+
+// max number of contacts included in a single group change event
+const maxGroupChangeEventContacts = 100
+
+// GroupChangeEventData is the payload of a group change event, which is a batch of contacts added to or removed from a
+// group for the same cause
+type GroupChangeEventData struct {
+	Group     *assets.GroupReference    `json:"group"`
+	Direction GroupChangeDirection      `json:"direction"`
+	Cause     GroupChangeCause          `json:"cause"`
+	Contacts  []*flows.ContactReference `json:"contacts"`
+	CreatedOn time.Time                 `json:"created_on"`
+}
+
+type groupChangeKey struct {
+	groupID   GroupID
+	direction GroupChangeDirection
+	cause     GroupChangeCause
+}
+
+const sqlSelectContactReferences = `SELECT id, uuid, name FROM contacts_contact WHERE id = ANY($1)`
+
+// InsertGroupChangeEvents publishes the given changes to group membership as webhook events for the org's group
+// membership resthook, with one event per group, direction and cause rather than one per contact. Nothing is inserted
+// if the org doesn't have that resthook or it has no subscribers. Events are delivered to subscribers by the
+// deliver_group_changes cron, which tracks the delivery status of each event and retries failed deliveries.
+func InsertGroupChangeEvents(ctx context.Context, db DBorTx, oa *OrgAssets, changes []*GroupChange) error {
+	resthook := oa.ResthookBySlug(ResthookSlugGroupMembership)
+	if resthook == nil || len(resthook.Subscribers()) == 0 || len(changes) == 0 {
+		return nil
+	}
+
+	// batch up contacts by group change, keeping the order of the changes
+	keys := make([]groupChangeKey, 0, 2)
+	contactsByKey := make(map[groupChangeKey][]ContactID)
+	contactIDs := make([]ContactID, 0, len(changes))
+	for _, c := range changes {
+		k := groupChangeKey{groupID: c.GroupID, direction: c.Direction, cause: c.Cause}
+		if _, seen := contactsByKey[k]; !seen {
+			keys = append(keys, k)
+		}
+		contactsByKey[k] = append(contactsByKey[k], c.ContactID)
+		contactIDs = append(contactIDs, c.ContactID)
+	}
+
+	refs, err := loadContactReferences(ctx, db, contactIDs)
+	if err != nil {
+		return err
+	}
+
+	now := dates.Now()
+	events := make([]*WebhookEvent, 0, len(keys))
+
+	for _, k := range keys {
+		group := oa.GroupByID(k.groupID)
+		if group == nil {
+			continue
+		}
+
+		ids := contactsByKey[k]
+		for i := 0; i < len(ids); i += maxGroupChangeEventContacts {
+			batch := ids[i:min(i+maxGroupChangeEventContacts, len(ids))]
+
+			data := &GroupChangeEventData{
+				Group:     assets.NewGroupReference(group.UUID(), group.Name()),
+				Direction: k.direction,
+				Cause:     k.cause,
+				Contacts:  make([]*flows.ContactReference, 0, len(batch)),
+				CreatedOn: now,
+			}
+			for _, id := range batch {
+				if ref := refs[id]; ref != nil {
+					data.Contacts = append(data.Contacts, ref)
+				}
+			}
+			if len(data.Contacts) == 0 {
+				continue
+			}
+
+			event := NewWebhookEvent(oa.OrgID(), resthook.ID(), string(jsonx.MustMarshal(data)), now)
+			event.e.Status = null.String(WebhookEventStatusPending)
+			event.e.NextAttempt = &now
+			events = append(events, event)
+		}
+	}
+
+	return errors.Wrap(InsertWebhookEvents(ctx, db, events), "error inserting group change events")
+}
+
+// loads references to the given contacts keyed by their ids
+func loadContactReferences(ctx context.Context, db DBorTx, ids []ContactID) (map[ContactID]*flows.ContactReference, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectContactReferences, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying contacts for group changes")
+	}
+	defer rows.Close()
+
+	refs := make(map[ContactID]*flows.ContactReference, len(ids))
+	for rows.Next() {
+		var id ContactID
+		var uuid flows.ContactUUID
+		var name null.String
+		if err := rows.Scan(&id, &uuid, &name); err != nil {
+			return nil, errors.Wrap(err, "error scanning contact for group changes")
+		}
+		refs[id] = flows.NewContactReference(uuid, string(name))
+	}
+
+	return refs, errors.Wrap(rows.Err(), "error iterating contacts for group changes")
+}
+
+const sqlQueueGroupChangeEvents = `
+   UPDATE api_webhookevent
+      SET status = 'Q', next_attempt = $3
+    WHERE id IN (
+           SELECT e.id
+             FROM api_webhookevent e
+             JOIN api_resthook r ON r.id = e.resthook_id
+            WHERE r.slug = $1 AND e.status IN ('P', 'Q') AND e.next_attempt <= NOW()
+         ORDER BY e.next_attempt, e.id
+            LIMIT $2
+         )
+RETURNING id, data, resthook_id, org_id, created_on`
+
+// QueueGroupChangeEvents marks group change events which are due a delivery attempt as queued and returns them in the
+// order they were created. Events which were queued but not delivered after a while are assumed to have had their
+// delivery task lost and are returned again.
+func QueueGroupChangeEvents(ctx context.Context, db DBorTx, limit int) ([]*WebhookEvent, error) {
+	events, err := loadWebhookEvents(ctx, db, sqlQueueGroupChangeEvents, ResthookSlugGroupMembership, limit, dates.Now().Add(webhookEventQueuedTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(events, func(a, b *WebhookEvent) int { return cmp.Compare(a.ID(), b.ID()) })
+	return events, nil
+}
@@ -0,0 +1,101 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertGroupChangeEvents(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer func() {
+		rt.DB.MustExec(`DELETE FROM api_webhookevent`)
+		rt.DB.MustExec(`DELETE FROM api_resthooksubscriber`)
+		rt.DB.MustExec(`DELETE FROM api_resthook`)
+	}()
+
+	changes := []*models.GroupChange{
+		{ContactID: testdata.Cathy.ID, GroupID: testdata.DoctorsGroup.ID, Direction: models.GroupChangeAdded, Cause: models.GroupChangeCauseFlow},
+		{ContactID: testdata.Bob.ID, GroupID: testdata.TestersGroup.ID, Direction: models.GroupChangeRemoved, Cause: models.GroupChangeCauseImport},
+		{ContactID: testdata.George.ID, GroupID: testdata.DoctorsGroup.ID, Direction: models.GroupChangeAdded, Cause: models.GroupChangeCauseFlow},
+	}
+
+	// org doesn't have the resthook so nothing is inserted
+	oa := testdata.Org1.Load(rt)
+	err := models.InsertGroupChangeEvents(ctx, rt.DB, oa, changes)
+	assert.NoError(t, err)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent`).Returns(0)
+
+	// create the resthook but without any subscribers
+	var resthookID models.ResthookID
+	rt.DB.Get(&resthookID, `INSERT INTO api_resthook(is_active, slug, org_id, created_on, modified_on, created_by_id, modified_by_id) VALUES(TRUE, 'group-membership', 1, NOW(), NOW(), 1, 1) RETURNING id;`)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshResthooks)
+	require.NoError(t, err)
+
+	err = models.InsertGroupChangeEvents(ctx, rt.DB, oa, changes)
+	assert.NoError(t, err)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent`).Returns(0)
+
+	// now add a subscriber
+	rt.DB.MustExec(`INSERT INTO api_resthooksubscriber(is_active, created_on, modified_on, target_url, created_by_id, modified_by_id, resthook_id) VALUES(TRUE, NOW(), NOW(), 'http://example.com/', 1, 1, $1);`, resthookID)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshResthooks)
+	require.NoError(t, err)
+
+	err = models.InsertGroupChangeEvents(ctx, rt.DB, oa, changes)
+	assert.NoError(t, err)
+
+	// changes are batched into one event per group, direction and cause
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent WHERE org_id = $1 AND resthook_id = $2 AND action = 'POST'`, testdata.Org1.ID, resthookID).Returns(2)
+	assertdb.Query(t, rt.DB, `SELECT data::jsonb->'contacts' FROM api_webhookevent WHERE data::jsonb->'group'->>'uuid' = $1 AND data::jsonb->>'direction' = 'added' AND data::jsonb->>'cause' = 'flow'`, testdata.DoctorsGroup.UUID).
+		Returns(fmt.Sprintf(`[{"name": "Cathy", "uuid": "%s"}, {"name": "George", "uuid": "%s"}]`, testdata.Cathy.UUID, testdata.George.UUID))
+	assertdb.Query(t, rt.DB, `SELECT data::jsonb->'contacts'->0->>'uuid' FROM api_webhookevent WHERE data::jsonb->'group'->>'uuid' = $1 AND data::jsonb->>'direction' = 'removed' AND data::jsonb->>'cause' = 'import'`, testdata.TestersGroup.UUID).Returns(string(testdata.Bob.UUID))
+
+	// and are pending delivery
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent WHERE status = 'P' AND attempts = 0 AND next_attempt IS NOT NULL`).Returns(2)
+
+	// queuing them for delivery returns them in order and they can't be queued again until the queued timeout
+	events, err := models.QueueGroupChangeEvents(ctx, rt.DB, 100)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, testdata.Org1.ID, events[0].OrgID())
+	assert.Equal(t, resthookID, events[0].ResthookID())
+	assert.Less(t, events[0].ID(), events[1].ID())
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent WHERE status = 'Q'`).Returns(2)
+
+	requeued, err := models.QueueGroupChangeEvents(ctx, rt.DB, 100)
+	require.NoError(t, err)
+	assert.Len(t, requeued, 0)
+
+	rt.DB.MustExec(`UPDATE api_webhookevent SET next_attempt = NOW() - INTERVAL '1 minute'`)
+
+	requeued, err = models.QueueGroupChangeEvents(ctx, rt.DB, 1)
+	require.NoError(t, err)
+	assert.Len(t, requeued, 1)
+
+	// record the outcomes of delivering them
+	err = models.MarkWebhookEventsDelivered(ctx, rt.DB, []models.WebhookEventID{events[0].ID()})
+	require.NoError(t, err)
+	err = models.MarkWebhookEventsErrored(ctx, rt.DB, []models.WebhookEventID{events[1].ID()}, 2)
+	require.NoError(t, err)
+
+	assertdb.Query(t, rt.DB, `SELECT status, attempts FROM api_webhookevent WHERE id = $1`, events[0].ID()).Columns(map[string]any{"status": "D", "attempts": int64(1)})
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent WHERE id = $1 AND status = 'P' AND attempts = 1 AND next_attempt > NOW()`, events[1].ID()).Returns(1)
+
+	err = models.MarkWebhookEventsErrored(ctx, rt.DB, []models.WebhookEventID{events[1].ID()}, 2)
+	require.NoError(t, err)
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent WHERE id = $1 AND status = 'F' AND attempts = 2 AND next_attempt IS NULL`, events[1].ID()).Returns(1)
+
+	events, err = models.LoadWebhookEvents(ctx, rt.DB, testdata.Org1.ID, []models.WebhookEventID{events[1].ID()})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
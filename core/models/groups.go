@@ -3,11 +3,18 @@ package models
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/pkg/errors"
 )
@@ -60,6 +67,288 @@ func (g *Group) Status() GroupStatus { return g.Status_ }
 // Type returns the type of this group
 func (g *Group) Type() GroupType { return g.Type_ }
 
+// matches conditions with relative date or age values, see RelativeDateCondition
+var relativeConditionRegex = regexp.MustCompile(`(?i)\b([a-z][a-z0-9_.]*)\s*(<=|>=|<|>|!=|=)\s*("\s*(today|(\d+)\s+(day|week|month|year)s?\s+ago)\s*"|today\b|(\d+)\b)`)
+
+// RelativeDateCondition is a condition in a query which compares a date property with a date relative to the current
+// date, or which compares the age given by a date property with a number. For the latter, Property is the date property.
+//
+// Queries can compare date properties, i.e. created_on, last_seen_on or a date field, with dates relative to the
+// current day in the org's timezone, which contactql itself doesn't support and so are resolved before queries are
+// parsed. Relative dates are written as `today` or as a quoted number of days, weeks, months or years ago:
+//
+//	last_seen_on < "30 days ago"
+//	joined = today
+//	fields.joined >= "1 month ago"
+//
+// The age in whole years given by a date property can also be compared with a number by adding .age to the property:
+//
+//	birthdate.age >= 18
+//	fields.birthdate.age = 21
+//
+// Relative dates and ages inside other quoted values are left as they are.
+type RelativeDateCondition struct {
+	Property string
+	Operator string
+
+	start, end int // position of the text in the query which is replaced when resolving
+	num        int
+	unit       string
+	age        bool
+}
+
+// Boundaries returns the dates on the given day where a contact's value for the property starts or stops matching
+// this condition
+func (c *RelativeDateCondition) Boundaries(day dates.Date) []dates.Date {
+	if !c.age {
+		return []dates.Date{c.dateOn(day, c.num)}
+	}
+
+	switch c.Operator {
+	case ">=", "<":
+		return []dates.Date{c.dateOn(day, c.num)}
+	case ">", "<=":
+		return []dates.Date{c.dateOn(day, c.num+1)}
+	default:
+		return []dates.Date{c.dateOn(day, c.num+1), c.dateOn(day, c.num)}
+	}
+}
+
+// returns the date which is num of our unit before the given day
+func (c *RelativeDateCondition) dateOn(day dates.Date, num int) dates.Date {
+	t := time.Date(day.Year, day.Month, day.Day, 0, 0, 0, 0, time.UTC)
+
+	switch c.unit {
+	case "day":
+		t = t.AddDate(0, 0, -num)
+	case "week":
+		t = t.AddDate(0, 0, -7*num)
+	case "month":
+		t = t.AddDate(0, -num, 0)
+	case "year":
+		t = t.AddDate(-num, 0, 0)
+	}
+
+	return dates.ExtractDate(t)
+}
+
+// returns the text which replaces this condition's relative value when resolved on the given day
+func (c *RelativeDateCondition) resolve(oa *OrgAssets, day dates.Date) string {
+	if !c.age {
+		return FormatQueryDate(oa, c.dateOn(day, c.num))
+	}
+
+	// an age of N means born on or before N years ago and after N+1 years ago
+	bounds := c.Boundaries(day)
+	switch c.Operator {
+	case ">=", ">":
+		return fmt.Sprintf("%s <= %s", c.Property, FormatQueryDate(oa, bounds[0]))
+	case "<", "<=":
+		return fmt.Sprintf("%s > %s", c.Property, FormatQueryDate(oa, bounds[0]))
+	case "=":
+		return fmt.Sprintf("(%s > %s AND %s <= %s)", c.Property, FormatQueryDate(oa, bounds[0]), c.Property, FormatQueryDate(oa, bounds[1]))
+	default:
+		return fmt.Sprintf("(%s <= %s OR %s > %s)", c.Property, FormatQueryDate(oa, bounds[0]), c.Property, FormatQueryDate(oa, bounds[1]))
+	}
+}
+
+// returns the normalized form of the relative value of this condition, or for an age, of the whole condition
+func (c *RelativeDateCondition) normalized(property string) string {
+	if c.age {
+		return fmt.Sprintf("%s.age %s %d", property, c.Operator, c.num)
+	}
+	if c.unit == "" {
+		return `"today"`
+	}
+	if c.num == 1 {
+		return fmt.Sprintf(`"1 %s ago"`, c.unit)
+	}
+	return fmt.Sprintf(`"%d %ss ago"`, c.num, c.unit)
+}
+
+// FindRelativeDateConditions finds the conditions in the given query which compare a date property with a relative
+// date or compare the age given by a date property. Membership of smart groups with these queries changes as time
+// passes and not only when contacts change.
+func FindRelativeDateConditions(oa *OrgAssets, query string) []*RelativeDateCondition {
+	conds := make([]*RelativeDateCondition, 0)
+	quoted := findQuotedStrings(query)
+
+	for _, m := range relativeConditionRegex.FindAllStringSubmatchIndex(query, -1) {
+		// ignore matches which start inside quoted values, and quoted values must be a whole string
+		if insideQuotedString(quoted, m[2]) || !isQueryValue(quoted, query, m[6], m[7]) {
+			continue
+		}
+
+		property := strings.ToLower(query[m[2]:m[3]])
+		cond := &RelativeDateCondition{Operator: query[m[4]:m[5]], start: m[6], end: m[7]}
+
+		if m[14] >= 0 {
+			// a number value is only relative when comparing an age, and can't be the start of a decimal
+			if !strings.HasSuffix(property, ".age") || (m[15] < len(query) && query[m[15]] == '.') {
+				continue
+			}
+			property = strings.TrimSuffix(property, ".age")
+			cond.num, _ = strconv.Atoi(query[m[14]:m[15]])
+			cond.unit = "year"
+			cond.age = true
+			cond.start = m[2]
+		} else if m[10] >= 0 {
+			cond.num, _ = strconv.Atoi(query[m[10]:m[11]])
+			cond.unit = strings.ToLower(query[m[12]:m[13]])
+		}
+
+		if !isDateProperty(oa, property) {
+			continue
+		}
+
+		cond.Property = property
+		conds = append(conds, cond)
+	}
+	return conds
+}
+
+// ResolveRelativeDates replaces the relative dates and ages in the given query with the dates they refer to on the
+// given day
+func ResolveRelativeDates(oa *OrgAssets, query string, day dates.Date) string {
+	conds := FindRelativeDateConditions(oa, query)
+	if len(conds) == 0 {
+		return query
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, c := range conds {
+		b.WriteString(query[last:c.start])
+		b.WriteString(c.resolve(oa, day))
+		last = c.end
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+// NormalizeRelativeQuery normalizes the given query using the given function, which should parse and format it, but
+// keeps its relative dates and ages rather than the dates they resolve to today. These are swapped for placeholder
+// dates before normalizing and swapped back afterwards.
+func NormalizeRelativeQuery(oa *OrgAssets, query string, normalize func(string) (string, error)) (string, error) {
+	conds := FindRelativeDateConditions(oa, query)
+	if len(conds) == 0 {
+		return normalize(query)
+	}
+
+	placeholders := make([]string, len(conds))
+
+	var b strings.Builder
+	last := 0
+	for i, c := range conds {
+		placeholders[i] = strconv.Quote(time.Date(1900, 1, 1+i, 0, 0, 0, 0, time.UTC).Format(time.RFC3339))
+
+		b.WriteString(query[last:c.start])
+		if c.age {
+			b.WriteString(c.Property + " = ")
+		}
+		b.WriteString(placeholders[i])
+		last = c.end
+	}
+	b.WriteString(query[last:])
+
+	normalized, err := normalize(b.String())
+	if err != nil {
+		return "", err
+	}
+
+	for i, c := range conds {
+		if c.age {
+			// the property may itself have been normalized so use it as found
+			placeheld := regexp.MustCompile(`([^\s()]+) = ` + regexp.QuoteMeta(placeholders[i]))
+			normalized = placeheld.ReplaceAllStringFunc(normalized, func(m string) string {
+				return c.normalized(placeheld.FindStringSubmatch(m)[1])
+			})
+		} else {
+			normalized = strings.ReplaceAll(normalized, placeholders[i], c.normalized(c.Property))
+		}
+	}
+	return normalized, nil
+}
+
+// FormatQueryDate formats the given date as a quoted query value which contactql will parse as that day in the org's
+// timezone regardless of the org's date format
+func FormatQueryDate(oa *OrgAssets, d dates.Date) string {
+	return strconv.Quote(d.Combine(dates.ZeroTimeOfDay, oa.Env().Timezone()).Format(time.RFC3339))
+}
+
+func isDateProperty(oa *OrgAssets, property string) bool {
+	if property == contactql.AttributeCreatedOn || property == contactql.AttributeLastSeenOn {
+		return true
+	}
+
+	field := oa.FieldByKey(strings.TrimPrefix(property, "fields."))
+	return field != nil && field.Type() == assets.FieldTypeDatetime
+}
+
+// finds the start and end positions of the quoted strings in the given query, where quotes can be escaped with \
+func findQuotedStrings(query string) [][2]int {
+	quoted := make([][2]int, 0)
+	start := -1
+
+	for i := 0; i < len(query); i++ {
+		if query[i] != '"' {
+			continue
+		}
+		if start < 0 {
+			start = i
+		} else if query[i-1] != '\\' {
+			quoted = append(quoted, [2]int{start, i + 1})
+			start = -1
+		}
+	}
+
+	// an unterminated string runs to the end of the query
+	if start >= 0 {
+		quoted = append(quoted, [2]int{start, len(query)})
+	}
+	return quoted
+}
+
+func insideQuotedString(quoted [][2]int, pos int) bool {
+	for _, q := range quoted {
+		if pos >= q[0] && pos < q[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// checks that the value at the given position is either a whole quoted string or outside of any quoted string
+func isQueryValue(quoted [][2]int, query string, start, end int) bool {
+	if query[start] != '"' {
+		return !insideQuotedString(quoted, start)
+	}
+	for _, q := range quoted {
+		if q[0] == start {
+			return q[1] == end
+		}
+	}
+	return false
+}
+
+// resolves the relative dates in the queries of groups as they're given to the engine, which can only evaluate
+// absolute dates
+func resolveGroupQueries(oa *OrgAssets, day dates.Date) []assets.Group {
+	resolved := make([]assets.Group, len(oa.groups))
+	for i, g := range oa.groups {
+		group := g.(*Group)
+		if group.Type() == GroupTypeSmart && group.Query() != "" {
+			if query := ResolveRelativeDates(oa, group.Query(), day); query != group.Query() {
+				withDates := *group
+				withDates.Query_ = query
+				g = &withDates
+			}
+		}
+		resolved[i] = g
+	}
+	return resolved
+}
+
 // loads the groups for the passed in org
 func loadGroups(ctx context.Context, db *sql.DB, orgID OrgID) ([]assets.Group, error) {
 	rows, err := db.QueryContext(ctx, sqlSelectGroupsByOrg, orgID)
@@ -138,6 +427,20 @@ func ContactIDsForGroupIDs(ctx context.Context, tx DBorTx, groupIDs []GroupID) (
 	return contactIDs, nil
 }
 
+// FilterContactIDsInGroup returns which of the given contacts are in the given group
+func FilterContactIDsInGroup(ctx context.Context, db DBorTx, groupID GroupID, contactIDs []ContactID) ([]ContactID, error) {
+	rows, err := db.QueryContext(ctx, `SELECT contact_id FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1 AND contact_id = ANY($2)`, groupID, pq.Array(contactIDs))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting contacts in group: %d", groupID)
+	}
+
+	ids, err := dbutil.ScanAllSlice(rows, make([]ContactID, 0, len(contactIDs)))
+	if err != nil {
+		return nil, errors.Wrap(err, "error scanning contact ids")
+	}
+	return ids, nil
+}
+
 const updateGroupStatusSQL = `UPDATE contacts_contactgroup SET status = $2 WHERE id = $1`
 
 // UpdateGroupStatus updates the group status for the passed in group
@@ -151,7 +454,7 @@ func UpdateGroupStatus(ctx context.Context, db DBorTx, groupID GroupID, status G
 
 // RemoveContactsFromGroupAndCampaigns removes the passed in contacts from the passed in group, taking care of also
 // removing them from any associated campaigns
-func RemoveContactsFromGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *OrgAssets, groupID GroupID, contactIDs []ContactID) error {
+func RemoveContactsFromGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *OrgAssets, groupID GroupID, contactIDs []ContactID, cause GroupChangeCause) error {
 	removeBatch := func(batch []ContactID) error {
 		tx, err := db.BeginTxx(ctx, nil)
 
@@ -173,6 +476,12 @@ func RemoveContactsFromGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *O
 			return errors.Wrapf(err, "error removing contacts from group: %d", groupID)
 		}
 
+		err = InsertGroupChangeEvents(ctx, tx, oa, NewGroupChangesForRemoves(removals, cause))
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error publishing group changes")
+		}
+
 		// remove from any campaign events
 		err = DeleteUnfiredEventsForGroupRemoval(ctx, tx, oa, batch, groupID)
 		if err != nil {
@@ -213,7 +522,7 @@ func RemoveContactsFromGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *O
 
 // AddContactsToGroupAndCampaigns takes care of adding the passed in contacts to the passed in group, updating any
 // associated campaigns as needed
-func AddContactsToGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *OrgAssets, groupID GroupID, contactIDs []ContactID) error {
+func AddContactsToGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *OrgAssets, groupID GroupID, contactIDs []ContactID, cause GroupChangeCause) error {
 	// we need session assets in order to recalculate campaign events
 	addBatch := func(batch []ContactID) error {
 		tx, err := db.BeginTxx(ctx, nil)
@@ -236,6 +545,12 @@ func AddContactsToGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *OrgAss
 			return errors.Wrapf(err, "error adding contacts to group: %d", groupID)
 		}
 
+		err = InsertGroupChangeEvents(ctx, tx, oa, NewGroupChangesForAdds(adds, cause))
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error publishing group changes")
+		}
+
 		// now load our contacts and add update their campaign events
 		contacts, err := LoadContacts(ctx, tx, oa, batch)
 		if err != nil {
@@ -290,3 +605,17 @@ func AddContactsToGroupAndCampaigns(ctx context.Context, db *sqlx.DB, oa *OrgAss
 
 	return nil
 }
+
+const sqlSelectOrgIDsWithSmartGroups = `
+SELECT DISTINCT g.org_id
+           FROM contacts_contactgroup g
+           JOIN orgs_org o ON o.id = g.org_id
+          WHERE g.group_type = 'Q' AND g.is_active = TRUE AND o.is_active = TRUE
+       ORDER BY g.org_id`
+
+// GetOrgIDsWithSmartGroups gets the ids of active orgs which have at least one smart group
+func GetOrgIDsWithSmartGroups(ctx context.Context, db *sqlx.DB) ([]OrgID, error) {
+	var orgIDs []OrgID
+	err := db.SelectContext(ctx, &orgIDs, sqlSelectOrgIDsWithSmartGroups)
+	return orgIDs, errors.Wrap(err, "error selecting orgs with smart groups")
+}
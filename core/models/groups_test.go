@@ -2,8 +2,11 @@ package models_test
 
 import (
 	"testing"
+	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
@@ -45,3 +48,76 @@ func TestLoadGroups(t *testing.T) {
 		assert.Equal(t, tc.query, group.Query())
 	}
 }
+
+func TestRelativeDates(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2023, 10, 5, 10, 0, 0, 0, time.UTC)))
+
+	testdata.InsertContactGroup(rt, testdata.Org1, "3d8c6c2a-5c3e-4a55-9b56-7e3e2c0c8b01", "Inactive", `last_seen_on < "30 days ago"`)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshGroups)
+	require.NoError(t, err)
+
+	tcs := []struct {
+		query    string
+		conds    []string
+		resolved string
+	}{
+		{`gender = F`, []string{}, `gender = F`},
+		{`joined > 2023-01-01`, []string{}, `joined > 2023-01-01`},
+		{`name = today`, []string{}, `name = today`}, // not a date property
+		{`joined = today`, []string{"joined ="}, `joined = "2023-10-04T00:00:00-07:00"`},
+		{`created_on < "2 weeks ago" AND gender = F`, []string{"created_on <"}, `created_on < "2023-09-20T00:00:00-07:00" AND gender = F`},
+		{`fields.joined >= "1 month ago" OR last_seen_on <= "1 year ago"`, []string{"fields.joined >=", "last_seen_on <="}, `fields.joined >= "2023-09-04T00:00:00-07:00" OR last_seen_on <= "2022-10-04T00:00:00-07:00"`},
+		{`name = "joined = today"`, []string{}, `name = "joined = today"`},                                                 // inside a quoted value
+		{`name = "bob \" joined = today"`, []string{}, `name = "bob \" joined = today"`},                                   // inside a quoted value with an escaped quote
+		{`name = "bob" OR joined = "today"`, []string{"joined ="}, `name = "bob" OR joined = "2023-10-04T00:00:00-07:00"`}, // after a quoted value
+		{`age > 18`, []string{}, `age > 18`},                                                                               // not an age condition
+		{`joined.age > 18.5`, []string{}, `joined.age > 18.5`},
+		{`joined.age >= 18`, []string{"joined >="}, `joined <= "2005-10-04T00:00:00-07:00"`},
+		{`joined.age > 18`, []string{"joined >"}, `joined <= "2004-10-04T00:00:00-07:00"`},
+		{`joined.age < 18`, []string{"joined <"}, `joined > "2005-10-04T00:00:00-07:00"`},
+		{`fields.joined.age = 21 AND gender = F`, []string{"fields.joined ="}, `(fields.joined > "2001-10-04T00:00:00-07:00" AND fields.joined <= "2002-10-04T00:00:00-07:00") AND gender = F`},
+		{`joined.age != 21`, []string{"joined !="}, `(joined <= "2001-10-04T00:00:00-07:00" OR joined > "2002-10-04T00:00:00-07:00")`},
+		{`name.age > 18`, []string{}, `name.age > 18`}, // not a date property
+	}
+
+	today := dates.NewDate(2023, 10, 4) // still the 4th in the org's timezone
+
+	for _, tc := range tcs {
+		conds := make([]string, 0)
+		for _, c := range models.FindRelativeDateConditions(oa, tc.query) {
+			conds = append(conds, c.Property+" "+c.Operator)
+		}
+		assert.Equal(t, tc.conds, conds, "conditions mismatch for query: %s", tc.query)
+		assert.Equal(t, tc.resolved, models.ResolveRelativeDates(oa, tc.query, today), "resolved query mismatch for query: %s", tc.query)
+	}
+
+	// normalizing keeps relative dates and ages
+	normalize := func(q string) (string, error) {
+		parsed, err := contactql.ParseQuery(oa.Env(), q, nil)
+		if err != nil {
+			return "", err
+		}
+		return parsed.String(), nil
+	}
+	normalized, err := models.NormalizeRelativeQuery(oa, `LAST_SEEN_ON<"30 Days Ago" and (JOINED.AGE>=18 or gender=F)`, normalize)
+	assert.NoError(t, err)
+	assert.Equal(t, `last_seen_on < "30 days ago" AND (joined.age >= 18 OR gender = "F")`, normalized)
+
+	normalized, err = models.NormalizeRelativeQuery(oa, `joined = today OR joined > "1 week ago"`, normalize)
+	assert.NoError(t, err)
+	assert.Equal(t, `joined = "today" OR joined > "1 week ago"`, normalized)
+
+	// groups given to the engine have their relative dates resolved
+	group := oa.GroupByUUID("3d8c6c2a-5c3e-4a55-9b56-7e3e2c0c8b01")
+	assert.Equal(t, `last_seen_on < "30 days ago"`, group.Query())
+
+	engineGroup := oa.SessionAssets().Groups().Get("3d8c6c2a-5c3e-4a55-9b56-7e3e2c0c8b01")
+	require.NotNil(t, engineGroup)
+	assert.Equal(t, `last_seen_on < "2023-09-04T00:00:00-07:00"`, engineGroup.Query())
+}
@@ -0,0 +1,351 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/goflow"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/null/v3"
+	"github.com/pkg/errors"
+)
+
+// ContactImportSourceID is the type for contact import source IDs
+type ContactImportSourceID int
+
+// NilContactImportSourceID is our constant for a nil import source id
+const NilContactImportSourceID = ContactImportSourceID(0)
+
+func (i *ContactImportSourceID) Scan(value any) error         { return null.ScanInt(value, i) }
+func (i ContactImportSourceID) Value() (driver.Value, error)  { return null.IntValue(i) }
+func (i *ContactImportSourceID) UnmarshalJSON(b []byte) error { return null.UnmarshalInt(b, i) }
+func (i ContactImportSourceID) MarshalJSON() ([]byte, error)  { return null.MarshalInt(i) }
+
+// ContactImportSourceFormat is the format of the file fetched from an import source
+type ContactImportSourceFormat string
+
+// import source format constants
+const (
+	ContactImportSourceFormatCSV  ContactImportSourceFormat = "csv"
+	ContactImportSourceFormatJSON ContactImportSourceFormat = "json"
+)
+
+// the number of records in each batch of an import, same as the web app uses
+const contactImportBatchSize = 100
+
+// ContactImportSource is a remote URL from which contacts are imported, optionally on a schedule
+type ContactImportSource struct {
+	ID           ContactImportSourceID     `db:"id"           json:"id"`
+	OrgID        OrgID                     `db:"org_id"       json:"org_id"`
+	URL          string                    `db:"url"          json:"url"`
+	Format       ContactImportSourceFormat `db:"format"       json:"format"`
+	Mode         ContactImportMode         `db:"mode"         json:"mode"`
+	ScheduleID   ScheduleID                `db:"schedule_id"  json:"schedule_id"`
+	CreatedByID  UserID                    `db:"created_by_id" json:"created_by_id"`
+	LastSyncedOn *time.Time                `db:"last_synced_on" json:"last_synced_on"`
+}
+
+const sqlSelectContactImportSource = `
+SELECT id, org_id, url, format, mode, schedule_id, created_by_id, last_synced_on
+  FROM contacts_contactimportsource
+ WHERE id = $1 AND is_active = TRUE`
+
+// LoadContactImportSource loads an active contact import source by ID
+func LoadContactImportSource(ctx context.Context, db DBorTx, id ContactImportSourceID) (*ContactImportSource, error) {
+	s := &ContactImportSource{}
+	if err := db.GetContext(ctx, s, sqlSelectContactImportSource, id); err != nil {
+		return nil, errors.Wrapf(err, "error loading contact import source id=%d", id)
+	}
+	return s, nil
+}
+
+// Fetch fetches the contents of this source using the same HTTP access config as webhooks so that disallowed networks
+// can't be reached
+func (s *ContactImportSource) Fetch(ctx context.Context, rt *runtime.Runtime) ([]byte, error) {
+	client, retries, access := goflow.HTTP(rt.Config)
+
+	req, err := httpx.NewRequest(http.MethodGet, s.URL, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	req = req.WithContext(ctx)
+
+	trace, err := httpx.DoTrace(client, req, retries, access, rt.Config.ImportSourceMaxBytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching import source %s", s.URL)
+	}
+	if trace.Response.StatusCode/100 != 2 {
+		return nil, errors.Errorf("error fetching import source %s: got status %d", s.URL, trace.Response.StatusCode)
+	}
+
+	return trace.ResponseBody, nil
+}
+
+// MarkSynced records that this source was just synced
+func (s *ContactImportSource) MarkSynced(ctx context.Context, db DBorTx) error {
+	now := dates.Now()
+	s.LastSyncedOn = &now
+
+	_, err := db.ExecContext(ctx, `UPDATE contacts_contactimportsource SET last_synced_on = $2 WHERE id = $1`, s.ID, s.LastSyncedOn)
+	return errors.Wrap(err, "error marking import source as synced")
+}
+
+// ParseContactImportSource parses the contents of an import source into contact specs. A JSON source is an array of
+// specs, and a CSV source has a header row with columns like UUID, Name, Language, URN:<scheme> and Field:<key>.
+func ParseContactImportSource(format ContactImportSourceFormat, data []byte) ([]*ContactSpec, error) {
+	switch format {
+	case ContactImportSourceFormatJSON:
+		var specs []*ContactSpec
+		if err := jsonx.Unmarshal(data, &specs); err != nil {
+			return nil, errors.Wrap(err, "error parsing JSON source")
+		}
+		for i, spec := range specs {
+			spec.ImportRow = i + 1
+		}
+		return specs, nil
+	case ContactImportSourceFormatCSV:
+		return parseContactImportCSV(data)
+	default:
+		return nil, errors.Errorf("unknown import source format: %s", format)
+	}
+}
+
+func parseContactImportCSV(data []byte) ([]*ContactSpec, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+
+	headers, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CSV header")
+	}
+	for i := range headers {
+		headers[i] = strings.ToLower(strings.TrimSpace(headers[i]))
+	}
+
+	specs := make([]*ContactSpec, 0, 100)
+	for row := 2; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading CSV row %d", row)
+		}
+
+		spec := &ContactSpec{Fields: make(map[string]string), ImportRow: row}
+
+		for i, value := range record {
+			if i >= len(headers) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			header := headers[i]
+
+			switch {
+			case header == "uuid" || header == "contact uuid":
+				spec.UUID = flows.ContactUUID(value)
+			case header == "name":
+				v := value
+				spec.Name = &v
+			case header == "language":
+				v := value
+				spec.Language = &v
+			case strings.HasPrefix(header, "urn:"):
+				if value != "" {
+					spec.URNs = append(spec.URNs, urns.URN(header[4:]+":"+value))
+				}
+			case strings.HasPrefix(header, "field:"):
+				spec.Fields[header[6:]] = value
+			}
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+// ContactSpecKey returns the key used to recognize the same record across syncs of a source, which is its UUID or
+// otherwise its first normalized URN. Records with neither have no key.
+func ContactSpecKey(spec *ContactSpec, country string) string {
+	if spec.UUID != "" {
+		return string(spec.UUID)
+	}
+	if len(spec.URNs) > 0 {
+		return string(spec.URNs[0].Normalize(country).Identity())
+	}
+	return ""
+}
+
+// ContactSpecHash returns a hash of the content of a spec, ignoring where in the source it came from
+func ContactSpecHash(spec *ContactSpec) string {
+	c := *spec
+	c.ImportRow = 0
+	c.ImportKey = ""
+	c.ImportHash = ""
+
+	// field maps are marshaled with sorted keys so this is stable
+	h := sha1.Sum(jsonx.MustMarshal(&c))
+	return hex.EncodeToString(h[:])
+}
+
+const sqlSelectContactImportSourceHashes = `SELECT record_key, hash FROM contacts_contactimportsourcehash WHERE source_id = $1`
+
+// LoadHashes loads the content hashes of the records last successfully imported from this source
+func (s *ContactImportSource) LoadHashes(ctx context.Context, db DBorTx) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, sqlSelectContactImportSourceHashes, s.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying import source hashes")
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]string)
+	var key, hash string
+	for rows.Next() {
+		if err := rows.Scan(&key, &hash); err != nil {
+			return nil, errors.Wrap(err, "error scanning import source hash")
+		}
+		hashes[key] = hash
+	}
+	return hashes, errors.Wrap(rows.Err(), "error iterating import source hashes")
+}
+
+// ChangedSpecs returns the specs which are new or have changed since the last sync, keying and hashing each of them
+func (s *ContactImportSource) ChangedSpecs(oa *OrgAssets, specs []*ContactSpec, hashes map[string]string) []*ContactSpec {
+	country := string(oa.Env().DefaultCountry())
+	changed := make([]*ContactSpec, 0, len(specs))
+
+	for _, spec := range specs {
+		spec.ImportKey = ContactSpecKey(spec, country)
+		spec.ImportHash = ContactSpecHash(spec)
+
+		// records we can't recognize in the next sync are always imported
+		if spec.ImportKey == "" || hashes[spec.ImportKey] != spec.ImportHash {
+			changed = append(changed, spec)
+		}
+	}
+	return changed
+}
+
+const sqlSelectUnfinishedSourceImport = `
+SELECT EXISTS(SELECT 1 FROM contacts_contactimport WHERE source_id = $1 AND status IN ('P', 'O'))`
+
+// HasUnfinishedImport returns whether an import from this source is still pending or processing
+func (s *ContactImportSource) HasUnfinishedImport(ctx context.Context, db DBorTx) (bool, error) {
+	var unfinished bool
+	err := db.GetContext(ctx, &unfinished, sqlSelectUnfinishedSourceImport, s.ID)
+	return unfinished, errors.Wrap(err, "error checking for unfinished imports")
+}
+
+const sqlInsertSourceContactImport = `
+INSERT INTO contacts_contactimport(org_id, file, original_filename, mappings, num_records, group_id, mode, dry_run, source_id, started_on, status, created_on, created_by_id, modified_on, modified_by_id, is_active)
+     VALUES($1, '', $2, '{}', $3, NULL, $4, FALSE, $5, $6, 'O', $6, $7, $6, $7, TRUE)
+  RETURNING id`
+
+const sqlInsertContactImportBatch = `
+INSERT INTO contacts_contactimportbatch(contact_import_id, status, specs, record_start, record_end, num_created, num_updated, num_skipped, num_errored, errors, results, finished_on)
+     VALUES($1, 'P', $2, $3, $4, 0, 0, 0, 0, '[]', NULL, NULL)
+  RETURNING id`
+
+// CreateImport creates a new contact import of the given specs from this source, split into batches
+func (s *ContactImportSource) CreateImport(ctx context.Context, db DB, specs []*ContactSpec) (*ContactImport, []*ContactImportBatch, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error starting transaction")
+	}
+
+	imp := &ContactImport{OrgID: s.OrgID, Status: ContactImportStatusProcessing, Mode: s.Mode, SourceID: s.ID, CreatedByID: s.CreatedByID}
+
+	if err := tx.GetContext(ctx, &imp.ID, sqlInsertSourceContactImport, s.OrgID, s.URL, len(specs), s.Mode, s.ID, dates.Now(), s.CreatedByID); err != nil {
+		tx.Rollback()
+		return nil, nil, errors.Wrap(err, "error inserting contact import")
+	}
+
+	batches := make([]*ContactImportBatch, 0, len(specs)/contactImportBatchSize+1)
+
+	for start := 0; start < len(specs); start += contactImportBatchSize {
+		end := start + contactImportBatchSize
+		if end > len(specs) {
+			end = len(specs)
+		}
+
+		b := &ContactImportBatch{ImportID: imp.ID, Status: ContactImportStatusPending, Specs: jsonx.MustMarshal(specs[start:end]), RecordStart: start, RecordEnd: end}
+
+		if err := tx.GetContext(ctx, &b.ID, sqlInsertContactImportBatch, b.ImportID, b.Specs, b.RecordStart, b.RecordEnd); err != nil {
+			tx.Rollback()
+			return nil, nil, errors.Wrap(err, "error inserting contact import batch")
+		}
+
+		batches = append(batches, b)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, errors.Wrap(err, "error committing contact import")
+	}
+
+	return imp, batches, nil
+}
+
+const sqlUpsertContactImportSourceHash = `
+INSERT INTO contacts_contactimportsourcehash(source_id, record_key, hash)
+     VALUES(:source_id, :record_key, :hash)
+ON CONFLICT(source_id, record_key) DO UPDATE SET hash = EXCLUDED.hash`
+
+type contactImportSourceHash struct {
+	SourceID ContactImportSourceID `db:"source_id"`
+	Key      string                `db:"record_key"`
+	Hash     string                `db:"hash"`
+}
+
+// SaveContactImportSourceHashes saves the content hashes of the records in the given completed batch which created or
+// updated a contact, so that they aren't imported again until they change. Errored and skipped records are retried by
+// the next sync.
+func SaveContactImportSourceHashes(ctx context.Context, db DBorTx, sourceID ContactImportSourceID, b *ContactImportBatch) error {
+	var specs []*ContactSpec
+	if err := jsonx.Unmarshal(b.Specs, &specs); err != nil {
+		return errors.Wrap(err, "error unmarshaling specs")
+	}
+
+	var results []*ContactImportResult
+	if len(b.Results) > 0 {
+		if err := json.Unmarshal(b.Results, &results); err != nil {
+			return errors.Wrap(err, "error unmarshaling results")
+		}
+	}
+
+	// a source can repeat a record, in which case the last one wins
+	byKey := make(map[string]*contactImportSourceHash, len(specs))
+	hashes := make([]*contactImportSourceHash, 0, len(specs))
+	for i, spec := range specs {
+		if spec.ImportKey == "" || i >= len(results) || (results[i].Outcome != ContactImportOutcomeCreated && results[i].Outcome != ContactImportOutcomeUpdated) {
+			continue
+		}
+		if h := byKey[spec.ImportKey]; h != nil {
+			h.Hash = spec.ImportHash
+			continue
+		}
+		h := &contactImportSourceHash{SourceID: sourceID, Key: spec.ImportKey, Hash: spec.ImportHash}
+		byKey[spec.ImportKey] = h
+		hashes = append(hashes, h)
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	return errors.Wrap(BulkQuery(ctx, "saved import source hashes", db, sqlUpsertContactImportSourceHash, hashes), "error saving import source hashes")
+}
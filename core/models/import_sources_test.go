@@ -0,0 +1,141 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContactImportSource(t *testing.T) {
+	specs, err := models.ParseContactImportSource(models.ContactImportSourceFormatCSV, []byte(
+		"Contact UUID,Name,Language,URN:Tel,URN:Email,Field:Age\n"+
+			"6393abc0-283d-4c9b-a1b3-641a035c34bf,Cathy,eng,+16055741111,,30\n"+
+			",Ann, ,0788123123,ann@nyaruka.com,\n",
+	))
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+
+	assert.Equal(t, flows.ContactUUID("6393abc0-283d-4c9b-a1b3-641a035c34bf"), specs[0].UUID)
+	assert.Equal(t, "Cathy", *specs[0].Name)
+	assert.Equal(t, "eng", *specs[0].Language)
+	assert.Equal(t, []urns.URN{"tel:+16055741111"}, specs[0].URNs)
+	assert.Equal(t, map[string]string{"age": "30"}, specs[0].Fields)
+	assert.Equal(t, 2, specs[0].ImportRow)
+
+	assert.Equal(t, flows.ContactUUID(""), specs[1].UUID)
+	assert.Equal(t, "Ann", *specs[1].Name)
+	assert.Equal(t, "", *specs[1].Language)
+	assert.Equal(t, []urns.URN{"tel:0788123123", "email:ann@nyaruka.com"}, specs[1].URNs)
+	assert.Equal(t, map[string]string{"age": ""}, specs[1].Fields)
+	assert.Equal(t, 3, specs[1].ImportRow)
+
+	specs, err = models.ParseContactImportSource(models.ContactImportSourceFormatJSON, []byte(`[
+		{"name": "Bob", "urns": ["tel:+16055742222"], "fields": {"gender": "M"}},
+		{"name": "George"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	assert.Equal(t, "Bob", *specs[0].Name)
+	assert.Equal(t, 1, specs[0].ImportRow)
+	assert.Equal(t, 2, specs[1].ImportRow)
+
+	_, err = models.ParseContactImportSource(models.ContactImportSourceFormatJSON, []byte(`{"name": "Bob"}`))
+	assert.EqualError(t, err, "error parsing JSON source: json: cannot unmarshal object into Go value of type []*models.ContactSpec")
+
+	_, err = models.ParseContactImportSource("xml", []byte(`<contacts/>`))
+	assert.EqualError(t, err, "unknown import source format: xml")
+
+	// keys are UUIDs or normalized first URNs
+	assert.Equal(t, "6393abc0-283d-4c9b-a1b3-641a035c34bf", models.ContactSpecKey(&models.ContactSpec{UUID: "6393abc0-283d-4c9b-a1b3-641a035c34bf", URNs: []urns.URN{"tel:+16055741111"}}, "US"))
+	assert.Equal(t, "tel:+16055741111", models.ContactSpecKey(&models.ContactSpec{URNs: []urns.URN{"tel:(605) 574-1111"}}, "US"))
+	assert.Equal(t, "", models.ContactSpecKey(&models.ContactSpec{}, "US"))
+
+	// and hashes only consider the content of specs
+	name1, name2 := "Bob", "Robert"
+	spec1 := &models.ContactSpec{Name: &name1, Fields: map[string]string{"age": "30", "gender": "M"}, ImportRow: 2}
+	spec2 := &models.ContactSpec{Name: &name1, Fields: map[string]string{"gender": "M", "age": "30"}, ImportRow: 5}
+	spec3 := &models.ContactSpec{Name: &name2, Fields: map[string]string{"age": "30", "gender": "M"}, ImportRow: 2}
+	assert.Equal(t, models.ContactSpecHash(spec1), models.ContactSpecHash(spec2))
+	assert.NotEqual(t, models.ContactSpecHash(spec1), models.ContactSpecHash(spec3))
+}
+
+func TestContactImportSources(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	sourceID := testdata.InsertContactImportSource(rt, testdata.Org1, "http://rapidpro.io/contacts.csv", models.ContactImportSourceFormatCSV, models.NilScheduleID, testdata.Admin)
+
+	source, err := models.LoadContactImportSource(ctx, rt.DB, sourceID)
+	require.NoError(t, err)
+	assert.Equal(t, testdata.Org1.ID, source.OrgID)
+	assert.Equal(t, "http://rapidpro.io/contacts.csv", source.URL)
+	assert.Equal(t, models.ContactImportModeUpsert, source.Mode)
+	assert.Nil(t, source.LastSyncedOn)
+
+	specs, err := models.ParseContactImportSource(models.ContactImportSourceFormatJSON, []byte(`[
+		{"name": "Ann", "urns": ["tel:+16055740001"]},
+		{"name": "Ben", "urns": ["tel:+16055740002"]},
+		{"name": "Nobody"}
+	]`))
+	require.NoError(t, err)
+
+	hashes, err := source.LoadHashes(ctx, rt.DB)
+	require.NoError(t, err)
+	assert.Len(t, hashes, 0)
+
+	// with no hashes, everything has changed
+	changed := source.ChangedSpecs(oa, specs, hashes)
+	assert.Len(t, changed, 3)
+
+	imp, batches, err := source.CreateImport(ctx, rt.DB, changed)
+	require.NoError(t, err)
+	assert.Len(t, batches, 1)
+	assert.Equal(t, 0, batches[0].RecordStart)
+	assert.Equal(t, 3, batches[0].RecordEnd)
+
+	imp, err = models.LoadContactImport(ctx, rt.DB, imp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sourceID, imp.SourceID)
+
+	unfinished, err := source.HasUnfinishedImport(ctx, rt.DB)
+	require.NoError(t, err)
+	assert.True(t, unfinished)
+
+	batch, err := models.LoadContactImportBatch(ctx, rt.DB, batches[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, batch.Import(ctx, rt, imp))
+
+	batch, err = models.LoadContactImportBatch(ctx, rt.DB, batches[0].ID)
+	require.NoError(t, err)
+	batch.Results = []byte(`[{"outcome": "created"}, {"outcome": "skipped"}, {"outcome": "created"}]`)
+
+	// hashes are only saved for records with keys which created or updated a contact
+	err = models.SaveContactImportSourceHashes(ctx, rt.DB, sourceID, batch)
+	require.NoError(t, err)
+
+	assertdb.Query(t, rt.DB, `SELECT record_key FROM contacts_contactimportsourcehash WHERE source_id = $1`, sourceID).Returns("tel:+16055740001")
+
+	hashes, err = source.LoadHashes(ctx, rt.DB)
+	require.NoError(t, err)
+	assert.Len(t, hashes, 1)
+
+	// so now only the unchanged record is skipped
+	changed = source.ChangedSpecs(oa, specs, hashes)
+	assert.Len(t, changed, 2)
+	assert.Equal(t, "Ben", *changed[0].Name)
+	assert.Equal(t, "Nobody", *changed[1].Name)
+
+	require.NoError(t, source.MarkSynced(ctx, rt.DB))
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimportsource WHERE id = $1 AND last_synced_on IS NOT NULL`, sourceID).Returns(1)
+}
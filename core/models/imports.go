@@ -1,10 +1,15 @@
 package models
 
 import (
+	"bytes"
 	"context"
 	"database/sql/driver"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,8 +18,10 @@ import (
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
 	"github.com/nyaruka/goflow/flows/modifiers"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/null/v3"
@@ -43,19 +50,33 @@ const (
 	ContactImportStatusFailed     ContactImportStatus = "F"
 )
 
+// ContactImportMode is how an import treats contacts which do or don't already exist
+type ContactImportMode string
+
+// import mode constants
+const (
+	ContactImportModeUpsert ContactImportMode = "upsert" // create new contacts and update existing ones
+	ContactImportModeCreate ContactImportMode = "create" // only create new contacts, skipping existing ones
+	ContactImportModeUpdate ContactImportMode = "update" // only update existing contacts, skipping unknown ones
+)
+
 type ContactImport struct {
-	ID          ContactImportID     `db:"id"`
-	OrgID       OrgID               `db:"org_id"`
-	Status      ContactImportStatus `db:"status"`
-	CreatedByID UserID              `db:"created_by_id"`
-	FinishedOn  *time.Time          `db:"finished_on"`
+	ID          ContactImportID       `db:"id"`
+	OrgID       OrgID                 `db:"org_id"`
+	Status      ContactImportStatus   `db:"status"`
+	Mode        ContactImportMode     `db:"mode"`
+	DryRun      bool                  `db:"dry_run"`
+	CreatedByID UserID                `db:"created_by_id"`
+	FinishedOn  *time.Time            `db:"finished_on"`
+	ResultURL   null.String           `db:"result_url"`
+	SourceID    ContactImportSourceID `db:"source_id"`
 
 	// we fetch unique batch statuses concatenated as a string, see https://github.com/jmoiron/sqlx/issues/168
 	BatchStatuses string `db:"batch_statuses"`
 }
 
 var sqlLoadContactImport = `
-         SELECT i.id, i.org_id, i.status, i.created_by_id, i.finished_on, array_to_string(array_agg(DISTINCT b.status), '') AS "batch_statuses"
+         SELECT i.id, i.org_id, i.status, COALESCE(i.mode, 'upsert') AS mode, COALESCE(i.dry_run, FALSE) AS dry_run, i.created_by_id, i.finished_on, i.result_url, i.source_id, array_to_string(array_agg(DISTINCT b.status), '') AS "batch_statuses"
            FROM contacts_contactimport i
 LEFT OUTER JOIN contacts_contactimportbatch b ON b.contact_import_id = i.id
           WHERE i.id = $1
@@ -85,6 +106,60 @@ func (i *ContactImport) MarkFinished(ctx context.Context, db DBorTx, status Cont
 	return errors.Wrap(err, "error marking import as finished")
 }
 
+const sqlSelectContactImportResults = `
+  SELECT results
+    FROM contacts_contactimportbatch
+   WHERE contact_import_id = $1 AND results IS NOT NULL
+ORDER BY record_start`
+
+// WriteResultFile writes a CSV file of the results of every record in this import to attachment storage, and saves its
+// URL on the import
+func (i *ContactImport) WriteResultFile(ctx context.Context, rt *runtime.Runtime) error {
+	rows, err := rt.DB.QueryContext(ctx, sqlSelectContactImportResults, i.ID)
+	if err != nil {
+		return errors.Wrap(err, "error querying import results")
+	}
+	defer rows.Close()
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"Row", "Outcome", "Contact UUID", "Changes", "Reason"})
+
+	for rows.Next() {
+		var resultsJSON json.RawMessage
+		var results []*ContactImportResult
+
+		if err := rows.Scan(&resultsJSON); err != nil {
+			return errors.Wrap(err, "error scanning import results")
+		}
+		if err := jsonx.Unmarshal(resultsJSON, &results); err != nil {
+			return errors.Wrap(err, "error unmarshaling import results")
+		}
+
+		for _, r := range results {
+			w.Write([]string{strconv.Itoa(r.Row), string(r.Outcome), string(r.ContactUUID), strings.Join(r.Changes, ", "), r.Reason})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "error iterating import results")
+	}
+
+	w.Flush()
+
+	// include a random UUID so that the URL of the file can't be guessed from the org and import ids
+	path := filepath.Join(rt.Config.S3AttachmentsPrefix, "contact_imports", fmt.Sprint(i.OrgID), fmt.Sprintf("%d_%s_results.csv", i.ID, uuids.New()))
+
+	url, err := rt.AttachmentStorage.Put(ctx, path, "text/csv", buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "error storing import result file")
+	}
+
+	i.ResultURL = null.String(url)
+
+	_, err = rt.DB.ExecContext(ctx, `UPDATE contacts_contactimport SET result_url = $2 WHERE id = $1`, i.ID, i.ResultURL)
+	return errors.Wrap(err, "error saving import result file URL")
+}
+
 // ContactImportBatch is a batch of contacts within a larger import
 type ContactImportBatch struct {
 	ID       ContactImportBatchID `db:"id"`
@@ -99,39 +174,68 @@ type ContactImportBatch struct {
 	// results written after processing this batch
 	NumCreated int             `db:"num_created"`
 	NumUpdated int             `db:"num_updated"`
+	NumSkipped int             `db:"num_skipped"`
 	NumErrored int             `db:"num_errored"`
 	Errors     json.RawMessage `db:"errors"`
+	Results    json.RawMessage `db:"results"`
 	FinishedOn *time.Time      `db:"finished_on"`
 }
 
-// Import does the actual import of this batch
-func (b *ContactImportBatch) Import(ctx context.Context, rt *runtime.Runtime, orgID OrgID, userID UserID) error {
+// Import does the actual import of this batch using the mode of the given import. If the import is a dry run then
+// every record is validated and its result recorded, but nothing is written.
+func (b *ContactImportBatch) Import(ctx context.Context, rt *runtime.Runtime, imp *ContactImport) error {
 	// if any error occurs this batch should be marked as failed
-	if err := b.tryImport(ctx, rt, orgID, userID); err != nil {
-		b.markFailed(ctx, rt.DB)
+	if err := b.tryImport(ctx, rt, imp); err != nil {
+		if err := b.markFailed(ctx, rt.DB); err != nil {
+			slog.Error("error marking import batch as failed", "error", err, "batch_id", b.ID)
+		}
 		return err
 	}
 	return nil
 }
 
+// ContactImportOutcome is the outcome of importing a single record
+type ContactImportOutcome string
+
+// import outcome constants
+const (
+	ContactImportOutcomeCreated ContactImportOutcome = "created"
+	ContactImportOutcomeUpdated ContactImportOutcome = "updated"
+	ContactImportOutcomeSkipped ContactImportOutcome = "skipped"
+	ContactImportOutcomeErrored ContactImportOutcome = "errored"
+)
+
 // holds work data for import of a single contact
 type importContact struct {
 	record      int
 	spec        *ContactSpec
 	contact     *Contact
 	created     bool
+	skipped     string // reason this record was skipped
 	flowContact *flows.Contact
 	mods        []flows.Modifier
+	changes     []string
 	errors      []string
 }
 
-func (b *ContactImportBatch) tryImport(ctx context.Context, rt *runtime.Runtime, orgID OrgID, userID UserID) error {
+func (i *importContact) outcome() ContactImportOutcome {
+	if i.skipped != "" {
+		return ContactImportOutcomeSkipped
+	} else if i.created {
+		return ContactImportOutcomeCreated
+	} else if i.contact == nil {
+		return ContactImportOutcomeErrored
+	}
+	return ContactImportOutcomeUpdated
+}
+
+func (b *ContactImportBatch) tryImport(ctx context.Context, rt *runtime.Runtime, ci *ContactImport) error {
 	if err := b.markProcessing(ctx, rt.DB); err != nil {
 		return errors.Wrap(err, "error marking as processing")
 	}
 
 	// grab our org assets
-	oa, err := GetOrgAssetsWithRefresh(ctx, rt, orgID, RefreshFields|RefreshGroups)
+	oa, err := GetOrgAssetsWithRefresh(ctx, rt, ci.OrgID, RefreshFields|RefreshGroups)
 	if err != nil {
 		return errors.Wrap(err, "error loading org assets")
 	}
@@ -148,23 +252,34 @@ func (b *ContactImportBatch) tryImport(ctx context.Context, rt *runtime.Runtime,
 		imports[i] = &importContact{record: b.RecordStart + i, spec: specs[i]}
 	}
 
-	if err := b.getOrCreateContacts(ctx, rt.DB, oa, imports); err != nil {
+	if err := b.getOrCreateContacts(ctx, rt.DB, oa, ci.Mode, ci.DryRun, imports); err != nil {
 		return errors.Wrap(err, "error getting and creating contacts")
 	}
 
 	// gather up contacts and modifiers
 	modifiersByContact := make(map[*flows.Contact][]flows.Modifier, len(imports))
 	for _, imp := range imports {
-		// ignore errored imports which couldn't get/create a contact
-		if imp.contact != nil {
+		// ignore skipped or errored imports which couldn't get/create a contact
+		if imp.flowContact != nil && imp.skipped == "" {
 			modifiersByContact[imp.flowContact] = imp.mods
 		}
 	}
 
-	// and apply in bulk
-	_, err = ApplyModifiers(ctx, rt, oa, userID, modifiersByContact)
-	if err != nil {
-		return errors.Wrap(err, "error applying modifiers")
+	// and apply in bulk, or if this is a dry run, only in memory to see what would change
+	var eventsByContact map[*flows.Contact][]flows.Event
+	if ci.DryRun {
+		eventsByContact = modifyContacts(rt, oa, modifiersByContact)
+	} else {
+		eventsByContact, err = applyModifiers(ctx, rt, oa, ci.CreatedByID, modifiersByContact, true)
+		if err != nil {
+			return errors.Wrap(err, "error applying modifiers")
+		}
+	}
+
+	for _, imp := range imports {
+		if imp.flowContact != nil {
+			imp.changes = describeContactChanges(eventsByContact[imp.flowContact])
+		}
 	}
 
 	if err := b.markComplete(ctx, rt.DB, imports); err != nil {
@@ -174,8 +289,9 @@ func (b *ContactImportBatch) tryImport(ctx context.Context, rt *runtime.Runtime,
 	return nil
 }
 
-// for each import, fetches or creates the contact, creates the modifiers needed to set fields etc
-func (b *ContactImportBatch) getOrCreateContacts(ctx context.Context, db *sqlx.DB, oa *OrgAssets, imports []*importContact) error {
+// for each import, fetches or creates the contact according to the import mode, creates the modifiers needed to set
+// fields etc. If this is a dry run, new contacts aren't created and instead are represented by empty flow contacts.
+func (b *ContactImportBatch) getOrCreateContacts(ctx context.Context, db *sqlx.DB, oa *OrgAssets, mode ContactImportMode, dryRun bool, imports []*importContact) error {
 	sa := oa.SessionAssets()
 
 	// build map of UUIDs to contacts
@@ -191,29 +307,57 @@ func (b *ContactImportBatch) getOrCreateContacts(ctx context.Context, db *sqlx.D
 
 		isActive := spec.Status == "" || spec.Status == flows.ContactStatusActive
 
+		// ensure all URNs are normalized
+		for i, urn := range spec.URNs {
+			spec.URNs[i] = urn.Normalize(string(oa.Env().DefaultCountry()))
+		}
+
 		uuid := spec.UUID
 		if uuid != "" {
 			imp.contact = contactsByUUID[uuid]
 			if imp.contact == nil {
-				addError("Unable to find contact with UUID '%s'", uuid)
+				if mode == ContactImportModeUpdate {
+					imp.skipped = fmt.Sprintf("No contact with UUID '%s'", uuid)
+				} else {
+					addError("Unable to find contact with UUID '%s'", uuid)
+				}
 				continue
 			}
-
-			imp.flowContact, err = imp.contact.FlowContact(oa)
+		} else {
+			imp.contact, err = contactFromURNs(ctx, db, oa, spec.URNs)
 			if err != nil {
-				return errors.Wrapf(err, "error creating flow contact for %d", imp.contact.ID())
+				addError("Unable to find or create contact with URNs %s", joinURNIdentities(spec.URNs))
+				continue
 			}
 
-		} else {
-			imp.contact, imp.flowContact, imp.created, err = GetOrCreateContact(ctx, db, oa, spec.URNs, NilChannelID)
-			if err != nil {
-				urnStrs := make([]string, len(spec.URNs))
-				for i := range spec.URNs {
-					urnStrs[i] = string(spec.URNs[i].Identity())
+			if imp.contact == nil {
+				if mode == ContactImportModeUpdate {
+					imp.skipped = fmt.Sprintf("No contact with URNs %s", joinURNIdentities(spec.URNs))
+					continue
 				}
 
-				addError("Unable to find or create contact with URNs %s", strings.Join(urnStrs, ", "))
-				continue
+				if dryRun {
+					imp.created = true
+					imp.flowContact = flows.NewEmptyContact(sa, "", i18n.NilLanguage, nil)
+				} else {
+					imp.contact, imp.flowContact, imp.created, err = GetOrCreateContact(ctx, db, oa, spec.URNs, NilChannelID)
+					if err != nil {
+						addError("Unable to find or create contact with URNs %s", joinURNIdentities(spec.URNs))
+						continue
+					}
+				}
+			}
+		}
+
+		if imp.contact != nil && !imp.created && mode == ContactImportModeCreate {
+			imp.skipped = fmt.Sprintf("Contact already exists with UUID '%s'", imp.contact.UUID())
+			continue
+		}
+
+		if imp.flowContact == nil {
+			imp.flowContact, err = imp.contact.FlowContact(oa)
+			if err != nil {
+				return errors.Wrapf(err, "error creating flow contact for %d", imp.contact.ID())
 			}
 		}
 
@@ -286,6 +430,56 @@ func (b *ContactImportBatch) loadContactsByUUID(ctx context.Context, db DBorTx,
 	return contactsByUUID, nil
 }
 
+// looks up the single existing contact which owns the given URNs, returning nil if there isn't one
+func contactFromURNs(ctx context.Context, db Queryer, oa *OrgAssets, urnz []urns.URN) (*Contact, error) {
+	if len(urnz) == 0 {
+		return nil, nil
+	}
+
+	owners, err := contactIDsFromURNs(ctx, db, oa.OrgID(), urnz)
+	if err != nil {
+		return nil, errors.Wrap(err, "error looking up contacts for URNs")
+	}
+
+	uniqueOwners := uniqueContactIDs(owners)
+	if len(uniqueOwners) > 1 {
+		return nil, errors.New("error because URNs belong to different contacts")
+	} else if len(uniqueOwners) == 0 {
+		return nil, nil
+	}
+
+	return LoadContact(ctx, db, oa, uniqueOwners[0])
+}
+
+func joinURNIdentities(urnz []urns.URN) string {
+	identities := make([]string, len(urnz))
+	for i := range urnz {
+		identities[i] = string(urnz[i].Identity())
+	}
+	return strings.Join(identities, ", ")
+}
+
+// describes the changes made to a contact by the given events, e.g. "name" or "field:age"
+func describeContactChanges(evts []flows.Event) []string {
+	changes := make([]string, 0, len(evts))
+	seen := make(map[string]bool, len(evts))
+
+	for _, e := range evts {
+		var change string
+		if fc, ok := e.(*events.ContactFieldChangedEvent); ok {
+			change = "field:" + fc.Field.Key
+		} else {
+			change = strings.TrimSuffix(strings.TrimPrefix(e.Type(), "contact_"), "_changed")
+		}
+
+		if !seen[change] {
+			changes = append(changes, change)
+			seen[change] = true
+		}
+	}
+	return changes
+}
+
 func (b *ContactImportBatch) markProcessing(ctx context.Context, db DBorTx) error {
 	b.Status = ContactImportStatusProcessing
 	_, err := db.ExecContext(ctx, `UPDATE contacts_contactimportbatch SET status = $2 WHERE id = $1`, b.ID, b.Status)
@@ -295,32 +489,54 @@ func (b *ContactImportBatch) markProcessing(ctx context.Context, db DBorTx) erro
 func (b *ContactImportBatch) markComplete(ctx context.Context, db DBorTx, imports []*importContact) error {
 	numCreated := 0
 	numUpdated := 0
+	numSkipped := 0
 	numErrored := 0
 	importErrors := make([]importError, 0, 10)
-	for _, imp := range imports {
-		if imp.contact == nil {
-			numErrored++
-		} else if imp.created {
+	results := make([]*ContactImportResult, len(imports))
+	for i, imp := range imports {
+		outcome := imp.outcome()
+		switch outcome {
+		case ContactImportOutcomeCreated:
 			numCreated++
-		} else {
+		case ContactImportOutcomeUpdated:
 			numUpdated++
+		case ContactImportOutcomeSkipped:
+			numSkipped++
+		case ContactImportOutcomeErrored:
+			numErrored++
 		}
 		for _, e := range imp.errors {
 			importErrors = append(importErrors, importError{Record: imp.record, Row: imp.spec.ImportRow, Message: e})
 		}
+
+		results[i] = &ContactImportResult{Record: imp.record, Row: imp.spec.ImportRow, Outcome: outcome, Changes: imp.changes}
+		if imp.contact != nil {
+			results[i].ContactUUID = imp.contact.UUID()
+		}
+		if imp.skipped != "" {
+			results[i].Reason = imp.skipped
+		} else {
+			results[i].Reason = strings.Join(imp.errors, "; ")
+		}
 	}
 
 	errorsJSON, err := jsonx.Marshal(importErrors)
 	if err != nil {
 		return errors.Wrap(err, "error marshaling errors")
 	}
+	resultsJSON, err := jsonx.Marshal(results)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling results")
+	}
 
 	now := dates.Now()
 	b.Status = ContactImportStatusComplete
 	b.NumCreated = numCreated
 	b.NumUpdated = numUpdated
+	b.NumSkipped = numSkipped
 	b.NumErrored = numErrored
 	b.Errors = errorsJSON
+	b.Results = resultsJSON
 	b.FinishedOn = &now
 	_, err = db.NamedExecContext(ctx,
 		`UPDATE 
@@ -329,8 +545,10 @@ func (b *ContactImportBatch) markComplete(ctx context.Context, db DBorTx, import
 			status = :status, 
 			num_created = :num_created, 
 			num_updated = :num_updated, 
+			num_skipped = :num_skipped, 
 			num_errored = :num_errored, 
 			errors = :errors, 
+			results = :results, 
 			finished_on = :finished_on 
 		WHERE 
 			id = :id`,
@@ -339,12 +557,35 @@ func (b *ContactImportBatch) markComplete(ctx context.Context, db DBorTx, import
 	return err
 }
 
+// marks this batch as failed, recording every record in it as errored so that they show up in the results. The batch
+// is still marked as failed if its specs can't be read or the results can't be written.
 func (b *ContactImportBatch) markFailed(ctx context.Context, db DBorTx) error {
+	var specs []*ContactSpec
+	specsErr := jsonx.Unmarshal(b.Specs, &specs)
+
+	results := make([]*ContactImportResult, len(specs))
+	for i, spec := range specs {
+		results[i] = &ContactImportResult{Record: b.RecordStart + i, Row: spec.ImportRow, Outcome: ContactImportOutcomeErrored, Reason: "Unable to import this record due to an internal error"}
+	}
+
+	resultsJSON, resultsErr := jsonx.Marshal(results)
+	if specsErr == nil && resultsErr == nil {
+		b.NumErrored = len(specs)
+		b.Results = resultsJSON
+	}
+
 	now := dates.Now()
 	b.Status = ContactImportStatusFailed
 	b.FinishedOn = &now
-	_, err := db.ExecContext(ctx, `UPDATE contacts_contactimportbatch SET status = $2, finished_on = $3 WHERE id = $1`, b.ID, b.Status, b.FinishedOn)
-	return err
+	_, err := db.ExecContext(ctx, `UPDATE contacts_contactimportbatch SET status = $2, num_errored = $3, results = $4, finished_on = $5 WHERE id = $1`, b.ID, b.Status, b.NumErrored, b.Results, b.FinishedOn)
+	if err != nil {
+		return errors.Wrap(err, "error updating import batch")
+	}
+
+	if specsErr != nil {
+		return errors.Wrap(specsErr, "error unmarshaling specs")
+	}
+	return errors.Wrap(resultsErr, "error marshaling results")
 }
 
 var loadContactImportBatchSQL = `
@@ -381,6 +622,20 @@ type ContactSpec struct {
 	Groups   []assets.GroupUUID  `json:"groups"`
 
 	ImportRow int `json:"_import_row"`
+
+	// set when imported from a source, to recognize unchanged records in the next sync
+	ImportKey  string `json:"_import_key,omitempty"`
+	ImportHash string `json:"_import_hash,omitempty"`
+}
+
+// ContactImportResult is the result of importing a single record
+type ContactImportResult struct {
+	Record      int                  `json:"record"`
+	Row         int                  `json:"row"`
+	Outcome     ContactImportOutcome `json:"outcome"`
+	ContactUUID flows.ContactUUID    `json:"contact_uuid,omitempty"`
+	Changes     []string             `json:"changes,omitempty"`
+	Reason      string               `json:"reason,omitempty"`
 }
 
 // an error message associated with a particular record
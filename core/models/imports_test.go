@@ -70,10 +70,13 @@ func TestContactImports(t *testing.T) {
 		importID := testdata.InsertContactImport(rt, testdata.Org1, testdata.Admin)
 		batchID := testdata.InsertContactImportBatch(rt, importID, tc.Specs)
 
+		imp, err := models.LoadContactImport(ctx, rt.DB, importID)
+		require.NoError(t, err)
+
 		batch, err := models.LoadContactImportBatch(ctx, rt.DB, batchID)
 		require.NoError(t, err)
 
-		err = batch.Import(ctx, rt, testdata.Org1.ID, testdata.Admin.ID)
+		err = batch.Import(ctx, rt, imp)
 		require.NoError(t, err)
 
 		results := &struct {
@@ -178,7 +181,7 @@ func TestLoadContactImport(t *testing.T) {
 	assert.Equal(t, 0, batch1.RecordStart)
 	assert.Equal(t, 2, batch1.RecordEnd)
 
-	err = batch1.Import(ctx, rt, testdata.Org1.ID, testdata.Admin.ID)
+	err = batch1.Import(ctx, rt, imp)
 	require.NoError(t, err)
 
 	imp, err = models.LoadContactImport(ctx, rt.DB, importID)
@@ -192,6 +195,101 @@ func TestLoadContactImport(t *testing.T) {
 	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimportbatch WHERE status = 'P' AND finished_on IS NULL`).Returns(1)
 }
 
+func TestContactImportModes(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetStorage)
+
+	importBatch := func(mode models.ContactImportMode, dryRun bool, specs string) (*models.ContactImport, *models.ContactImportBatch) {
+		importID := testdata.InsertContactImport(rt, testdata.Org1, testdata.Admin)
+		rt.DB.MustExec(`UPDATE contacts_contactimport SET mode = $2, dry_run = $3 WHERE id = $1`, importID, mode, dryRun)
+		batchID := testdata.InsertContactImportBatch(rt, importID, []byte(specs))
+
+		imp, err := models.LoadContactImport(ctx, rt.DB, importID)
+		require.NoError(t, err)
+		assert.Equal(t, mode, imp.Mode)
+		assert.Equal(t, dryRun, imp.DryRun)
+
+		batch, err := models.LoadContactImportBatch(ctx, rt.DB, batchID)
+		require.NoError(t, err)
+
+		err = batch.Import(ctx, rt, imp)
+		require.NoError(t, err)
+
+		return imp, batch
+	}
+
+	assertResults := func(batch *models.ContactImportBatch, created, updated, skipped, errored int, results string) {
+		assertdb.Query(t, rt.DB, `SELECT num_created, num_updated, num_skipped, num_errored FROM contacts_contactimportbatch WHERE id = $1`, batch.ID).
+			Columns(map[string]any{"num_created": int64(created), "num_updated": int64(updated), "num_skipped": int64(skipped), "num_errored": int64(errored)})
+
+		var actual []byte
+		require.NoError(t, rt.DB.Get(&actual, `SELECT results FROM contacts_contactimportbatch WHERE id = $1`, batch.ID))
+		test.AssertEqualJSON(t, []byte(results), actual)
+	}
+
+	// update only mode skips contacts which don't exist
+	_, batch := importBatch(models.ContactImportModeUpdate, false, `[
+		{"name": "Catherine", "urns": ["tel:+16055741111"], "_import_row": 2},
+		{"name": "Nobody", "urns": ["tel:+16055749999"], "_import_row": 3},
+		{"uuid": "f3a2b9b0-5a43-4c7e-9a4b-7f8d2c1e0a99", "name": "Nobody", "_import_row": 4}
+	]`)
+	assertResults(batch, 0, 1, 2, 0, `[
+		{"record": 0, "row": 2, "outcome": "updated", "contact_uuid": "6393abc0-283d-4c9b-a1b3-641a035c34bf", "changes": ["name"]},
+		{"record": 1, "row": 3, "outcome": "skipped", "reason": "No contact with URNs tel:+16055749999"},
+		{"record": 2, "row": 4, "outcome": "skipped", "reason": "No contact with UUID 'f3a2b9b0-5a43-4c7e-9a4b-7f8d2c1e0a99'"}
+	]`)
+	assertdb.Query(t, rt.DB, `SELECT name FROM contacts_contact WHERE id = $1`, testdata.Cathy.ID).Returns("Catherine")
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contact WHERE name = 'Nobody'`).Returns(0)
+
+	// create only mode skips contacts which already exist
+	_, batch = importBatch(models.ContactImportModeCreate, false, `[
+		{"name": "Robert", "urns": ["tel:+16055742222"], "_import_row": 2},
+		{"name": "Newbie", "urns": ["tel:+16055748888"], "_import_row": 3}
+	]`)
+	assertdb.Query(t, rt.DB, `SELECT name FROM contacts_contact WHERE id = $1`, testdata.Bob.ID).Returns("Bob")
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contact WHERE name = 'Newbie'`).Returns(1)
+
+	var newbieUUID string
+	require.NoError(t, rt.DB.Get(&newbieUUID, `SELECT uuid FROM contacts_contact WHERE name = 'Newbie'`))
+
+	assertResults(batch, 1, 0, 1, 0, `[
+		{"record": 0, "row": 2, "outcome": "skipped", "contact_uuid": "b699a406-7e44-49be-9f01-1a82893e8a10", "reason": "Contact already exists with UUID 'b699a406-7e44-49be-9f01-1a82893e8a10'"},
+		{"record": 1, "row": 3, "outcome": "created", "contact_uuid": "`+newbieUUID+`", "changes": ["name"]}
+	]`)
+
+	// a dry run reports what would change without changing anything
+	imp, batch := importBatch(models.ContactImportModeUpsert, true, `[
+		{"name": "Jorge", "urns": ["tel:+16055743333"], "fields": {"age": "77"}, "_import_row": 2},
+		{"name": "Dry", "urns": ["tel:+16055747777"], "_import_row": 3},
+		{"name": "Bad", "urns": ["tel:+16055746666"], "language": "xyz", "_import_row": 4},
+		{"uuid": "f3a2b9b0-5a43-4c7e-9a4b-7f8d2c1e0a99", "_import_row": 5}
+	]`)
+	assertResults(batch, 2, 1, 0, 1, `[
+		{"record": 0, "row": 2, "outcome": "updated", "contact_uuid": "8d024bcd-f473-4719-a00a-bd0bb1190135", "changes": ["name", "field:age"]},
+		{"record": 1, "row": 3, "outcome": "created", "changes": ["urns", "name"]},
+		{"record": 2, "row": 4, "outcome": "created", "changes": ["urns", "name"], "reason": "'xyz' is not a valid language code"},
+		{"record": 3, "row": 5, "outcome": "errored", "reason": "Unable to find contact with UUID 'f3a2b9b0-5a43-4c7e-9a4b-7f8d2c1e0a99'"}
+	]`)
+	assertdb.Query(t, rt.DB, `SELECT name FROM contacts_contact WHERE id = $1`, testdata.George.ID).Returns("George")
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contact WHERE name IN ('Dry', 'Bad')`).Returns(0)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contacturn WHERE identity IN ('tel:+16055747777', 'tel:+16055746666')`).Returns(0)
+
+	// and the results can be written as a CSV file
+	err := imp.WriteResultFile(ctx, rt)
+	require.NoError(t, err)
+	assert.NotEqual(t, "", string(imp.ResultURL))
+	assertdb.Query(t, rt.DB, `SELECT result_url FROM contacts_contactimport WHERE id = $1`, imp.ID).Returns(string(imp.ResultURL))
+
+	contents, err := os.ReadFile(string(imp.ResultURL))
+	require.NoError(t, err)
+	assert.Equal(t, "Row,Outcome,Contact UUID,Changes,Reason\n"+
+		"2,updated,8d024bcd-f473-4719-a00a-bd0bb1190135,\"name, field:age\",\n"+
+		"3,created,,\"urns, name\",\n"+
+		"4,created,,\"urns, name\",'xyz' is not a valid language code\n"+
+		"5,errored,,,Unable to find contact with UUID 'f3a2b9b0-5a43-4c7e-9a4b-7f8d2c1e0a99'\n", string(contents))
+}
+
 func TestContactSpecUnmarshal(t *testing.T) {
 	s := &models.ContactSpec{}
 	jsonx.Unmarshal([]byte(`{}`), s)
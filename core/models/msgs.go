@@ -82,6 +82,7 @@ const (
 	MsgFailedTooOld         = MsgFailedReason("O")
 	MsgFailedNoDestination  = MsgFailedReason("D")
 	MsgFailedChannelRemoved = MsgFailedReason("R")
+	MsgFailedTemplate       = MsgFailedReason("T") // template translation can't be sent with these variables
 )
 
 var unsendableToFailedReason = map[flows.UnsendableReason]MsgFailedReason{
@@ -347,6 +348,10 @@ func newOutgoingTextMsg(rt *runtime.Runtime, org *Org, channel *Channel, contact
 	if out.UnsendableReason() != flows.NilUnsendableReason {
 		m.Status = MsgStatusFailed
 		m.FailedReason = unsendableToFailedReason[out.UnsendableReason()]
+	} else if tpl != nil && out.Templating() != nil && !templatingIsValid(tpl, channel, out) {
+		// fail messages the channel would reject because they don't match the template
+		m.Status = MsgStatusFailed
+		m.FailedReason = MsgFailedTemplate
 	} else if org.Suspended() {
 		// we fail messages for suspended orgs right away
 		m.Status = MsgStatusFailed
@@ -386,6 +391,16 @@ func newOutgoingTextMsg(rt *runtime.Runtime, org *Org, channel *Channel, contact
 	return msg, nil
 }
 
+// checks that the given message has an approved translation of its template for its channel and the right number of
+// variables for it. Templates are only checked against channels they have translations for.
+func templatingIsValid(t *Template, channel *Channel, m *flows.MsgOut) bool {
+	if channel == nil || !t.HasChannel(channel.UUID()) {
+		return true
+	}
+	tt := t.FindChannelTranslation(channel.UUID(), m.Locale())
+	return tt != nil && tt.VariableCount() == len(m.Templating().Variables())
+}
+
 func buildMsgMetadata(m *flows.MsgOut, t *Template) map[string]any {
 	metadata := make(map[string]any)
 	if m.Templating() != nil && t != nil {
@@ -807,6 +822,39 @@ func UpdateMessageDeletedBySender(ctx context.Context, db *sql.DB, orgID OrgID,
 	return errors.Wrap(tx.Commit(), "error committing transaction")
 }
 
+// IVRRecording is the recorded audio attached to an incoming IVR message
+type IVRRecording struct {
+	MsgID       MsgID          `db:"id"`
+	Attachments pq.StringArray `db:"attachments"`
+}
+
+const sqlSelectIVRRecordingsBefore = `
+  SELECT id, attachments
+    FROM msgs_msg
+   WHERE org_id = $1 AND direction = 'I' AND msg_type = 'V' AND created_on < $2 AND array_length(attachments, 1) > 0
+ORDER BY created_on
+   LIMIT $3`
+
+// LoadIVRRecordingsBefore loads recordings attached to incoming IVR messages created before the given time
+func LoadIVRRecordingsBefore(ctx context.Context, db *sqlx.DB, orgID OrgID, before time.Time, limit int) ([]*IVRRecording, error) {
+	recordings := make([]*IVRRecording, 0, limit)
+	err := db.SelectContext(ctx, &recordings, sqlSelectIVRRecordingsBefore, orgID, before, limit)
+	return recordings, errors.Wrap(err, "error selecting IVR recordings")
+}
+
+// ClearMsgAttachments removes the attachments from the given messages, e.g. after they have been deleted from storage
+func ClearMsgAttachments(ctx context.Context, db DBorTx, msgIDs []MsgID) error {
+	_, err := db.ExecContext(ctx, `UPDATE msgs_msg SET attachments = '{}', modified_on = NOW() WHERE id = ANY($1)`, pq.Array(msgIDs))
+	return errors.Wrap(err, "error clearing message attachments")
+}
+
+// UpdateIVRMsgText sets the text of an incoming IVR message, e.g. to the transcript of its recording, provided it
+// doesn't already have text
+func UpdateIVRMsgText(ctx context.Context, db DBorTx, orgID OrgID, uuid flows.MsgUUID, text string) error {
+	_, err := db.ExecContext(ctx, `UPDATE msgs_msg SET text = $3, modified_on = NOW() WHERE org_id = $1 AND uuid = $2 AND msg_type = 'V' AND direction = 'I' AND text = ''`, orgID, uuid, text)
+	return errors.Wrap(err, "error updating IVR message text")
+}
+
 // NilID implementations
 
 func (i *MsgID) Scan(value any) error         { return null.ScanInt(value, i) }
@@ -9,9 +9,11 @@ import (
 	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/test"
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/runtime"
@@ -40,6 +42,8 @@ func TestNewOutgoingFlowMsg(t *testing.T) {
 		Attachments  []utils.Attachment
 		QuickReplies []string
 		Topic        flows.MsgTopic
+		Templating   *flows.MsgTemplating
+		Locale       i18n.Locale
 		Unsendable   flows.UnsendableReason
 		Flow         *testdata.Flow
 		ResponseTo   models.MsgID
@@ -136,6 +140,66 @@ func TestNewOutgoingFlowMsg(t *testing.T) {
 			ExpectedMsgCount:     1,
 			ExpectedPriority:     false,
 		},
+		{
+			Channel:              testdata.FacebookChannel,
+			Text:                 "valid template",
+			Contact:              testdata.Cathy,
+			URN:                  urns.URN(fmt.Sprintf("tel:+250700000001?id=%d", testdata.Cathy.URNID)),
+			URNID:                testdata.Cathy.URNID,
+			Templating:           flows.NewMsgTemplating(assets.NewTemplateReference("9c22b594-fcab-4b29-9bcb-ce4404894a80", "revive_issue"), []string{"name", "tooth"}, "tpls"),
+			Locale:               "eng-US",
+			Flow:                 testdata.Favorites,
+			ExpectedStatus:       models.MsgStatusQueued,
+			ExpectedFailedReason: models.NilMsgFailedReason,
+			ExpectedMetadata: map[string]any{"templating": map[string]any{
+				"template":  map[string]any{"uuid": "9c22b594-fcab-4b29-9bcb-ce4404894a80", "name": "revive_issue"},
+				"variables": []any{"name", "tooth"},
+				"namespace": "tpls",
+				"language":  "en_US",
+			}},
+			ExpectedMsgCount: 1,
+			ExpectedPriority: false,
+		},
+		{
+			Channel:              testdata.FacebookChannel,
+			Text:                 "template variable mismatch",
+			Contact:              testdata.Cathy,
+			URN:                  urns.URN(fmt.Sprintf("tel:+250700000001?id=%d", testdata.Cathy.URNID)),
+			URNID:                testdata.Cathy.URNID,
+			Templating:           flows.NewMsgTemplating(assets.NewTemplateReference("9c22b594-fcab-4b29-9bcb-ce4404894a80", "revive_issue"), []string{"name"}, "tpls"),
+			Locale:               "eng-US",
+			Flow:                 testdata.Favorites,
+			ExpectedStatus:       models.MsgStatusFailed,
+			ExpectedFailedReason: models.MsgFailedTemplate,
+			ExpectedMetadata: map[string]any{"templating": map[string]any{
+				"template":  map[string]any{"uuid": "9c22b594-fcab-4b29-9bcb-ce4404894a80", "name": "revive_issue"},
+				"variables": []any{"name"},
+				"namespace": "tpls",
+				"language":  "en_US",
+			}},
+			ExpectedMsgCount: 1,
+			ExpectedPriority: false,
+		},
+		{
+			Channel:              testdata.TwilioChannel,
+			Text:                 "template without translations for channel",
+			Contact:              testdata.Cathy,
+			URN:                  urns.URN(fmt.Sprintf("tel:+250700000001?id=%d", testdata.Cathy.URNID)),
+			URNID:                testdata.Cathy.URNID,
+			Templating:           flows.NewMsgTemplating(assets.NewTemplateReference("9c22b594-fcab-4b29-9bcb-ce4404894a80", "revive_issue"), []string{"name"}, "tpls"),
+			Locale:               "eng-US",
+			Flow:                 testdata.Favorites,
+			ExpectedStatus:       models.MsgStatusQueued,
+			ExpectedFailedReason: models.NilMsgFailedReason,
+			ExpectedMetadata: map[string]any{"templating": map[string]any{
+				"template":  map[string]any{"uuid": "9c22b594-fcab-4b29-9bcb-ce4404894a80", "name": "revive_issue"},
+				"variables": []any{"name"},
+				"namespace": "tpls",
+				"language":  "en_US",
+			}},
+			ExpectedMsgCount: 1,
+			ExpectedPriority: false,
+		},
 	}
 
 	now := time.Now()
@@ -163,8 +227,13 @@ func TestNewOutgoingFlowMsg(t *testing.T) {
 			session.SetIncomingMsg(tc.ResponseTo, null.NullString)
 		}
 
-		flowMsg := flows.NewMsgOut(tc.URN, chRef, tc.Text, tc.Attachments, tc.QuickReplies, nil, tc.Topic, i18n.NilLocale, tc.Unsendable)
-		msg, err := models.NewOutgoingFlowMsg(rt, oa.Org(), ch, session, flow, flowMsg, nil, now)
+		var tpl *models.Template
+		if tc.Templating != nil {
+			tpl = oa.TemplateByUUID(tc.Templating.Template().UUID)
+		}
+
+		flowMsg := flows.NewMsgOut(tc.URN, chRef, tc.Text, tc.Attachments, tc.QuickReplies, tc.Templating, tc.Topic, tc.Locale, tc.Unsendable)
+		msg, err := models.NewOutgoingFlowMsg(rt, oa.Org(), ch, session, flow, flowMsg, tpl, now)
 
 		assert.NoError(t, err)
 
@@ -191,7 +260,7 @@ func TestNewOutgoingFlowMsg(t *testing.T) {
 
 		assert.Equal(t, tc.ExpectedStatus, msg.Status(), "status mismatch for %s", desc)
 		assert.Equal(t, tc.ExpectedFailedReason, msg.FailedReason(), "failed reason mismatch for %s", desc)
-		assert.Equal(t, tc.ExpectedMetadata, msg.Metadata())
+		test.AssertEqualJSON(t, jsonx.MustMarshal(tc.ExpectedMetadata), jsonx.MustMarshal(msg.Metadata()), "metadata mismatch for %s", desc)
 		assert.Equal(t, tc.ExpectedMsgCount, msg.MsgCount())
 		assert.Equal(t, now, msg.CreatedOn())
 		assert.True(t, msg.ID() > 0)
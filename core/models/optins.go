@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/null/v3"
 	"github.com/pkg/errors"
@@ -48,3 +50,52 @@ func loadOptIns(ctx context.Context, db *sql.DB, orgID OrgID) ([]assets.OptIn, e
 
 	return ScanJSONRows(rows, func() assets.OptIn { return &OptIn{} })
 }
+
+// OptInStatus is the status of a contact for an optin
+type OptInStatus string
+
+const (
+	OptInStatusOptedIn  = OptInStatus("I")
+	OptInStatusOptedOut = OptInStatus("O")
+)
+
+// an older event which arrives late shouldn't overwrite the current status
+const sqlUpsertContactOptIn = `
+INSERT INTO contacts_contactoptin(org_id, contact_id, optin_id, status, modified_on)
+     VALUES($1, $2, $3, $4, $5)
+ON CONFLICT(contact_id, optin_id) DO UPDATE SET status = EXCLUDED.status, modified_on = EXCLUDED.modified_on
+      WHERE contacts_contactoptin.modified_on <= EXCLUDED.modified_on`
+
+const sqlInsertContactOptInEvent = `
+INSERT INTO contacts_contactoptinevent(org_id, contact_id, optin_id, channel_id, status, created_on)
+     VALUES($1, $2, $3, $4, $5, $6)`
+
+// SetContactOptInStatus records the given status for the contact and optin, and adds it to the contact's optin history
+func SetContactOptInStatus(ctx context.Context, db DB, orgID OrgID, contactID ContactID, optInID OptInID, channelID ChannelID, status OptInStatus, when time.Time) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlUpsertContactOptIn, orgID, contactID, optInID, status, when); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error updating contact optin status")
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlInsertContactOptInEvent, orgID, contactID, optInID, channelID, status, when); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error inserting contact optin event")
+	}
+
+	return errors.Wrap(tx.Commit(), "error committing contact optin status")
+}
+
+const sqlSelectOptedOutContactIDs = `
+SELECT contact_id
+  FROM contacts_contactoptin
+ WHERE optin_id = $1 AND contact_id = ANY($2) AND status = 'O'`
+
+// GetOptedOutContactIDs returns which of the given contacts are currently opted out of the given optin
+func GetOptedOutContactIDs(ctx context.Context, db Queryer, optInID OptInID, contactIDs []ContactID) ([]ContactID, error) {
+	return queryContactIDs(ctx, db, sqlSelectOptedOutContactIDs, optInID, pq.Array(contactIDs))
+}
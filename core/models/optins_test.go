@@ -2,7 +2,9 @@ package models_test
 
 import (
 	"testing"
+	"time"
 
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
@@ -30,3 +32,35 @@ func TestOptIns(t *testing.T) {
 	assert.Equal(t, offers.UUID, optIns[1].UUID())
 	assert.Equal(t, "Offers", optIns[1].Name())
 }
+
+func TestContactOptInStatus(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	polls := testdata.InsertOptIn(rt, testdata.Org1, "Polls")
+	offers := testdata.InsertOptIn(rt, testdata.Org1, "Offers")
+
+	t1 := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	t2 := time.Date(2023, 9, 2, 12, 0, 0, 0, time.UTC)
+
+	err := models.SetContactOptInStatus(ctx, rt.DB, testdata.Org1.ID, testdata.Cathy.ID, polls.ID, testdata.VonageChannel.ID, models.OptInStatusOptedIn, t1)
+	require.NoError(t, err)
+	err = models.SetContactOptInStatus(ctx, rt.DB, testdata.Org1.ID, testdata.Cathy.ID, polls.ID, testdata.VonageChannel.ID, models.OptInStatusOptedOut, t2)
+	require.NoError(t, err)
+	err = models.SetContactOptInStatus(ctx, rt.DB, testdata.Org1.ID, testdata.Bob.ID, polls.ID, testdata.VonageChannel.ID, models.OptInStatusOptedIn, t1)
+	require.NoError(t, err)
+	err = models.SetContactOptInStatus(ctx, rt.DB, testdata.Org1.ID, testdata.George.ID, offers.ID, testdata.VonageChannel.ID, models.OptInStatusOptedOut, t1)
+	require.NoError(t, err)
+
+	// an older event arriving late doesn't change the current status but is still recorded in the history
+	err = models.SetContactOptInStatus(ctx, rt.DB, testdata.Org1.ID, testdata.Cathy.ID, polls.ID, testdata.VonageChannel.ID, models.OptInStatusOptedIn, t1)
+	require.NoError(t, err)
+
+	assertdb.Query(t, rt.DB, `SELECT status FROM contacts_contactoptin WHERE contact_id = $1 AND optin_id = $2`, testdata.Cathy.ID, polls.ID).Returns("O")
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactoptinevent WHERE contact_id = $1 AND optin_id = $2`, testdata.Cathy.ID, polls.ID).Returns(3)
+
+	optedOut, err := models.GetOptedOutContactIDs(ctx, rt.DB, polls.ID, []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []models.ContactID{testdata.Cathy.ID}, optedOut)
+}
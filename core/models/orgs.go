@@ -12,9 +12,11 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/engine"
@@ -62,6 +64,12 @@ const (
 	configSMTPServer  = "smtp_server"
 	configDTOneKey    = "dtone_key"
 	configDTOneSecret = "dtone_secret"
+
+	configTicketAutoCloseDays    = "ticket_autoclose_days"
+	configTicketAutoCloseTopics  = "ticket_autoclose_topics"
+	configTicketAutoCloseMessage = "ticket_autoclose_message"
+
+	configIVRRecordingRetentionDays = "ivr_recording_retention_days"
 )
 
 // Org is mailroom's type for RapidPro orgs. It also implements the envs.Environment interface for GoFlow
@@ -111,6 +119,42 @@ func (o *Org) ConfigValue(key string, def string) string {
 	return def
 }
 
+// ConfigInt returns the int value for the passed in config (or default if not found)
+func (o *Org) ConfigInt(key string, def int) int {
+	v, ok := o.o.Config[key].(float64)
+	if ok {
+		return int(v)
+	}
+	return def
+}
+
+// TicketAutoCloseDays returns the number of days of inactivity after which open tickets are closed, 0 if disabled
+func (o *Org) TicketAutoCloseDays() int {
+	return o.ConfigInt(configTicketAutoCloseDays, 0)
+}
+
+// TicketAutoCloseDaysForTopic returns the number of days of inactivity after which open tickets with the given topic
+// are closed, 0 if disabled. Topics can override the org setting with a "ticket_autoclose_topics" config object keyed by
+// topic UUID, e.g. {"ticket_autoclose_topics": {"<topic uuid>": 3}}, where 0 disables auto-closing for that topic.
+func (o *Org) TicketAutoCloseDaysForTopic(topicUUID assets.TopicUUID) int {
+	if topics, ok := o.o.Config[configTicketAutoCloseTopics].(map[string]any); ok {
+		if v, ok := topics[string(topicUUID)].(float64); ok {
+			return int(v)
+		}
+	}
+	return o.TicketAutoCloseDays()
+}
+
+// TicketAutoCloseMessage returns the message to send to contacts before their idle tickets are closed, if any
+func (o *Org) TicketAutoCloseMessage() string {
+	return o.ConfigValue(configTicketAutoCloseMessage, "")
+}
+
+// IVRRecordingRetentionDays returns the number of days after which IVR recordings are deleted, 0 if kept forever
+func (o *Org) IVRRecordingRetentionDays() int {
+	return o.ConfigInt(configIVRRecordingRetentionDays, 0)
+}
+
 // EmailService returns the email service for this org
 func (o *Org) EmailService(c *runtime.Config, retries *smtpx.RetryConfig) (flows.EmailService, error) {
 	connectionURL := o.ConfigValue(configSMTPServer, c.SMTPServer)
@@ -158,6 +202,15 @@ func (o *Org) StoreAttachment(ctx context.Context, rt *runtime.Runtime, filename
 	return utils.Attachment(contentType + ":" + url), nil
 }
 
+// GetAttachment fetches the content of an attachment previously stored with StoreAttachment
+func (o *Org) GetAttachment(ctx context.Context, rt *runtime.Runtime, filename string) (string, []byte, error) {
+	contentType, content, err := rt.AttachmentStorage.Get(ctx, o.attachmentPath(rt.Config.S3AttachmentsPrefix, filename))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to get attachment content")
+	}
+	return contentType, content, nil
+}
+
 func (o *Org) attachmentPath(prefix string, filename string) string {
 	parts := []string{prefix, fmt.Sprintf("%d", o.ID())}
 
@@ -204,6 +257,35 @@ func LoadOrg(ctx context.Context, cfg *runtime.Config, db *sql.DB, orgID OrgID)
 	return org, nil
 }
 
+const sqlSelectOrgIDsWithTicketAutoClose = `
+SELECT id
+  FROM orgs_org
+ WHERE is_active = TRUE AND (
+           CASE WHEN jsonb_typeof(config->'ticket_autoclose_days') = 'number' THEN (config->>'ticket_autoclose_days')::numeric > 0 ELSE FALSE END OR
+           CASE WHEN jsonb_typeof(config->'ticket_autoclose_topics') = 'object' THEN config->'ticket_autoclose_topics' != '{}'::jsonb ELSE FALSE END
+       )
+ORDER BY id`
+
+// GetOrgIDsWithTicketAutoClose gets the ids of active orgs which have auto-closing of idle tickets enabled
+func GetOrgIDsWithTicketAutoClose(ctx context.Context, db *sqlx.DB) ([]OrgID, error) {
+	var orgIDs []OrgID
+	err := db.SelectContext(ctx, &orgIDs, sqlSelectOrgIDsWithTicketAutoClose)
+	return orgIDs, errors.Wrap(err, "error selecting orgs with ticket auto-close")
+}
+
+const sqlSelectOrgIDsWithIVRRecordingRetention = `
+SELECT id
+  FROM orgs_org
+ WHERE is_active = TRUE AND CASE WHEN jsonb_typeof(config->'ivr_recording_retention_days') = 'number' THEN (config->>'ivr_recording_retention_days')::numeric > 0 ELSE FALSE END
+ORDER BY id`
+
+// GetOrgIDsWithIVRRecordingRetention gets the ids of active orgs which have a retention limit on IVR recordings
+func GetOrgIDsWithIVRRecordingRetention(ctx context.Context, db *sqlx.DB) ([]OrgID, error) {
+	var orgIDs []OrgID
+	err := db.SelectContext(ctx, &orgIDs, sqlSelectOrgIDsWithIVRRecordingRetention)
+	return orgIDs, errors.Wrap(err, "error selecting orgs with IVR recording retention")
+}
+
 const selectOrgByID = `
 SELECT ROW_TO_JSON(o) FROM (SELECT
 	id,
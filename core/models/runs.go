@@ -2,13 +2,18 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/buger/jsonparser"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/null/v3"
 	"github.com/pkg/errors"
 )
@@ -140,3 +145,94 @@ func newRun(ctx context.Context, tx *sqlx.Tx, oa *OrgAssets, session *Session, f
 
 	return run, nil
 }
+
+const sqlSelectRunByResultInput = `
+  SELECT uuid, results
+    FROM flows_flowrun
+   WHERE contact_id = $1 AND strpos(results::text, $2) > 0
+ORDER BY id DESC
+   LIMIT 1`
+
+// AddResultTranscript adds the transcript of an IVR recording to the extra of the run result whose input was that
+// recording. If the run's session is still waiting, its output is updated too so that later writes of the run from
+// the engine keep the transcript.
+func AddResultTranscript(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, contact *flows.Contact, recordingURL, transcript string) error {
+	var run struct {
+		UUID    flows.RunUUID `db:"uuid"`
+		Results string        `db:"results"`
+	}
+	err := rt.DB.GetContext(ctx, &run, sqlSelectRunByResultInput, contact.ID(), recordingURL)
+	if err == sql.ErrNoRows {
+		return nil // run may have been deleted
+	}
+	if err != nil {
+		return errors.Wrap(err, "error selecting run with recording result")
+	}
+
+	results, changed := addResultTranscript([]byte(run.Results), recordingURL, transcript)
+	if !changed {
+		return nil
+	}
+
+	if _, err := rt.DB.ExecContext(ctx, `UPDATE flows_flowrun SET results = $2, modified_on = NOW() WHERE uuid = $1`, run.UUID, string(results)); err != nil {
+		return errors.Wrap(err, "error updating run results")
+	}
+
+	session, err := FindWaitingSessionForContact(ctx, rt.DB, rt.SessionStorage, oa, FlowTypeVoice, contact)
+	if err != nil {
+		return errors.Wrap(err, "error loading waiting session")
+	}
+	if session == nil {
+		return nil
+	}
+
+	output := []byte(session.Output())
+	runIndex := -1
+	i := 0
+	jsonparser.ArrayEach(output, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if uuid, _ := jsonparser.GetString(value, "uuid"); uuid == string(run.UUID) {
+			runIndex = i
+		}
+		i++
+	}, "runs")
+
+	// run isn't part of this session
+	if runIndex < 0 {
+		return nil
+	}
+
+	runPath := fmt.Sprintf("[%d]", runIndex)
+	runResults, _, _, _ := jsonparser.Get(output, "runs", runPath, "results")
+	runResults, changed = addResultTranscript(runResults, recordingURL, transcript)
+	if !changed {
+		return nil
+	}
+
+	if output, err = jsonparser.Set(output, runResults, "runs", runPath, "results"); err != nil {
+		return errors.Wrap(err, "error updating session output")
+	}
+	session.s.Output = null.String(output)
+
+	if session.OutputURL() != "" {
+		return WriteSessionOutputsToStorage(ctx, rt, []*Session{session})
+	}
+
+	_, err = rt.DB.ExecContext(ctx, `UPDATE flows_flowsession SET output = $2 WHERE id = $1`, session.ID(), session.Output())
+	return errors.Wrap(err, "error updating session output")
+}
+
+// adds the transcript to the extra of each result in the given results JSON whose input contains the recording URL
+func addResultTranscript(results []byte, recordingURL, transcript string) ([]byte, bool) {
+	var keys []string
+	jsonparser.ObjectEach(results, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		if input, _ := jsonparser.GetString(value, "input"); strings.Contains(input, recordingURL) {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+
+	for _, key := range keys {
+		results, _ = jsonparser.Set(results, jsonx.MustMarshal(transcript), key, "extra", "transcript")
+	}
+	return results, len(keys) > 0
+}
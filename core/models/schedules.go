@@ -66,9 +66,10 @@ type Schedule struct {
 		// Timezone of our org
 		Timezone string `json:"timezone"`
 
-		// associated broadcast or trigger
-		Broadcast *Broadcast `json:"broadcast,omitempty"`
-		Trigger   *Trigger   `json:"trigger,omitempty"`
+		// associated broadcast, trigger or contact import source
+		Broadcast    *Broadcast           `json:"broadcast,omitempty"`
+		Trigger      *Trigger             `json:"trigger,omitempty"`
+		ImportSource *ContactImportSource `json:"import_source,omitempty"`
 	}
 }
 
@@ -84,18 +85,20 @@ func NewSchedule(period RepeatPeriod, hourOfDay, minuteOfHour, dayOfMonth *int,
 	return sched
 }
 
-func (s *Schedule) ID() ScheduleID             { return s.s.ID }
-func (s *Schedule) OrgID() OrgID               { return s.s.OrgID }
-func (s *Schedule) Broadcast() *Broadcast      { return s.s.Broadcast }
-func (s *Schedule) Trigger() *Trigger          { return s.s.Trigger }
-func (s *Schedule) RepeatPeriod() RepeatPeriod { return s.s.RepeatPeriod }
-func (s *Schedule) NextFire() *time.Time       { return s.s.NextFire }
-func (s *Schedule) LastFire() *time.Time       { return s.s.LastFire }
+func (s *Schedule) ID() ScheduleID                     { return s.s.ID }
+func (s *Schedule) OrgID() OrgID                       { return s.s.OrgID }
+func (s *Schedule) Broadcast() *Broadcast              { return s.s.Broadcast }
+func (s *Schedule) Trigger() *Trigger                  { return s.s.Trigger }
+func (s *Schedule) ImportSource() *ContactImportSource { return s.s.ImportSource }
+func (s *Schedule) RepeatPeriod() RepeatPeriod         { return s.s.RepeatPeriod }
+func (s *Schedule) NextFire() *time.Time               { return s.s.NextFire }
+func (s *Schedule) LastFire() *time.Time               { return s.s.LastFire }
 func (s *Schedule) Timezone() (*time.Location, error) {
 	return time.LoadLocation(s.s.Timezone)
 }
 
-// DeleteWithTarget deactivates this schedule along with its associated broadcast or flow start
+// DeleteWithTarget deactivates this schedule along with its associated broadcast or flow start, or detaches it from
+// its contact import source
 func (s *Schedule) DeleteWithTarget(ctx context.Context, tx *sql.Tx) error {
 	if s.Broadcast() != nil {
 		if _, err := tx.ExecContext(ctx, `UPDATE msgs_broadcast SET is_active = FALSE, schedule_id = NULL WHERE id = $1`, s.Broadcast().ID); err != nil {
@@ -105,6 +108,10 @@ func (s *Schedule) DeleteWithTarget(ctx context.Context, tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, `UPDATE triggers_trigger SET is_active = FALSE, schedule_id = NULL WHERE id = $1`, s.Trigger().ID()); err != nil {
 			return errors.Wrap(err, "error deactivating scheduled trigger")
 		}
+	} else if s.ImportSource() != nil {
+		if _, err := tx.ExecContext(ctx, `UPDATE contacts_contactimportsource SET schedule_id = NULL WHERE id = $1`, s.ImportSource().ID); err != nil {
+			return errors.Wrap(err, "error detaching schedule from import source")
+		}
 	}
 
 	if _, err := tx.ExecContext(ctx, `DELETE FROM schedules_schedule WHERE id = $1`, s.s.ID); err != nil {
@@ -258,7 +265,12 @@ SELECT ROW_TO_JSON(s) FROM (
                 (SELECT ARRAY_AGG(te.contactgroup_id) FROM (SELECT contactgroup_id FROM triggers_trigger_exclude_groups WHERE trigger_id = t.id) te) as exclude_group_ids
             FROM triggers_trigger t 
             WHERE t.schedule_id = s.id AND t.is_active = TRUE AND t.is_archived = FALSE
-        ) r) AS trigger
+        ) r) AS trigger,
+        (SELECT ROW_TO_JSON(r) FROM (
+            SELECT cs.id, cs.org_id, cs.url, cs.format, cs.mode
+            FROM contacts_contactimportsource cs
+            WHERE cs.schedule_id = s.id AND cs.is_active = TRUE
+        ) r) AS import_source
         FROM schedules_schedule s 
         JOIN orgs_org o ON s.org_id = o.id
        WHERE s.next_fire < NOW() AND NOT is_paused 
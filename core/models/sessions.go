@@ -973,6 +973,18 @@ func InterruptSessionsForChannel(ctx context.Context, db *sqlx.DB, channelID Cha
 	return errors.Wrapf(ExitSessions(ctx, db, sessionIDs, SessionStatusInterrupted), "error exiting sessions")
 }
 
+// InterruptSessionsForCalls interrupts any waiting sessions for the given calls
+func InterruptSessionsForCalls(ctx context.Context, db *sqlx.DB, callIDs []CallID) error {
+	sessionIDs := make([]SessionID, 0, len(callIDs))
+
+	err := db.SelectContext(ctx, &sessionIDs, `SELECT id FROM flows_flowsession WHERE status = 'W' AND call_id = ANY($1)`, pq.Array(callIDs))
+	if err != nil {
+		return errors.Wrapf(err, "error selecting waiting sessions for calls")
+	}
+
+	return errors.Wrapf(ExitSessions(ctx, db, sessionIDs, SessionStatusInterrupted), "error exiting sessions")
+}
+
 const sqlWaitingSessionIDsForFlows = `
 SELECT id
   FROM flows_flowsession
@@ -993,3 +1005,35 @@ func InterruptSessionsForFlows(ctx context.Context, db *sqlx.DB, flowIDs []FlowI
 
 	return errors.Wrapf(ExitSessions(ctx, db, sessionIDs, SessionStatusInterrupted), "error exiting sessions")
 }
+
+const sqlSelectWaitingSessionsOnNodes = `
+SELECT r.current_node_uuid, r.session_id
+  FROM flows_flowrun r
+  JOIN flows_flowsession s ON s.id = r.session_id
+ WHERE r.flow_id = $1 AND r.status IN ('A', 'W') AND r.current_node_uuid = ANY($2::uuid[]) AND s.status = 'W'`
+
+// GetWaitingSessionsOnNodes gets the waiting sessions which have a run in the given flow currently on one of the given
+// nodes, i.e. either waiting there or in a subflow entered from there, organized by node
+func GetWaitingSessionsOnNodes(ctx context.Context, db DBorTx, flowID FlowID, nodeUUIDs []flows.NodeUUID) (map[flows.NodeUUID][]SessionID, error) {
+	sessions := make(map[flows.NodeUUID][]SessionID)
+	if len(nodeUUIDs) == 0 {
+		return sessions, nil
+	}
+
+	rows, err := db.QueryContext(ctx, sqlSelectWaitingSessionsOnNodes, flowID, pq.Array(nodeUUIDs))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting waiting sessions on nodes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nodeUUID flows.NodeUUID
+		var sessionID SessionID
+		if err := rows.Scan(&nodeUUID, &sessionID); err != nil {
+			return nil, errors.Wrapf(err, "error scanning waiting session")
+		}
+		sessions[nodeUUID] = append(sessions[nodeUUID], sessionID)
+	}
+
+	return sessions, errors.Wrapf(rows.Err(), "error selecting waiting sessions on nodes")
+}
@@ -489,6 +489,32 @@ func TestClearWaitTimeout(t *testing.T) {
 	assertdb.Query(t, rt.DB, `SELECT timeout_on FROM flows_flowsession WHERE id = $1`, session.ID()).Returns(nil)
 }
 
+func TestGetWaitingSessionsOnNodes(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	node1 := flows.NodeUUID("5c1d3bd8-5d4b-4b52-9b2d-0b1a5e9a8f01")
+	node2 := flows.NodeUUID("b1f3c4e2-0c39-4d5e-9a6b-6c8a0e2d7f02")
+
+	session1ID, run1ID := insertSessionAndRun(rt, testdata.Cathy, models.FlowTypeMessaging, models.SessionStatusWaiting, testdata.Favorites, models.NilCallID)
+	session2ID, run2ID := insertSessionAndRun(rt, testdata.Bob, models.FlowTypeMessaging, models.SessionStatusWaiting, testdata.Favorites, models.NilCallID)
+	_, run3ID := insertSessionAndRun(rt, testdata.George, models.FlowTypeMessaging, models.SessionStatusCompleted, testdata.Favorites, models.NilCallID)
+
+	rt.DB.MustExec(`UPDATE flows_flowrun SET current_node_uuid = $2 WHERE id = $1`, run1ID, node1)
+	rt.DB.MustExec(`UPDATE flows_flowrun SET current_node_uuid = $2 WHERE id = $1`, run2ID, node2)
+	rt.DB.MustExec(`UPDATE flows_flowrun SET current_node_uuid = $2 WHERE id = $1`, run3ID, node1)
+
+	waiting, err := models.GetWaitingSessionsOnNodes(ctx, rt.DB, testdata.Favorites.ID, []flows.NodeUUID{node1, node2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[flows.NodeUUID][]models.SessionID{node1: {session1ID}, node2: {session2ID}}, waiting)
+
+	// nodes of other flows aren't included
+	waiting, err = models.GetWaitingSessionsOnNodes(ctx, rt.DB, testdata.PickANumber.ID, []flows.NodeUUID{node1, node2})
+	assert.NoError(t, err)
+	assert.Len(t, waiting, 0)
+}
+
 func insertSessionAndRun(rt *runtime.Runtime, contact *testdata.Contact, sessionType models.FlowType, status models.SessionStatus, flow *testdata.Flow, connID models.CallID) (models.SessionID, models.FlowRunID) {
 	// create session and add a run with same status
 	sessionID := testdata.InsertFlowSession(rt, testdata.Org1, contact, sessionType, status, flow, connID)
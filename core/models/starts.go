@@ -93,6 +93,8 @@ type FlowStart struct {
 	Params         null.JSON `json:"params,omitempty"          db:"params"`
 	ParentSummary  null.JSON `json:"parent_summary,omitempty"  db:"parent_summary"`
 	SessionHistory null.JSON `json:"session_history,omitempty" db:"session_history"`
+
+	CallRetryPolicy *CallRetryPolicy `json:"call_retry_policy,omitempty" db:"call_retry_policy"`
 }
 
 // NewFlowStart creates a new flow start objects for the passed in parameters
@@ -173,10 +175,37 @@ func MarkStartFailed(ctx context.Context, db DBorTx, startID StartID) error {
 	return errors.Wrapf(err, "error setting flow start as failed")
 }
 
-// GetFlowStartAttributes gets the basic attributes for the passed in start id, this includes ONLY its id, uuid, flow_id and params
+// GetFlowStartStatus gets the current status of the passed in flow start
+func GetFlowStartStatus(ctx context.Context, db DBorTx, startID StartID) (StartStatus, error) {
+	var status StartStatus
+	err := db.GetContext(ctx, &status, `SELECT status FROM flows_flowstart WHERE id = $1`, startID)
+	return status, errors.Wrapf(err, "error loading status for flow start: %d", startID)
+}
+
+const sqlFailStartsForChannel = `
+UPDATE flows_flowstart
+   SET status = 'F', modified_on = NOW()
+ WHERE id IN (
+	SELECT DISTINCT fsc.flowstart_id
+	  FROM flows_flowstart_calls fsc
+	  JOIN ivr_call c ON c.id = fsc.call_id
+	 WHERE c.org_id = $1 AND c.channel_id = $2
+ ) AND status IN ('P', 'S')`
+
+// FailStartsForChannel fails pending and started flow starts which have calls on the given channel
+func FailStartsForChannel(ctx context.Context, db DBorTx, orgID OrgID, channelID ChannelID) (int, error) {
+	res, err := db.ExecContext(ctx, sqlFailStartsForChannel, orgID, channelID)
+	if err != nil {
+		return 0, errors.Wrap(err, "error failing flow starts")
+	}
+	rows, _ := res.RowsAffected()
+	return int(rows), nil
+}
+
+// GetFlowStartAttributes gets the basic attributes for the passed in start id, this includes ONLY its id, uuid, flow_id, params and call retry policy
 func GetFlowStartAttributes(ctx context.Context, db DBorTx, startID StartID) (*FlowStart, error) {
 	start := &FlowStart{}
-	err := db.GetContext(ctx, start, `SELECT id, uuid, flow_id, params, parent_summary, session_history FROM flows_flowstart WHERE id = $1`, startID)
+	err := db.GetContext(ctx, start, `SELECT id, uuid, flow_id, params, parent_summary, session_history, call_retry_policy FROM flows_flowstart WHERE id = $1`, startID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to load start attributes for id: %d", startID)
 	}
@@ -234,8 +263,8 @@ func InsertFlowStarts(ctx context.Context, db DBorTx, starts []*FlowStart) error
 
 const sqlInsertStart = `
 INSERT INTO
-	flows_flowstart(uuid,  org_id,  flow_id,  start_type,  created_on, modified_on, query,  exclusions,  status, params,  parent_summary,  session_history)
-			 VALUES(:uuid, :org_id, :flow_id, :start_type, NOW(),      NOW(),       :query, :exclusions, 'P',    :params, :parent_summary, :session_history)
+	flows_flowstart(uuid,  org_id,  flow_id,  start_type,  created_on, modified_on, query,  exclusions,  status, params,  parent_summary,  session_history,  call_retry_policy)
+			 VALUES(:uuid, :org_id, :flow_id, :start_type, NOW(),      NOW(),       :query, :exclusions, 'P',    :params, :parent_summary, :session_history, :call_retry_policy)
 RETURNING
 	id
 `
@@ -3,9 +3,12 @@ package models
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/actions"
 	"github.com/pkg/errors"
 )
 
@@ -17,30 +20,66 @@ type Template struct {
 
 func (t *Template) Name() string              { return t.Name_ }
 func (t *Template) UUID() assets.TemplateUUID { return t.UUID_ }
+
+// Translations returns the approved translations of this template, as those are the only ones which can be sent
 func (t *Template) Translations() []assets.TemplateTranslation {
-	trs := make([]assets.TemplateTranslation, len(t.Translations_))
-	for i := range trs {
-		trs[i] = t.Translations_[i]
+	trs := make([]assets.TemplateTranslation, 0, len(t.Translations_))
+	for _, tt := range t.Translations_ {
+		if tt.IsApproved() {
+			trs = append(trs, tt)
+		}
 	}
 	return trs
 }
 
+// FindTranslation returns the approved translation with the given locale
 func (t *Template) FindTranslation(l i18n.Locale) *TemplateTranslation {
 	for _, tt := range t.Translations_ {
-		if tt.Locale() == l {
+		if tt.IsApproved() && tt.Locale() == l {
+			return tt
+		}
+	}
+	return nil
+}
+
+// HasChannel returns whether this template has any translations, approved or not, for the given channel
+func (t *Template) HasChannel(channel assets.ChannelUUID) bool {
+	for _, tt := range t.Translations_ {
+		if tt.Channel().UUID == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// FindChannelTranslation returns the approved translation for the given channel with the given locale
+func (t *Template) FindChannelTranslation(channel assets.ChannelUUID, l i18n.Locale) *TemplateTranslation {
+	for _, tt := range t.Translations_ {
+		if tt.IsApproved() && tt.Channel().UUID == channel && tt.Locale() == l {
 			return tt
 		}
 	}
 	return nil
 }
 
+// TemplateTranslationStatus is the approval status of a template translation
+type TemplateTranslationStatus string
+
+const (
+	TemplateTranslationStatusApproved    = TemplateTranslationStatus("A")
+	TemplateTranslationStatusPending     = TemplateTranslationStatus("P")
+	TemplateTranslationStatusRejected    = TemplateTranslationStatus("R")
+	TemplateTranslationStatusUnsupported = TemplateTranslationStatus("U")
+)
+
 type TemplateTranslation struct {
-	Channel_        *assets.ChannelReference `json:"channel"`
-	Namespace_      string                   `json:"namespace"`
-	Locale_         i18n.Locale              `json:"locale"`
-	ExternalLocale_ string                   `json:"external_locale"`
-	Content_        string                   `json:"content"`
-	VariableCount_  int                      `json:"variable_count"`
+	Channel_        *assets.ChannelReference  `json:"channel"`
+	Namespace_      string                    `json:"namespace"`
+	Locale_         i18n.Locale               `json:"locale"`
+	ExternalLocale_ string                    `json:"external_locale"`
+	Content_        string                    `json:"content"`
+	VariableCount_  int                       `json:"variable_count"`
+	Status_         TemplateTranslationStatus `json:"status"`
 }
 
 func (t *TemplateTranslation) Channel() *assets.ChannelReference { return t.Channel_ }
@@ -49,6 +88,130 @@ func (t *TemplateTranslation) Locale() i18n.Locale               { return t.Loca
 func (t *TemplateTranslation) ExternalLocale() string            { return t.ExternalLocale_ }
 func (t *TemplateTranslation) Content() string                   { return t.Content_ }
 func (t *TemplateTranslation) VariableCount() int                { return t.VariableCount_ }
+func (t *TemplateTranslation) Status() TemplateTranslationStatus { return t.Status_ }
+func (t *TemplateTranslation) IsApproved() bool {
+	return t.Status_ == TemplateTranslationStatusApproved
+}
+
+// TemplateIssueType is the type of a problem found when validating the use of a template
+type TemplateIssueType string
+
+const (
+	TemplateIssueMissingTranslation = TemplateIssueType("missing_translation")
+	TemplateIssueVariableMismatch   = TemplateIssueType("variable_count_mismatch")
+	TemplateIssueNotApproved        = TemplateIssueType("not_approved")
+)
+
+// TemplateIssue is a problem with the use of a template which means messages would be rejected by the channel
+type TemplateIssue struct {
+	Type        TemplateIssueType         `json:"type"`
+	NodeUUID    flows.NodeUUID            `json:"node_uuid,omitempty"`
+	ActionUUID  flows.ActionUUID          `json:"action_uuid,omitempty"`
+	Template    *assets.TemplateReference `json:"template"`
+	Channel     *assets.ChannelReference  `json:"channel,omitempty"`
+	Language    i18n.Language             `json:"language,omitempty"`
+	Description string                    `json:"description"`
+}
+
+// Validate checks that this template can be sent on every channel it has translations for, in each of the
+// given languages, with the given number of variables
+func (t *Template) Validate(variables map[i18n.Language]int, languages []i18n.Language) []*TemplateIssue {
+	ref := assets.NewTemplateReference(t.UUID_, t.Name_)
+	issues := make([]*TemplateIssue, 0)
+
+	if len(t.Translations_) == 0 {
+		return append(issues, &TemplateIssue{Type: TemplateIssueMissingTranslation, Template: ref, Description: "template has no translations"})
+	}
+
+	// the channels this template has translations for, whatever their status
+	channels := make([]*assets.ChannelReference, 0, 2)
+	seen := make(map[assets.ChannelUUID]bool)
+	for _, tt := range t.Translations_ {
+		if !seen[tt.Channel().UUID] {
+			channels = append(channels, tt.Channel())
+			seen[tt.Channel().UUID] = true
+		}
+	}
+
+	for _, ch := range channels {
+		for _, lang := range languages {
+			var approved, unapproved *TemplateTranslation
+			for _, tt := range t.Translations_ {
+				if ttLang, _ := tt.Locale().Split(); tt.Channel().UUID == ch.UUID && ttLang == lang {
+					if tt.IsApproved() {
+						approved = tt
+					} else {
+						unapproved = tt
+					}
+				}
+			}
+
+			issue := &TemplateIssue{Template: ref, Channel: ch, Language: lang}
+
+			if approved != nil {
+				if approved.VariableCount() == variables[lang] {
+					continue
+				}
+				issue.Type = TemplateIssueVariableMismatch
+				issue.Description = fmt.Sprintf("translation expects %d variables but %d are provided", approved.VariableCount(), variables[lang])
+			} else if unapproved != nil {
+				issue.Type = TemplateIssueNotApproved
+				issue.Description = fmt.Sprintf("translation has status %s and hasn't been approved", unapproved.Status())
+			} else {
+				issue.Type = TemplateIssueMissingTranslation
+				issue.Description = "no translation for channel in language"
+			}
+
+			issues = append(issues, issue)
+		}
+	}
+
+	return issues
+}
+
+// ValidateFlowTemplates checks the templates used by send_msg actions in the given flow
+func ValidateFlowTemplates(oa *OrgAssets, flow flows.Flow) []*TemplateIssue {
+	languages := make([]i18n.Language, 0, 3)
+	for _, lang := range append([]i18n.Language{flow.Language()}, flow.Localization().Languages()...) {
+		if lang != i18n.NilLanguage && lang != "und" {
+			languages = append(languages, lang)
+		}
+	}
+
+	issues := make([]*TemplateIssue, 0)
+
+	for _, node := range flow.Nodes() {
+		for _, action := range node.Actions() {
+			sendMsg, isSendMsg := action.(*actions.SendMsgAction)
+			if !isSendMsg || sendMsg.Templating == nil {
+				continue
+			}
+
+			// missing templates are reported as missing dependencies by the flow inspection
+			template := oa.TemplateByUUID(sendMsg.Templating.Template.UUID)
+			if template == nil {
+				continue
+			}
+
+			variables := make(map[i18n.Language]int, len(languages))
+			for _, lang := range languages {
+				variables[lang] = len(sendMsg.Templating.Variables)
+
+				if localized := flow.Localization().GetItemTranslation(lang, sendMsg.Templating.UUID, "variables"); localized != nil {
+					variables[lang] = len(localized)
+				}
+			}
+
+			for _, issue := range template.Validate(variables, languages) {
+				issue.NodeUUID = node.UUID()
+				issue.ActionUUID = action.UUID()
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	return issues
+}
 
 // loads the templates for the passed in org
 func loadTemplates(ctx context.Context, db *sql.DB, orgID OrgID) ([]assets.Template, error) {
@@ -71,13 +234,13 @@ SELECT ROW_TO_JSON(r) FROM (SELECT
 			tr.external_locale as external_locale,
 			tr.content as content,
 			tr.variable_count as variable_count,
+			tr.status as status,
 			JSON_BUILD_OBJECT('uuid', c.uuid, 'name', c.name) as channel
 		FROM
 			templates_templatetranslation tr
 			JOIN channels_channel c ON tr.channel_id = c.id
 		WHERE 
 			tr.is_active = TRUE AND
-			tr.status = 'A' AND
 			tr.template_id = t.id AND
 			c.is_active = TRUE
 	) tr) as translations
@@ -5,6 +5,8 @@ import (
 
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/goflow"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
@@ -50,3 +52,105 @@ func TestTemplates(t *testing.T) {
 
 	assert.Nil(t, oa.TemplateByUUID("f67e498e-08fa-44e0-8acd-4c10122de714"))
 }
+
+func TestTemplateValidate(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshTemplates)
+	require.NoError(t, err)
+
+	tpl := oa.TemplateByUUID("9c22b594-fcab-4b29-9bcb-ce4404894a80")
+	require.NotNil(t, tpl)
+
+	// approved translation with right number of variables
+	issues := tpl.Validate(map[i18n.Language]int{"eng": 2}, []i18n.Language{"eng"})
+	assert.Len(t, issues, 0)
+
+	// wrong number of variables and no translation at all in spanish
+	issues = tpl.Validate(map[i18n.Language]int{"eng": 1, "spa": 2}, []i18n.Language{"eng", "spa"})
+	if assert.Len(t, issues, 2) {
+		assert.Equal(t, models.TemplateIssueVariableMismatch, issues[0].Type)
+		assert.Equal(t, testdata.FacebookChannel.UUID, issues[0].Channel.UUID)
+		assert.Equal(t, i18n.Language("eng"), issues[0].Language)
+		assert.Equal(t, "translation expects 2 variables but 1 are provided", issues[0].Description)
+		assert.Equal(t, models.TemplateIssueMissingTranslation, issues[1].Type)
+		assert.Equal(t, i18n.Language("spa"), issues[1].Language)
+	}
+
+	// translations which aren't approved can't be sent
+	rt.DB.MustExec(`UPDATE templates_templatetranslation SET status = 'P' WHERE locale = 'eng-US'`)
+	defer rt.DB.MustExec(`UPDATE templates_templatetranslation SET status = 'A' WHERE locale = 'eng-US'`)
+
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshTemplates)
+	require.NoError(t, err)
+
+	tpl = oa.TemplateByUUID("9c22b594-fcab-4b29-9bcb-ce4404894a80")
+	assert.Len(t, tpl.Translations(), 0)
+
+	issues = tpl.Validate(map[i18n.Language]int{"eng": 2}, []i18n.Language{"eng"})
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, models.TemplateIssueNotApproved, issues[0].Type)
+		assert.Equal(t, "translation has status P and hasn't been approved", issues[0].Description)
+	}
+}
+
+func TestValidateFlowTemplates(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshTemplates)
+	require.NoError(t, err)
+
+	flow, err := goflow.ReadFlow(rt.Config, []byte(`{
+		"uuid": "2b0da7d5-4a4e-4b38-87ef-0b9e3ab56f2c",
+		"name": "Templated",
+		"spec_version": "13.2.0",
+		"language": "eng",
+		"type": "messaging",
+		"revision": 1,
+		"expire_after_minutes": 10080,
+		"localization": {
+			"spa": {
+				"9c5a2e8d-8b0c-4f43-9d7e-3a4b5c6d7e8f": {"variables": ["@contact.name", "muelas"]}
+			}
+		},
+		"nodes": [
+			{
+				"uuid": "4fac7935-d13b-4b36-bf15-98075dca822a",
+				"actions": [
+					{
+						"type": "send_msg",
+						"uuid": "5a1e9fe3-0e5b-4b3c-8b0e-2d1b7b5a8c11",
+						"text": "Hi there",
+						"templating": {
+							"uuid": "9c5a2e8d-8b0c-4f43-9d7e-3a4b5c6d7e8f",
+							"template": {"uuid": "9c22b594-fcab-4b29-9bcb-ce4404894a80", "name": "revive_issue"},
+							"variables": ["@contact.name", "teeth"]
+						}
+					},
+					{
+						"type": "send_msg",
+						"uuid": "8e0f0d1c-6a3b-4c5d-9e7f-1a2b3c4d5e6f",
+						"text": "Bye",
+						"templating": {
+							"uuid": "0d1e2f3a-4b5c-4d6e-8f7a-9b0c1d2e3f4a",
+							"template": {"uuid": "e1d2c3b4-a5f6-4e7d-8c9b-0a1b2c3d4e5f", "name": "missing"},
+							"variables": []
+						}
+					}
+				],
+				"exits": [{"uuid": "a0b1c2d3-e4f5-4a6b-8c7d-9e0f1a2b3c4d"}]
+			}
+		]
+	}`))
+	require.NoError(t, err)
+
+	// english has the right number of variables, there's no spanish translation, and missing templates are ignored
+	issues := models.ValidateFlowTemplates(oa, flow)
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, models.TemplateIssueMissingTranslation, issues[0].Type)
+		assert.Equal(t, flows.NodeUUID("4fac7935-d13b-4b36-bf15-98075dca822a"), issues[0].NodeUUID)
+		assert.Equal(t, flows.ActionUUID("5a1e9fe3-0e5b-4b3c-8b0e-2d1b7b5a8c11"), issues[0].ActionUUID)
+		assert.Equal(t, testdata.FacebookChannel.UUID, issues[0].Channel.UUID)
+		assert.Equal(t, i18n.Language("spa"), issues[0].Language)
+	}
+}
@@ -19,6 +19,7 @@ const (
 	TicketEventTypeTopicChanged TicketEventType = "T"
 	TicketEventTypeClosed       TicketEventType = "C"
 	TicketEventTypeReopened     TicketEventType = "R"
+	TicketEventTypeMerged       TicketEventType = "M"
 )
 
 type TicketEvent struct {
@@ -31,6 +32,7 @@ type TicketEvent struct {
 		Note        null.String     `json:"note,omitempty"          db:"note"`
 		TopicID     TopicID         `json:"topic_id,omitempty"      db:"topic_id"`
 		AssigneeID  UserID          `json:"assignee_id,omitempty"   db:"assignee_id"`
+		TargetID    TicketID        `json:"target_id,omitempty"     db:"target_id"`
 		CreatedByID UserID          `json:"created_by_id,omitempty" db:"created_by_id"`
 		CreatedOn   time.Time       `json:"created_on"              db:"created_on"`
 	}
@@ -60,6 +62,13 @@ func NewTicketReopenedEvent(t *Ticket, userID UserID) *TicketEvent {
 	return newTicketEvent(t, userID, TicketEventTypeReopened, "", NilTopicID, NilUserID)
 }
 
+// NewTicketMergedEvent creates a new event on the given ticket recording that it was merged into the target ticket
+func NewTicketMergedEvent(t *Ticket, userID UserID, target *Ticket) *TicketEvent {
+	event := newTicketEvent(t, userID, TicketEventTypeMerged, "", NilTopicID, NilUserID)
+	event.e.TargetID = target.ID()
+	return event
+}
+
 func newTicketEvent(t *Ticket, userID UserID, eventType TicketEventType, note string, topicID TopicID, assigneeID UserID) *TicketEvent {
 	event := &TicketEvent{}
 	e := &event.e
@@ -83,6 +92,7 @@ func (e *TicketEvent) EventType() TicketEventType { return e.e.EventType }
 func (e *TicketEvent) Note() null.String          { return e.e.Note }
 func (e *TicketEvent) TopicID() TopicID           { return e.e.TopicID }
 func (e *TicketEvent) AssigneeID() UserID         { return e.e.AssigneeID }
+func (e *TicketEvent) TargetID() TicketID         { return e.e.TargetID }
 func (e *TicketEvent) CreatedByID() UserID        { return e.e.CreatedByID }
 
 // MarshalJSON is our custom marshaller so that our inner struct get output
@@ -97,8 +107,8 @@ func (e *TicketEvent) UnmarshalJSON(b []byte) error {
 
 const sqlInsertTicketEvents = `
 INSERT INTO
-	tickets_ticketevent(org_id,  contact_id,  ticket_id,  event_type,  note,  topic_id,  assignee_id,  created_on,  created_by_id)
-	            VALUES(:org_id, :contact_id, :ticket_id, :event_type, :note, :topic_id, :assignee_id, :created_on, :created_by_id)
+	tickets_ticketevent(org_id,  contact_id,  ticket_id,  event_type,  note,  topic_id,  assignee_id,  target_id,  created_on,  created_by_id)
+	            VALUES(:org_id, :contact_id, :ticket_id, :event_type, :note, :topic_id, :assignee_id, :target_id, :created_on, :created_by_id)
 RETURNING
 	id
 `
@@ -169,6 +169,59 @@ func LoadTickets(ctx context.Context, db *sqlx.DB, ids []TicketID) ([]*Ticket, e
 	return loadTickets(ctx, db, sqlSelectTicketsByID, pq.Array(ids))
 }
 
+const sqlSelectIdleTickets = `
+SELECT
+  id,
+  uuid,
+  org_id,
+  contact_id,
+  status,
+  topic_id,
+  body,
+  assignee_id,
+  opened_on,
+  opened_by_id,
+  opened_in_id,
+  replied_on,
+  modified_on,
+  closed_on,
+  last_activity_on
+    FROM tickets_ticket
+   WHERE org_id = $1 AND topic_id = $2 AND status = 'O' AND last_activity_on < $3
+ORDER BY last_activity_on ASC
+   LIMIT $4`
+
+// LoadIdleTickets loads up to limit open tickets for the given org and topic which have had no activity since the given time
+func LoadIdleTickets(ctx context.Context, db *sqlx.DB, orgID OrgID, topicID TopicID, since time.Time, limit int) ([]*Ticket, error) {
+	return loadTickets(ctx, db, sqlSelectIdleTickets, orgID, topicID, since, limit)
+}
+
+const sqlSelectOpenTicketsByAssignee = `
+SELECT
+  id,
+  uuid,
+  org_id,
+  contact_id,
+  status,
+  topic_id,
+  body,
+  assignee_id,
+  opened_on,
+  opened_by_id,
+  opened_in_id,
+  replied_on,
+  modified_on,
+  closed_on,
+  last_activity_on
+    FROM tickets_ticket
+   WHERE org_id = $1 AND assignee_id = $2 AND status = 'O' AND ($3 = 0 OR topic_id = $3)
+ORDER BY opened_on ASC`
+
+// LoadOpenTicketsForAssignee loads all open tickets assigned to the given user, optionally filtered by topic
+func LoadOpenTicketsForAssignee(ctx context.Context, db *sqlx.DB, orgID OrgID, assigneeID UserID, topicID TopicID) ([]*Ticket, error) {
+	return loadTickets(ctx, db, sqlSelectOpenTicketsByAssignee, orgID, assigneeID, int(topicID))
+}
+
 func loadTickets(ctx context.Context, db *sqlx.DB, query string, params ...any) ([]*Ticket, error) {
 	rows, err := db.QueryxContext(ctx, query, params...)
 	if err != nil && err != sql.ErrNoRows {
@@ -432,6 +485,20 @@ UPDATE tickets_ticket
 
 // CloseTickets closes the passed in tickets
 func CloseTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, tickets []*Ticket) (map[*Ticket]*TicketEvent, error) {
+	eventsByTicket, contactIDs, err := closeTickets(ctx, rt.DB, userID, tickets)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recalcGroupsForTicketChanges(ctx, rt.DB, oa, contactIDs); err != nil {
+		return nil, errors.Wrapf(err, "error recalculting groups")
+	}
+
+	return eventsByTicket, nil
+}
+
+// closes the passed in tickets which aren't already closed, returning the closed events and the affected contacts
+func closeTickets(ctx context.Context, db DBorTx, userID UserID, tickets []*Ticket) (map[*Ticket]*TicketEvent, map[ContactID]bool, error) {
 	ids := make([]TicketID, 0, len(tickets))
 	events := make([]*TicketEvent, 0, len(tickets))
 	eventsByTicket := make(map[*Ticket]*TicketEvent, len(tickets))
@@ -455,20 +522,16 @@ func CloseTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userI
 	}
 
 	// mark the tickets as closed in the db
-	_, err := rt.DB.ExecContext(ctx, sqlCloseTickets, pq.Array(ids), now)
+	_, err := db.ExecContext(ctx, sqlCloseTickets, pq.Array(ids), now)
 	if err != nil {
-		return nil, errors.Wrap(err, "error updating tickets")
-	}
-
-	if err := InsertTicketEvents(ctx, rt.DB, events); err != nil {
-		return nil, errors.Wrapf(err, "error inserting ticket events")
+		return nil, nil, errors.Wrap(err, "error updating tickets")
 	}
 
-	if err := recalcGroupsForTicketChanges(ctx, rt.DB, oa, contactIDs); err != nil {
-		return nil, errors.Wrapf(err, "error recalculting groups")
+	if err := InsertTicketEvents(ctx, db, events); err != nil {
+		return nil, nil, errors.Wrapf(err, "error inserting ticket events")
 	}
 
-	return eventsByTicket, nil
+	return eventsByTicket, contactIDs, nil
 }
 
 const sqlReopenTickets = `
@@ -518,6 +581,79 @@ func ReopenTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, user
 	return eventsByTicket, nil
 }
 
+const sqlMergeTicketEvents = `
+UPDATE tickets_ticketevent
+   SET ticket_id = $2
+ WHERE ticket_id = $1 AND NOT event_type = ANY($3)`
+
+const sqlMergeTicketMsgs = `
+UPDATE msgs_msg
+   SET ticket_id = $2
+ WHERE ticket_id = $1`
+
+// events which belong to the lifecycle of a ticket and so aren't moved when it's merged into another ticket
+var ticketStatusEventTypes = []TicketEventType{TicketEventTypeOpened, TicketEventTypeClosed, TicketEventTypeReopened}
+
+// MergeTickets merges the source ticket into the target ticket. Messages and events on the source are moved to the
+// target, except for events like opened and closed which concern the status of the source. The source is closed in the
+// same transaction, and the merged event, which references the target, is returned along with the closed event if the
+// source was still open. Both tickets must belong to the same contact and the target must be open.
+func MergeTickets(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, source, target *Ticket) (*TicketEvent, *TicketEvent, error) {
+	if source.ID() == target.ID() {
+		return nil, nil, errors.New("can't merge ticket into itself")
+	}
+	if source.OrgID() != target.OrgID() || source.ContactID() != target.ContactID() {
+		return nil, nil, errors.New("can't merge tickets belonging to different contacts")
+	}
+	if target.Status() != TicketStatusOpen {
+		return nil, nil, errors.New("can't merge ticket into a closed ticket")
+	}
+
+	now := dates.Now()
+
+	tx, err := rt.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error beginning transaction")
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlMergeTicketEvents, source.ID(), target.ID(), pq.Array(ticketStatusEventTypes)); err != nil {
+		tx.Rollback()
+		return nil, nil, errors.Wrap(err, "error moving ticket events")
+	}
+	if _, err := tx.ExecContext(ctx, sqlMergeTicketMsgs, source.ID(), target.ID()); err != nil {
+		tx.Rollback()
+		return nil, nil, errors.Wrap(err, "error moving ticket messages")
+	}
+
+	evt := NewTicketMergedEvent(source, userID, target)
+	if err := InsertTicketEvents(ctx, tx, []*TicketEvent{evt}); err != nil {
+		tx.Rollback()
+		return nil, nil, errors.Wrap(err, "error inserting ticket events")
+	}
+
+	if err := updateTicketLastActivity(ctx, tx, []TicketID{target.ID()}, now); err != nil {
+		tx.Rollback()
+		return nil, nil, errors.Wrap(err, "error updating ticket activity")
+	}
+	target.t.LastActivityOn = now
+
+	closed, contactIDs, err := closeTickets(ctx, tx, userID, []*Ticket{source})
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, errors.Wrap(err, "error closing merged ticket")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, errors.Wrap(err, "error committing ticket merge")
+	}
+
+	if err := recalcGroupsForTicketChanges(ctx, rt.DB, oa, contactIDs); err != nil {
+		return nil, nil, errors.Wrap(err, "error recalculting groups")
+	}
+
+	return evt, closed[source], nil
+}
+
 // because groups can be based on "tickets" need to recalculate after closing/reopening tickets
 func recalcGroupsForTicketChanges(ctx context.Context, db DBorTx, oa *OrgAssets, contactIDs map[ContactID]bool) error {
 	ids := make([]ContactID, 0, len(contactIDs))
@@ -300,6 +300,68 @@ func TestReopenTickets(t *testing.T) {
 	assertTicketDailyCount(t, rt, models.TicketDailyCountOpening, fmt.Sprintf("o:%d", testdata.Org1.ID), 0)
 }
 
+func TestMergeTickets(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	ticket1 := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Where my shoes", time.Now(), nil)
+	modelTicket1 := ticket1.Load(rt)
+
+	ticket2 := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Where my pants", time.Now(), nil)
+	modelTicket2 := ticket2.Load(rt)
+
+	ticket3 := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Bob, testdata.DefaultTopic, "Where my hat", time.Now(), nil)
+	modelTicket3 := ticket3.Load(rt)
+
+	_, err = models.TicketsAddNote(ctx, rt.DB, oa, testdata.Admin.ID, []*models.Ticket{modelTicket2}, "spam")
+	require.NoError(t, err)
+	_, err = models.TicketsAssign(ctx, rt.DB, oa, testdata.Admin.ID, []*models.Ticket{modelTicket2}, testdata.Agent.ID)
+	require.NoError(t, err)
+
+	msg := testdata.InsertOutgoingMsg(rt, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", nil, models.MsgStatusSent, false)
+	rt.DB.MustExec(`UPDATE msgs_msg SET ticket_id = $1 WHERE id = $2`, ticket2.ID, msg.ID)
+
+	ticket4 := testdata.InsertClosedTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Where my socks", nil)
+	modelTicket4 := ticket4.Load(rt)
+
+	// can't merge tickets of different contacts, a ticket with itself or into a closed ticket
+	_, _, err = models.MergeTickets(ctx, rt, oa, testdata.Admin.ID, modelTicket3, modelTicket1)
+	assert.EqualError(t, err, "can't merge tickets belonging to different contacts")
+	_, _, err = models.MergeTickets(ctx, rt, oa, testdata.Admin.ID, modelTicket1, modelTicket1)
+	assert.EqualError(t, err, "can't merge ticket into itself")
+	_, _, err = models.MergeTickets(ctx, rt, oa, testdata.Admin.ID, modelTicket2, modelTicket4)
+	assert.EqualError(t, err, "can't merge ticket into a closed ticket")
+
+	evt, closedEvt, err := models.MergeTickets(ctx, rt, oa, testdata.Admin.ID, modelTicket2, modelTicket1)
+	require.NoError(t, err)
+	assert.Equal(t, models.TicketEventTypeMerged, evt.EventType())
+	assert.Equal(t, ticket1.ID, evt.TargetID())
+	assert.Equal(t, models.TicketEventTypeClosed, closedEvt.EventType())
+	assert.Equal(t, models.TicketStatusClosed, modelTicket2.Status())
+
+	// source ticket is closed with merged and closed events
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticket WHERE id = $1 AND status = 'C' AND closed_on IS NOT NULL`, ticket2.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'M' AND target_id = $2`, ticket2.ID, ticket1.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'C'`, ticket2.ID).Returns(1)
+
+	// and its other events and messages now belong to the target ticket
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type IN ('N', 'A')`, ticket1.ID).Returns(2)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'C'`, ticket1.ID).Returns(0)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM msgs_msg WHERE ticket_id = $1`, ticket1.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticket WHERE id = $1 AND status = 'O'`, ticket1.ID).Returns(1)
+
+	// merging an already closed ticket doesn't close it again
+	ticket5 := testdata.InsertClosedTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Where my hat", nil)
+
+	_, closedEvt, err = models.MergeTickets(ctx, rt, oa, testdata.Admin.ID, ticket5.Load(rt), modelTicket1)
+	require.NoError(t, err)
+	assert.Nil(t, closedEvt)
+}
+
 func TestTicketRecordReply(t *testing.T) {
 	ctx, rt := testsuite.Runtime()
 
@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/null/v3"
 	"github.com/pkg/errors"
@@ -92,3 +93,13 @@ func loadUsers(ctx context.Context, db *sql.DB, orgID OrgID) ([]assets.User, err
 
 	return ScanJSONRows(rows, func() assets.User { return &User{} })
 }
+
+// GetSystemUserID gets the id of the system user used for automated actions, or NilUserID if there isn't one
+func GetSystemUserID(ctx context.Context, db *sqlx.DB) (UserID, error) {
+	var userID UserID
+	err := db.GetContext(ctx, &userID, `SELECT id FROM auth_user WHERE username = 'system'`)
+	if err == sql.ErrNoRows {
+		return NilUserID, nil
+	}
+	return userID, errors.Wrap(err, "error looking up system user")
+}
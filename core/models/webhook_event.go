@@ -3,22 +3,45 @@ package models
 import (
 	"context"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/null/v3"
+	"github.com/pkg/errors"
 )
 
 type WebhookEventID int64
 
+// WebhookEventStatus is the delivery status of a webhook event. This is only set for events which mailroom delivers to
+// resthook subscribers itself, i.e. group change events, as other events are only recorded.
+type WebhookEventStatus string
+
+const (
+	WebhookEventStatusPending   = WebhookEventStatus("P") // waiting for its next delivery attempt
+	WebhookEventStatusQueued    = WebhookEventStatus("Q") // queued for delivery
+	WebhookEventStatusDelivered = WebhookEventStatus("D") // delivered to all subscribers
+	WebhookEventStatusFailed    = WebhookEventStatus("F") // gave up after too many attempts
+)
+
+// how long a queued event is left before it's assumed that its delivery task was lost and it can be queued again
+const webhookEventQueuedTimeout = time.Minute * 10
+
 // WebhookEvent represents an event that was created, mostly used for resthooks
 type WebhookEvent struct {
 	e struct {
-		ID         WebhookEventID `db:"id"`
-		Data       string         `db:"data"`
-		ResthookID ResthookID     `db:"resthook_id"`
-		OrgID      OrgID          `db:"org_id"`
-		CreatedOn  time.Time      `db:"created_on"`
+		ID          WebhookEventID `db:"id"`
+		Data        string         `db:"data"`
+		ResthookID  ResthookID     `db:"resthook_id"`
+		OrgID       OrgID          `db:"org_id"`
+		CreatedOn   time.Time      `db:"created_on"`
+		Status      null.String    `db:"status"`
+		NextAttempt *time.Time     `db:"next_attempt"`
 	}
 }
 
-func (e *WebhookEvent) ID() WebhookEventID { return e.e.ID }
+func (e *WebhookEvent) ID() WebhookEventID     { return e.e.ID }
+func (e *WebhookEvent) Data() string           { return e.e.Data }
+func (e *WebhookEvent) ResthookID() ResthookID { return e.e.ResthookID }
+func (e *WebhookEvent) OrgID() OrgID           { return e.e.OrgID }
 
 // NewWebhookEvent creates a new webhook event
 func NewWebhookEvent(orgID OrgID, resthookID ResthookID, data string, createdOn time.Time) *WebhookEvent {
@@ -34,8 +57,8 @@ func NewWebhookEvent(orgID OrgID, resthookID ResthookID, data string, createdOn
 }
 
 const sqlInsertWebhookEvents = `
-INSERT INTO api_webhookevent(data, resthook_id, org_id, created_on, action)
-     VALUES(:data, :resthook_id, :org_id, :created_on, 'POST')
+INSERT INTO api_webhookevent(data, resthook_id, org_id, created_on, action, status, attempts, next_attempt)
+     VALUES(:data, :resthook_id, :org_id, :created_on, 'POST', :status, 0, :next_attempt)
   RETURNING id`
 
 // InsertWebhookEvents inserts the passed in webhook events, assigning them ids
@@ -51,3 +74,59 @@ func InsertWebhookEvents(ctx context.Context, db DBorTx, events []*WebhookEvent)
 
 	return BulkQuery(ctx, "inserted webhook events", db, sqlInsertWebhookEvents, is)
 }
+
+const sqlSelectWebhookEventsByID = `
+  SELECT id, data, resthook_id, org_id, created_on
+    FROM api_webhookevent
+   WHERE org_id = $1 AND id = ANY($2)
+ORDER BY id`
+
+// LoadWebhookEvents loads the webhook events with the given ids
+func LoadWebhookEvents(ctx context.Context, db DBorTx, orgID OrgID, ids []WebhookEventID) ([]*WebhookEvent, error) {
+	return loadWebhookEvents(ctx, db, sqlSelectWebhookEventsByID, orgID, pq.Array(ids))
+}
+
+func loadWebhookEvents(ctx context.Context, db DBorTx, query string, args ...any) ([]*WebhookEvent, error) {
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying webhook events")
+	}
+	defer rows.Close()
+
+	events := make([]*WebhookEvent, 0, 10)
+	for rows.Next() {
+		e := &WebhookEvent{}
+		if err := rows.StructScan(&e.e); err != nil {
+			return nil, errors.Wrap(err, "error scanning webhook event")
+		}
+		events = append(events, e)
+	}
+
+	return events, errors.Wrap(rows.Err(), "error iterating webhook events")
+}
+
+const sqlMarkWebhookEventsDelivered = `
+UPDATE api_webhookevent
+   SET status = 'D', attempts = attempts + 1, next_attempt = NULL
+ WHERE id = ANY($1)`
+
+// MarkWebhookEventsDelivered marks the given webhook events as delivered
+func MarkWebhookEventsDelivered(ctx context.Context, db DBorTx, ids []WebhookEventID) error {
+	_, err := db.ExecContext(ctx, sqlMarkWebhookEventsDelivered, pq.Array(ids))
+	return errors.Wrap(err, "error marking webhook events as delivered")
+}
+
+const sqlMarkWebhookEventsErrored = `
+UPDATE api_webhookevent
+   SET status = CASE WHEN attempts + 1 >= $2 THEN 'F' ELSE 'P' END,
+       attempts = attempts + 1,
+       next_attempt = CASE WHEN attempts + 1 >= $2 THEN NULL ELSE NOW() + INTERVAL '1 minute' * POWER(2, attempts) END
+ WHERE id = ANY($1)`
+
+// MarkWebhookEventsErrored records a failed delivery attempt for the given webhook events. Events are retried with an
+// exponential backoff until they've been attempted the given number of times and are marked as failed, so a max of zero
+// fails them straight away.
+func MarkWebhookEventsErrored(ctx context.Context, db DBorTx, ids []WebhookEventID, maxAttempts int) error {
+	_, err := db.ExecContext(ctx, sqlMarkWebhookEventsErrored, pq.Array(ids), maxAttempts)
+	return errors.Wrap(err, "error marking webhook events as errored")
+}
@@ -186,6 +186,50 @@ func TestNewCourierMsg(t *testing.T) {
 		"urn": "tel:+16055741111",
 		"uuid": "%s"
 	}`, optIn.ID(), session.ID(), msg4.UUID()))
+
+	// a templated message on a channel which has translations of the template, with the right number of variables
+	flowMsg5 := flows.NewMsgOut(
+		cathyURN,
+		assets.NewChannelReference(testdata.FacebookChannel.UUID, "Facebook"),
+		"Hi there",
+		nil, nil,
+		flows.NewMsgTemplating(assets.NewTemplateReference("9c22b594-fcab-4b29-9bcb-ce4404894a80", "revive_issue"), []string{"name", "tooth"}, "tpls"),
+		flows.NilMsgTopic,
+		`eng-US`,
+		flows.NilUnsendableReason,
+	)
+	msg5, err := models.NewOutgoingFlowMsg(rt, oa.Org(), oa.ChannelByUUID(testdata.FacebookChannel.UUID), session, flow, flowMsg5, tpl, time.Date(2021, 11, 9, 14, 3, 30, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, models.MsgStatusQueued, msg5.Status())
+
+	createAndAssertCourierMsg(t, ctx, rt, oa, msg5, cathyURNs[0], fmt.Sprintf(`{
+		"channel_uuid": "%s",
+		"contact_id": 10000,
+		"contact_last_seen_on": "2023-04-20T10:15:00Z",
+		"contact_urn_id": 10000,
+		"created_on": "2021-11-09T14:03:30Z",
+		"flow": {"uuid": "9de3663f-c5c5-4c92-9f45-ecbc09abcc85", "name": "Favorites"},
+		"high_priority": true,
+		"id": 6,
+		"locale": "eng-US",
+		"metadata": {
+			"templating": {
+				"template": {"uuid": "9c22b594-fcab-4b29-9bcb-ce4404894a80", "name": "revive_issue"},
+				"variables": ["name", "tooth"],
+				"namespace": "tpls",
+				"language": "en_US"
+			}
+		},
+		"org_id": 1,
+		"origin": "flow",
+		"response_to_external_id": "EX123",
+		"session_id": %d,
+		"session_status": "W",
+		"text": "Hi there",
+		"tps_cost": 1,
+		"urn": "tel:+16055741111",
+		"uuid": "%s"
+	}`, testdata.FacebookChannel.UUID, session.ID(), msg5.UUID()))
 }
 
 func createAndAssertCourierMsg(t *testing.T, ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, m *models.Msg, u *models.ContactURN, expectedJSON string) {
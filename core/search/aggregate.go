@@ -0,0 +1,120 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+)
+
+// FacetType is the type of a facet
+type FacetType string
+
+// facet types
+const (
+	FacetTypeField     = FacetType("field")
+	FacetTypeGroup     = FacetType("group")
+	FacetTypeScheme    = FacetType("scheme")
+	FacetTypeLanguage  = FacetType("language")
+	FacetTypeCreatedOn = FacetType("created_on")
+)
+
+const (
+	// number of buckets returned for a facet if it doesn't specify a limit
+	defaultFacetLimit = 10
+
+	// maximum number of buckets that can be returned for a facet
+	maxFacetLimit = 100
+)
+
+// Facet is a breakdown of the contacts matching a query into buckets, e.g. by the value of a field. Datetime fields and
+// created_on are bucketed by week, starting on Monday in the org's timezone.
+type Facet struct {
+	Type  FacetType `json:"type"  validate:"required,eq=field|eq=group|eq=scheme|eq=language|eq=created_on"`
+	Key   string    `json:"key"   validate:"required_if=Type field"`
+	Limit int       `json:"limit" validate:"omitempty,min=1"`
+}
+
+// returns the maximum number of buckets for this facet
+func (f *Facet) limit() int {
+	if f.Limit <= 0 {
+		return defaultFacetLimit
+	}
+	if f.Limit > maxFacetLimit {
+		return maxFacetLimit
+	}
+	return f.Limit
+}
+
+// Bucket is the count of contacts for a single value of a facet. Values are ordered by count except for weeks which are
+// in chronological order and are the most recent weeks if there are more than the limit.
+type Bucket struct {
+	Key   string `json:"key"`
+	Name  string `json:"name,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// GetContactAggregations returns the total count of contacts matching the given query and their breakdowns by each of
+// the given facets
+func GetContactAggregations(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query string, facets []*Facet) (*contactql.ContactQuery, int64, [][]*Bucket, error) {
+	start := time.Now()
+
+	parsed, err := parseSearchQuery(rt, oa, query)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	for _, f := range facets {
+		if f.Type == FacetTypeField && oa.FieldByKey(f.Key) == nil {
+			return nil, 0, nil, contactql.NewQueryError(contactql.ErrUnknownProperty, "can't resolve '%s' to a field", f.Key)
+		}
+	}
+
+	total, buckets, err := GetBackend(rt).Aggregate(ctx, rt, oa, parsed, facets)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	recordSearch(rt, oa, "aggregate", query, time.Since(start))
+
+	slog.Debug("contact aggregation complete", "org_id", oa.OrgID(), "query", query, "elapsed", time.Since(start), "facets", len(facets))
+
+	return parsed, total, buckets, nil
+}
+
+// builds a bucket for a group, returning nil if the group no longer exists
+func groupBucket(oa *models.OrgAssets, id models.GroupID, count int64) *Bucket {
+	group := oa.GroupByID(id)
+	if group == nil {
+		return nil
+	}
+	return &Bucket{Key: string(group.UUID()), Name: group.Name(), Count: count}
+}
+
+// returns the most recent limit buckets of a chronological list of weekly buckets
+func recentWeeks(buckets []*Bucket, limit int) []*Bucket {
+	if len(buckets) > limit {
+		return buckets[len(buckets)-limit:]
+	}
+	return buckets
+}
+
+// sorts buckets by count descending and then by key
+func sortBuckets(buckets []*Bucket) {
+	sort.SliceStable(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return buckets[i].Key < buckets[j].Key
+	})
+}
+
+// returns whether the given facet buckets contacts by week
+func isWeeklyFacet(oa *models.OrgAssets, f *Facet) bool {
+	return f.Type == FacetTypeCreatedOn || (f.Type == FacetTypeField && oa.FieldByKey(f.Key).Type() == assets.FieldTypeDatetime)
+}
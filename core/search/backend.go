@@ -0,0 +1,55 @@
+package search
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+)
+
+// supported search backends
+const (
+	BackendElastic  = "elastic"
+	BackendPostgres = "postgres"
+)
+
+// Backend is a way of finding the contacts which match a parsed contactql query
+type Backend interface {
+	// Count returns the number of contacts matching the given query
+	Count(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery) (int64, error)
+
+	// FindPage returns a sorted page of the contacts in the given group which match the given query, as well as the
+	// total number of matches
+	FindPage(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, query *contactql.ContactQuery, sort string, offset int, pageSize int) ([]models.ContactID, int64, error)
+
+	// FindAll returns up to limit unsorted active contacts which match the given query, where -1 means no limit
+	FindAll(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, limit int) ([]models.ContactID, error)
+
+	// Stream passes pages of active contacts which match the given query to fn, in ascending order of ID and starting
+	// after the given ID, along with the total number of matches after that ID
+	Stream(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, after models.ContactID, pageSize int, fn PageFunc) error
+
+	// Aggregate returns the number of contacts which match the given query and the buckets of each of the given facets
+	Aggregate(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, facets []*Facet) (int64, [][]*Bucket, error)
+
+	// IndexLag returns how long it can take for changes to contacts to be reflected in searches
+	IndexLag() time.Duration
+}
+
+// PageFunc is called with each page of contact ids streamed from a search
+type PageFunc func(ids []models.ContactID, total int) error
+
+var backends = map[string]Backend{
+	BackendElastic:  &elasticBackend{},
+	BackendPostgres: &postgresBackend{},
+}
+
+// GetBackend returns the search backend selected by the given runtime's config
+func GetBackend(rt *runtime.Runtime) Backend {
+	if b := backends[rt.Config.SearchBackend]; b != nil {
+		return b
+	}
+	return backends[BackendElastic]
+}
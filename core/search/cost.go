@@ -0,0 +1,102 @@
+package search
+
+import (
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/mailroom/runtime"
+)
+
+// ErrQueryTooExpensive is the query error code for a query whose estimated cost exceeds the configured limit
+const ErrQueryTooExpensive = "too_expensive"
+
+// relative costs of the different parts of a query, where a simple equality condition costs 1
+const (
+	costCondition     = 1
+	costNegation      = 1  // != has to consider every contact that doesn't match
+	costRange         = 2  // comparisons of numbers and dates
+	costLocation      = 3  // location names are matched against paths
+	costNameContains  = 5  // tokenized match on names
+	costURNContains   = 10 // phrase match on nested URN paths, i.e. effectively a leading wildcard
+	costOrBranch      = 2  // each additional branch of an OR combination
+	costNestedOrScale = 2  // OR combinations inside other OR combinations multiply their cost
+)
+
+// EstimateQueryCost estimates the cost of running the given query. This is a rough measure of how expensive a query
+// will be for the search backend, and is mostly driven by text contains operators and the number of OR branches.
+func EstimateQueryCost(query *contactql.ContactQuery) int {
+	if query == nil {
+		return 0
+	}
+	return nodeCost(query.Resolver(), query.Root(), false)
+}
+
+func nodeCost(res contactql.Resolver, node contactql.QueryNode, inOr bool) int {
+	switch n := node.(type) {
+	case *contactql.BoolCombination:
+		isOr := n.Operator() == contactql.BoolOperatorOr
+		cost := 0
+		for _, child := range n.Children() {
+			cost += nodeCost(res, child, inOr || isOr)
+		}
+		if isOr {
+			cost += (len(n.Children()) - 1) * costOrBranch
+			if inOr {
+				cost *= costNestedOrScale
+			}
+		}
+		return cost
+
+	case *contactql.Condition:
+		return conditionCost(res, n)
+	}
+
+	return costCondition
+}
+
+func conditionCost(res contactql.Resolver, c *contactql.Condition) int {
+	cost := costCondition
+
+	if c.Operator() == contactql.OpNotEqual {
+		cost += costNegation
+	}
+
+	// checks for whether a property is set are cheap regardless of its type
+	if c.Value() == "" {
+		return cost
+	}
+
+	switch c.Operator() {
+	case contactql.OpContains:
+		if c.PropertyType() == contactql.PropertyTypeAttribute && c.PropertyKey() == contactql.AttributeName {
+			return cost + costNameContains
+		}
+		return cost + costURNContains
+	case contactql.OpGreaterThan, contactql.OpGreaterThanOrEqual, contactql.OpLessThan, contactql.OpLessThanOrEqual:
+		cost += costRange
+	}
+
+	if c.PropertyType() == contactql.PropertyTypeField && res != nil {
+		if field := res.ResolveField(c.PropertyKey()); field != nil {
+			switch field.Type() {
+			case assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+				cost += costLocation
+			}
+		}
+	}
+
+	return cost
+}
+
+// CheckQueryCost checks the estimated cost of the given query against the configured limit, returning a query error if
+// it's too expensive to run
+func CheckQueryCost(rt *runtime.Runtime, query *contactql.ContactQuery) error {
+	if rt.Config.SearchMaxQueryCost <= 0 || query == nil {
+		return nil
+	}
+
+	cost := EstimateQueryCost(query)
+	if cost > rt.Config.SearchMaxQueryCost {
+		return contactql.NewQueryError(ErrQueryTooExpensive, "query is too complex to run, try removing some conditions or contains operators")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package search_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/assets/static"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateQueryCost(t *testing.T) {
+	env := envs.NewBuilder().Build()
+	resolver := contactql.NewMockResolver([]assets.Field{
+		static.NewField("f1b5aea6-6586-41c7-9020-1a6326cc6565", "gender", "Gender", assets.FieldTypeText),
+		static.NewField("6c86d5ab-3fd9-4a5c-a5b6-48168b016747", "age", "Age", assets.FieldTypeNumber),
+		static.NewField("b0f4e4a4-5d6f-4f5e-8f2a-6c0f1e1b4a65", "state", "State", assets.FieldTypeState),
+	}, nil, nil)
+
+	tcs := []struct {
+		query string
+		cost  int
+	}{
+		{`gender = M`, 1},
+		{`gender != M`, 2},
+		{`gender = ""`, 1},
+		{`age > 10`, 3},
+		{`state = Kigali`, 4},
+		{`name ~ bob`, 6},
+		{`tel ~ 0788`, 11},
+		{`gender = M OR gender = F OR age > 10`, 9},
+		{`(gender = M OR age > 10) AND (name ~ bob OR tel ~ 078)`, 25},
+		{`gender = M OR (age > 10 AND (gender = F OR tel ~ 078))`, 34},
+	}
+
+	for _, tc := range tcs {
+		parsed, err := contactql.ParseQuery(env, tc.query, resolver)
+		require.NoError(t, err, "error parsing query: %s", tc.query)
+
+		assert.Equal(t, tc.cost, search.EstimateQueryCost(parsed), "cost mismatch for query: %s", tc.query)
+	}
+
+	assert.Equal(t, 0, search.EstimateQueryCost(nil))
+}
+
+func TestQueryCostLimit(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer func() { rt.Config.SearchMaxQueryCost = 0 }()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	rt.Config.SearchMaxQueryCost = 10
+
+	_, _, err = search.GetContactTotal(ctx, rt, oa, "george")
+	assert.NoError(t, err)
+
+	_, _, _, err = search.GetContactIDsForQueryPage(ctx, rt, oa, nil, nil, "tel ~ 0788 OR name ~ bob", "", 0, 50)
+	assert.EqualError(t, err, "error checking query: tel ~ 0788 OR name ~ bob: query is too complex to run, try removing some conditions or contains operators")
+
+	isQueryErr, qerr := contactql.IsQueryError(err)
+	assert.True(t, isQueryErr)
+	assert.Equal(t, search.ErrQueryTooExpensive, qerr.(*contactql.QueryError).Code())
+
+	// zero means no limit
+	rt.Config.SearchMaxQueryCost = 0
+
+	_, _, _, err = search.GetContactIDsForQueryPage(ctx, rt, oa, nil, nil, "tel ~ 0788 OR name ~ bob", "", 0, 50)
+	assert.NoError(t, err)
+}
+
+func TestSlowSearches(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+	defer func() { rt.Config.SearchSlowThreshold = 5000 }()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	// nothing is slow with the default threshold
+	_, _, err = search.GetContactTotal(ctx, rt, oa, "george")
+	require.NoError(t, err)
+
+	searches, err := search.GetSlowSearches(ctx, rt, models.NilOrgID)
+	assert.NoError(t, err)
+	assert.Len(t, searches, 0)
+
+	// but everything is with a threshold of 1ms
+	rt.Config.SearchSlowThreshold = 1
+
+	_, _, err = search.GetContactTotal(ctx, rt, oa, "george")
+	require.NoError(t, err)
+	_, _, _, err = search.GetContactIDsForQueryPage(ctx, rt, oa, nil, nil, "age > 10", "", 0, 50)
+	require.NoError(t, err)
+
+	searches, err = search.GetSlowSearches(ctx, rt, models.NilOrgID)
+	assert.NoError(t, err)
+	assert.Len(t, searches, 2)
+	assert.GreaterOrEqual(t, searches[0].ElapsedMS, searches[1].ElapsedMS)
+
+	for _, s := range searches {
+		assert.Equal(t, testdata.Org1.ID, s.OrgID)
+		assert.Contains(t, []string{"count", "page"}, s.Type)
+	}
+
+	// and can be filtered by org
+	searches, err = search.GetSlowSearches(ctx, rt, testdata.Org2.ID)
+	assert.NoError(t, err)
+	assert.Len(t, searches, 0)
+
+	// searches older than a week are ignored, and pruned when the next slow search is recorded
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	old, _ := json.Marshal(&search.SlowSearch{OrgID: testdata.Org1.ID, Type: "count", Query: "old", ElapsedMS: 100000, SearchedOn: time.Now().Add(-8 * 24 * time.Hour)})
+	_, err = rc.Do("ZADD", "slow_searches", 100000, string(old))
+	require.NoError(t, err)
+
+	searches, err = search.GetSlowSearches(ctx, rt, models.NilOrgID)
+	assert.NoError(t, err)
+	assert.Len(t, searches, 2)
+
+	_, _, err = search.GetContactTotal(ctx, rt, oa, "bob")
+	require.NoError(t, err)
+
+	assertredis.ZCard(t, rt.RP, "slow_searches", 3)
+}
@@ -2,47 +2,170 @@ package search
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/runtime"
-	"github.com/olivere/elastic/v7"
 	"github.com/pkg/errors"
 )
 
 // PopulateSmartGroup calculates which members should be part of a group and populates the contacts
 // for that group by performing the minimum number of inserts / deletes.
-func PopulateSmartGroup(ctx context.Context, rt *runtime.Runtime, es *elastic.Client, oa *models.OrgAssets, groupID models.GroupID, query string) (int, error) {
+func PopulateSmartGroup(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, groupID models.GroupID, query string) (int, error) {
 	err := models.UpdateGroupStatus(ctx, rt.DB, groupID, models.GroupStatusEvaluating)
 	if err != nil {
 		return 0, errors.Wrapf(err, "error marking dynamic group as evaluating")
 	}
 
+	count, _, _, err := syncSmartGroup(ctx, rt, oa, groupID, query)
+	if err != nil {
+		return 0, err
+	}
+
+	// mark our group as no longer evaluating
+	err = models.UpdateGroupStatus(ctx, rt.DB, groupID, models.GroupStatusReady)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error marking dynamic group as ready")
+	}
+
+	return count, nil
+}
+
+// TimeRelativeGroups returns the smart groups of the given org whose queries compare dates with relative dates, e.g.
+// `last_seen_on < "30 days ago"`, and so whose membership changes as time passes. Groups with invalid queries are ignored.
+func TimeRelativeGroups(oa *models.OrgAssets) []*models.Group {
+	all, _ := oa.Groups()
+	groups := make([]*models.Group, 0)
+
+	for _, g := range all {
+		group := oa.GroupByID(g.(*models.Group).ID()) // engine groups have their relative dates resolved
+		if group.Type() != models.GroupTypeSmart || len(models.FindRelativeDateConditions(oa, group.Query())) == 0 {
+			continue
+		}
+
+		if _, err := ParseQuery(oa, group.Query()); err == nil {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// ReevaluateSmartGroup re-evaluates the query of an already populated group whose query has relative dates, only adding
+// and removing the contacts whose membership has changed. If the group was last evaluated on the given day then only
+// contacts with dates between where the relative dates fell on that day and where they fall today are re-evaluated,
+// otherwise the whole group is. Unlike PopulateSmartGroup the status of the group isn't changed. Returns the number of
+// contacts added and removed.
+func ReevaluateSmartGroup(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, groupID models.GroupID, query string, lastDay dates.Date) (int, int, error) {
+	if lastDay.Equal(dates.ZeroDate) {
+		_, added, removed, err := syncSmartGroup(ctx, rt, oa, groupID, query)
+		return added, removed, err
+	}
+
+	today := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()))
+	conds := models.FindRelativeDateConditions(oa, query)
+	if lastDay.Compare(today) >= 0 || len(conds) == 0 {
+		return 0, 0, nil
+	}
+
+	// build a query for the contacts whose dates are between where the boundaries of the relative conditions were and
+	// where they are now, as only these contacts can have had their membership changed by the passing of time
+	windows := make([]string, 0, len(conds))
+	for _, c := range conds {
+		todays := c.Boundaries(today)
+		for i, last := range c.Boundaries(lastDay) {
+			windows = append(windows, fmt.Sprintf("(%s >= %s AND %s <= %s)", c.Property, models.FormatQueryDate(oa, last), c.Property, models.FormatQueryDate(oa, todays[i])))
+		}
+	}
+	window := strings.Join(windows, " OR ")
+
+	if err := waitForIndex(ctx, rt, oa); err != nil {
+		return 0, 0, err
+	}
+
+	candidates, err := GetContactIDsForQuery(ctx, rt, oa, window, -1)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "error finding contacts near date boundaries for group: %d", groupID)
+	}
+	if len(candidates) == 0 {
+		return 0, 0, nil
+	}
+
+	qualifying, err := GetContactIDsForQuery(ctx, rt, oa, fmt.Sprintf("(%s) AND (%s)", query, window), -1)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "error performing query: %s for group: %d", query, groupID)
+	}
+
+	current, err := models.FilterContactIDsInGroup(ctx, rt.DB, groupID, candidates)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "unable to look up contact ids for group: %d", groupID)
+	}
+
+	present := make(map[models.ContactID]bool, len(current))
+	for _, id := range current {
+		present[id] = true
+	}
+
+	adds := make([]models.ContactID, 0, 10)
+	for _, id := range qualifying {
+		if !present[id] {
+			adds = append(adds, id)
+		}
+		delete(present, id)
+	}
+
+	removals := make([]models.ContactID, 0, len(present))
+	for id := range present {
+		removals = append(removals, id)
+	}
+
+	if err := updateGroupContacts(ctx, rt, oa, groupID, adds, removals); err != nil {
+		return 0, 0, err
+	}
+
+	return len(adds), len(removals), nil
+}
+
+// waits until any contacts changed before now should have been indexed by the search backend
+func waitForIndex(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets) error {
 	start := time.Now()
 
 	// we have a bit of a race with the indexer process.. we want to make sure that any contacts that changed
 	// before this group was updated but after the last index are included, so if a contact was modified
-	// more recently than 10 seconds ago, we wait that long before starting in populating our group
-	newest, err := models.GetNewestContactModifiedOn(ctx, rt.DB, oa)
-	if err != nil {
-		return 0, errors.Wrapf(err, "error getting most recent contact modified_on for org: %d", oa.OrgID())
+	// more recently than the backend's index lag, we wait that long before starting in populating our group
+	if lag := GetBackend(rt).IndexLag(); lag > 0 {
+		newest, err := models.GetNewestContactModifiedOn(ctx, rt.DB, oa)
+		if err != nil {
+			return errors.Wrapf(err, "error getting most recent contact modified_on for org: %d", oa.OrgID())
+		}
+		if newest != nil {
+			n := *newest
+
+			// if it was more recent than the lag, sleep until it has been that long
+			if n.Add(lag).After(start) {
+				sleep := n.Add(lag).Sub(start)
+				slog.Info("sleeping before evaluating dynamic group", "sleep", sleep)
+				time.Sleep(sleep)
+			}
+		}
 	}
-	if newest != nil {
-		n := *newest
+	return nil
+}
 
-		// if it was more recent than 10 seconds ago, sleep until it has been 10 seconds
-		if n.Add(time.Second * 10).After(start) {
-			sleep := n.Add(time.Second * 10).Sub(start)
-			slog.Info("sleeping before evaluating dynamic group", "sleep", sleep)
-			time.Sleep(sleep)
-		}
+// updates the membership of a group to match its query, returning the new count of contacts and the number of
+// contacts added and removed
+func syncSmartGroup(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, groupID models.GroupID, query string) (int, int, int, error) {
+	if err := waitForIndex(ctx, rt, oa); err != nil {
+		return 0, 0, 0, err
 	}
 
 	// get current set of contacts in our group
 	ids, err := models.ContactIDsForGroupIDs(ctx, rt.DB, []models.GroupID{groupID})
 	if err != nil {
-		return 0, errors.Wrapf(err, "unable to look up contact ids for group: %d", groupID)
+		return 0, 0, 0, errors.Wrapf(err, "unable to look up contact ids for group: %d", groupID)
 	}
 	present := make(map[models.ContactID]bool, len(ids))
 	for _, i := range ids {
@@ -52,7 +175,7 @@ func PopulateSmartGroup(ctx context.Context, rt *runtime.Runtime, es *elastic.Cl
 	// calculate new set of ids
 	new, err := GetContactIDsForQuery(ctx, rt, oa, query, -1)
 	if err != nil {
-		return 0, errors.Wrapf(err, "error performing query: %s for group: %d", query, groupID)
+		return 0, 0, 0, errors.Wrapf(err, "error performing query: %s for group: %d", query, groupID)
 	}
 
 	// find which contacts need to be added or removed
@@ -70,22 +193,25 @@ func PopulateSmartGroup(ctx context.Context, rt *runtime.Runtime, es *elastic.Cl
 		removals = append(removals, id)
 	}
 
-	// first remove all the contacts
-	err = models.RemoveContactsFromGroupAndCampaigns(ctx, rt.DB, oa, groupID, removals)
-	if err != nil {
-		return 0, errors.Wrapf(err, "error removing contacts from group: %d", groupID)
+	if err := updateGroupContacts(ctx, rt, oa, groupID, adds, removals); err != nil {
+		return 0, 0, 0, err
 	}
 
-	// then add them all
-	err = models.AddContactsToGroupAndCampaigns(ctx, rt.DB, oa, groupID, adds)
+	return len(new), len(adds), len(removals), nil
+}
+
+// adds and removes the given contacts to and from a group, updating campaign events for them
+func updateGroupContacts(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, groupID models.GroupID, adds, removals []models.ContactID) error {
+	// first remove all the contacts
+	err := models.RemoveContactsFromGroupAndCampaigns(ctx, rt.DB, oa, groupID, removals, models.GroupChangeCauseSmartGroup)
 	if err != nil {
-		return 0, errors.Wrapf(err, "error adding contacts to group: %d", groupID)
+		return errors.Wrapf(err, "error removing contacts from group: %d", groupID)
 	}
 
-	// mark our group as no longer evaluating
-	err = models.UpdateGroupStatus(ctx, rt.DB, groupID, models.GroupStatusReady)
+	// then add them all
+	err = models.AddContactsToGroupAndCampaigns(ctx, rt.DB, oa, groupID, adds, models.GroupChangeCauseSmartGroup)
 	if err != nil {
-		return 0, errors.Wrapf(err, "error marking dynamic group as ready")
+		return errors.Wrapf(err, "error adding contacts to group: %d", groupID)
 	}
 
 	// finally update modified_on for all affected contacts to ensure these changes are seen by rp-indexer
@@ -95,8 +221,8 @@ func PopulateSmartGroup(ctx context.Context, rt *runtime.Runtime, es *elastic.Cl
 
 	err = models.UpdateContactModifiedOn(ctx, rt.DB, changed)
 	if err != nil {
-		return 0, errors.Wrapf(err, "error updating contact modified_on after group population")
+		return errors.Wrapf(err, "error updating contact modified_on after group population")
 	}
 
-	return len(new), nil
+	return nil
 }
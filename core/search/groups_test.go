@@ -5,12 +5,14 @@ import (
 	"testing"
 
 	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSmartGroups(t *testing.T) {
@@ -61,7 +63,7 @@ func TestSmartGroups(t *testing.T) {
 		err := models.UpdateGroupStatus(ctx, rt.DB, testdata.DoctorsGroup.ID, models.GroupStatusInitializing)
 		assert.NoError(t, err)
 
-		count, err := search.PopulateSmartGroup(ctx, rt, rt.ES, oa, testdata.DoctorsGroup.ID, tc.query)
+		count, err := search.PopulateSmartGroup(ctx, rt, oa, testdata.DoctorsGroup.ID, tc.query)
 		assert.NoError(t, err, "error populating smart group for: %s", tc.query)
 
 		assert.Equal(t, count, len(tc.expectedContactIDs), "%d: contact count mismatch", i)
@@ -81,3 +83,71 @@ func TestSmartGroups(t *testing.T) {
 			Returns(len(tc.expectedEventIDs), "wrong contacts with events for query: %s", tc.query)
 	}
 }
+
+func TestTimeRelativeGroups(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	testdata.InsertContactGroup(rt, testdata.Org1, "3d8c6c2a-5c3e-4a55-9b56-7e3e2c0c8b01", "Joined Recently", `joined > "7 days ago"`)
+	testdata.InsertContactGroup(rt, testdata.Org1, "1b1f3b7d-02e3-4a8e-9f5a-1e1a6a5d9c02", "New Or Women", `created_on > today OR gender = F`)
+	testdata.InsertContactGroup(rt, testdata.Org1, "7e0b2f3c-8c0a-4b41-bd5f-4b7e1f0a2d03", "Joined 2023", "joined > 2023-01-01")
+	testdata.InsertContactGroup(rt, testdata.Org1, "9f5c1d2e-6a7b-4c8d-9e0f-1a2b3c4d5e04", "Never Seen", `last_seen_on = ""`)
+	testdata.InsertContactGroup(rt, testdata.Org1, "6a7b8c9d-0e1f-4a2b-8c3d-4e5f6a7b8c05", "Invalid", `created_on < "2 days ago" AND xyz = 1`)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshGroups)
+	require.NoError(t, err)
+
+	names := make([]string, 0)
+	for _, g := range search.TimeRelativeGroups(oa) {
+		names = append(names, g.Name())
+	}
+	assert.ElementsMatch(t, []string{"Joined Recently", "New Or Women"}, names)
+}
+
+func TestReevaluateSmartGroup(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	query := `gender = F AND created_on < "1 day ago"`
+
+	// Bob is wrongly in the group and Cathy is wrongly missing from it
+	group := testdata.InsertContactGroup(rt, testdata.Org1, "e52fee05-2f95-4445-aef6-2fe7dac2fd56", "Old Women", query, testdata.Bob)
+
+	testsuite.ReindexElastic(ctx)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshGroups)
+	require.NoError(t, err)
+
+	// never re-evaluated so whole group is evaluated
+	added, removed, err := search.ReevaluateSmartGroup(ctx, rt, oa, group.ID, query, dates.ZeroDate)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, removed)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, group.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT contact_id FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, group.ID).Returns(int64(testdata.Cathy.ID))
+
+	today := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()))
+
+	// already re-evaluated today so nothing to do
+	added, removed, err = search.ReevaluateSmartGroup(ctx, rt, oa, group.ID, query, today)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 0, removed)
+
+	// George becomes a woman created 2 days ago, and Bob is put back in the group but his created_on is outside of the
+	// window of dates that could have changed since 3 days ago, so only George is affected
+	rt.DB.MustExec(`UPDATE contacts_contact SET created_on = NOW() - INTERVAL '2 days', fields = fields || jsonb_build_object($2::text, jsonb_build_object('text', 'F')) WHERE id = $1`, testdata.George.ID, testdata.GenderField.UUID)
+	rt.DB.MustExec(`INSERT INTO contacts_contactgroup_contacts(contact_id, contactgroup_id) VALUES($1, $2)`, testdata.Bob.ID, group.ID)
+
+	testsuite.ReindexElastic(ctx)
+
+	threeDaysAgo := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()).AddDate(0, 0, -3))
+
+	added, removed, err = search.ReevaluateSmartGroup(ctx, rt, oa, group.ID, query, threeDaysAgo)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 0, removed)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1 AND contact_id = ANY($2)`, group.ID, pq.Array([]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID})).Returns(3)
+}
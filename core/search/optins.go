@@ -0,0 +1,136 @@
+package search
+
+import (
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/contactql/es"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/olivere/elastic/v7"
+)
+
+// PropertyOptIn is the property used to query contacts by the optins they are currently opted into, e.g. optin = "Newsletter"
+const PropertyOptIn = "optin"
+
+// query error codes for conditions on optins
+const (
+	ErrInvalidOptIn     = "invalid_optin"
+	ErrUnsupportedOptIn = "unsupported_optin"
+)
+
+// contactql doesn't know about optins so we expose them to the parser as a text field which we then convert
+// to a query on the contact optins table ourselves
+type optInField struct{}
+
+func (f *optInField) UUID() assets.FieldUUID { return "" }
+func (f *optInField) Key() string            { return PropertyOptIn }
+func (f *optInField) Name() string           { return "Opt-In" }
+func (f *optInField) Type() assets.FieldType { return assets.FieldTypeText }
+
+// resolver resolves query properties using the session assets of an org, plus optins
+type resolver struct {
+	oa *models.OrgAssets
+}
+
+func (r *resolver) ResolveField(key string) assets.Field {
+	// a real field with the same key takes precedence
+	if f := r.oa.SessionAssets().ResolveField(key); f != nil {
+		return f
+	}
+	if key == PropertyOptIn {
+		return &optInField{}
+	}
+	return nil
+}
+
+func (r *resolver) ResolveGroup(name string) assets.Group {
+	return r.oa.SessionAssets().ResolveGroup(name)
+}
+
+func (r *resolver) ResolveFlow(name string) assets.Flow {
+	return r.oa.SessionAssets().ResolveFlow(name)
+}
+
+// ParseQuery parses the given query using the assets of the given org. Relative dates, which contactql doesn't
+// support, are resolved to where they fall today in the org's timezone.
+func ParseQuery(oa *models.OrgAssets, query string) (*contactql.ContactQuery, error) {
+	query = models.ResolveRelativeDates(oa, query, dates.ExtractDate(dates.Now().In(oa.Env().Timezone())))
+
+	parsed, err := contactql.ParseQuery(oa.Env(), query, &resolver{oa: oa})
+	if err != nil {
+		return nil, err
+	}
+
+	var invalid error
+	walkOptInConditions(parsed, func(c *contactql.Condition) {
+		if invalid == nil && c.Value() != "" && oa.OptInByName(c.Value()) == nil {
+			invalid = contactql.NewQueryError(ErrInvalidOptIn, "'%s' is not a valid optin name", c.Value())
+		}
+	})
+	if invalid != nil {
+		return nil, invalid
+	}
+
+	return parsed, nil
+}
+
+// InspectQuery inspects the given query, hiding the optin pseudo field from the returned field references
+func InspectQuery(query *contactql.ContactQuery) *contactql.Inspection {
+	inspection := contactql.Inspect(query)
+
+	if usesOptIn(query) {
+		fields := make([]*assets.FieldReference, 0, len(inspection.Fields))
+		for _, f := range inspection.Fields {
+			if f.Key != PropertyOptIn {
+				fields = append(fields, f)
+			}
+		}
+		inspection.Fields = fields
+
+		// smart groups are also evaluated by the engine which can't see optins
+		inspection.AllowAsGroup = false
+	}
+
+	return inspection
+}
+
+// converts the given query to an elastic query. The indexer doesn't index which optins contacts are opted into so
+// queries on optins can only be performed with the postgres backend.
+func toElasticQuery(oa *models.OrgAssets, query *contactql.ContactQuery) (elastic.Query, error) {
+	if usesOptIn(query) {
+		return nil, contactql.NewQueryError(ErrUnsupportedOptIn, "optin conditions aren't supported when searching with Elasticsearch")
+	}
+
+	return es.ToElasticQuery(oa.Env(), assetMapper, query), nil
+}
+
+func isOptInCondition(res contactql.Resolver, c *contactql.Condition) bool {
+	if c.PropertyType() != contactql.PropertyTypeField || c.PropertyKey() != PropertyOptIn || res == nil {
+		return false
+	}
+	_, isOptIn := res.ResolveField(c.PropertyKey()).(*optInField)
+	return isOptIn
+}
+
+func usesOptIn(query *contactql.ContactQuery) bool {
+	uses := false
+	walkOptInConditions(query, func(*contactql.Condition) { uses = true })
+	return uses
+}
+
+func walkOptInConditions(query *contactql.ContactQuery, fn func(*contactql.Condition)) {
+	var walk func(contactql.QueryNode)
+	walk = func(node contactql.QueryNode) {
+		switch n := node.(type) {
+		case *contactql.BoolCombination:
+			for _, child := range n.Children() {
+				walk(child)
+			}
+		case *contactql.Condition:
+			if isOptInCondition(query.Resolver(), n) {
+				fn(n)
+			}
+		}
+	}
+	walk(query.Root())
+}
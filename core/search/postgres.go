@@ -0,0 +1,621 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/stringsx"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// contact statuses as they're named in queries
+var sqlContactStatuses = map[string]models.ContactStatus{
+	"active":   models.ContactStatusActive,
+	"blocked":  models.ContactStatusBlocked,
+	"stopped":  models.ContactStatusStopped,
+	"archived": models.ContactStatusArchived,
+}
+
+// postgresBackend searches contacts directly in the database, for deployments which don't run Elasticsearch
+type postgresBackend struct{}
+
+func (b *postgresBackend) Count(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery) (int64, error) {
+	where, args, err := BuildSQLQuery(oa, nil, models.NilContactStatus, nil, query)
+	if err != nil {
+		return 0, errors.Wrap(err, "error building query")
+	}
+
+	var count int64
+	if err := rt.ReadonlyDB.QueryRowContext(ctx, `SELECT count(*) FROM contacts_contact c WHERE `+where, args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "error performing query")
+	}
+	return count, nil
+}
+
+func (b *postgresBackend) FindPage(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, query *contactql.ContactQuery, sort string, offset int, pageSize int) ([]models.ContactID, int64, error) {
+	where, args, err := BuildSQLQuery(oa, group, models.NilContactStatus, excludeIDs, query)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error building query")
+	}
+
+	orderBy, err := toSQLSort(oa, sort)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error parsing sort")
+	}
+
+	var total int64
+	if err := rt.ReadonlyDB.QueryRowContext(ctx, `SELECT count(*) FROM contacts_contact c WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, errors.Wrap(err, "error performing query")
+	}
+
+	sql := fmt.Sprintf(`SELECT c.id FROM contacts_contact c WHERE %s ORDER BY %s LIMIT %d OFFSET %d`, where, orderBy, pageSize, offset)
+
+	ids, err := queryContactIDs(ctx, rt, sql, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ids, total, nil
+}
+
+func (b *postgresBackend) FindAll(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, limit int) ([]models.ContactID, error) {
+	where, args, err := BuildSQLQuery(oa, nil, models.ContactStatusActive, nil, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "error building query")
+	}
+
+	sql := `SELECT c.id FROM contacts_contact c WHERE ` + where
+	if limit >= 0 {
+		sql += fmt.Sprintf(` LIMIT %d`, limit)
+	}
+
+	return queryContactIDs(ctx, rt, sql, args)
+}
+
+func (b *postgresBackend) Stream(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, after models.ContactID, pageSize int, fn PageFunc) error {
+	where, args, err := BuildSQLQuery(oa, nil, models.ContactStatusActive, nil, query)
+	if err != nil {
+		return errors.Wrap(err, "error building query")
+	}
+	where += fmt.Sprintf(" AND c.id > $%d", len(args)+1)
+
+	var total int
+	if err := rt.ReadonlyDB.QueryRowContext(ctx, `SELECT count(*) FROM contacts_contact c WHERE `+where, append(args, after)...).Scan(&total); err != nil {
+		return errors.Wrap(err, "error performing query")
+	}
+
+	sql := fmt.Sprintf(`SELECT c.id FROM contacts_contact c WHERE %s ORDER BY c.id LIMIT %d`, where, pageSize)
+
+	for {
+		ids, err := queryContactIDs(ctx, rt, sql, append(args, after))
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := fn(ids, total); err != nil {
+			return err
+		}
+
+		if len(ids) < pageSize {
+			return nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+func (b *postgresBackend) Aggregate(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, facets []*Facet) (int64, [][]*Bucket, error) {
+	where, args, err := BuildSQLQuery(oa, nil, models.NilContactStatus, nil, query)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "error building query")
+	}
+
+	var total int64
+	if err := rt.ReadonlyDB.QueryRowContext(ctx, `SELECT count(*) FROM contacts_contact c WHERE `+where, args...).Scan(&total); err != nil {
+		return 0, nil, errors.Wrap(err, "error performing query")
+	}
+
+	buckets := make([][]*Bucket, len(facets))
+	for i, f := range facets {
+		sql, fargs, err := toSQLAggregation(oa, f, where, args)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "error building aggregation")
+		}
+
+		rows, err := rt.ReadonlyDB.QueryContext(ctx, sql, fargs...)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "error performing aggregation")
+		}
+
+		buckets[i] = make([]*Bucket, 0)
+		for rows.Next() {
+			var key string
+			var count int64
+			if err := rows.Scan(&key, &count); err != nil {
+				rows.Close()
+				return 0, nil, errors.Wrap(err, "error scanning aggregation bucket")
+			}
+
+			if f.Type == FacetTypeGroup {
+				groupID, _ := strconv.Atoi(key)
+				if bucket := groupBucket(oa, models.GroupID(groupID), count); bucket != nil {
+					buckets[i] = append(buckets[i], bucket)
+				}
+			} else {
+				buckets[i] = append(buckets[i], &Bucket{Key: key, Count: count})
+			}
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return 0, nil, errors.Wrap(err, "error performing aggregation")
+		}
+
+		// weeks are selected most recent first so that the limit keeps the most recent
+		if isWeeklyFacet(oa, f) {
+			slices.Reverse(buckets[i])
+		}
+	}
+
+	return total, buckets, nil
+}
+
+// builds the SQL to select the buckets of a facet for contacts matching the given condition, which return the same keys
+// as the equivalent Elastic aggregations
+func toSQLAggregation(oa *models.OrgAssets, f *Facet, where string, args []any) (string, []any, error) {
+	from := "contacts_contact c"
+	count := "count(*)"
+	var key string
+
+	weekly := func(expr string) string {
+		args = append(args, oa.Env().Timezone().String())
+		return fmt.Sprintf("to_char(date_trunc('week', %s AT TIME ZONE $%d), 'YYYY-MM-DD')", expr, len(args))
+	}
+
+	switch f.Type {
+	case FacetTypeField:
+		field := oa.FieldByKey(f.Key)
+		if field == nil {
+			return "", nil, errors.Errorf("no such field with key: %s", f.Key)
+		}
+
+		// field UUIDs are safe to include as they are validated when fields are loaded
+		value := fmt.Sprintf("(c.fields->'%s'->>'%s')", field.UUID(), field.Type())
+
+		switch field.Type() {
+		case assets.FieldTypeNumber:
+			key = fmt.Sprintf("trim_scale(%s::numeric)::text", value)
+		case assets.FieldTypeDatetime:
+			key = weekly(value + "::timestamptz")
+		case assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+			key = locationName(value)
+		default:
+			key = "LOWER(" + value + ")"
+		}
+	case FacetTypeGroup:
+		from += " JOIN contacts_contactgroup_contacts gc ON gc.contact_id = c.id"
+		key = "gc.contactgroup_id::text"
+	case FacetTypeScheme:
+		// a contact can have multiple URNs with the same scheme so count contacts rather than URNs
+		from += " JOIN contacts_contacturn u ON u.contact_id = c.id"
+		key = "u.scheme"
+		count = "count(DISTINCT c.id)"
+	case FacetTypeLanguage:
+		key = "c.language"
+	case FacetTypeCreatedOn:
+		key = weekly("c.created_on")
+	default:
+		return "", nil, errors.Errorf("unsupported facet type: %s", f.Type)
+	}
+
+	orderBy := "2 DESC, 1"
+	if isWeeklyFacet(oa, f) {
+		orderBy = "1 DESC"
+	}
+
+	sql := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s AND %s IS NOT NULL GROUP BY 1 ORDER BY %s LIMIT %d`, key, count, from, where, key, orderBy, f.limit())
+	return sql, args, nil
+}
+
+// changes to contacts are visible to the database immediately
+func (b *postgresBackend) IndexLag() time.Duration {
+	return 0
+}
+
+func queryContactIDs(ctx context.Context, rt *runtime.Runtime, sql string, args []any) ([]models.ContactID, error) {
+	rows, err := rt.ReadonlyDB.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing query")
+	}
+	defer rows.Close()
+
+	ids := make([]models.ContactID, 0, 100)
+	for rows.Next() {
+		var id models.ContactID
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "error scanning contact id")
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, errors.Wrap(rows.Err(), "error performing query")
+}
+
+// BuildSQLQuery turns the passed in contactql query into a condition on contacts_contact aliased as c, returning the
+// condition and its parameters
+func BuildSQLQuery(oa *models.OrgAssets, group *models.Group, status models.ContactStatus, excludeIDs []models.ContactID, query *contactql.ContactQuery) (string, []any, error) {
+	b := &sqlBuilder{oa: oa}
+
+	// filter by org and active contacts
+	conds := []string{fmt.Sprintf("c.org_id = %s", b.arg(oa.OrgID())), "c.is_active = TRUE"}
+
+	// our group if present
+	if group != nil {
+		conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM contacts_contactgroup_contacts g WHERE g.contact_id = c.id AND g.contactgroup_id = %s)", b.arg(group.ID())))
+	}
+
+	// our status is present
+	if status != models.NilContactStatus {
+		conds = append(conds, fmt.Sprintf("c.status = %s", b.arg(status)))
+	}
+
+	// exclude ids if present
+	if len(excludeIDs) > 0 {
+		conds = append(conds, fmt.Sprintf("NOT (c.id = ANY(%s))", b.arg(pq.Array(excludeIDs))))
+	}
+
+	// and by our query if present
+	if query != nil {
+		b.resolver = query.Resolver()
+
+		cond, err := b.node(query.Root())
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	return strings.Join(conds, " AND "), b.args, nil
+}
+
+// sqlBuilder builds SQL conditions from query nodes, collecting parameters as it goes. Conditions are built so that
+// they are never NULL, so that they can be safely negated, and they have the same semantics as the Elastic queries
+// built by goflow, e.g. foo != x is true if foo is not set.
+type sqlBuilder struct {
+	oa       *models.OrgAssets
+	resolver contactql.Resolver
+	args     []any
+}
+
+// adds a parameter, returning its placeholder
+func (b *sqlBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *sqlBuilder) node(node contactql.QueryNode) (string, error) {
+	switch n := node.(type) {
+	case *contactql.BoolCombination:
+		conds := make([]string, len(n.Children()))
+		for i, child := range n.Children() {
+			cond, err := b.node(child)
+			if err != nil {
+				return "", err
+			}
+			conds[i] = cond
+		}
+		if n.Operator() == contactql.BoolOperatorAnd {
+			return "(" + strings.Join(conds, " AND ") + ")", nil
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", nil
+
+	case *contactql.Condition:
+		if isOptInCondition(b.resolver, n) {
+			return b.optInCondition(n), nil
+		}
+
+		switch n.PropertyType() {
+		case contactql.PropertyTypeField:
+			return b.fieldCondition(n)
+		case contactql.PropertyTypeAttribute:
+			return b.attributeCondition(n)
+		case contactql.PropertyTypeScheme:
+			return b.schemeCondition(n), nil
+		}
+		return "", errors.Errorf("unsupported property type: %s", n.PropertyType())
+	}
+
+	return "", errors.Errorf("unsupported node type: %T", node)
+}
+
+func (b *sqlBuilder) fieldCondition(c *contactql.Condition) (string, error) {
+	field := b.resolver.ResolveField(c.PropertyKey())
+	if field == nil {
+		return "", errors.Errorf("no such field with key: %s", c.PropertyKey())
+	}
+
+	fieldType := field.Type()
+	value := fmt.Sprintf("(c.fields->%s->>'%s')", b.arg(string(field.UUID())), fieldType)
+
+	if isSetCheck(c) {
+		return existence(c, value+" IS NOT NULL"), nil
+	}
+
+	switch fieldType {
+	case assets.FieldTypeText:
+		return equality(c, fmt.Sprintf("COALESCE(LOWER(%s) = %s, FALSE)", value, b.arg(strings.ToLower(c.Value())))), nil
+	case assets.FieldTypeNumber:
+		return b.numberComparison(c, value+"::numeric")
+	case assets.FieldTypeDatetime:
+		return b.dateComparison(c, value+"::timestamptz")
+	case assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+		return equality(c, fmt.Sprintf("COALESCE(%s = %s, FALSE)", locationName(value), b.arg(strings.ToLower(c.Value())))), nil
+	}
+
+	return "", errors.Errorf("unsupported field type: %s", fieldType)
+}
+
+func (b *sqlBuilder) attributeCondition(c *contactql.Condition) (string, error) {
+	key := c.PropertyKey()
+	value := strings.ToLower(c.Value())
+
+	switch key {
+	case contactql.AttributeUUID:
+		return equality(c, fmt.Sprintf("c.uuid::text = %s", b.arg(value))), nil
+
+	case contactql.AttributeID:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return equality(c, "FALSE"), nil
+		}
+		return equality(c, fmt.Sprintf("c.id = %s", b.arg(id))), nil
+
+	case contactql.AttributeName:
+		if isSetCheck(c) {
+			return existence(c, "COALESCE(c.name, '') != ''"), nil
+		}
+		if c.Operator() == contactql.OpContains {
+			return fmt.Sprintf("COALESCE(LOWER(c.name) ~ %s, FALSE)", b.arg(namePrefixRegex(value))), nil
+		}
+		return equality(c, fmt.Sprintf("COALESCE(LOWER(c.name) = %s, FALSE)", b.arg(value))), nil
+
+	case contactql.AttributeStatus:
+		return equality(c, fmt.Sprintf("c.status = %s", b.arg(sqlContactStatuses[value]))), nil
+
+	case contactql.AttributeLanguage:
+		if isSetCheck(c) {
+			return existence(c, "COALESCE(c.language, '') != ''"), nil
+		}
+		return equality(c, fmt.Sprintf("COALESCE(c.language = %s, FALSE)", b.arg(value))), nil
+
+	case contactql.AttributeCreatedOn:
+		return b.dateComparison(c, "c.created_on")
+
+	case contactql.AttributeLastSeenOn:
+		if isSetCheck(c) {
+			return existence(c, "c.last_seen_on IS NOT NULL"), nil
+		}
+		return b.dateComparison(c, "c.last_seen_on")
+
+	case contactql.AttributeURN:
+		return b.urnCondition(c, ""), nil
+
+	case contactql.AttributeGroup:
+		if isSetCheck(c) {
+			return existence(c, "EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc JOIN contacts_contactgroup g ON g.id = gc.contactgroup_id WHERE gc.contact_id = c.id AND g.group_type IN ('M', 'Q'))"), nil
+		}
+		group := c.ValueAsGroup(b.resolver).(*models.Group)
+		return equality(c, fmt.Sprintf("EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc WHERE gc.contact_id = c.id AND gc.contactgroup_id = %s)", b.arg(group.ID()))), nil
+
+	case contactql.AttributeFlow:
+		if isSetCheck(c) {
+			return existence(c, "c.current_flow_id IS NOT NULL"), nil
+		}
+		flow := c.ValueAsFlow(b.resolver).(*models.Flow)
+		return equality(c, fmt.Sprintf("COALESCE(c.current_flow_id = %s, FALSE)", b.arg(flow.ID()))), nil
+
+	case contactql.AttributeHistory:
+		if isSetCheck(c) {
+			return existence(c, "EXISTS (SELECT 1 FROM flows_flowrun r WHERE r.contact_id = c.id)"), nil
+		}
+		flow := c.ValueAsFlow(b.resolver).(*models.Flow)
+		return equality(c, fmt.Sprintf("EXISTS (SELECT 1 FROM flows_flowrun r WHERE r.contact_id = c.id AND r.flow_id = %s)", b.arg(flow.ID()))), nil
+
+	case contactql.AttributeTickets:
+		return b.numberComparison(c, "c.ticket_count")
+	}
+
+	return "", errors.Errorf("unsupported contact attribute: %s", key)
+}
+
+func (b *sqlBuilder) schemeCondition(c *contactql.Condition) string {
+	return b.urnCondition(c, c.PropertyKey())
+}
+
+// builds a condition on the contact's URNs, optionally limited to the given scheme
+func (b *sqlBuilder) urnCondition(c *contactql.Condition, scheme string) string {
+	urnExists := "EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id"
+	if scheme != "" {
+		urnExists += fmt.Sprintf(" AND u.scheme = %s", b.arg(scheme))
+	}
+
+	if isSetCheck(c) {
+		return existence(c, urnExists+")")
+	}
+
+	value := strings.ToLower(c.Value())
+
+	if c.Operator() == contactql.OpContains {
+		return fmt.Sprintf("%s AND LOWER(u.path) LIKE %s)", urnExists, b.arg("%"+escapeLike(value)+"%"))
+	}
+	return equality(c, fmt.Sprintf("%s AND LOWER(u.path) = %s)", urnExists, b.arg(value)))
+}
+
+// optins are searched by which the contact is currently opted into
+func (b *sqlBuilder) optInCondition(c *contactql.Condition) string {
+	optInExists := "EXISTS (SELECT 1 FROM contacts_contactoptin o WHERE o.contact_id = c.id AND o.status = 'I'"
+
+	if isSetCheck(c) {
+		return existence(c, optInExists+")")
+	}
+
+	optInID := models.NilOptInID
+	if optIn := b.oa.OptInByName(c.Value()); optIn != nil {
+		optInID = optIn.ID()
+	}
+	return equality(c, fmt.Sprintf("%s AND o.optin_id = %s)", optInExists, b.arg(optInID)))
+}
+
+func (b *sqlBuilder) numberComparison(c *contactql.Condition, expr string) (string, error) {
+	value, _ := c.ValueAsNumber()
+	arg := b.arg(value.String()) + "::numeric"
+
+	switch c.Operator() {
+	case contactql.OpEqual:
+		return fmt.Sprintf("COALESCE(%s = %s, FALSE)", expr, arg), nil
+	case contactql.OpNotEqual:
+		return fmt.Sprintf("NOT COALESCE(%s = %s, FALSE)", expr, arg), nil
+	case contactql.OpGreaterThan:
+		return fmt.Sprintf("COALESCE(%s > %s, FALSE)", expr, arg), nil
+	case contactql.OpGreaterThanOrEqual:
+		return fmt.Sprintf("COALESCE(%s >= %s, FALSE)", expr, arg), nil
+	case contactql.OpLessThan:
+		return fmt.Sprintf("COALESCE(%s < %s, FALSE)", expr, arg), nil
+	case contactql.OpLessThanOrEqual:
+		return fmt.Sprintf("COALESCE(%s <= %s, FALSE)", expr, arg), nil
+	}
+
+	return "", errors.Errorf("unsupported number operator: %s", c.Operator())
+}
+
+// dates are compared by day in the timezone of the org
+func (b *sqlBuilder) dateComparison(c *contactql.Condition, expr string) (string, error) {
+	value, _ := c.ValueAsDate(b.oa.Env())
+	start, end := dates.DayToUTCRange(value, value.Location())
+
+	switch c.Operator() {
+	case contactql.OpEqual:
+		return fmt.Sprintf("COALESCE(%s >= %s AND %s < %s, FALSE)", expr, b.arg(start), expr, b.arg(end)), nil
+	case contactql.OpNotEqual:
+		return fmt.Sprintf("NOT COALESCE(%s >= %s AND %s < %s, FALSE)", expr, b.arg(start), expr, b.arg(end)), nil
+	case contactql.OpGreaterThan:
+		return fmt.Sprintf("COALESCE(%s >= %s, FALSE)", expr, b.arg(end)), nil
+	case contactql.OpGreaterThanOrEqual:
+		return fmt.Sprintf("COALESCE(%s >= %s, FALSE)", expr, b.arg(start)), nil
+	case contactql.OpLessThan:
+		return fmt.Sprintf("COALESCE(%s < %s, FALSE)", expr, b.arg(start)), nil
+	case contactql.OpLessThanOrEqual:
+		return fmt.Sprintf("COALESCE(%s < %s, FALSE)", expr, b.arg(end)), nil
+	}
+
+	return "", errors.Errorf("unsupported date operator: %s", c.Operator())
+}
+
+// converts the given sort, e.g. -age, to an ORDER BY clause, with missing values always last and ties broken by
+// most recent contact first
+func toSQLSort(oa *models.OrgAssets, sortBy string) (string, error) {
+	// default to most recent first by id
+	if sortBy == "" {
+		return "c.id DESC", nil
+	}
+
+	// figure out if we are ascending or descending (default is ascending, can be changed with leading -)
+	property := sortBy
+	direction := "ASC"
+	if strings.HasPrefix(sortBy, "-") {
+		direction = "DESC"
+		property = sortBy[1:]
+	}
+
+	property = strings.ToLower(property)
+
+	var expr string
+
+	switch property {
+	case contactql.AttributeID:
+		return "c.id " + direction, nil
+	case contactql.AttributeName:
+		expr = "LOWER(c.name)"
+	case contactql.AttributeCreatedOn, contactql.AttributeLastSeenOn, contactql.AttributeLanguage:
+		expr = "c." + property
+	default:
+		// we are sorting by a custom field
+		field := oa.SessionAssets().ResolveField(property)
+		if field == nil {
+			return "", errors.Errorf("no such field with key: %s", property)
+		}
+
+		// field UUIDs are safe to include as they are validated when fields are loaded
+		value := fmt.Sprintf("(c.fields->'%s'->>'%s')", field.UUID(), field.Type())
+
+		switch field.Type() {
+		case assets.FieldTypeNumber:
+			expr = value + "::numeric"
+		case assets.FieldTypeDatetime:
+			expr = value + "::timestamptz"
+		case assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+			expr = locationName(value)
+		default:
+			expr = "LOWER(" + value + ")"
+		}
+	}
+
+	return fmt.Sprintf("%s %s NULLS LAST, c.id DESC", expr, direction), nil
+}
+
+// location values are full paths but we only search against the name of the location
+func locationName(value string) string {
+	return fmt.Sprintf(`LOWER(regexp_replace(%s, '^.* > ', ''))`, value)
+}
+
+// name contains conditions match if any word of the name starts with any token of the value, where tokens are
+// truncated to 8 characters, which is equivalent to how names are indexed in Elastic
+func namePrefixRegex(value string) string {
+	tokens := make([]string, 0)
+	for _, token := range utils.TokenizeStringByUnicodeSeg(value) {
+		if len(token) >= 2 {
+			tokens = append(tokens, regexp.QuoteMeta(stringsx.Truncate(token, 8)))
+		}
+	}
+	return `\m(` + strings.Join(tokens, "|") + `)`
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func isSetCheck(c *contactql.Condition) bool {
+	return (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && c.Value() == ""
+}
+
+// for set checks, x = "" is true if x doesn't exist and x != "" is true if it does
+func existence(c *contactql.Condition, exists string) string {
+	if c.Operator() == contactql.OpEqual {
+		return "NOT " + exists
+	}
+	return exists
+}
+
+// for equality conditions, x != y is the negation of x = y
+func equality(c *contactql.Condition, equals string) string {
+	if c.Operator() == contactql.OpNotEqual {
+		return "NOT " + equals
+	}
+	return equals
+}
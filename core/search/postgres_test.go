@@ -0,0 +1,123 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSQLQuery(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	tcs := []struct {
+		query        string
+		expectedSQL  string
+		expectedArgs []any
+	}{
+		{
+			query:        "age > 30",
+			expectedSQL:  `c.org_id = $1 AND c.is_active = TRUE AND COALESCE((c.fields->$2->>'number')::numeric > $3::numeric, FALSE)`,
+			expectedArgs: []any{testdata.Org1.ID, string(testdata.AgeField.UUID), "30"},
+		},
+		{
+			query:        `gender != "F" OR name = ""`,
+			expectedSQL:  `c.org_id = $1 AND c.is_active = TRUE AND (NOT COALESCE(LOWER((c.fields->$2->>'text')) = $3, FALSE) OR NOT COALESCE(c.name, '') != '')`,
+			expectedArgs: []any{testdata.Org1.ID, string(testdata.GenderField.UUID), "f"},
+		},
+		{
+			query:        "tel ~ 0605",
+			expectedSQL:  `c.org_id = $1 AND c.is_active = TRUE AND EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id AND u.scheme = $2 AND LOWER(u.path) LIKE $3)`,
+			expectedArgs: []any{testdata.Org1.ID, "tel", "%0605%"},
+		},
+		{
+			query:        "bob smith",
+			expectedSQL:  `c.org_id = $1 AND c.is_active = TRUE AND (COALESCE(LOWER(c.name) ~ $2, FALSE) AND COALESCE(LOWER(c.name) ~ $3, FALSE))`,
+			expectedArgs: []any{testdata.Org1.ID, `\m(bob)`, `\m(smith)`},
+		},
+		{
+			query:        "group = Doctors",
+			expectedSQL:  `c.org_id = $1 AND c.is_active = TRUE AND EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc WHERE gc.contact_id = c.id AND gc.contactgroup_id = $2)`,
+			expectedArgs: []any{testdata.Org1.ID, testdata.DoctorsGroup.ID},
+		},
+	}
+
+	for _, tc := range tcs {
+		parsed, err := search.ParseQuery(oa, tc.query)
+		require.NoError(t, err, "error parsing query: %s", tc.query)
+
+		sql, args, err := search.BuildSQLQuery(oa, nil, models.NilContactStatus, nil, parsed)
+		require.NoError(t, err, "error building query: %s", tc.query)
+		assert.Equal(t, tc.expectedSQL, sql, "sql mismatch for query: %s", tc.query)
+		assert.Equal(t, tc.expectedArgs, args, "args mismatch for query: %s", tc.query)
+	}
+}
+
+func TestPostgresMatchesElastic(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer func() { rt.Config.SearchBackend = search.BackendElastic }()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	queries := []string{
+		`cathy`,
+		`name = "Cathy"`,
+		`name != "Cathy"`,
+		`name = ""`,
+		`id = 10000`,
+		`uuid = 6393abc0-283d-4c9b-a1b3-641a035c34bf`,
+		`status = active`,
+		`status != blocked`,
+		`language = eng`,
+		`language = ""`,
+		`created_on > 2015-01-01`,
+		`last_seen_on = ""`,
+		`tel ~ 0605`,
+		`tel = +16055741111`,
+		`urn != ""`,
+		`twitter = ""`,
+		`age > 20`,
+		`age = ""`,
+		`age != 30`,
+		`gender = F`,
+		`gender != F`,
+		`joined < 2020-01-01`,
+		`joined != ""`,
+		`group = Doctors`,
+		`group != Doctors`,
+		`flow = ""`,
+		`history = Favorites`,
+		`tickets > 0`,
+		`(age > 20 OR gender = M) AND tel != ""`,
+	}
+
+	for _, query := range queries {
+		rt.Config.SearchBackend = search.BackendElastic
+		expected, err := search.GetContactIDsForQuery(ctx, rt, oa, query, -1)
+		require.NoError(t, err, "error performing query with elastic: %s", query)
+
+		rt.Config.SearchBackend = search.BackendPostgres
+		actual, err := search.GetContactIDsForQuery(ctx, rt, oa, query, -1)
+		require.NoError(t, err, "error performing query with postgres: %s", query)
+
+		assert.ElementsMatch(t, expected, actual, "results mismatch for query: %s", query)
+	}
+
+	// optin conditions can only be searched with postgres
+	rt.Config.SearchBackend = search.BackendElastic
+	_, err = search.GetContactIDsForQuery(ctx, rt, oa, `optin = ""`, -1)
+	assert.EqualError(t, err, "optin conditions aren't supported when searching with Elasticsearch")
+
+	rt.Config.SearchBackend = search.BackendPostgres
+	_, err = search.GetContactIDsForQuery(ctx, rt, oa, `optin = ""`, -1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	resolveProgressKey    = "resolve_progress:%s"
+	resolveProgressExpire = 60 * 60 * 24 // 1 day
+)
+
+// ResolveProgress records how far a task has got through streaming its recipients, so that if the task is retried
+// it can resume after the last contact it processed rather than starting again
+type ResolveProgress struct {
+	key string
+
+	LastID    models.ContactID
+	Processed int
+}
+
+// LoadResolveProgress loads the progress saved with the given name, e.g. start:1234, returning empty progress if
+// there is none. Progress with an empty name is never saved.
+func LoadResolveProgress(rc redis.Conn, name string) (*ResolveProgress, error) {
+	if name == "" {
+		return &ResolveProgress{}, nil
+	}
+
+	p := &ResolveProgress{key: fmt.Sprintf(resolveProgressKey, name)}
+
+	values, err := redis.IntMap(rc.Do("HGETALL", p.key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading resolve progress")
+	}
+
+	p.LastID = models.ContactID(values["last_id"])
+	p.Processed = values["processed"]
+	return p, nil
+}
+
+// Record records that the given contacts have been processed
+func (p *ResolveProgress) Record(rc redis.Conn, ids []models.ContactID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	p.LastID = ids[len(ids)-1]
+	p.Processed += len(ids)
+
+	if p.key == "" {
+		return nil
+	}
+
+	rc.Send("MULTI")
+	rc.Send("HSET", p.key, "last_id", p.LastID, "processed", p.Processed)
+	rc.Send("EXPIRE", p.key, resolveProgressExpire)
+	_, err := rc.Do("EXEC")
+	return errors.Wrapf(err, "error saving resolve progress")
+}
+
+// Clear clears this progress once everything has been processed
+func (p *ResolveProgress) Clear(rc redis.Conn) error {
+	if p.key == "" {
+		return nil
+	}
+
+	_, err := rc.Do("DEL", p.key)
+	return errors.Wrapf(err, "error clearing resolve progress")
+}
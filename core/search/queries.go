@@ -17,7 +17,7 @@ func BuildRecipientsQuery(oa *models.OrgAssets, flow *models.Flow, groups []*mod
 	var err error
 
 	if userQuery != "" {
-		parsedQuery, err = contactql.ParseQuery(oa.Env(), userQuery, oa.SessionAssets())
+		parsedQuery, err = ParseQuery(oa, userQuery)
 		if err != nil {
 			return "", errors.Wrap(err, "invalid user query")
 		}
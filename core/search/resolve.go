@@ -2,6 +2,7 @@ package search
 
 import (
 	"context"
+	"slices"
 
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/flows"
@@ -19,14 +20,36 @@ type Recipients struct {
 	ExcludeGroupIDs []models.GroupID
 }
 
+// RecipientsPageFunc is called with each page of resolved recipients, along with the total number of recipients
+// after the ID that resolving started after
+type RecipientsPageFunc func(ids []models.ContactID, total int) error
+
 // ResolveRecipients resolves a set of contacts, groups, urns etc into a set of unique contacts
 func ResolveRecipients(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, flow *models.Flow, recipients *Recipients, limit int) ([]models.ContactID, error) {
+	matches := make([]models.ContactID, 0)
+
+	err := StreamRecipients(ctx, rt, oa, flow, recipients, limit, models.NilContactID, func(ids []models.ContactID, total int) error {
+		matches = append(matches, ids...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// StreamRecipients resolves a set of contacts, groups, urns etc into a set of unique contacts which are passed to fn
+// in pages. Contacts are resolved in ascending order of ID, skipping any up to and including the given ID, so that a
+// caller which remembers the last contact it processed can resume from there. Contacts created from URNs are always
+// passed last.
+func StreamRecipients(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, flow *models.Flow, recipients *Recipients, limit int, after models.ContactID, fn RecipientsPageFunc) error {
 	idsSeen := make(map[models.ContactID]bool)
 
 	// start by loading the explicitly listed contacts
 	includeContacts, err := models.LoadContacts(ctx, rt.DB, oa, recipients.ContactIDs)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for _, c := range includeContacts {
 		idsSeen[c.ID()] = true
@@ -39,7 +62,7 @@ func ResolveRecipients(ctx context.Context, rt *runtime.Runtime, oa *models.OrgA
 	if len(recipients.URNs) > 0 {
 		fetchedByURN, createdByURN, err := models.GetOrCreateContactsFromURNs(ctx, rt.DB, oa, recipients.URNs)
 		if err != nil {
-			return nil, errors.Wrap(err, "error getting contact ids from urns")
+			return errors.Wrap(err, "error getting contact ids from urns")
 		}
 		for _, c := range fetchedByURN {
 			if !idsSeen[c.ID()] {
@@ -67,45 +90,85 @@ func ResolveRecipients(ctx context.Context, rt *runtime.Runtime, oa *models.OrgA
 		}
 	}
 
-	var matches []models.ContactID
+	created := make([]models.ContactID, 0, len(createdContacts))
+	for _, c := range createdContacts {
+		created = append(created, c.ID())
+	}
 
 	// if we're only including individual contacts and there are no exclusions, we can just return those contacts
 	if len(includeGroups) == 0 && recipients.Query == "" && recipients.Exclusions == models.NoExclusions && len(excludeGroups) == 0 {
-		matches := make([]models.ContactID, 0, len(includeContacts)+len(createdContacts))
+		matches := make([]models.ContactID, 0, len(includeContacts))
 		for _, c := range includeContacts {
 			matches = append(matches, c.ID())
 		}
-		for _, c := range createdContacts {
-			matches = append(matches, c.ID())
-		}
-		return matches, nil
+		return streamSlice(matches, created, after, fn)
 	}
 
-	if len(includeContacts) > 0 || len(includeGroups) > 0 || recipients.Query != "" {
-		// reduce contacts to UUIDs
-		includeContactUUIDs := make([]flows.ContactUUID, len(includeContacts))
-		for i, contact := range includeContacts {
-			includeContactUUIDs[i] = contact.UUID()
-		}
+	// only add created contacts if not excluding contacts based on last seen - other exclusions can't apply to a newly
+	// created contact
+	if recipients.Exclusions.NotSeenSinceDays > 0 {
+		created = created[:0]
+	}
 
-		query, err := BuildRecipientsQuery(oa, flow, includeGroups, includeContactUUIDs, recipients.Query, recipients.Exclusions, excludeGroups)
-		if err != nil {
-			return nil, errors.Wrap(err, "error building query")
-		}
+	if len(includeContacts) == 0 && len(includeGroups) == 0 && recipients.Query == "" {
+		return streamSlice(nil, created, after, fn)
+	}
 
-		matches, err = GetContactIDsForQuery(ctx, rt, oa, query, limit)
+	// reduce contacts to UUIDs
+	includeContactUUIDs := make([]flows.ContactUUID, len(includeContacts))
+	for i, contact := range includeContacts {
+		includeContactUUIDs[i] = contact.UUID()
+	}
+
+	query, err := BuildRecipientsQuery(oa, flow, includeGroups, includeContactUUIDs, recipients.Query, recipients.Exclusions, excludeGroups)
+	if err != nil {
+		return errors.Wrap(err, "error building query")
+	}
+
+	// limited searches are small enough to do in one go
+	if limit >= 0 {
+		matches, err := GetContactIDsForQuery(ctx, rt, oa, query, limit)
 		if err != nil {
-			return nil, errors.Wrap(err, "error performing contact search")
+			return errors.Wrap(err, "error performing contact search")
 		}
+		return streamSlice(matches, created, after, fn)
 	}
 
-	// only add created contacts if not excluding contacts based on last seen - other exclusions can't apply to a newly
-	// created contact
-	if recipients.Exclusions.NotSeenSinceDays == 0 {
-		for _, c := range createdContacts {
-			matches = append(matches, c.ID())
+	parsed, err := ParseQuery(oa, query)
+	if err != nil {
+		return errors.Wrapf(err, "error performing contact search: error parsing query: %s", query)
+	}
+
+	// created contacts are passed after all the contacts found by searching
+	total := len(created)
+
+	err = GetBackend(rt).Stream(ctx, rt, oa, parsed, after, streamPageSize, func(ids []models.ContactID, found int) error {
+		total = found + len(created)
+		return fn(ids, total)
+	})
+	if err != nil {
+		return errors.Wrap(err, "error performing contact search")
+	}
+
+	if len(created) > 0 {
+		return fn(created, total)
+	}
+	return nil
+}
+
+// passes the given contacts after the given ID to fn as a single page, followed by any created contacts
+func streamSlice(ids, created []models.ContactID, after models.ContactID, fn RecipientsPageFunc) error {
+	page := make([]models.ContactID, 0, len(ids)+len(created))
+	for _, id := range ids {
+		if id > after {
+			page = append(page, id)
 		}
 	}
+	slices.Sort(page)
+	page = append(page, created...)
 
-	return matches, nil
+	if len(page) == 0 {
+		return nil
+	}
+	return fn(page, len(page))
 }
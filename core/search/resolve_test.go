@@ -8,6 +8,7 @@ import (
 	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -103,3 +104,77 @@ func TestResolveRecipients(t *testing.T) {
 		assert.ElementsMatch(t, tc.expectedIDs, actualIDs, "contact ids mismatch in %d", i)
 	}
 }
+
+func TestStreamRecipients(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	testsuite.ReindexElastic(ctx)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	recipients := &search.Recipients{Query: `name = "Cathy" OR name = "Bob" OR name = "George"`}
+
+	for _, backend := range []string{search.BackendElastic, search.BackendPostgres} {
+		rt.Config.SearchBackend = backend
+
+		var pages [][]models.ContactID
+		var totals []int
+		collect := func(ids []models.ContactID, total int) error {
+			pages = append(pages, ids)
+			totals = append(totals, total)
+			return nil
+		}
+
+		err = search.StreamRecipients(ctx, rt, oa, nil, recipients, -1, models.NilContactID, collect)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]models.ContactID{{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID}}, pages, "pages mismatch for %s", backend)
+		assert.Equal(t, []int{3}, totals, "totals mismatch for %s", backend)
+
+		// resuming after a contact only gives us the contacts after it
+		pages, totals = nil, nil
+
+		err = search.StreamRecipients(ctx, rt, oa, nil, recipients, -1, testdata.Cathy.ID, collect)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]models.ContactID{{testdata.Bob.ID, testdata.George.ID}}, pages, "pages mismatch for %s", backend)
+		assert.Equal(t, []int{2}, totals, "totals mismatch for %s", backend)
+	}
+}
+
+func TestResolveProgress(t *testing.T) {
+	_, rt := testsuite.Runtime()
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	p, err := search.LoadResolveProgress(rc, "start:123")
+	require.NoError(t, err)
+	assert.Equal(t, models.NilContactID, p.LastID)
+	assert.Equal(t, 0, p.Processed)
+
+	err = p.Record(rc, []models.ContactID{10000, 10001})
+	assert.NoError(t, err)
+	err = p.Record(rc, []models.ContactID{10002})
+	assert.NoError(t, err)
+
+	assertredis.HGetAll(t, rt.RP, "resolve_progress:start:123", map[string]string{"last_id": "10002", "processed": "3"})
+
+	p, err = search.LoadResolveProgress(rc, "start:123")
+	require.NoError(t, err)
+	assert.Equal(t, models.ContactID(10002), p.LastID)
+	assert.Equal(t, 3, p.Processed)
+
+	err = p.Clear(rc)
+	assert.NoError(t, err)
+
+	assertredis.NotExists(t, rt.RP, "resolve_progress:start:123")
+
+	// progress without a name isn't saved
+	p, err = search.LoadResolveProgress(rc, "")
+	require.NoError(t, err)
+	assert.NoError(t, p.Record(rc, []models.ContactID{10000}))
+	assert.Equal(t, models.ContactID(10000), p.LastID)
+}
@@ -3,7 +3,6 @@ package search
 import (
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"strconv"
 	"time"
@@ -32,7 +31,7 @@ func (m *AssetMapper) Group(g assets.Group) int64 {
 var assetMapper = &AssetMapper{}
 
 // BuildElasticQuery turns the passed in contact ql query into an elastic query
-func BuildElasticQuery(oa *models.OrgAssets, group *models.Group, status models.ContactStatus, excludeIDs []models.ContactID, query *contactql.ContactQuery) elastic.Query {
+func BuildElasticQuery(oa *models.OrgAssets, group *models.Group, status models.ContactStatus, excludeIDs []models.ContactID, query *contactql.ContactQuery) (elastic.Query, error) {
 	// filter by org and active contacts
 	eq := elastic.NewBoolQuery().Must(
 		elastic.NewTermQuery("org_id", oa.OrgID()),
@@ -60,121 +59,179 @@ func BuildElasticQuery(oa *models.OrgAssets, group *models.Group, status models.
 
 	// and by our query if present
 	if query != nil {
-		q := es.ToElasticQuery(oa.Env(), assetMapper, query)
+		q, err := toElasticQuery(oa, query)
+		if err != nil {
+			return nil, err
+		}
 		eq = eq.Must(q)
 	}
 
-	return eq
+	return eq, nil
 }
 
 // GetContactTotal returns the total count of matching contacts for the given query
 func GetContactTotal(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query string) (*contactql.ContactQuery, int64, error) {
-	env := oa.Env()
-	var parsed *contactql.ContactQuery
-	var err error
+	start := time.Now()
 
-	if rt.ES == nil {
-		return nil, 0, errors.Errorf("no elastic client available, check your configuration")
+	parsed, err := parseSearchQuery(rt, oa, query)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if query != "" {
-		parsed, err = contactql.ParseQuery(env, query, oa.SessionAssets())
-		if err != nil {
-			return nil, 0, errors.Wrapf(err, "error parsing query: %s", query)
-		}
+	count, err := GetBackend(rt).Count(ctx, rt, oa, parsed)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	eq := BuildElasticQuery(oa, nil, models.NilContactStatus, nil, parsed)
+	recordSearch(rt, oa, "count", query, time.Since(start))
 
-	count, err := rt.ES.Count(rt.Config.ElasticContactsIndex).Routing(strconv.FormatInt(int64(oa.OrgID()), 10)).Query(eq).Do(ctx)
+	return parsed, count, nil
+}
+
+// parses the given query for a search, rejecting it if it's too expensive to run
+func parseSearchQuery(rt *runtime.Runtime, oa *models.OrgAssets, query string) (*contactql.ContactQuery, error) {
+	if query == "" {
+		return nil, nil
+	}
+
+	parsed, err := ParseQuery(oa, query)
 	if err != nil {
-		// Get *elastic.Error which contains additional information
-		ee, ok := err.(*elastic.Error)
-		if !ok {
-			return nil, 0, errors.Wrap(err, "error performing query")
-		}
+		return nil, errors.Wrapf(err, "error parsing query: %s", query)
+	}
 
-		return nil, 0, errors.Wrapf(err, "error performing query: %s", ee.Details.Reason)
+	if err := CheckQueryCost(rt, parsed); err != nil {
+		return nil, errors.Wrapf(err, "error checking query: %s", query)
 	}
 
-	return parsed, count, nil
+	return parsed, nil
 }
 
 // GetContactIDsForQueryPage returns a page of contact ids for the given query and sort
 func GetContactIDsForQueryPage(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, query string, sort string, offset int, pageSize int) (*contactql.ContactQuery, []models.ContactID, int64, error) {
-	env := oa.Env()
-	index := rt.Config.ElasticContactsIndex
 	start := time.Now()
-	var parsed *contactql.ContactQuery
-	var err error
 
+	parsed, err := parseSearchQuery(rt, oa, query)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	ids, total, err := GetBackend(rt).FindPage(ctx, rt, oa, group, excludeIDs, parsed, sort, offset, pageSize)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	recordSearch(rt, oa, "page", query, time.Since(start))
+
+	slog.Debug("paged contact query complete",
+		"org_id", oa.OrgID(),
+		"query", query,
+		"elapsed", time.Since(start),
+		"page_count", len(ids),
+		"total_count", total,
+	)
+
+	return parsed, ids, total, nil
+}
+
+// GetContactIDsForQuery returns up to limit the contact ids that match the given query without sorting. Limit of -1 means return all.
+func GetContactIDsForQuery(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query string, limit int) ([]models.ContactID, error) {
+	start := time.Now()
+
+	parsed, err := ParseQuery(oa, query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing query: %s", query)
+	}
+
+	ids, err := GetBackend(rt).FindAll(ctx, rt, oa, parsed, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	recordSearch(rt, oa, "all", query, time.Since(start))
+
+	slog.Debug("contact query complete",
+		"org_id", oa.OrgID(),
+		"query", query,
+		"elapsed", time.Since(start),
+		"match_count", len(ids),
+	)
+
+	return ids, nil
+}
+
+const (
+	// page size used when streaming results to collect all matches
+	streamPageSize = 10000
+
+	// how long Elastic should keep a point in time open between page requests
+	pointInTimeKeepAlive = "5m"
+)
+
+// elasticBackend searches the contacts index which rp-indexer maintains in Elasticsearch
+type elasticBackend struct{}
+
+func (b *elasticBackend) Count(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery) (int64, error) {
 	if rt.ES == nil {
-		return nil, nil, 0, errors.Errorf("no elastic client available, check your configuration")
+		return 0, errors.Errorf("no elastic client available, check your configuration")
 	}
 
-	if query != "" {
-		parsed, err = contactql.ParseQuery(env, query, oa.SessionAssets())
-		if err != nil {
-			return nil, nil, 0, errors.Wrapf(err, "error parsing query: %s", query)
-		}
+	eq, err := BuildElasticQuery(oa, nil, models.NilContactStatus, nil, query)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := rt.ES.Count(rt.Config.ElasticContactsIndex).Routing(strconv.FormatInt(int64(oa.OrgID()), 10)).Query(eq).Do(ctx)
+	if err != nil {
+		return 0, elasticError(err)
+	}
+
+	return count, nil
+}
+
+func (b *elasticBackend) FindPage(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, query *contactql.ContactQuery, sort string, offset int, pageSize int) ([]models.ContactID, int64, error) {
+	if rt.ES == nil {
+		return nil, 0, errors.Errorf("no elastic client available, check your configuration")
 	}
 
-	eq := BuildElasticQuery(oa, group, models.NilContactStatus, excludeIDs, parsed)
+	eq, err := BuildElasticQuery(oa, group, models.NilContactStatus, excludeIDs, query)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	fieldSort, err := es.ToElasticFieldSort(sort, oa.SessionAssets())
 	if err != nil {
-		return nil, nil, 0, errors.Wrapf(err, "error parsing sort")
+		return nil, 0, errors.Wrapf(err, "error parsing sort")
 	}
 
-	s := rt.ES.Search(index).TrackTotalHits(true).Routing(strconv.FormatInt(int64(oa.OrgID()), 10))
+	s := rt.ES.Search(rt.Config.ElasticContactsIndex).TrackTotalHits(true).Routing(strconv.FormatInt(int64(oa.OrgID()), 10))
 	s = s.Size(pageSize).From(offset).Query(eq).SortBy(fieldSort).FetchSource(false)
 
 	results, err := s.Do(ctx)
 	if err != nil {
-		// Get *elastic.Error which contains additional information
-		ee, ok := err.(*elastic.Error)
-		if !ok {
-			return nil, nil, 0, errors.Wrapf(err, "error performing query")
-		}
-
-		return nil, nil, 0, errors.Wrapf(err, "error performing query: %s", ee.Details.Reason)
+		return nil, 0, elasticError(err)
 	}
 
 	ids := make([]models.ContactID, 0, pageSize)
 	ids, err = appendIDsFromHits(ids, results.Hits.Hits)
 	if err != nil {
-		return nil, nil, 0, err
+		return nil, 0, err
 	}
 
-	slog.Debug("paged contact query complete",
-		"org_id", oa.OrgID(),
-		"query", query,
-		"elapsed", time.Since(start),
-		"page_count", len(ids),
-		"total_count", results.Hits.TotalHits.Value,
-	)
-
-	return parsed, ids, results.Hits.TotalHits.Value, nil
+	return ids, results.Hits.TotalHits.Value, nil
 }
 
-// GetContactIDsForQuery returns up to limit the contact ids that match the given query without sorting. Limit of -1 means return all.
-func GetContactIDsForQuery(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query string, limit int) ([]models.ContactID, error) {
-	env := oa.Env()
+func (b *elasticBackend) FindAll(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, limit int) ([]models.ContactID, error) {
 	index := rt.Config.ElasticContactsIndex
-	start := time.Now()
 
 	if rt.ES == nil {
 		return nil, errors.Errorf("no elastic client available, check your configuration")
 	}
 
-	// turn into elastic query
-	parsed, err := contactql.ParseQuery(env, query, oa.SessionAssets())
+	routing := strconv.FormatInt(int64(oa.OrgID()), 10)
+	eq, err := BuildElasticQuery(oa, nil, models.ContactStatusActive, nil, query)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error parsing query: %s", query)
+		return nil, err
 	}
-
-	routing := strconv.FormatInt(int64(oa.OrgID()), 10)
-	eq := BuildElasticQuery(oa, nil, models.ContactStatusActive, nil, parsed)
 	ids := make([]models.ContactID, 0, 100)
 
 	// if limit provided that can be done with regular search, do that
@@ -186,32 +243,214 @@ func GetContactIDsForQuery(ctx context.Context, rt *runtime.Runtime, oa *models.
 		return appendIDsFromHits(ids, results.Hits.Hits)
 	}
 
-	// for larger limits, use scroll service
-	// note that this is no longer recommended, see https://www.elastic.co/guide/en/elasticsearch/reference/current/scroll-api.html
-	scroll := rt.ES.Scroll(index).Routing(routing).KeepAlive("15m").Size(10000).Query(eq).FetchSource(false)
-	for {
-		results, err := scroll.Do(ctx)
-		if err == io.EOF {
-			slog.Debug("contact query complete",
-				"org_id", oa.OrgID(),
-				"query", query,
-				"elapsed", time.Since(start),
-				"match_count", len(ids),
-			)
-
-			return ids, nil
+	// for larger limits, page through all results
+	err = b.Stream(ctx, rt, oa, query, models.NilContactID, streamPageSize, func(page []models.ContactID, total int) error {
+		ids = append(ids, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (b *elasticBackend) Stream(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, after models.ContactID, pageSize int, fn PageFunc) error {
+	if rt.ES == nil {
+		return errors.Errorf("no elastic client available, check your configuration")
+	}
+
+	eq, err := BuildElasticQuery(oa, nil, models.ContactStatusActive, nil, query)
+	if err != nil {
+		return err
+	}
+
+	// use a point in time so that we page through a consistent view of the index
+	pit, err := rt.ES.OpenPointInTime(rt.Config.ElasticContactsIndex).Routing(strconv.FormatInt(int64(oa.OrgID()), 10)).KeepAlive(pointInTimeKeepAlive).Do(ctx)
+	if err != nil {
+		return elasticError(err)
+	}
+	pitID := pit.Id
+
+	defer func() {
+		if _, err := rt.ES.ClosePointInTime(pitID).Do(context.Background()); err != nil {
+			slog.Error("error closing point in time", "error", err)
 		}
+	}()
+
+	total := -1
+
+	for {
+		s := rt.ES.Search().PointInTime(elastic.NewPointInTimeWithKeepAlive(pitID, pointInTimeKeepAlive))
+		s = s.Query(eq).SortBy(elastic.NewFieldSort("id").Asc()).SearchAfter(after).Size(pageSize).FetchSource(false).TrackTotalHits(total < 0)
+
+		results, err := s.Do(ctx)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error scrolling through results for search: %s", query)
+			return elasticError(err)
 		}
 
-		ids, err = appendIDsFromHits(ids, results.Hits.Hits)
+		// the id of a point in time can change between requests
+		if results.PitId != "" {
+			pitID = results.PitId
+		}
+		if total < 0 && results.Hits.TotalHits != nil {
+			total = int(results.Hits.TotalHits.Value)
+		}
+
+		ids, err := appendIDsFromHits(make([]models.ContactID, 0, len(results.Hits.Hits)), results.Hits.Hits)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := fn(ids, total); err != nil {
+			return err
+		}
+
+		if len(ids) < pageSize {
+			return nil
 		}
+		after = ids[len(ids)-1]
 	}
 }
 
+func (b *elasticBackend) Aggregate(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query *contactql.ContactQuery, facets []*Facet) (int64, [][]*Bucket, error) {
+	if rt.ES == nil {
+		return 0, nil, errors.Errorf("no elastic client available, check your configuration")
+	}
+
+	eq, err := BuildElasticQuery(oa, nil, models.NilContactStatus, nil, query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s := rt.ES.Search(rt.Config.ElasticContactsIndex).TrackTotalHits(true).Routing(strconv.FormatInt(int64(oa.OrgID()), 10))
+	s = s.Size(0).Query(eq)
+
+	for i, f := range facets {
+		s = s.Aggregation(fmt.Sprintf("f%d", i), toElasticAggregation(oa, f))
+	}
+
+	results, err := s.Do(ctx)
+	if err != nil {
+		return 0, nil, elasticError(err)
+	}
+
+	buckets := make([][]*Bucket, len(facets))
+	for i, f := range facets {
+		buckets[i] = fromElasticAggregation(oa, f, results.Aggregations, fmt.Sprintf("f%d", i))
+	}
+
+	return results.Hits.TotalHits.Value, buckets, nil
+}
+
+// converts a facet to an elastic aggregation, where aggregations of nested documents are wrapped so that we can always
+// read the buckets from a sub-aggregation called v
+func toElasticAggregation(oa *models.OrgAssets, f *Facet) elastic.Aggregation {
+	weekly := func(field string) elastic.Aggregation {
+		return elastic.NewDateHistogramAggregation().Field(field).CalendarInterval("week").TimeZone(oa.Env().Timezone().String()).Format("yyyy-MM-dd").MinDocCount(1)
+	}
+
+	switch f.Type {
+	case FacetTypeField:
+		field := oa.FieldByKey(f.Key)
+
+		var values elastic.Aggregation
+		switch field.Type() {
+		case assets.FieldTypeDatetime:
+			values = weekly("fields.datetime")
+		case assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+			values = elastic.NewTermsAggregation().Field(fmt.Sprintf("fields.%s_keyword", field.Type())).Size(f.limit())
+		default:
+			values = elastic.NewTermsAggregation().Field(fmt.Sprintf("fields.%s", field.Type())).Size(f.limit())
+		}
+
+		return elastic.NewNestedAggregation().Path("fields").SubAggregation("f",
+			elastic.NewFilterAggregation().Filter(elastic.NewTermQuery("fields.field", field.UUID())).SubAggregation("v", values),
+		)
+	case FacetTypeGroup:
+		return elastic.NewTermsAggregation().Field("group_ids").Size(f.limit())
+	case FacetTypeScheme:
+		// a contact can have multiple URNs with the same scheme so count contacts rather than URNs
+		return elastic.NewNestedAggregation().Path("urns").SubAggregation("v",
+			elastic.NewTermsAggregation().Field("urns.scheme").Size(f.limit()).SubAggregation("c", elastic.NewReverseNestedAggregation()),
+		)
+	case FacetTypeLanguage:
+		return elastic.NewTermsAggregation().Field("language").Size(f.limit())
+	case FacetTypeCreatedOn:
+		return weekly("created_on")
+	}
+
+	panic(fmt.Sprintf("unsupported facet type: %s", f.Type))
+}
+
+// reads the buckets of a facet from the results of its elastic aggregation
+func fromElasticAggregation(oa *models.OrgAssets, f *Facet, aggs elastic.Aggregations, name string) []*Bucket {
+	buckets := make([]*Bucket, 0)
+
+	// unwrap aggregations of nested documents
+	switch f.Type {
+	case FacetTypeField:
+		nested, _ := aggs.Nested(name)
+		filtered, _ := nested.Filter("f")
+		aggs, name = filtered.Aggregations, "v"
+	case FacetTypeScheme:
+		nested, _ := aggs.Nested(name)
+		aggs, name = nested.Aggregations, "v"
+	}
+
+	if isWeeklyFacet(oa, f) {
+		histogram, _ := aggs.DateHistogram(name)
+		for _, b := range histogram.Buckets {
+			buckets = append(buckets, &Bucket{Key: *b.KeyAsString, Count: b.DocCount})
+		}
+		return recentWeeks(buckets, f.limit())
+	}
+
+	terms, _ := aggs.Terms(name)
+	for _, b := range terms.Buckets {
+		switch f.Type {
+		case FacetTypeGroup:
+			if bucket := groupBucket(oa, models.GroupID(b.Key.(float64)), b.DocCount); bucket != nil {
+				buckets = append(buckets, bucket)
+			}
+		case FacetTypeScheme:
+			contacts, _ := b.ReverseNested("c")
+			buckets = append(buckets, &Bucket{Key: b.Key.(string), Count: contacts.DocCount})
+		default:
+			key := fmt.Sprint(b.Key)
+			if n, isNumber := b.Key.(float64); isNumber {
+				key = strconv.FormatFloat(n, 'f', -1, 64)
+			}
+			buckets = append(buckets, &Bucket{Key: key, Count: b.DocCount})
+		}
+	}
+
+	// scheme buckets are ordered by number of URNs so re-sort by number of contacts
+	if f.Type == FacetTypeScheme {
+		sortBuckets(buckets)
+	}
+
+	return buckets
+}
+
+// rp-indexer polls for modified contacts so we allow it some time to catch up
+func (b *elasticBackend) IndexLag() time.Duration {
+	return 10 * time.Second
+}
+
+// utility to include the details of elastic errors when wrapping them
+func elasticError(err error) error {
+	// Get *elastic.Error which contains additional information
+	ee, ok := err.(*elastic.Error)
+	if !ok {
+		return errors.Wrap(err, "error performing query")
+	}
+
+	return errors.Wrapf(err, "error performing query: %s", ee.Details.Reason)
+}
+
 // utility to convert search hits to contact IDs and append them to the given slice
 func appendIDsFromHits(ids []models.ContactID, hits []*elastic.SearchHit) ([]models.ContactID, error) {
 	for _, hit := range hits {
@@ -31,14 +31,18 @@ func TestGetContactTotal(t *testing.T) {
 		},
 	}
 
-	for i, tc := range tcs {
-		_, total, err := search.GetContactTotal(ctx, rt, oa, tc.query)
-
-		if tc.expectedError != "" {
-			assert.EqualError(t, err, tc.expectedError)
-		} else {
-			assert.NoError(t, err, "%d: error encountered performing query", i)
-			assert.Equal(t, tc.expectedTotal, total, "%d: total mismatch", i)
+	for _, backend := range []string{search.BackendElastic, search.BackendPostgres} {
+		rt.Config.SearchBackend = backend
+
+		for i, tc := range tcs {
+			_, total, err := search.GetContactTotal(ctx, rt, oa, tc.query)
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err, "%d: error encountered performing query with %s", i, backend)
+				assert.Equal(t, tc.expectedTotal, total, "%d: total mismatch with %s", i, backend)
+			}
 		}
 	}
 }
@@ -92,17 +96,21 @@ func TestGetContactIDsForQueryPage(t *testing.T) {
 		},
 	}
 
-	for i, tc := range tcs {
-		group := oa.GroupByID(tc.group.ID)
+	for _, backend := range []string{search.BackendElastic, search.BackendPostgres} {
+		rt.Config.SearchBackend = backend
 
-		_, ids, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, group, tc.excludeIDs, tc.query, tc.sort, 0, 50)
+		for i, tc := range tcs {
+			group := oa.GroupByID(tc.group.ID)
 
-		if tc.expectedError != "" {
-			assert.EqualError(t, err, tc.expectedError)
-		} else {
-			assert.NoError(t, err, "%d: error encountered performing query", i)
-			assert.Equal(t, tc.expectedContacts, ids, "%d: ids mismatch", i)
-			assert.Equal(t, tc.expectedTotal, total, "%d: total mismatch", i)
+			_, ids, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, group, tc.excludeIDs, tc.query, tc.sort, 0, 50)
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err, "%d: error encountered performing query with %s", i, backend)
+				assert.Equal(t, tc.expectedContacts, ids, "%d: ids mismatch with %s", i, backend)
+				assert.Equal(t, tc.expectedTotal, total, "%d: total mismatch with %s", i, backend)
+			}
 		}
 	}
 }
@@ -140,14 +148,18 @@ func TestGetContactIDsForQuery(t *testing.T) {
 		},
 	}
 
-	for i, tc := range tcs {
-		ids, err := search.GetContactIDsForQuery(ctx, rt, oa, tc.query, tc.limit)
+	for _, backend := range []string{search.BackendElastic, search.BackendPostgres} {
+		rt.Config.SearchBackend = backend
+
+		for i, tc := range tcs {
+			ids, err := search.GetContactIDsForQuery(ctx, rt, oa, tc.query, tc.limit)
 
-		if tc.expectedError != "" {
-			assert.EqualError(t, err, tc.expectedError)
-		} else {
-			assert.NoError(t, err, "%d: error encountered performing query", i)
-			assert.ElementsMatch(t, tc.expectedContacts, ids, "%d: ids mismatch", i)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err, "%d: error encountered performing query with %s", i, backend)
+				assert.ElementsMatch(t, tc.expectedContacts, ids, "%d: ids mismatch with %s", i, backend)
+			}
 		}
 	}
 }
@@ -0,0 +1,121 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/redisx"
+	"github.com/pkg/errors"
+)
+
+const (
+	slowSearchesKey    = "slow_searches"
+	slowSearchesCap    = 100
+	slowSearchesMaxAge = time.Hour * 24 * 7
+)
+
+// SlowSearch is a contact search which took longer than the configured threshold
+type SlowSearch struct {
+	OrgID      models.OrgID `json:"org_id"`
+	Type       string       `json:"type"`
+	Query      string       `json:"query"`
+	ElapsedMS  int          `json:"elapsed_ms"`
+	SearchedOn time.Time    `json:"searched_on"`
+}
+
+// the slowest searches are kept in a capped sorted set scored by elapsed time. The expiry of the set is refreshed by
+// every add so entries older than the max age are pruned individually.
+var slowSearches = redisx.NewCappedZSet(slowSearchesKey, slowSearchesCap, slowSearchesMaxAge)
+
+// records the given search in the slow searches report if it took longer than the configured threshold
+func recordSearch(rt *runtime.Runtime, oa *models.OrgAssets, typ, query string, elapsed time.Duration) {
+	if rt.Config.SearchSlowThreshold <= 0 || elapsed < time.Duration(rt.Config.SearchSlowThreshold)*time.Millisecond {
+		return
+	}
+
+	slog.Warn("slow contact search", "org_id", oa.OrgID(), "type", typ, "query", query, "elapsed", elapsed)
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	s := &SlowSearch{OrgID: oa.OrgID(), Type: typ, Query: query, ElapsedMS: int(elapsed / time.Millisecond), SearchedOn: time.Now()}
+
+	if err := addSlowSearch(rc, s); err != nil {
+		slog.Error("error recording slow search", "error", err, "org_id", oa.OrgID())
+	}
+}
+
+func addSlowSearch(rc redis.Conn, s *SlowSearch) error {
+	// prune first so that old searches don't keep newer ones out of the capped set
+	if _, err := loadSlowSearches(rc, true); err != nil {
+		return err
+	}
+
+	// members need to be unique so the time of the search is included in each
+	member, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling slow search")
+	}
+
+	return errors.Wrap(slowSearches.Add(rc, string(member), float64(s.ElapsedMS)), "error adding slow search to set")
+}
+
+// GetSlowSearches returns the slowest recent contact searches, slowest first, optionally filtered to a single org
+func GetSlowSearches(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) ([]*SlowSearch, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	all, err := loadSlowSearches(rc, false)
+	if err != nil {
+		return nil, err
+	}
+
+	searches := make([]*SlowSearch, 0, len(all))
+	for _, s := range all {
+		if orgID == models.NilOrgID || s.OrgID == orgID {
+			searches = append(searches, s)
+		}
+	}
+
+	sort.SliceStable(searches, func(i, j int) bool { return searches[i].ElapsedMS > searches[j].ElapsedMS })
+
+	return searches, nil
+}
+
+// loads the slow searches which aren't older than the max age, optionally removing older ones from the set
+func loadSlowSearches(rc redis.Conn, prune bool) ([]*SlowSearch, error) {
+	members, _, err := slowSearches.Members(rc)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading slow searches")
+	}
+
+	cutoff := time.Now().Add(-slowSearchesMaxAge)
+	searches := make([]*SlowSearch, 0, len(members))
+	stale := make([]any, 0)
+
+	for _, m := range members {
+		s := &SlowSearch{}
+		if err := json.Unmarshal([]byte(m), s); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling slow search")
+		}
+		if s.SearchedOn.Before(cutoff) {
+			stale = append(stale, m)
+		} else {
+			searches = append(searches, s)
+		}
+	}
+
+	if prune && len(stale) > 0 {
+		if _, err := rc.Do("ZREM", redis.Args{}.Add(slowSearchesKey).Add(stale...)...); err != nil {
+			return nil, errors.Wrap(err, "error pruning old slow searches")
+		}
+	}
+
+	return searches, nil
+}
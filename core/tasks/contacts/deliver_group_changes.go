@@ -0,0 +1,192 @@
+package contacts
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/goflow"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// TypeDeliverGroupChanges is the type of the task to deliver group change events to resthook subscribers
+const TypeDeliverGroupChanges = "deliver_group_changes"
+
+const (
+	maxGroupChangesPerCron = 1000
+	maxGroupChangesPerTask = 100
+
+	// number of times we'll try to deliver a group change event before giving up on it
+	maxGroupChangeAttempts = 5
+)
+
+func init() {
+	tasks.RegisterType(TypeDeliverGroupChanges, func() tasks.Task { return &DeliverGroupChangesTask{} })
+	tasks.RegisterCron("deliver_group_changes", false, &DeliverGroupChangesCron{})
+}
+
+// DeliverGroupChangesCron queues new group change events for delivery to the subscribers of the org's group
+// membership resthook
+type DeliverGroupChangesCron struct{}
+
+func (c *DeliverGroupChangesCron) Next(last time.Time) time.Time {
+	return tasks.CronNext(last, time.Second*30)
+}
+
+// Run looks for group change events which are due a delivery attempt and queues tasks to deliver them, batched by org
+func (c *DeliverGroupChangesCron) Run(ctx context.Context, rt *runtime.Runtime) (map[string]any, error) {
+	events, err := models.QueueGroupChangeEvents(ctx, rt.DB, maxGroupChangesPerCron)
+	if err != nil {
+		return nil, errors.Wrap(err, "error queuing group change events")
+	}
+	if len(events) == 0 {
+		return map[string]any{"events": 0, "tasks": 0}, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	// batch up events by org
+	orgIDs := make([]models.OrgID, 0, 5)
+	eventIDsByOrg := make(map[models.OrgID][]models.WebhookEventID)
+	for _, e := range events {
+		if _, seen := eventIDsByOrg[e.OrgID()]; !seen {
+			orgIDs = append(orgIDs, e.OrgID())
+		}
+		eventIDsByOrg[e.OrgID()] = append(eventIDsByOrg[e.OrgID()], e.ID())
+	}
+
+	numTasks := 0
+	for _, orgID := range orgIDs {
+		ids := eventIDsByOrg[orgID]
+
+		for i := 0; i < len(ids); i += maxGroupChangesPerTask {
+			task := &DeliverGroupChangesTask{EventIDs: ids[i:min(i+maxGroupChangesPerTask, len(ids))]}
+
+			// if this fails, the events will be queued again once their queued timeout has passed
+			if err := tasks.Queue(rc, queue.BatchQueue, orgID, task, queue.LowPriority); err != nil {
+				return nil, errors.Wrapf(err, "error queuing group change delivery for org: %d", orgID)
+			}
+			numTasks++
+		}
+	}
+
+	return map[string]any{"events": len(events), "tasks": numTasks}, nil
+}
+
+// DeliverGroupChangesTask is our task to POST group change events to the subscribers of the org's group membership
+// resthook
+type DeliverGroupChangesTask struct {
+	EventIDs []models.WebhookEventID `json:"event_ids"`
+}
+
+func (t *DeliverGroupChangesTask) Type() string {
+	return TypeDeliverGroupChanges
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *DeliverGroupChangesTask) Timeout() time.Duration {
+	return time.Minute * 5
+}
+
+// Perform POSTs each event to each subscriber, recording an HTTP log for each call. Subscribers which respond with 410
+// Gone are unsubscribed, as they are when called from a flow. Events which couldn't be delivered to every subscriber
+// are retried later, to all subscribers, until they've been attempted too many times.
+func (t *DeliverGroupChangesTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org assets for org: %d", orgID)
+	}
+
+	events, err := models.LoadWebhookEvents(ctx, rt.DB, orgID, t.EventIDs)
+	if err != nil {
+		return errors.Wrap(err, "error loading group change events")
+	}
+
+	resthook := oa.ResthookBySlug(models.ResthookSlugGroupMembership)
+	if resthook == nil || len(resthook.Subscribers()) == 0 {
+		// nobody left to deliver to so fail them without any further attempts
+		return models.MarkWebhookEventsErrored(ctx, rt.DB, t.EventIDs, 0)
+	}
+
+	client, retries, access := goflow.HTTP(rt.Config)
+	unsubs := make([]*models.ResthookUnsubscribe, 0, 1)
+	logs := make([]*models.HTTPLog, 0, len(events))
+	gone := make(map[string]bool)
+	delivered := make([]models.WebhookEventID, 0, len(events))
+	errored := make([]models.WebhookEventID, 0)
+
+	for _, e := range events {
+		ok := true
+
+		for _, url := range resthook.Subscribers() {
+			if gone[url] {
+				continue
+			}
+
+			req, err := httpx.NewRequest(http.MethodPost, url, strings.NewReader(e.Data()), map[string]string{"Content-Type": "application/json"})
+			if err != nil {
+				slog.Error("error creating group change request", "url", url, "error", err)
+				ok = false
+				continue
+			}
+			req = req.WithContext(ctx)
+
+			trace, err := httpx.DoTrace(client, req, retries, access, -1)
+			if trace != nil {
+				log := flows.NewHTTPLog(trace, flows.HTTPStatusFromCode, nil)
+				logs = append(logs, models.NewWebhookCalledLog(orgID, models.NilFlowID, log.URL, log.StatusCode, log.Request, log.Response, log.Status != flows.CallStatusSuccess, time.Duration(log.ElapsedMS)*time.Millisecond, log.Retries, log.CreatedOn))
+			}
+			if err != nil {
+				slog.Error("error delivering group change event", "event_id", e.ID(), "url", url, "error", err)
+				ok = false
+				continue
+			}
+
+			if trace.Response.StatusCode == http.StatusGone {
+				unsubs = append(unsubs, &models.ResthookUnsubscribe{OrgID: orgID, Slug: resthook.Slug(), URL: url})
+				gone[url] = true
+			} else if trace.Response.StatusCode/100 != 2 {
+				ok = false
+			}
+		}
+
+		if ok {
+			delivered = append(delivered, e.ID())
+		} else {
+			errored = append(errored, e.ID())
+		}
+	}
+
+	tx, err := rt.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+
+	if err := models.UnsubscribeResthooks(ctx, tx, unsubs); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := models.InsertHTTPLogs(ctx, tx, logs); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error inserting http logs")
+	}
+	if err := models.MarkWebhookEventsDelivered(ctx, tx, delivered); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := models.MarkWebhookEventsErrored(ctx, tx, errored, maxGroupChangeAttempts); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "error committing group change deliveries")
+}
@@ -0,0 +1,100 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliverGroupChanges(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	defer func() {
+		rt.DB.MustExec(`DELETE FROM api_webhookevent`)
+		rt.DB.MustExec(`DELETE FROM api_resthooksubscriber`)
+		rt.DB.MustExec(`DELETE FROM api_resthook`)
+	}()
+
+	mocks := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://example.com/subscriber1": {
+			httpx.NewMockResponse(200, nil, []byte(`{"ok": true}`)),
+			httpx.NewMockResponse(503, nil, []byte(`{"error": "unavailable"}`)),
+			httpx.NewMockResponse(200, nil, []byte(`{"ok": true}`)),
+		},
+		"http://example.com/subscriber2": {
+			httpx.NewMockResponse(410, nil, []byte(`{"error": "gone"}`)),
+		},
+	})
+	httpx.SetRequestor(mocks)
+
+	var resthookID models.ResthookID
+	rt.DB.Get(&resthookID, `INSERT INTO api_resthook(is_active, slug, org_id, created_on, modified_on, created_by_id, modified_by_id) VALUES(TRUE, 'group-membership', $1, NOW(), NOW(), 1, 1) RETURNING id`, testdata.Org1.ID)
+	rt.DB.MustExec(`INSERT INTO api_resthooksubscriber(is_active, created_on, modified_on, target_url, created_by_id, modified_by_id, resthook_id) VALUES(TRUE, NOW(), NOW(), 'http://example.com/subscriber1', 1, 1, $1)`, resthookID)
+	rt.DB.MustExec(`INSERT INTO api_resthooksubscriber(is_active, created_on, modified_on, target_url, created_by_id, modified_by_id, resthook_id) VALUES(TRUE, NOW(), NOW(), 'http://example.com/subscriber2', 1, 1, $1)`, resthookID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshResthooks)
+	require.NoError(t, err)
+
+	err = models.InsertGroupChangeEvents(ctx, rt.DB, oa, []*models.GroupChange{
+		{ContactID: testdata.Cathy.ID, GroupID: testdata.DoctorsGroup.ID, Direction: models.GroupChangeAdded, Cause: models.GroupChangeCauseFlow},
+		{ContactID: testdata.Bob.ID, GroupID: testdata.DoctorsGroup.ID, Direction: models.GroupChangeAdded, Cause: models.GroupChangeCauseFlow},
+		{ContactID: testdata.Bob.ID, GroupID: testdata.TestersGroup.ID, Direction: models.GroupChangeRemoved, Cause: models.GroupChangeCauseFlow},
+	})
+	require.NoError(t, err)
+
+	cron := &contacts.DeliverGroupChangesCron{}
+
+	res, err := cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"events": 2, "tasks": 1}, res)
+
+	tasks := testsuite.CurrentTasks(t, rt)[testdata.Org1.ID]
+	require.Len(t, tasks, 1)
+	assert.Equal(t, contacts.TypeDeliverGroupChanges, tasks[0].Type)
+
+	// running again doesn't queue the same events again
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"events": 0, "tasks": 0}, res)
+
+	testsuite.FlushTasks(t, rt)
+
+	// both events were posted to the first subscriber, and the second subscriber was unsubscribed after the first
+	require.Len(t, mocks.Requests(), 3)
+	assert.Equal(t, "http://example.com/subscriber1", mocks.Requests()[0].URL.String())
+	assert.Equal(t, "http://example.com/subscriber2", mocks.Requests()[1].URL.String())
+	assert.Equal(t, "http://example.com/subscriber1", mocks.Requests()[2].URL.String())
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_resthooksubscriber WHERE is_active = FALSE AND target_url = 'http://example.com/subscriber2'`).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM request_logs_httplog WHERE log_type = 'webhook_called' AND flow_id IS NULL AND org_id = $1`, testdata.Org1.ID).Returns(3)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM request_logs_httplog WHERE log_type = 'webhook_called' AND is_error = TRUE`).Returns(2)
+
+	// the first event was delivered but the second errored and will be retried
+	assertdb.Query(t, rt.DB, `SELECT array_agg(status ORDER BY id) FROM api_webhookevent`).Returns([]byte(`{D,P}`))
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM api_webhookevent WHERE status = 'P' AND attempts = 1 AND next_attempt > NOW()`).Returns(1)
+
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"events": 0, "tasks": 0}, res)
+
+	rt.DB.MustExec(`UPDATE api_webhookevent SET next_attempt = NOW() WHERE status = 'P'`)
+
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"events": 1, "tasks": 1}, res)
+
+	testsuite.FlushTasks(t, rt)
+
+	assert.False(t, mocks.HasUnused())
+	assertdb.Query(t, rt.DB, `SELECT array_agg(status ORDER BY id) FROM api_webhookevent`).Returns([]byte(`{D,D}`))
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM request_logs_httplog WHERE log_type = 'webhook_called' AND flow_id IS NULL`).Returns(4)
+}
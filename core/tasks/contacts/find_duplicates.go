@@ -0,0 +1,70 @@
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/redisx"
+	"github.com/pkg/errors"
+)
+
+// TypeFindDuplicates is the type of the task to find duplicate contacts in an org
+const TypeFindDuplicates = "find_duplicates"
+
+const findDuplicatesLockKey string = "lock:find_duplicates_%d"
+
+func init() {
+	tasks.RegisterType(TypeFindDuplicates, func() tasks.Task { return &FindDuplicatesTask{} })
+}
+
+// FindDuplicatesTask is our task to scan an org for likely duplicate contacts
+type FindDuplicatesTask struct{}
+
+func (t *FindDuplicatesTask) Type() string {
+	return TypeFindDuplicates
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *FindDuplicatesTask) Timeout() time.Duration {
+	return time.Hour
+}
+
+// Perform runs all duplicate matchers against the org and replaces its existing duplicate candidates
+func (t *FindDuplicatesTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	locker := redisx.NewLocker(fmt.Sprintf(findDuplicatesLockKey, orgID), time.Hour)
+	lock, err := locker.Grab(rt.RP, time.Second)
+	if err != nil {
+		return errors.Wrapf(err, "error grabbing lock to find duplicates in org: %d", orgID)
+	}
+
+	// another scan of this org is already running
+	if lock == "" {
+		return nil
+	}
+	defer locker.Release(rt.RP, lock)
+
+	start := time.Now()
+
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org when finding duplicates: %d", orgID)
+	}
+
+	dupes, err := models.FindContactDuplicates(ctx, rt.DB, oa)
+	if err != nil {
+		return errors.Wrapf(err, "error finding duplicates in org: %d", orgID)
+	}
+
+	if err := models.ReplaceContactDuplicates(ctx, rt.DB, orgID, dupes); err != nil {
+		return errors.Wrapf(err, "error saving duplicates for org: %d", orgID)
+	}
+
+	slog.Info("completed finding duplicate contacts", "org_id", orgID, "elapsed", time.Since(start), "count", len(dupes))
+
+	return nil
+}
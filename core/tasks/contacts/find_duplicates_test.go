@@ -0,0 +1,34 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// a stale candidate from a previous scan
+	rt.DB.MustExec(`INSERT INTO contacts_contactduplicate(org_id, contact1_id, contact2_id, matchers, confidence, created_on) VALUES($1, $2, $3, '{urn}', 0.9, NOW())`, testdata.Org1.ID, testdata.Bob.ID, testdata.George.ID)
+
+	// Bob and Robert have the same email in different cases
+	testdata.InsertContactURN(rt, testdata.Org1, testdata.Bob, urns.URN("mailto:bob@nyaruka.com"), 999, nil)
+	robert := testdata.InsertContact(rt, testdata.Org1, "5a0a8e0b-e8b9-4d6e-9a5c-1f0a1b2c3d4e", "Robert", "eng", models.ContactStatusActive)
+	testdata.InsertContactURN(rt, testdata.Org1, robert, urns.URN("mailto:Bob@Nyaruka.com"), 1000, nil)
+
+	task := &contacts.FindDuplicatesTask{}
+	err := task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactduplicate WHERE contact1_id = $1 AND contact2_id = $2`, testdata.Bob.ID, testdata.George.ID).Returns(0)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactduplicate WHERE org_id = $1 AND contact1_id = $2 AND contact2_id = $3 AND matchers = '{urn}'`, testdata.Org1.ID, testdata.Bob.ID, robert.ID).Returns(1)
+}
@@ -3,6 +3,7 @@ package contacts
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -45,7 +46,15 @@ func (t *ImportContactBatchTask) Perform(ctx context.Context, rt *runtime.Runtim
 		return errors.Wrap(err, "error loading contact import")
 	}
 
-	batchErr := batch.Import(ctx, rt, orgID, imp.CreatedByID)
+	batchErr := batch.Import(ctx, rt, imp)
+
+	// for imports from a source, record what was imported so that unchanged records are skipped by the next sync, and
+	// if that fails they'll just be imported again
+	if batchErr == nil && imp.SourceID != models.NilContactImportSourceID && !imp.DryRun {
+		if err := models.SaveContactImportSourceHashes(ctx, rt.DB, imp.SourceID, batch); err != nil {
+			slog.Error("error saving import source hashes", "error", err, "import_id", imp.ID)
+		}
+	}
 
 	// decrement the redis key that holds remaining batches to see if the overall import is now finished
 	rc := rt.RP.Get()
@@ -61,12 +70,20 @@ func (t *ImportContactBatchTask) Perform(ctx context.Context, rt *runtime.Runtim
 			}
 		}
 
+		// a missing result file shouldn't prevent the import from being finished
+		if err := imp.WriteResultFile(ctx, rt); err != nil {
+			slog.Error("error writing contact import result file", "error", err, "import_id", imp.ID)
+		}
+
 		if err := imp.MarkFinished(ctx, rt.DB, status); err != nil {
 			return errors.Wrap(err, "error marking import as finished")
 		}
 
-		if err := models.NotifyImportFinished(ctx, rt.DB, imp); err != nil {
-			return errors.Wrap(err, "error creating import finished notification")
+		// recurring syncs from a source don't notify the user every time
+		if imp.SourceID == models.NilContactImportSourceID {
+			if err := models.NotifyImportFinished(ctx, rt.DB, imp); err != nil {
+				return errors.Wrap(err, "error creating import finished notification")
+			}
 		}
 	}
 
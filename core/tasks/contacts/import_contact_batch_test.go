@@ -18,7 +18,7 @@ func TestImportContactBatch(t *testing.T) {
 	rc := rt.RP.Get()
 	defer rc.Close()
 
-	defer testsuite.Reset(testsuite.ResetData)
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetStorage)
 
 	importID := testdata.InsertContactImport(rt, testdata.Org1, testdata.Admin)
 	batch1ID := testdata.InsertContactImportBatch(rt, importID, []byte(`[
@@ -51,6 +51,7 @@ func TestImportContactBatch(t *testing.T) {
 
 	// import is now complete and there is a notification for the creator
 	assertdb.Query(t, rt.DB, `SELECT status FROM contacts_contactimport WHERE id = $1`, importID).Columns(map[string]any{"status": "C"})
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimport WHERE id = $1 AND result_url ~ '/contact_imports/1/\d+_[0-9a-f-]{36}_results\.csv$'`, importID).Returns(1)
 	assertdb.Query(t, rt.DB, `SELECT org_id, notification_type, scope, user_id FROM notifications_notification WHERE contact_import_id = $1`, importID).
 		Columns(map[string]any{
 			"org_id":            int64(testdata.Org1.ID),
@@ -56,7 +56,7 @@ func (t *PopulateDynamicGroupTask) Perform(ctx context.Context, rt *runtime.Runt
 		return errors.Wrapf(err, "unable to load org when populating group: %d", t.GroupID)
 	}
 
-	count, err := search.PopulateSmartGroup(ctx, rt, rt.ES, oa, t.GroupID, t.Query)
+	count, err := search.PopulateSmartGroup(ctx, rt, oa, t.GroupID, t.Query)
 	if err != nil {
 		return errors.Wrapf(err, "error populating smart group: %d", t.GroupID)
 	}
@@ -0,0 +1,159 @@
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/redisx"
+	"github.com/pkg/errors"
+)
+
+// TypeReevaluateSmartGroup is the type of the task to re-evaluate a time-relative smart group
+const TypeReevaluateSmartGroup = "reevaluate_smart_group"
+
+// redis hash of the date, in the org's timezone, on which each time-relative smart group was last re-evaluated
+const groupsReevaluatedKey = "smart_groups_reevaluated"
+
+func init() {
+	tasks.RegisterType(TypeReevaluateSmartGroup, func() tasks.Task { return &ReevaluateSmartGroupTask{} })
+	tasks.RegisterCron("reevaluate_smart_groups", false, &ReevaluateGroupsCron{})
+}
+
+// ReevaluateGroupsCron queues re-evaluation of smart groups whose membership can change as time passes. Such changes
+// aren't picked up by the re-evaluation of groups that happens when contacts change.
+type ReevaluateGroupsCron struct{}
+
+func (c *ReevaluateGroupsCron) Next(last time.Time) time.Time {
+	// relative dates are resolved in the timezone of each org so run hourly to catch every org's change of day
+	return tasks.CronNext(last, time.Hour)
+}
+
+// Run queues a re-evaluation task for each ready time-relative smart group which hasn't been re-evaluated today
+func (c *ReevaluateGroupsCron) Run(ctx context.Context, rt *runtime.Runtime) (map[string]any, error) {
+	orgIDs, err := models.GetOrgIDsWithSmartGroups(ctx, rt.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	numQueued := 0
+
+	for _, orgID := range orgIDs {
+		oa, err := models.GetOrgAssets(ctx, rt, orgID)
+		if err != nil {
+			// log and move on to the next org so that one broken org doesn't block the others
+			slog.Error("error loading org assets to re-evaluate smart groups", "org_id", orgID, "error", err)
+			continue
+		}
+
+		today := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()))
+
+		for _, group := range search.TimeRelativeGroups(oa) {
+			// groups which are still being populated will be up to date when they're done
+			if group.Status() != models.GroupStatusReady {
+				continue
+			}
+
+			lastDay, err := getGroupReevaluatedOn(rc, group.ID())
+			if err != nil {
+				return nil, err
+			}
+			if lastDay.Equal(today) {
+				continue
+			}
+
+			task := &ReevaluateSmartGroupTask{GroupID: group.ID(), Query: group.Query()}
+			if err := tasks.Queue(rc, queue.BatchQueue, orgID, task, queue.LowPriority); err != nil {
+				return nil, errors.Wrapf(err, "error queuing task to re-evaluate smart group: %d", group.ID())
+			}
+			numQueued++
+		}
+	}
+
+	return map[string]any{"queued": numQueued}, nil
+}
+
+// ReevaluateSmartGroupTask is our task to update the contacts of a populated smart group whose membership may have
+// changed with time
+type ReevaluateSmartGroupTask struct {
+	GroupID models.GroupID `json:"group_id"`
+	Query   string         `json:"query"`
+}
+
+func (t *ReevaluateSmartGroupTask) Type() string {
+	return TypeReevaluateSmartGroup
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *ReevaluateSmartGroupTask) Timeout() time.Duration {
+	return time.Hour
+}
+
+// Perform adds and removes the contacts whose membership of the group has changed since it was last re-evaluated
+func (t *ReevaluateSmartGroupTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	// we share a lock with population so we never re-evaluate a group that's being populated
+	locker := redisx.NewLocker(fmt.Sprintf(populateLockKey, t.GroupID), time.Hour)
+	lock, err := locker.Grab(rt.RP, 0)
+	if err != nil {
+		return errors.Wrapf(err, "error grabbing lock to re-evaluate smart group: %d", t.GroupID)
+	}
+	if lock == "" {
+		slog.Info("skipping re-evaluation of smart group which is locked", "group_id", t.GroupID)
+		return nil
+	}
+	defer locker.Release(rt.RP, lock)
+
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org when re-evaluating group: %d", t.GroupID)
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	lastDay, err := getGroupReevaluatedOn(rc, t.GroupID)
+	if err != nil {
+		return err
+	}
+
+	today := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()))
+
+	added, removed, err := search.ReevaluateSmartGroup(ctx, rt, oa, t.GroupID, t.Query, lastDay)
+	if err != nil {
+		return errors.Wrapf(err, "error re-evaluating smart group: %d", t.GroupID)
+	}
+
+	if _, err := rc.Do("HSET", groupsReevaluatedKey, t.GroupID, today.String()); err != nil {
+		return errors.Wrapf(err, "error recording re-evaluation of smart group: %d", t.GroupID)
+	}
+
+	slog.Info("re-evaluated smart group", "group_id", t.GroupID, "org_id", orgID, "added", added, "removed", removed)
+	return nil
+}
+
+// gets the date on which the given group was last re-evaluated, or the zero date if it never has been
+func getGroupReevaluatedOn(rc redis.Conn, groupID models.GroupID) (dates.Date, error) {
+	val, err := redis.String(rc.Do("HGET", groupsReevaluatedKey, groupID))
+	if err == redis.ErrNil {
+		return dates.ZeroDate, nil
+	} else if err != nil {
+		return dates.ZeroDate, errors.Wrapf(err, "error getting last re-evaluation of smart group: %d", groupID)
+	}
+
+	day, err := dates.ParseDate(dates.ISO8601Date, val)
+	if err != nil {
+		return dates.ZeroDate, nil // re-evaluate fully if we can't parse what we have
+	}
+	return day, nil
+}
@@ -0,0 +1,53 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReevaluateGroups(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// Bob is wrongly in the group and Cathy is wrongly missing from it
+	group := testdata.InsertContactGroup(rt, testdata.Org1, "e52fee05-2f95-4445-aef6-2fe7dac2fd56", "Old Women", `gender = F AND created_on < "1 day ago"`, testdata.Bob)
+	testdata.InsertContactGroup(rt, testdata.Org1, "0a2b9b2e-7f4c-4d3a-9d1e-5c6f7a8b9c0d", "Women", "gender = F")
+	testdata.InsertContactGroup(rt, testdata.Org1, "4c1d6e2f-3a8b-4c9d-8e7f-6a5b4c3d2e1f", "Old", "created_on < 2030-01-01")
+
+	testsuite.ReindexElastic(ctx)
+	models.FlushCache()
+
+	cron := &contacts.ReevaluateGroupsCron{}
+	res, err := cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"queued": 1}, res)
+
+	tasks := testsuite.CurrentTasks(t, rt)[testdata.Org1.ID]
+	require.Len(t, tasks, 1)
+	assert.Equal(t, contacts.TypeReevaluateSmartGroup, tasks[0].Type)
+
+	task := &contacts.ReevaluateSmartGroupTask{GroupID: group.ID, Query: `gender = F AND created_on < "1 day ago"`}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, group.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT contact_id FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, group.ID).Returns(int64(testdata.Cathy.ID))
+
+	// group status isn't changed by re-evaluation
+	assertdb.Query(t, rt.DB, `SELECT status FROM contacts_contactgroup WHERE id = $1`, group.ID).Returns("R")
+
+	// and it's recorded as re-evaluated today so the cron won't queue it again until tomorrow
+	testsuite.FlushTasks(t, rt)
+
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"queued": 0}, res)
+}
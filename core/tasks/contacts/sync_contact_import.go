@@ -0,0 +1,136 @@
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/redisx"
+	"github.com/pkg/errors"
+)
+
+// TypeSyncContactImport is the type of the task to sync contacts from an import source
+const TypeSyncContactImport = "sync_contact_import"
+
+const syncContactImportLockKey string = "lock:sync_contact_import_%d"
+
+func init() {
+	tasks.RegisterType(TypeSyncContactImport, func() tasks.Task { return &SyncContactImportTask{} })
+}
+
+// SyncContactImportTask is our task to fetch an import source and import the records which have changed since the last
+// sync of that source
+type SyncContactImportTask struct {
+	SourceID models.ContactImportSourceID `json:"source_id"`
+}
+
+func (t *SyncContactImportTask) Type() string {
+	return TypeSyncContactImport
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *SyncContactImportTask) Timeout() time.Duration {
+	return time.Minute * 15
+}
+
+// Perform fetches and parses the source, then creates an import of the changed records and queues its batches
+func (t *SyncContactImportTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	locker := redisx.NewLocker(fmt.Sprintf(syncContactImportLockKey, t.SourceID), time.Minute*15)
+	lock, err := locker.Grab(rt.RP, time.Second)
+	if err != nil {
+		return errors.Wrapf(err, "error grabbing lock to sync import source: %d", t.SourceID)
+	}
+
+	// another sync of this source is already running
+	if lock == "" {
+		return nil
+	}
+	defer locker.Release(rt.RP, lock)
+
+	source, err := models.LoadContactImportSource(ctx, rt.DB, t.SourceID)
+	if err != nil {
+		return errors.Wrap(err, "error loading import source")
+	}
+
+	// the lock only covers fetching and creating the import, so don't sync again until the last import has finished,
+	// otherwise records which it hasn't yet saved hashes for would be imported again
+	unfinished, err := source.HasUnfinishedImport(ctx, rt.DB)
+	if err != nil {
+		return err
+	}
+	if unfinished {
+		slog.Info("skipping sync of import source with unfinished import", "source_id", t.SourceID)
+		return nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, source.OrgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org when syncing import source: %d", t.SourceID)
+	}
+
+	data, err := source.Fetch(ctx, rt)
+	if err != nil {
+		return err
+	}
+
+	specs, err := models.ParseContactImportSource(source.Format, data)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing import source: %d", t.SourceID)
+	}
+
+	hashes, err := source.LoadHashes(ctx, rt.DB)
+	if err != nil {
+		return errors.Wrapf(err, "error loading hashes for import source: %d", t.SourceID)
+	}
+
+	changed := source.ChangedSpecs(oa, specs, hashes)
+
+	log := slog.With("source_id", t.SourceID, "records", len(specs), "changed", len(changed))
+
+	if len(changed) > 0 {
+		imp, batches, err := source.CreateImport(ctx, rt.DB, changed)
+		if err != nil {
+			return errors.Wrapf(err, "error creating import for import source: %d", t.SourceID)
+		}
+
+		if err := queueImportBatches(rt, source.OrgID, imp, batches); err != nil {
+			// mark the import as failed, otherwise it would never finish and would block all future syncs of this source
+			if ferr := imp.MarkFinished(ctx, rt.DB, models.ContactImportStatusFailed); ferr != nil {
+				slog.Error("error marking unqueued import as failed", "import_id", imp.ID, "error", ferr)
+			}
+			return err
+		}
+
+		log = log.With("import_id", imp.ID)
+	}
+
+	if err := source.MarkSynced(ctx, rt.DB); err != nil {
+		return err
+	}
+
+	log.Info("synced contact import source")
+
+	return nil
+}
+
+// queues the batches of a newly created import for processing
+func queueImportBatches(rt *runtime.Runtime, orgID models.OrgID, imp *models.ContactImport, batches []*models.ContactImportBatch) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if _, err := rc.Do("setex", fmt.Sprintf("contact_import_batches_remaining:%d", imp.ID), 60*60*24, len(batches)); err != nil {
+		return errors.Wrap(err, "error setting remaining batches for import")
+	}
+
+	for _, b := range batches {
+		if err := tasks.Queue(rc, queue.BatchQueue, orgID, &ImportContactBatchTask{ContactImportBatchID: b.ID}, queue.LowPriority); err != nil {
+			return errors.Wrapf(err, "error queuing import batch: %d", b.ID)
+		}
+	}
+	return nil
+}
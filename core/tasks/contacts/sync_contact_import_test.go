@@ -0,0 +1,67 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncContactImport(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis | testsuite.ResetStorage)
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"http://rapidpro.io/contacts.csv": {
+			httpx.NewMockResponse(200, nil, []byte("Name,URN:Tel,Field:Age\nNorbert,+16055740001,30\nLeah,+16055740002,25\n")),
+			httpx.NewMockResponse(200, nil, []byte("Name,URN:Tel,Field:Age\nNorbert,+16055740001,30\nLeah,+16055740002,26\n")),
+			httpx.NewMockResponse(503, nil, []byte("Unavailable")),
+		},
+	}))
+
+	sourceID := testdata.InsertContactImportSource(rt, testdata.Org1, "http://rapidpro.io/contacts.csv", models.ContactImportSourceFormatCSV, models.NilScheduleID, testdata.Admin)
+
+	sync := &contacts.SyncContactImportTask{SourceID: sourceID}
+
+	// first sync creates an import of every record
+	require.NoError(t, sync.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimport WHERE source_id = $1 AND num_records = 2`, sourceID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimportsource WHERE id = $1 AND last_synced_on IS NOT NULL`, sourceID).Returns(1)
+	testsuite.AssertBatchTasks(t, testdata.Org1.ID, map[string]int{"import_contact_batch": 1})
+
+	// syncing again whilst that import is unfinished does nothing
+	require.NoError(t, sync.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimport WHERE source_id = $1`, sourceID).Returns(1)
+
+	testsuite.FlushTasks(t, rt)
+
+	assertdb.Query(t, rt.DB, `SELECT status FROM contacts_contactimport WHERE source_id = $1`, sourceID).Returns("C")
+
+	assertdb.Query(t, rt.DB, `SELECT fields->$1->>'number' FROM contacts_contact WHERE name = 'Norbert'`, testdata.AgeField.UUID).Returns("30")
+	assertdb.Query(t, rt.DB, `SELECT fields->$1->>'number' FROM contacts_contact WHERE name = 'Leah'`, testdata.AgeField.UUID).Returns("25")
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimportsourcehash WHERE source_id = $1`, sourceID).Returns(2)
+
+	// and a sync from a source with a change to a record only imports that record
+	require.NoError(t, sync.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimport WHERE source_id = $1`, sourceID).Returns(2)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactimport WHERE source_id = $1 AND num_records = 1`, sourceID).Returns(1)
+
+	testsuite.FlushTasks(t, rt)
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contact WHERE name = 'Leah'`).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT fields->$1->>'number' FROM contacts_contact WHERE name = 'Leah'`, testdata.AgeField.UUID).Returns("26")
+
+	// a source which can't be fetched is an error
+	err := sync.Perform(ctx, rt, testdata.Org1.ID)
+	require.EqualError(t, err, "error fetching import source http://rapidpro.io/contacts.csv: got status 503")
+}
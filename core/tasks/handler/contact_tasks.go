@@ -179,6 +179,24 @@ func HandleChannelEvent(ctx context.Context, rt *runtime.Runtime, eventType mode
 		return nil, errors.Wrapf(err, "error changing primary URN")
 	}
 
+	// record the contact's new status for the optin
+	if (eventType == models.EventTypeOptIn || eventType == models.EventTypeOptOut) && oa.OptInByID(event.OptInID()) != nil {
+		status := models.OptInStatusOptedIn
+		if eventType == models.EventTypeOptOut {
+			status = models.OptInStatusOptedOut
+		}
+
+		occurredOn := event.OccurredOn()
+		if occurredOn.IsZero() {
+			occurredOn = dates.Now()
+		}
+
+		err = models.SetContactOptInStatus(ctx, rt.DB, oa.OrgID(), modelContact.ID(), event.OptInID(), event.ChannelID(), status, occurredOn)
+		if err != nil {
+			return nil, errors.Wrap(err, "error updating contact optin status")
+		}
+	}
+
 	// build our flow contact
 	contact, err := modelContact.FlowContact(oa)
 	if err != nil {
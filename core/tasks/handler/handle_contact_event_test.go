@@ -563,6 +563,10 @@ func TestChannelEvents(t *testing.T) {
 			assert.True(t, lastSeen.Equal(start) || lastSeen.After(start), "%d: expected last seen to be updated", i)
 		}
 	}
+
+	// cathy opted in and then out of polls
+	assertdb.Query(t, rt.DB, `SELECT status FROM contacts_contactoptin WHERE contact_id = $1 AND optin_id = $2`, testdata.Cathy.ID, polls.ID).Returns("O")
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contactoptinevent WHERE contact_id = $1 AND optin_id = $2`, testdata.Cathy.ID, polls.ID).Returns(2)
 }
 
 func TestTicketEvents(t *testing.T) {
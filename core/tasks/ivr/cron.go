@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/tasks"
@@ -67,19 +68,15 @@ func (c *RetryCron) Run(ctx context.Context, rt *runtime.Runtime) (map[string]an
 			continue
 		}
 
-		// finally load the full URN
-		urn, err := models.URNForID(ctx, rt.DB, oa, call.ContactURNID())
-		if err != nil {
-			log.Error("unable to load contact urn", "error", err, "urn_id", call.ContactURNID())
-			continue
-		}
-
-		clog, err := ivr.RequestStartForCall(ctx, rt, channel, urn, call)
+		requested, clog, err := retryCall(ctx, rt, oa, channel, call)
 		if clog != nil {
 			clogs = append(clogs, clog)
 		}
 		if err != nil {
-			log.Error("error requesting start for call", "error", err)
+			log.Error("error retrying call", "error", err)
+			continue
+		}
+		if !requested {
 			continue
 		}
 
@@ -94,3 +91,22 @@ func (c *RetryCron) Run(ctx context.Context, rt *runtime.Runtime) (map[string]an
 
 	return map[string]any{"retried": len(calls)}, nil
 }
+
+// retries the given call unless it's outside of the calling window of its retry policy, in which case it's deferred
+// until the window opens. Returns whether a start was requested and the channel log of that request.
+func retryCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, channel *models.Channel, call *models.Call) (bool, *models.ChannelLog, error) {
+	now := dates.Now()
+	policy := models.ResolveCallRetryPolicy(call.RetryPolicy(), channel, nil)
+	if next := policy.NextInWindow(now, policy.Timezone(oa.Env())); next.After(now) {
+		return false, nil, errors.Wrap(call.MarkDeferred(ctx, rt.DB, next), "error deferring call")
+	}
+
+	// load the full URN
+	urn, err := models.URNForID(ctx, rt.DB, oa, call.ContactURNID())
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "unable to load contact urn %d", call.ContactURNID())
+	}
+
+	clog, err := ivr.RequestStartForCall(ctx, rt, channel, urn, call)
+	return true, clog, errors.Wrap(err, "error requesting start for call")
+}
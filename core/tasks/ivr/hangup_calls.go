@@ -0,0 +1,60 @@
+package ivr
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const TypeHangupCalls = "hangup_calls"
+
+func init() {
+	tasks.RegisterType(TypeHangupCalls, func() tasks.Task { return &HangupCallsTask{} })
+}
+
+// HangupCallsTask hangs up calls through their IVR provider. The calls have already been failed and their sessions
+// interrupted by the time this runs, so errors from the provider are only logged.
+type HangupCallsTask struct {
+	CallIDs []models.CallID `json:"call_ids"`
+}
+
+func (t *HangupCallsTask) Type() string {
+	return TypeHangupCalls
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *HangupCallsTask) Timeout() time.Duration {
+	return time.Minute * 5
+}
+
+func (t *HangupCallsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	calls, err := models.LoadCalls(ctx, rt.DB, orgID, t.CallIDs)
+	if err != nil {
+		return errors.Wrap(err, "error loading calls")
+	}
+
+	clogs := make([]*models.ChannelLog, 0, len(calls))
+
+	for _, call := range calls {
+		clog, err := ivr.HangupCall(ctx, rt, call)
+		if clog != nil {
+			clogs = append(clogs, clog)
+		}
+		if err != nil {
+			// log error but carry on with other calls
+			slog.Error("error hanging up call", "error", err, "call_id", call.ID())
+		}
+	}
+
+	if err := models.InsertChannelLogs(ctx, rt, clogs); err != nil {
+		return errors.Wrap(err, "error inserting channel logs")
+	}
+
+	return nil
+}
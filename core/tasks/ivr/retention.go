@@ -0,0 +1,112 @@
+package ivr
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const recordingRetentionBatchSize = 100
+
+func init() {
+	tasks.RegisterCron("delete_ivr_recordings", false, &RecordingRetentionCron{})
+}
+
+type RecordingRetentionCron struct{}
+
+func (c *RecordingRetentionCron) Next(last time.Time) time.Time {
+	return tasks.CronNext(last, time.Hour)
+}
+
+// Run deletes IVR recordings older than the retention limit of each org which has one
+func (c *RecordingRetentionCron) Run(ctx context.Context, rt *runtime.Runtime) (map[string]any, error) {
+	storage, ok := rt.AttachmentStorage.(runtime.DeletableStorage)
+	if !ok {
+		return map[string]any{"deleted": 0}, nil
+	}
+
+	orgIDs, err := models.GetOrgIDsWithIVRRecordingRetention(ctx, rt.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	numDeleted := 0
+
+	for _, orgID := range orgIDs {
+		deleted, err := deleteOldRecordings(ctx, rt, storage, orgID)
+		numDeleted += deleted
+
+		// log and move on to the next org so that one broken org doesn't block the others
+		if err != nil {
+			slog.Error("error deleting IVR recordings", "org_id", orgID, "error", err)
+		}
+	}
+
+	return map[string]any{"deleted": numDeleted}, nil
+}
+
+func deleteOldRecordings(ctx context.Context, rt *runtime.Runtime, storage runtime.DeletableStorage, orgID models.OrgID) (int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	// org assets may be more recent than the query that found this org
+	days := oa.Org().IVRRecordingRetentionDays()
+	if days <= 0 {
+		return 0, nil
+	}
+
+	before := dates.Now().Add(-time.Duration(days) * time.Hour * 24)
+	numDeleted := 0
+
+	for {
+		recordings, err := models.LoadIVRRecordingsBefore(ctx, rt.DB, orgID, before, recordingRetentionBatchSize)
+		if err != nil {
+			return numDeleted, err
+		}
+		if len(recordings) == 0 {
+			break
+		}
+
+		msgIDs := make([]models.MsgID, 0, len(recordings))
+
+		for _, r := range recordings {
+			if err := deleteRecordingFiles(ctx, storage, r); err != nil {
+				// leave this message's attachments in place so we try again next time
+				slog.Error("error deleting IVR recording", "msg_id", r.MsgID, "error", err)
+				continue
+			}
+			msgIDs = append(msgIDs, r.MsgID)
+		}
+
+		if err := models.ClearMsgAttachments(ctx, rt.DB, msgIDs); err != nil {
+			return numDeleted, err
+		}
+
+		numDeleted += len(msgIDs)
+
+		// if we couldn't delete any of this batch, don't keep loading it
+		if len(recordings) < recordingRetentionBatchSize || len(msgIDs) == 0 {
+			break
+		}
+	}
+
+	return numDeleted, nil
+}
+
+func deleteRecordingFiles(ctx context.Context, storage runtime.DeletableStorage, r *models.IVRRecording) error {
+	for _, a := range r.Attachments {
+		if err := storage.DeleteURL(ctx, utils.Attachment(a).URL()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
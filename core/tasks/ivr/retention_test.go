@@ -0,0 +1,70 @@
+package ivr_test
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	ivrtasks "github.com/nyaruka/mailroom/core/tasks/ivr"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingRetention(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetStorage)
+
+	oa := testdata.Org1.Load(rt)
+
+	storeRecording := func(name string) string {
+		a, err := oa.Org().StoreAttachment(ctx, rt, name, "audio/wav", io.NopCloser(strings.NewReader("RIFF")))
+		require.NoError(t, err)
+		return string(a)
+	}
+	insertRecording := func(contact *testdata.Contact, attachment string, age string) models.MsgID {
+		msg := testdata.InsertIncomingMsg(rt, testdata.Org1, testdata.TwilioChannel, contact, "", models.MsgStatusHandled)
+		rt.DB.MustExec(`UPDATE msgs_msg SET msg_type = 'V', attachments = $2, created_on = NOW() - $3::interval WHERE id = $1`, msg.ID, pq.StringArray{attachment}, age)
+		return msg.ID
+	}
+
+	old := storeRecording("a6b0bcfa-4d6d-4b6d-8ccc-7c1ea5b0b7e1.wav")
+	recent := storeRecording("b5f5b5a3-4d4b-4b5b-9a8a-3d6c1e0f7c2e.wav")
+
+	oldMsgID := insertRecording(testdata.Cathy, old, "10 days")
+	recentMsgID := insertRecording(testdata.Bob, recent, "2 days")
+
+	cron := &ivrtasks.RecordingRetentionCron{}
+
+	// no orgs have a retention limit so nothing to do
+	res, err := cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"deleted": 0}, res)
+
+	rt.DB.MustExec(`UPDATE orgs_org SET config = '{"ivr_recording_retention_days": 7}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"deleted": 1}, res)
+
+	// only the old recording has been deleted
+	assertdb.Query(t, rt.DB, `SELECT array_length(attachments, 1) FROM msgs_msg WHERE id = $1`, oldMsgID).Returns(nil)
+	assertdb.Query(t, rt.DB, `SELECT array_length(attachments, 1) FROM msgs_msg WHERE id = $1`, recentMsgID).Returns(1)
+
+	_, err = os.Stat(strings.SplitN(old, ":", 2)[1])
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(strings.SplitN(recent, ":", 2)[1])
+	assert.NoError(t, err)
+
+	// running again finds nothing more to delete
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"deleted": 0}, res)
+}
@@ -39,6 +39,26 @@ func (t *StartIVRFlowBatchTask) Perform(ctx context.Context, rt *runtime.Runtime
 
 // starts a batch of contacts in an IVR flow
 func handleFlowStartBatch(ctx context.Context, rt *runtime.Runtime, batch *models.FlowStartBatch) error {
+	// if the start has been failed, e.g. by its channel's calls being hung up, then skip this batch
+	var startPolicy *models.CallRetryPolicy
+	if batch.StartID != models.NilStartID {
+		status, err := models.GetFlowStartStatus(ctx, rt.DB, batch.StartID)
+		if err != nil {
+			return err
+		}
+		if status == models.StartStatusFailed {
+			slog.Info("skipping batch for failed start", "start_id", batch.StartID, "contacts", len(batch.ContactIDs))
+			return nil
+		}
+
+		// the retry policy of the start applies to every call in the batch
+		attrs, err := models.GetFlowStartAttributes(ctx, rt.DB, batch.StartID)
+		if err != nil {
+			return errors.Wrapf(err, "error loading start: %d", batch.StartID)
+		}
+		startPolicy = attrs.CallRetryPolicy
+	}
+
 	// load our org assets
 	oa, err := models.GetOrgAssets(ctx, rt, batch.OrgID)
 	if err != nil {
@@ -56,7 +76,7 @@ func handleFlowStartBatch(ctx context.Context, rt *runtime.Runtime, batch *model
 		start := time.Now()
 
 		ctx, cancel := context.WithTimeout(ctx, time.Minute)
-		session, err := ivr.RequestCall(ctx, rt, oa, batch, contact)
+		session, err := ivr.RequestCall(ctx, rt, oa, batch, startPolicy, contact)
 		cancel()
 		if err != nil {
 			slog.Error(fmt.Sprintf("error starting ivr flow for contact: %d and flow: %d", contact.ID(), batch.FlowID), "error", err)
@@ -12,6 +12,7 @@ import (
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/core/tasks"
+	ivrtasks "github.com/nyaruka/mailroom/core/tasks/ivr"
 	"github.com/nyaruka/mailroom/core/tasks/starts"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/testsuite"
@@ -68,6 +69,21 @@ func TestIVR(t *testing.T) {
 	testsuite.FlushTasks(t, rt)
 
 	assertdb.Query(t, rt.DB, `SELECT COUNT(*) FROM ivr_call WHERE contact_id = $1 AND status = $2 AND next_attempt IS NOT NULL;`, testdata.Cathy.ID, models.CallStatusQueued).Returns(1)
+
+	// batches for a start which has been failed, e.g. by hanging up its channel, are skipped
+	startID := testdata.InsertFlowStart(rt, testdata.Org1, testdata.IVRFlow, []*testdata.Contact{testdata.Bob})
+	models.MarkStartFailed(context.Background(), rt.DB, startID)
+
+	failedStart := &models.FlowStart{ID: startID, OrgID: testdata.Org1.ID, FlowID: testdata.IVRFlow.ID, StartType: models.StartTypeManual}
+	batch := failedStart.CreateBatch([]models.ContactID{testdata.Bob.ID}, models.FlowTypeVoice, true, 1)
+
+	err = tasks.Queue(rc, queue.BatchQueue, testdata.Org1.ID, &ivrtasks.StartIVRFlowBatchTask{FlowStartBatch: batch}, queue.DefaultPriority)
+	require.NoError(t, err)
+
+	testsuite.FlushTasks(t, rt)
+
+	assertdb.Query(t, rt.DB, `SELECT COUNT(*) FROM ivr_call WHERE contact_id = $1`, testdata.Bob.ID).Returns(0)
+	assertdb.Query(t, rt.DB, `SELECT status FROM flows_flowstart WHERE id = $1`, startID).Returns("F")
 }
 
 var service = &MockService{}
@@ -0,0 +1,103 @@
+package ivr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/redisx"
+	"github.com/pkg/errors"
+)
+
+const TypeStartQueuedCalls = "start_queued_calls"
+
+const startQueuedCallsLockKey string = "lock:start_queued_calls_%d"
+
+func init() {
+	tasks.RegisterType(TypeStartQueuedCalls, func() tasks.Task { return &StartQueuedCallsTask{} })
+}
+
+// StartQueuedCallsTask is queued when a call on a channel ends, to start any throttled calls now that there is a
+// free slot, rather than waiting for them to be retried
+type StartQueuedCallsTask struct {
+	ChannelID models.ChannelID `json:"channel_id"`
+}
+
+func (t *StartQueuedCallsTask) Type() string {
+	return TypeStartQueuedCalls
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *StartQueuedCallsTask) Timeout() time.Duration {
+	return time.Minute
+}
+
+func (t *StartQueuedCallsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrap(err, "error loading org assets")
+	}
+
+	// channel may have been deleted since the task was queued
+	channel := oa.ChannelByID(t.ChannelID)
+	if channel == nil {
+		return nil
+	}
+
+	// only one task at a time can start calls on a channel, otherwise they can both see the same free slots
+	locker := redisx.NewLocker(fmt.Sprintf(startQueuedCallsLockKey, t.ChannelID), time.Minute)
+	lock, err := locker.Grab(rt.RP, time.Second*5)
+	if err != nil {
+		return errors.Wrapf(err, "error grabbing lock to start queued calls on channel: %d", t.ChannelID)
+	}
+
+	// another task is already starting calls on this channel
+	if lock == "" {
+		return nil
+	}
+	defer locker.Release(rt.RP, lock)
+
+	limit, err := ivr.CallLimit(rt, channel)
+	if err != nil {
+		return err
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	active, err := models.ActiveCallCount(ctx, rt.DB, channel.ID())
+	if err != nil {
+		return err
+	}
+	if active >= limit {
+		return nil
+	}
+
+	calls, err := models.LoadQueuedCalls(ctx, rt.DB, channel.ID(), limit-active)
+	if err != nil {
+		return err
+	}
+
+	clogs := make([]*models.ChannelLog, 0, len(calls))
+
+	for _, call := range calls {
+		_, clog, err := retryCall(ctx, rt, oa, channel, call)
+		if clog != nil {
+			clogs = append(clogs, clog)
+		}
+		if err != nil {
+			slog.Error("error starting queued call", "error", err, "call_id", call.ID())
+		}
+	}
+
+	if err := models.InsertChannelLogs(ctx, rt, clogs); err != nil {
+		slog.Error("error inserting channel logs", "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package ivr_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	ivrtasks "github.com/nyaruka/mailroom/core/tasks/ivr"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartQueuedCalls(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	ivr.RegisterServiceType(models.ChannelType("ZZ"), NewMockProvider)
+
+	rt.DB.MustExec(`UPDATE channels_channel SET channel_type = 'ZZ', config = '{"max_concurrent_events": 2}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	service.callError = nil
+	service.callID = ivr.CallID("call1")
+
+	// George is on a call and Cathy and Bob have calls which were throttled
+	testdata.InsertCall(rt, testdata.Org1, testdata.TwilioChannel, testdata.George)
+	cathyCallID := testdata.InsertCall(rt, testdata.Org1, testdata.TwilioChannel, testdata.Cathy)
+	bobCallID := testdata.InsertCall(rt, testdata.Org1, testdata.TwilioChannel, testdata.Bob)
+	rt.DB.MustExec(`UPDATE ivr_call SET status = 'Q', direction = 'O', next_attempt = NOW() + INTERVAL '1 hour' WHERE id = $1`, cathyCallID)
+	rt.DB.MustExec(`UPDATE ivr_call SET status = 'Q', direction = 'O', next_attempt = NOW() + INTERVAL '2 hours' WHERE id = $1`, bobCallID)
+
+	oa := testdata.Org1.Load(rt)
+	channel := oa.ChannelByID(testdata.TwilioChannel.ID)
+
+	limit, err := ivr.CallLimit(rt, channel)
+	require.NoError(t, err)
+	assert.Equal(t, 2, limit)
+
+	// provider has told us it's at capacity so our budget has been halved to 1 and there are no free slots
+	rc.Do("HSET", fmt.Sprintf("ivr_concurrency:%s", testdata.TwilioChannel.UUID), "limit", 1)
+
+	task := &ivrtasks.StartQueuedCallsTask{ChannelID: testdata.TwilioChannel.ID}
+	require.NoError(t, task.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM ivr_call WHERE status = 'Q'`).Returns(2)
+
+	// budget grows back to 2 which frees one slot
+	rc.Do("HSET", fmt.Sprintf("ivr_concurrency:%s", testdata.TwilioChannel.UUID), "limit", 2)
+
+	// but nothing is started while another task has the channel locked
+	rc.Do("SET", fmt.Sprintf("lock:start_queued_calls_%d", testdata.TwilioChannel.ID), "123", "EX", 60)
+
+	require.NoError(t, task.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM ivr_call WHERE status = 'Q'`).Returns(2)
+
+	// once it's released, the longest queued call is started without waiting for its retry
+	rc.Do("DEL", fmt.Sprintf("lock:start_queued_calls_%d", testdata.TwilioChannel.ID))
+
+	require.NoError(t, task.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT status FROM ivr_call WHERE id = $1`, cathyCallID).Returns("W")
+	assertdb.Query(t, rt.DB, `SELECT status FROM ivr_call WHERE id = $1`, bobCallID).Returns("Q")
+
+	// and now that we're at the limit, nothing more is started
+	require.NoError(t, task.Perform(ctx, rt, testdata.Org1.ID))
+
+	assertdb.Query(t, rt.DB, `SELECT status FROM ivr_call WHERE id = $1`, bobCallID).Returns("Q")
+}
@@ -0,0 +1,73 @@
+package ivr
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const TypeTranscribeRecording = "transcribe_recording"
+
+func init() {
+	tasks.RegisterType(TypeTranscribeRecording, func() tasks.Task { return &TranscribeRecordingTask{} })
+}
+
+// TranscribeRecordingTask is queued after an IVR recording has been received, to transcribe it without holding up
+// the call, and then set the transcript as the text of the message and on the run result for the recording
+type TranscribeRecordingTask struct {
+	ContactID  models.ContactID `json:"contact_id"`
+	MsgUUID    flows.MsgUUID    `json:"msg_uuid"`
+	Attachment utils.Attachment `json:"attachment"`
+	Language   i18n.Language    `json:"language,omitempty"`
+}
+
+func (t *TranscribeRecordingTask) Type() string {
+	return TypeTranscribeRecording
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *TranscribeRecordingTask) Timeout() time.Duration {
+	return time.Minute * 5
+}
+
+func (t *TranscribeRecordingTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrap(err, "error loading org assets")
+	}
+
+	// recordings are stored under the name of their message
+	_, audio, err := oa.Org().GetAttachment(ctx, rt, path.Base(t.Attachment.URL()))
+	if err != nil {
+		return errors.Wrap(err, "error fetching recording")
+	}
+
+	transcript, err := ivr.TranscribeRecording(ctx, rt, oa, audio, t.Attachment.ContentType(), t.Language)
+	if err != nil || transcript == "" {
+		return err
+	}
+
+	if err := models.UpdateIVRMsgText(ctx, rt.DB, orgID, t.MsgUUID, transcript); err != nil {
+		return err
+	}
+
+	contact, err := models.LoadContact(ctx, rt.ReadonlyDB, oa, t.ContactID)
+	if err != nil {
+		return errors.Wrap(err, "error loading contact")
+	}
+	flowContact, err := contact.FlowContact(oa)
+	if err != nil {
+		return errors.Wrap(err, "error creating flow contact")
+	}
+
+	return models.AddResultTranscript(ctx, rt, oa, flowContact, t.Attachment.URL(), transcript)
+}
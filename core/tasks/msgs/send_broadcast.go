@@ -2,6 +2,7 @@ package msgs
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 
@@ -19,6 +20,9 @@ const (
 	TypeSendBroadcast = "send_broadcast"
 
 	startBatchSize = 100
+
+	// number of times we'll try to create the batches for a broadcast
+	maxErrors = 3
 )
 
 func init() {
@@ -28,6 +32,8 @@ func init() {
 // SendBroadcastTask is the task send broadcasts
 type SendBroadcastTask struct {
 	*models.Broadcast
+
+	ErrorCount int `json:"error_count,omitempty"`
 }
 
 func (t *SendBroadcastTask) Type() string {
@@ -41,13 +47,34 @@ func (t *SendBroadcastTask) Timeout() time.Duration {
 
 // Perform handles sending the broadcast by creating batches of broadcast sends for all the unique contacts
 func (t *SendBroadcastTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
-	if err := createBroadcastBatches(ctx, rt, t.Broadcast); err != nil {
+	if queued, err := createBroadcastBatches(ctx, rt, t.Broadcast); err != nil {
+		// if error is user created query error.. don't escalate error to sentry
+		isQueryError, _ := contactql.IsQueryError(err)
+
+		// broadcasts without an id can't be resumed, so retrying one which has already queued batches would resend to
+		// those contacts
+		canRetry := t.Broadcast.ID != models.NilBroadcastID || queued == 0
+
+		// other errors are retried, and the retry will resume after the last batch we created
+		if !isQueryError && canRetry {
+			t.ErrorCount++
+			if t.ErrorCount < maxErrors {
+				rc := rt.RP.Get()
+				retryErr := tasks.Queue(rc, queue.BatchQueue, orgID, t, queue.DefaultPriority)
+				rc.Close()
+
+				if retryErr == nil {
+					slog.Error("error creating broadcast batches, retrying", "error", err, "broadcast_id", t.Broadcast.ID, "error_count", t.ErrorCount)
+					return nil
+				}
+				slog.Error("error requeuing errored broadcast", "error", retryErr)
+			}
+		}
+
 		if t.Broadcast.ID != models.NilBroadcastID {
 			models.MarkBroadcastFailed(ctx, rt.DB, t.Broadcast.ID)
 		}
 
-		// if error is user created query error.. don't escalate error to sentry
-		isQueryError, _ := contactql.IsQueryError(err)
 		if !isQueryError {
 			return err
 		}
@@ -56,58 +83,82 @@ func (t *SendBroadcastTask) Perform(ctx context.Context, rt *runtime.Runtime, or
 	return nil
 }
 
-func createBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *models.Broadcast) error {
+// creates and queues the batches for the given broadcast, returning the number of batches queued
+func createBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *models.Broadcast) (int, error) {
 	oa, err := models.GetOrgAssets(ctx, rt, bcast.OrgID)
 	if err != nil {
-		return errors.Wrapf(err, "error getting org assets")
+		return 0, errors.Wrapf(err, "error getting org assets")
 	}
 
-	contactIDs, err := search.ResolveRecipients(ctx, rt, oa, nil, &search.Recipients{
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	// broadcasts without an id can't be resumed
+	progressName := ""
+	if bcast.ID != models.NilBroadcastID {
+		progressName = fmt.Sprintf("broadcast:%d", bcast.ID)
+	}
+
+	progress, err := search.LoadResolveProgress(rc, progressName)
+	if err != nil {
+		return 0, err
+	}
+
+	q := queue.BatchQueue
+	queued := 0
+
+	queueBatch := func(ids []models.ContactID, isLast bool) error {
+		batch := bcast.CreateBatch(ids, isLast)
+		if err := tasks.Queue(rc, q, bcast.OrgID, &SendBroadcastBatchTask{BroadcastBatch: batch}, queue.DefaultPriority); err != nil {
+			return errors.Wrap(err, "error queuing broadcast batch")
+		}
+		queued++
+		return progress.Record(rc, ids)
+	}
+
+	// the last batch is held back until we know it's the last one
+	var pending []models.ContactID
+	started := false
+
+	err = search.StreamRecipients(ctx, rt, oa, nil, &search.Recipients{
 		ContactIDs:      bcast.ContactIDs,
 		GroupIDs:        bcast.GroupIDs,
 		URNs:            bcast.URNs,
 		Query:           string(bcast.Query),
 		ExcludeGroupIDs: nil,
-	}, -1)
-	if err != nil {
-		return errors.Wrap(err, "error resolving broadcast recipients")
-	}
+	}, -1, progress.LastID, func(ids []models.ContactID, total int) error {
+		// two or fewer contacts? queue to our handler queue for sending
+		if !started {
+			if progress.Processed+total <= 2 {
+				q = queue.HandlerQueue
+			}
+			started = true
+		}
 
-	// if there are no contacts to send to, mark our broadcast as sent, we are done
-	if len(contactIDs) == 0 {
-		if bcast.ID != models.NilBroadcastID {
-			err = models.MarkBroadcastSent(ctx, rt.DB, bcast.ID)
-			if err != nil {
-				return errors.Wrapf(err, "error marking broadcast as sent")
+		for _, batch := range models.ChunkSlice(ids, startBatchSize) {
+			if pending != nil {
+				if err := queueBatch(pending, false); err != nil {
+					return err
+				}
 			}
+			pending = batch
 		}
 		return nil
+	})
+	if err != nil {
+		return queued, errors.Wrap(err, "error resolving broadcast recipients")
 	}
 
-	// two or fewer contacts? queue to our handler queue for sending
-	q := queue.BatchQueue
-	if len(contactIDs) <= 2 {
-		q = queue.HandlerQueue
-	}
-
-	rc := rt.RP.Get()
-	defer rc.Close()
-
-	// create tasks for batches of contacts
-	idBatches := models.ChunkSlice(contactIDs, startBatchSize)
-	for i, idBatch := range idBatches {
-		isLast := (i == len(idBatches)-1)
-
-		batch := bcast.CreateBatch(idBatch, isLast)
-		err = tasks.Queue(rc, q, bcast.OrgID, &SendBroadcastBatchTask{BroadcastBatch: batch}, queue.DefaultPriority)
-		if err != nil {
-			if i == 0 {
-				return errors.Wrap(err, "error queuing broadcast batch")
-			}
-			// if we've already queued other batches.. we don't want to error and have the task be retried
-			slog.Error("error queuing broadcast batch", "error", err)
+	if pending != nil {
+		if err := queueBatch(pending, true); err != nil {
+			return queued, err
+		}
+	} else if progress.Processed == 0 && bcast.ID != models.NilBroadcastID {
+		// if there are no contacts to send to, mark our broadcast as sent, we are done
+		if err := models.MarkBroadcastSent(ctx, rt.DB, bcast.ID); err != nil {
+			return queued, errors.Wrapf(err, "error marking broadcast as sent")
 		}
 	}
 
-	return nil
+	return queued, progress.Clear(rc)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
 	"github.com/nyaruka/mailroom/core/tasks/msgs"
 	"github.com/nyaruka/mailroom/core/tasks/starts"
 	"github.com/nyaruka/mailroom/runtime"
@@ -45,6 +46,7 @@ func (c *schedulesCron) Run(ctx context.Context, rt *runtime.Runtime) (map[strin
 	// for each unfired schedule
 	broadcasts := 0
 	triggers := 0
+	imports := 0
 	noops := 0
 
 	for _, s := range unfired {
@@ -106,8 +108,14 @@ func (c *schedulesCron) Run(ctx context.Context, rt *runtime.Runtime) (map[strin
 			// add our flow start task
 			task = &starts.StartFlowTask{FlowStart: start}
 			triggers++
+		} else if s.ImportSource() != nil {
+			log = log.With("import_source_id", s.ImportSource().ID)
+
+			// add our task to sync this import source
+			task = &contacts.SyncContactImportTask{SourceID: s.ImportSource().ID}
+			imports++
 		} else {
-			log.Error("schedule found with no associated active broadcast or trigger")
+			log.Error("schedule found with no associated active broadcast, trigger or import source")
 			noops++
 		}
 
@@ -146,5 +154,5 @@ func (c *schedulesCron) Run(ctx context.Context, rt *runtime.Runtime) (map[strin
 		}
 	}
 
-	return map[string]any{"broadcasts": broadcasts, "triggers": triggers, "noops": noops}, nil
+	return map[string]any{"broadcasts": broadcasts, "triggers": triggers, "imports": imports, "noops": noops}, nil
 }
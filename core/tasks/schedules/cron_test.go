@@ -35,6 +35,10 @@ func TestCheckSchedules(t *testing.T) {
 	s4 := testdata.InsertSchedule(rt, testdata.Org1, models.RepeatPeriodDaily, time.Now().Add(-time.Hour))
 	testdata.InsertScheduledTrigger(rt, testdata.Org1, testdata.Favorites, s4, []*testdata.Group{testdata.DoctorsGroup}, nil, nil)
 
+	// add a repeating schedule and tie a contact import source to it
+	s5 := testdata.InsertSchedule(rt, testdata.Org1, models.RepeatPeriodDaily, time.Now().Add(-time.Hour))
+	testdata.InsertContactImportSource(rt, testdata.Org1, "http://rapidpro.io/contacts.csv", models.ContactImportSourceFormatCSV, s5, testdata.Admin)
+
 	// add a repeating orphaned schedule
 	testdata.InsertSchedule(rt, testdata.Org1, models.RepeatPeriodDaily, time.Now().Add(-time.Hour))
 
@@ -42,7 +46,7 @@ func TestCheckSchedules(t *testing.T) {
 	cron := &schedulesCron{}
 	res, err := cron.Run(ctx, rt)
 	assert.NoError(t, err)
-	assert.Equal(t, map[string]any{"broadcasts": 2, "triggers": 2, "noops": 1}, res)
+	assert.Equal(t, map[string]any{"broadcasts": 2, "triggers": 2, "imports": 1, "noops": 1}, res)
 
 	// should have 2 flow starts added to our DB ready to go
 	assertdb.Query(t, rt.DB, `SELECT count(*) FROM flows_flowstart WHERE flow_id = $1 AND start_type = 'T' AND status = 'P'`, testdata.Favorites.ID).Returns(2)
@@ -79,7 +83,8 @@ func TestCheckSchedules(t *testing.T) {
 	// the repeating schedules should have next_fire and last_fire updated
 	assertdb.Query(t, rt.DB, `SELECT count(*) FROM schedules_schedule WHERE id = $1 AND next_fire > NOW() AND last_fire < NOW()`, s2).Returns(1)
 	assertdb.Query(t, rt.DB, `SELECT count(*) FROM schedules_schedule WHERE id = $1 AND next_fire > NOW() AND last_fire < NOW()`, s4).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM schedules_schedule WHERE id = $1 AND next_fire > NOW() AND last_fire < NOW()`, s5).Returns(1)
 
 	// check the tasks created
-	testsuite.AssertBatchTasks(t, testdata.Org1.ID, map[string]int{"start_flow": 2, "send_broadcast": 2})
+	testsuite.AssertBatchTasks(t, testdata.Org1.ID, map[string]int{"start_flow": 2, "send_broadcast": 2, "sync_contact_import": 1})
 }
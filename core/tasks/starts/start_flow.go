@@ -2,6 +2,7 @@ package starts
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 
@@ -20,15 +21,20 @@ const (
 	TypeStartFlow = "start_flow"
 
 	startBatchSize = 100
+
+	// number of times we'll try to create the batches for a start
+	maxErrors = 3
 )
 
 func init() {
 	tasks.RegisterType(TypeStartFlow, func() tasks.Task { return &StartFlowTask{} })
 }
 
-// StartFlowBatchTask is the start flow batch task
+// StartFlowTask is the start flow task
 type StartFlowTask struct {
 	*models.FlowStart
+
+	ErrorCount int `json:"error_count,omitempty"`
 }
 
 func (t *StartFlowTask) Type() string {
@@ -42,13 +48,29 @@ func (t *StartFlowTask) Timeout() time.Duration {
 
 func (t *StartFlowTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
 	if err := createFlowStartBatches(ctx, rt, t.FlowStart); err != nil {
-		models.MarkStartFailed(ctx, rt.DB, t.FlowStart.ID)
-
 		// if error is user created query error.. don't escalate error to sentry
 		isQueryError, _ := contactql.IsQueryError(err)
-		if !isQueryError {
-			return err
+		if isQueryError {
+			models.MarkStartFailed(ctx, rt.DB, t.FlowStart.ID)
+			return nil
 		}
+
+		// other errors are retried, and the retry will resume after the last batch we created
+		t.ErrorCount++
+		if t.ErrorCount < maxErrors {
+			rc := rt.RP.Get()
+			retryErr := tasks.Queue(rc, queue.BatchQueue, orgID, t, queue.DefaultPriority)
+			rc.Close()
+
+			if retryErr == nil {
+				slog.Error("error creating flow start batches, retrying", "error", err, "start_id", t.FlowStart.ID, "error_count", t.ErrorCount)
+				return nil
+			}
+			slog.Error("error requeuing errored flow start", "error", retryErr)
+		}
+
+		models.MarkStartFailed(ctx, rt.DB, t.FlowStart.ID)
+		return err
 	}
 
 	return nil
@@ -66,15 +88,84 @@ func createFlowStartBatches(ctx context.Context, rt *runtime.Runtime, start *mod
 		return errors.Wrap(err, "error loading flow")
 	}
 
-	var contactIDs []models.ContactID
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	progress, err := search.LoadResolveProgress(rc, fmt.Sprintf("start:%d", start.ID))
+	if err != nil {
+		return err
+	}
+
+	// by default we start in the batch queue unless we have two or fewer contacts, and if this is a big multi batch
+	// blast, we give it low priority
+	q, priority := queue.BatchQueue, queue.DefaultPriority
+	total := 0
+
+	queueBatch := func(ids []models.ContactID, isLast bool) error {
+		batch := start.CreateBatch(ids, flow.FlowType(), isLast, total)
+
+		// task is different if we are an IVR flow
+		var batchTask tasks.Task
+		if flow.FlowType() == models.FlowTypeVoice {
+			batchTask = &ivr.StartIVRFlowBatchTask{FlowStartBatch: batch}
+		} else {
+			batchTask = &StartFlowBatchTask{FlowStartBatch: batch}
+		}
+
+		if err := tasks.Queue(rc, q, start.OrgID, batchTask, priority); err != nil {
+			return errors.Wrap(err, "error queuing flow start batch")
+		}
+		return progress.Record(rc, ids)
+	}
+
+	// the last batch is held back until we know it's the last one
+	var pending []models.ContactID
+	started := false
+
+	addContacts := func(ids []models.ContactID, remaining int) error {
+		if !started {
+			total = progress.Processed + remaining
+
+			// mark our start as starting, last task will mark as complete
+			if err := models.MarkStartStarted(ctx, rt.DB, start.ID, total); err != nil {
+				return errors.Wrapf(err, "error marking start as started")
+			}
+
+			if total <= 2 {
+				q = queue.HandlerQueue
+			}
+			if total > startBatchSize {
+				priority = queue.LowPriority
+			}
+			started = true
+		}
+
+		for _, batch := range models.ChunkSlice(ids, startBatchSize) {
+			if pending != nil {
+				if err := queueBatch(pending, false); err != nil {
+					return err
+				}
+			}
+			pending = batch
+		}
+		return nil
+	}
 
 	if start.CreateContact {
-		// if we are meant to create a new contact, do so
+		// if we are meant to create a new contact, do so, unless a previous attempt already created it and queued its
+		// batch, in which case there's nothing left to do
+		if progress.Processed > 0 {
+			return progress.Clear(rc)
+		}
+
 		contact, _, err := models.CreateContact(ctx, rt.DB, oa, models.NilUserID, "", i18n.NilLanguage, nil)
 		if err != nil {
 			return errors.Wrapf(err, "error creating new contact")
 		}
-		contactIDs = []models.ContactID{contact.ID()}
+		err = addContacts([]models.ContactID{contact.ID()}, 1)
+		if err != nil {
+			return err
+		}
 	} else {
 		// otherwise resolve recipients across contacts, groups, urns etc
 
@@ -84,74 +175,32 @@ func createFlowStartBatches(ctx context.Context, rt *runtime.Runtime, start *mod
 			limit = 1
 		}
 
-		contactIDs, err = search.ResolveRecipients(ctx, rt, oa, flow, &search.Recipients{
+		err = search.StreamRecipients(ctx, rt, oa, flow, &search.Recipients{
 			ContactIDs:      start.ContactIDs,
 			GroupIDs:        start.GroupIDs,
 			URNs:            start.URNs,
 			Query:           string(start.Query),
 			Exclusions:      start.Exclusions,
 			ExcludeGroupIDs: start.ExcludeGroupIDs,
-		}, limit)
+		}, limit, progress.LastID, addContacts)
 		if err != nil {
 			return errors.Wrap(err, "error resolving start recipients")
 		}
 	}
 
-	// mark our start as starting, last task will mark as complete
-	err = models.MarkStartStarted(ctx, rt.DB, start.ID, len(contactIDs))
-	if err != nil {
-		return errors.Wrapf(err, "error marking start as started")
-	}
-
-	// if there are no contacts to start, mark our start as complete, we are done
-	if len(contactIDs) == 0 {
-		err = models.MarkStartComplete(ctx, rt.DB, start.ID)
-		if err != nil {
-			return errors.Wrapf(err, "error marking start as complete")
+	if pending != nil {
+		if err := queueBatch(pending, true); err != nil {
+			return err
 		}
-		return nil
-	}
-
-	// split the contact ids into batches to become batch tasks
-	idBatches := models.ChunkSlice(contactIDs, startBatchSize)
-
-	// by default we start in the batch queue unless we have two or fewer contacts
-	q := queue.BatchQueue
-	if len(contactIDs) <= 2 {
-		q = queue.HandlerQueue
-	}
-
-	// if this is a big multi batch blast, give it low priority
-	priority := queue.DefaultPriority
-	if len(idBatches) > 1 {
-		priority = queue.LowPriority
-	}
-
-	rc := rt.RP.Get()
-	defer rc.Close()
-
-	for i, idBatch := range idBatches {
-		isLast := (i == len(idBatches)-1)
-
-		batch := start.CreateBatch(idBatch, flow.FlowType(), isLast, len(contactIDs))
-
-		// task is different if we are an IVR flow
-		var batchTask tasks.Task
-		if flow.FlowType() == models.FlowTypeVoice {
-			batchTask = &ivr.StartIVRFlowBatchTask{FlowStartBatch: batch}
-		} else {
-			batchTask = &StartFlowBatchTask{FlowStartBatch: batch}
+	} else if progress.Processed == 0 {
+		// if there are no contacts to start, mark our start as complete, we are done
+		if err := models.MarkStartStarted(ctx, rt.DB, start.ID, 0); err != nil {
+			return errors.Wrapf(err, "error marking start as started")
 		}
-
-		err = tasks.Queue(rc, q, start.OrgID, batchTask, priority)
-		if err != nil {
-			if i == 0 {
-				return errors.Wrap(err, "error queuing flow start batch")
-			}
-			// if we've already queued other batches.. we don't want to error and have the task be retried
-			slog.Error("error queuing flow start batch", "error", err)
+		if err := models.MarkStartComplete(ctx, rt.DB, start.ID); err != nil {
+			return errors.Wrapf(err, "error marking start as complete")
 		}
 	}
 
-	return nil
+	return progress.Clear(rc)
 }
@@ -1,10 +1,12 @@
 package starts_test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/jsonx"
 	_ "github.com/nyaruka/mailroom/core/handlers"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
@@ -12,7 +14,9 @@ import (
 	"github.com/nyaruka/mailroom/core/tasks/starts"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStartFlowTask(t *testing.T) {
@@ -254,3 +258,61 @@ func TestStartFlowTask(t *testing.T) {
 		}
 	}
 }
+
+func TestStartFlowTaskResumes(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	testsuite.ReindexElastic(ctx)
+
+	var doctorIDs []models.ContactID
+	err := rt.DB.Select(&doctorIDs, `SELECT contact_id FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1 ORDER BY contact_id`, testdata.DoctorsGroup.ID)
+	require.NoError(t, err)
+	require.Len(t, doctorIDs, 121)
+
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, testdata.Favorites.ID).
+		WithGroupIDs([]models.GroupID{testdata.DoctorsGroup.ID})
+	require.NoError(t, models.InsertFlowStarts(ctx, rt.DB, []*models.FlowStart{start}))
+
+	// simulate a previous attempt which failed after queuing the batch of the first 100 contacts
+	rc.Do("HSET", fmt.Sprintf("resolve_progress:start:%d", start.ID), "last_id", doctorIDs[99], "processed", 100)
+
+	task := &starts.StartFlowTask{FlowStart: start, ErrorCount: 1}
+	require.NoError(t, task.Perform(ctx, rt, testdata.Org1.ID))
+
+	// only the remaining contacts are batched
+	orgTasks := testsuite.CurrentTasks(t, rt)[testdata.Org1.ID]
+	require.Len(t, orgTasks, 1)
+	assert.Equal(t, "start_flow_batch", orgTasks[0].Type)
+
+	batch := &models.FlowStartBatch{}
+	jsonx.MustUnmarshal(orgTasks[0].Task, batch)
+	assert.Equal(t, doctorIDs[100:], batch.ContactIDs)
+	assert.True(t, batch.IsLast)
+	assert.Equal(t, 121, batch.TotalContacts)
+
+	assertdb.Query(t, rt.DB, `SELECT contact_count FROM flows_flowstart WHERE id = $1`, start.ID).Returns(121)
+	assertredis.NotExists(t, rt.RP, fmt.Sprintf("resolve_progress:start:%d", start.ID))
+
+	testsuite.FlushTasks(t, rt)
+
+	// a start which creates a contact doesn't create another if its batch was already queued
+	start = models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, testdata.Favorites.ID).WithCreateContact(true)
+	require.NoError(t, models.InsertFlowStarts(ctx, rt.DB, []*models.FlowStart{start}))
+
+	rc.Do("HSET", fmt.Sprintf("resolve_progress:start:%d", start.ID), "last_id", 30000, "processed", 1)
+
+	var numContacts int
+	require.NoError(t, rt.DB.Get(&numContacts, `SELECT count(*) FROM contacts_contact`))
+
+	task = &starts.StartFlowTask{FlowStart: start, ErrorCount: 1}
+	require.NoError(t, task.Perform(ctx, rt, testdata.Org1.ID))
+
+	assert.Len(t, testsuite.CurrentTasks(t, rt)[testdata.Org1.ID], 0)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM contacts_contact`).Returns(numContacts)
+	assertredis.NotExists(t, rt.RP, fmt.Sprintf("resolve_progress:start:%d", start.ID))
+}
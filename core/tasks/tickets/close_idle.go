@@ -0,0 +1,186 @@
+package tickets
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/core/tasks/handler"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const closeIdleBatchSize = 100
+
+func init() {
+	tasks.RegisterCron("close_idle_tickets", false, &CloseIdleCron{})
+}
+
+type CloseIdleCron struct{}
+
+func (c *CloseIdleCron) Next(last time.Time) time.Time {
+	return tasks.CronNext(last, time.Minute*15)
+}
+
+// Run closes open tickets in orgs with auto-closing enabled, which have had no activity for the number of days configured
+// for the org or the ticket's topic
+func (c *CloseIdleCron) Run(ctx context.Context, rt *runtime.Runtime) (map[string]any, error) {
+	orgIDs, err := models.GetOrgIDsWithTicketAutoClose(ctx, rt.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := models.GetSystemUserID(ctx, rt.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	numClosed := 0
+
+	for _, orgID := range orgIDs {
+		closed, err := closeIdleTickets(ctx, rt, orgID, userID)
+		numClosed += closed
+
+		// log and move on to the next org so that one broken org doesn't block the others
+		if err != nil {
+			slog.Error("error closing idle tickets", "org_id", orgID, "error", err)
+		}
+	}
+
+	return map[string]any{"closed": numClosed}, nil
+}
+
+func closeIdleTickets(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID, userID models.UserID) (int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	topics, err := oa.Topics()
+	if err != nil {
+		return 0, errors.Wrap(err, "error loading topics")
+	}
+
+	numClosed := 0
+
+	for _, t := range topics {
+		topic := t.(*models.Topic)
+
+		// org assets may be more recent than the query that found this org
+		days := oa.Org().TicketAutoCloseDaysForTopic(topic.UUID())
+		if days <= 0 {
+			continue
+		}
+
+		closed, err := closeIdleTicketsForTopic(ctx, rt, oa, userID, topic, dates.Now().Add(-time.Duration(days)*time.Hour*24))
+		numClosed += closed
+		if err != nil {
+			return numClosed, errors.Wrapf(err, "error closing idle tickets for topic %d", topic.ID())
+		}
+	}
+
+	return numClosed, nil
+}
+
+func closeIdleTicketsForTopic(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, userID models.UserID, topic *models.Topic, idleSince time.Time) (int, error) {
+	numClosed := 0
+
+	for {
+		tickets, err := models.LoadIdleTickets(ctx, rt.DB, oa.OrgID(), topic.ID(), idleSince, closeIdleBatchSize)
+		if err != nil {
+			return numClosed, errors.Wrap(err, "error loading idle tickets")
+		}
+		if len(tickets) == 0 {
+			break
+		}
+
+		if text := oa.Org().TicketAutoCloseMessage(); text != "" {
+			if err := sendAutoCloseMessages(ctx, rt, oa, userID, tickets, text); err != nil {
+				return numClosed, errors.Wrap(err, "error sending auto-close messages")
+			}
+		}
+
+		evts, err := models.CloseTickets(ctx, rt, oa, userID, tickets)
+		if err != nil {
+			return numClosed, errors.Wrap(err, "error closing tickets")
+		}
+
+		if err := queueTicketEvents(rt, evts); err != nil {
+			return numClosed, err
+		}
+
+		numClosed += len(evts)
+
+		if len(tickets) < closeIdleBatchSize {
+			break
+		}
+	}
+
+	return numClosed, nil
+}
+
+// sends the given text to the contacts of the given tickets as ticket replies
+func sendAutoCloseMessages(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, userID models.UserID, tickets []*models.Ticket, text string) error {
+	contactIDs := make([]models.ContactID, len(tickets))
+	for i, t := range tickets {
+		contactIDs[i] = t.ContactID()
+	}
+
+	contacts, err := models.LoadContacts(ctx, rt.DB, oa, contactIDs)
+	if err != nil {
+		return errors.Wrap(err, "error loading ticket contacts")
+	}
+
+	contactsByID := make(map[models.ContactID]*models.Contact, len(contacts))
+	for _, c := range contacts {
+		contactsByID[c.ID()] = c
+	}
+
+	msgs := make([]*models.Msg, 0, len(tickets))
+
+	for _, ticket := range tickets {
+		c := contactsByID[ticket.ContactID()]
+		if c == nil {
+			continue
+		}
+
+		contact, err := c.FlowContact(oa)
+		if err != nil {
+			return errors.Wrap(err, "error creating flow contact")
+		}
+
+		out, ch := models.NewMsgOut(oa, contact, text, nil, nil, contact.Locale(oa.Env()))
+
+		msg, err := models.NewOutgoingTicketMsg(rt, oa.Org(), ch, contact, out, dates.Now(), ticket.ID(), userID)
+		if err != nil {
+			return errors.Wrap(err, "error creating outgoing message")
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if err := models.InsertMessages(ctx, rt.DB, msgs); err != nil {
+		return errors.Wrap(err, "error inserting outgoing messages")
+	}
+
+	msgio.QueueMessages(ctx, rt, rt.DB, nil, msgs)
+
+	return nil
+}
+
+// queues the closed events so that any ticket closed triggers fire
+func queueTicketEvents(rt *runtime.Runtime, evts map[*models.Ticket]*models.TicketEvent) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	for t, e := range evts {
+		if err := handler.QueueTicketEvent(rc, t.ContactID(), e); err != nil {
+			return errors.Wrapf(err, "error queueing ticket event for ticket %d", t.ID())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package tickets_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/tickets"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseIdleTickets(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	ticket1 := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Where my shoes", time.Now().Add(-time.Hour*24*10), nil)
+	ticket2 := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Bob, testdata.DefaultTopic, "Where my pants", time.Now().Add(-time.Hour*24*2), nil)
+	ticket3 := testdata.InsertOpenTicket(rt, testdata.Org2, testdata.Org2Contact, testdata.DefaultTopic, "Where my hat", time.Now().Add(-time.Hour*24*10), nil)
+
+	cron := &tickets.CloseIdleCron{}
+
+	// no orgs have auto-closing enabled so nothing to do
+	res, err := cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"closed": 0}, res)
+
+	rt.DB.MustExec(`UPDATE orgs_org SET config = '{"ticket_autoclose_days": 7, "ticket_autoclose_message": "Closing this due to inactivity"}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"closed": 1}, res)
+
+	// only ticket #1 is idle for long enough in an org with auto-closing enabled
+	assertdb.Query(t, rt.DB, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket1.ID).Columns(map[string]any{"status": "C"})
+	assertdb.Query(t, rt.DB, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket2.ID).Columns(map[string]any{"status": "O"})
+	assertdb.Query(t, rt.DB, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket3.ID).Columns(map[string]any{"status": "O"})
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM tickets_ticketevent WHERE ticket_id = $1 AND event_type = 'C'`, ticket1.ID).Returns(1)
+
+	// contact was sent the auto-close message as a ticket reply
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM msgs_msg WHERE ticket_id = $1 AND direction = 'O' AND text = 'Closing this due to inactivity'`, ticket1.ID).Returns(1)
+
+	// and closed event was queued for handling so that ticket closed triggers can fire
+	assertredis.LLen(t, rt.RP, fmt.Sprintf("c:%d:%d", testdata.Org1.ID, testdata.Cathy.ID), 1)
+	assertredis.LLen(t, rt.RP, fmt.Sprintf("c:%d:%d", testdata.Org1.ID, testdata.Bob.ID), 0)
+
+	// running again finds nothing else to close
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"closed": 0}, res)
+
+	// topics can override the org setting
+	ticket4 := testdata.InsertOpenTicket(rt, testdata.Org1, testdata.George, testdata.SalesTopic, "Where my socks", time.Now().Add(-time.Hour*24*2), nil)
+
+	rt.DB.MustExec(`UPDATE orgs_org SET config = config || jsonb_build_object('ticket_autoclose_topics', jsonb_build_object($2::text, 1)) WHERE id = $1`, testdata.Org1.ID, testdata.SalesTopic.UUID)
+	models.FlushCache()
+
+	res, err = cron.Run(ctx, rt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"closed": 1}, res)
+
+	// ticket #2 has the org default topic so isn't idle long enough, but ticket #4 is
+	assertdb.Query(t, rt.DB, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket2.ID).Columns(map[string]any{"status": "O"})
+	assertdb.Query(t, rt.DB, `SELECT status FROM tickets_ticket WHERE id = $1`, ticket4.ID).Columns(map[string]any{"status": "C"})
+}
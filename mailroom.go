@@ -15,6 +15,7 @@ import (
 	"github.com/nyaruka/gocommon/analytics"
 	"github.com/nyaruka/gocommon/storage"
 	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/utils/cron"
 	"github.com/nyaruka/mailroom/web"
@@ -129,11 +130,11 @@ func (mr *Mailroom) Start() error {
 		if err != nil {
 			return err
 		}
-		mr.rt.AttachmentStorage = storage.NewS3(s3Client, mr.rt.Config.S3AttachmentsBucket, c.S3Region, s3.BucketCannedACLPublicRead, 32)
+		mr.rt.AttachmentStorage = runtime.NewDeletableS3(s3Client, mr.rt.Config.S3AttachmentsBucket, c.S3Region, s3.BucketCannedACLPublicRead, 32)
 		mr.rt.SessionStorage = storage.NewS3(s3Client, mr.rt.Config.S3SessionsBucket, c.S3Region, s3.ObjectCannedACLPrivate, 32)
 		mr.rt.LogStorage = storage.NewS3(s3Client, mr.rt.Config.S3LogsBucket, c.S3Region, s3.ObjectCannedACLPrivate, 32)
 	} else {
-		mr.rt.AttachmentStorage = storage.NewFS("_storage/attachments", 0766)
+		mr.rt.AttachmentStorage = runtime.NewDeletableFS("_storage/attachments", 0766)
 		mr.rt.SessionStorage = storage.NewFS("_storage/sessions", 0766)
 		mr.rt.LogStorage = storage.NewFS("_storage/logs", 0766)
 	}
@@ -155,12 +156,16 @@ func (mr *Mailroom) Start() error {
 		log.Info(mr.rt.LogStorage.Name() + " log storage ok")
 	}
 
-	// initialize our elastic client
-	mr.rt.ES, err = newElasticClient(c.Elastic, c.ElasticUsername, c.ElasticPassword)
-	if err != nil {
-		log.Error("elastic search not available", "error", err)
+	// initialize our elastic client if that's where we search contacts
+	if c.SearchBackend != search.BackendPostgres {
+		mr.rt.ES, err = newElasticClient(c.Elastic, c.ElasticUsername, c.ElasticPassword)
+		if err != nil {
+			log.Error("elastic search not available", "error", err)
+		} else {
+			log.Info("elastic ok")
+		}
 	} else {
-		log.Info("elastic ok")
+		log.Info("searching contacts with " + c.SearchBackend)
 	}
 
 	// warn if we won't be doing FCM syncing
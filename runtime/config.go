@@ -15,6 +15,7 @@ import (
 
 func init() {
 	utils.RegisterValidatorAlias("session_storage", "eq=db|eq=s3", func(e validator.FieldError) string { return "is not a valid session storage mode" })
+	utils.RegisterValidatorAlias("search_backend", "eq=elastic|eq=postgres", func(e validator.FieldError) string { return "is not a valid search backend" })
 }
 
 // Config is our top level configuration object
@@ -47,12 +48,17 @@ type Config struct {
 	MaxStepsPerSprint    int    `help:"the maximum number of steps allowed per engine sprint"`
 	MaxResumesPerSession int    `help:"the maximum number of resumes allowed per engine session"`
 	MaxValueLength       int    `help:"the maximum size in characters for contact field values and run result values"`
+	MaxFieldHistory      int    `help:"the maximum number of changes kept in the history of each contact field, 0 for no history"`
 	SessionStorage       string `validate:"omitempty,session_storage"         help:"where to store session output (s3|db)"`
+	ImportSourceMaxBytes int    `help:"the maximum size of bytes of a file fetched from a contact import source URL"`
 
-	Elastic              string `validate:"url" help:"the URL of your ElasticSearch instance"`
+	SearchBackend        string `validate:"omitempty,search_backend" help:"which backend to use for contact searches (elastic|postgres)"`
+	Elastic              string `validate:"omitempty,url" help:"the URL of your ElasticSearch instance, required unless searching with postgres"`
 	ElasticUsername      string `help:"the username for ElasticSearch if using basic auth"`
 	ElasticPassword      string `help:"the password for ElasticSearch if using basic auth"`
 	ElasticContactsIndex string `help:"the name of index alias for contacts"`
+	SearchMaxQueryCost   int    `help:"the maximum estimated cost of a contact search query, 0 for no limit"`
+	SearchSlowThreshold  int    `help:"the time in milliseconds after which a contact search is reported as slow"`
 
 	S3Endpoint          string `help:"the S3 endpoint we will write attachments to"`
 	S3Region            string `help:"the S3 region we will write attachments to"`
@@ -108,12 +114,17 @@ func NewDefaultConfig() *Config {
 		MaxStepsPerSprint:    200,
 		MaxResumesPerSession: 250,
 		MaxValueLength:       640,
+		MaxFieldHistory:      0,
 		SessionStorage:       "db",
+		ImportSourceMaxBytes: 50 * 1024 * 1024, // 50MB
 
+		SearchBackend:        "elastic",
 		Elastic:              "http://localhost:9200",
 		ElasticUsername:      "",
 		ElasticPassword:      "",
 		ElasticContactsIndex: "contacts",
+		SearchMaxQueryCost:   0,
+		SearchSlowThreshold:  5000,
 
 		S3Endpoint:          "https://s3.amazonaws.com",
 		S3Region:            "us-east-1",
@@ -141,6 +152,11 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// any backend other than postgres defaults to searching with elastic
+	if c.SearchBackend != "postgres" && c.Elastic == "" {
+		return errors.New("field 'Elastic' is required when 'SearchBackend' is not postgres")
+	}
+
 	if _, _, err := c.ParseDisallowedNetworks(); err != nil {
 		return errors.Wrap(err, "unable to parse 'DisallowedNetworks'")
 	}
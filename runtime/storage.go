@@ -0,0 +1,80 @@
+package runtime
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/nyaruka/gocommon/storage"
+	"github.com/pkg/errors"
+)
+
+// DeletableStorage is a storage which also supports deleting files by the URLs returned when they were stored
+type DeletableStorage interface {
+	storage.Storage
+
+	// DeleteURL deletes the file stored at the given URL
+	DeleteURL(ctx context.Context, url string) error
+}
+
+type s3Deleter interface {
+	DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+}
+
+type deletableS3 struct {
+	storage.Storage
+	client s3Deleter
+	bucket string
+}
+
+// NewDeletableS3 creates a new S3 storage which supports deleting, provided the client does
+func NewDeletableS3(client storage.S3Client, bucket, region, acl string, workersPerBatch int) storage.Storage {
+	s := storage.NewS3(client, bucket, region, acl, workersPerBatch)
+
+	deleter, ok := client.(s3Deleter)
+	if !ok {
+		return s
+	}
+	return &deletableS3{Storage: s, client: deleter, bucket: bucket}
+}
+
+func (s *deletableS3) DeleteURL(ctx context.Context, u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return errors.Wrapf(err, "invalid S3 object URL: %s", u)
+	}
+
+	_, err = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(strings.TrimPrefix(parsed.Path, "/")),
+	})
+	return errors.Wrapf(err, "error deleting S3 object")
+}
+
+type deletableFS struct {
+	storage.Storage
+	directory string
+}
+
+// NewDeletableFS creates a new file system storage which supports deleting
+func NewDeletableFS(directory string, perms os.FileMode) storage.Storage {
+	return &deletableFS{Storage: storage.NewFS(directory, perms), directory: directory}
+}
+
+func (s *deletableFS) DeleteURL(ctx context.Context, u string) error {
+	// file system storage URLs are the full paths of files, which should be inside our directory
+	path := filepath.Clean(u)
+	if !strings.HasPrefix(path, filepath.Clean(s.directory)+string(filepath.Separator)) {
+		return errors.Errorf("path %s is outside of storage directory", u)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error deleting file")
+	}
+	return nil
+}
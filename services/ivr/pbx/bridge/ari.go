@@ -0,0 +1,243 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+// Event is an event received from the ARI events websocket, only the fields we use are included
+type Event struct {
+	Type      string     `json:"type"`
+	Args      []string   `json:"args,omitempty"`
+	Channel   *Channel   `json:"channel,omitempty"`
+	Playback  *Playback  `json:"playback,omitempty"`
+	Recording *Recording `json:"recording,omitempty"`
+	Digit     string     `json:"digit,omitempty"`
+	Cause     int        `json:"cause,omitempty"`
+}
+
+// Channel is an ARI channel
+type Channel struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Caller struct {
+		Number string `json:"number"`
+	} `json:"caller"`
+	Dialplan struct {
+		Exten string `json:"exten"`
+	} `json:"dialplan"`
+}
+
+// Playback is an ARI playback
+type Playback struct {
+	ID        string `json:"id"`
+	TargetURI string `json:"target_uri"`
+}
+
+// Recording is an ARI live recording
+type Recording struct {
+	Name      string `json:"name"`
+	TargetURI string `json:"target_uri"`
+	Duration  int    `json:"duration"`
+	Cause     string `json:"cause"`
+}
+
+// hangup causes as reported on ChannelDestroyed events, see https://wiki.asterisk.org/wiki/display/AST/Hangup+Cause+Mappings
+const (
+	causeUserBusy       = 17
+	causeNoUserResponse = 18
+	causeNoAnswer       = 19
+	causeCallRejected   = 21
+)
+
+// ariClient is a minimal client for the Asterisk REST Interface
+type ariClient struct {
+	httpClient *http.Client
+	baseURL    string
+	username   string
+	password   string
+	app        string
+}
+
+func newARIClient(httpClient *http.Client, baseURL, username, password, app string) *ariClient {
+	return &ariClient{
+		httpClient: httpClient,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		username:   username,
+		password:   password,
+		app:        app,
+	}
+}
+
+// Originate creates a new outgoing channel with the given id which will enter our Stasis app when answered
+func (c *ariClient) Originate(ctx context.Context, channelID, endpoint, callerID string, timeout int, appArgs string) error {
+	params := url.Values{"endpoint": {endpoint}, "app": {c.app}}
+	if callerID != "" {
+		params.Set("callerId", callerID)
+	}
+	if timeout > 0 {
+		params.Set("timeout", strconv.Itoa(timeout))
+	}
+	if appArgs != "" {
+		params.Set("appArgs", appArgs)
+	}
+	return c.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID), params)
+}
+
+// Answer answers the given channel
+func (c *ariClient) Answer(ctx context.Context, channelID string) error {
+	return c.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/answer", nil)
+}
+
+// Hangup hangs up the given channel with the given reason, e.g. normal or busy
+func (c *ariClient) Hangup(ctx context.Context, channelID, reason string) error {
+	return c.do(ctx, http.MethodDelete, "/channels/"+url.PathEscape(channelID), url.Values{"reason": {reason}})
+}
+
+// Play starts playing the given media URI, e.g. sound:https://example.com/hello.wav, on the given channel
+func (c *ariClient) Play(ctx context.Context, channelID, playbackID, media string) error {
+	path := fmt.Sprintf("/channels/%s/play/%s", url.PathEscape(channelID), url.PathEscape(playbackID))
+	return c.do(ctx, http.MethodPost, path, url.Values{"media": {media}})
+}
+
+// Record starts recording the given channel to a stored recording with the given name
+func (c *ariClient) Record(ctx context.Context, channelID, name string, maxDuration int, terminateOn string) error {
+	params := url.Values{
+		"name":               {name},
+		"format":             {"wav"},
+		"maxDurationSeconds": {strconv.Itoa(maxDuration)},
+		"ifExists":           {"overwrite"},
+		"beep":               {"true"},
+	}
+	if terminateOn != "" {
+		params.Set("terminateOn", terminateOn)
+	}
+	return c.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/record", params)
+}
+
+// CreateBridge creates a new mixing bridge with the given id
+func (c *ariClient) CreateBridge(ctx context.Context, bridgeID string) error {
+	return c.do(ctx, http.MethodPost, "/bridges/"+url.PathEscape(bridgeID), url.Values{"type": {"mixing"}})
+}
+
+// AddChannels adds the given channels to a bridge
+func (c *ariClient) AddChannels(ctx context.Context, bridgeID string, channelIDs ...string) error {
+	params := url.Values{"channel": {strings.Join(channelIDs, ",")}}
+	return c.do(ctx, http.MethodPost, "/bridges/"+url.PathEscape(bridgeID)+"/addChannel", params)
+}
+
+// DestroyBridge destroys the given bridge
+func (c *ariClient) DestroyBridge(ctx context.Context, bridgeID string) error {
+	return c.do(ctx, http.MethodDelete, "/bridges/"+url.PathEscape(bridgeID), nil)
+}
+
+// RecordingFile fetches the audio of the stored recording with the given name, caller must close the response body
+func (c *ariClient) RecordingFile(ctx context.Context, name string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/recordings/stored/"+url.PathEscape(name)+"/file", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(req)
+}
+
+// Connect opens the events websocket for our Stasis app
+func (c *ariClient) Connect() (*websocket.Conn, error) {
+	eventsURL, err := url.Parse(c.baseURL + "/events")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ARI URL")
+	}
+
+	origin := *eventsURL
+	if eventsURL.Scheme == "https" {
+		eventsURL.Scheme = "wss"
+	} else {
+		eventsURL.Scheme = "ws"
+	}
+	eventsURL.RawQuery = url.Values{"app": {c.app}}.Encode()
+
+	config, err := websocket.NewConfig(eventsURL.String(), origin.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating websocket config")
+	}
+	config.Header.Set("Authorization", "Basic "+httpx.BasicAuth(c.username, c.password))
+
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to ARI events")
+	}
+	return conn, nil
+}
+
+func (c *ariClient) newRequest(ctx context.Context, method, path string, params url.Values) (*http.Request, error) {
+	reqURL := c.baseURL + path
+	if len(params) > 0 {
+		reqURL += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	return req, nil
+}
+
+func (c *ariClient) do(ctx context.Context, method, path string, params url.Values) error {
+	req, err := c.newRequest(ctx, method, path, params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error making ARI request %s %s", method, path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &ariError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+type ariError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *ariError) Error() string {
+	return fmt.Sprintf("ARI request %s %s returned %d: %s", e.Method, e.Path, e.StatusCode, e.Body)
+}
+
+// channelID returns the id of the channel this event relates to, if any
+func (e *Event) channelID() string {
+	switch {
+	case e.Channel != nil:
+		return e.Channel.ID
+	case e.Playback != nil:
+		return strings.TrimPrefix(e.Playback.TargetURI, "channel:")
+	case e.Recording != nil:
+		return strings.TrimPrefix(e.Recording.TargetURI, "channel:")
+	}
+	return ""
+}
+
+func isNotFound(err error) bool {
+	ae, ok := errors.Cause(err).(*ariError)
+	return ok && ae.StatusCode == http.StatusNotFound
+}
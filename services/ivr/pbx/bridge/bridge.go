@@ -0,0 +1,363 @@
+// Package bridge implements the PBX IVR bridge protocol on top of the Asterisk REST Interface (ARI). It exposes the
+// small REST API that the PBX IVR service uses to originate and hang up calls, and drives those calls (and incoming
+// ones) by posting callbacks to mailroom and executing the commands it returns. See ../README.md for the protocol.
+package bridge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/mailroom/services/ivr/pbx"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+// Config is the configuration of a bridge
+type Config struct {
+	Address   string `help:"the address the bridge API listens on"`
+	PublicURL string `help:"the public URL of the bridge API, used for recording URLs"`
+	Username  string `help:"the username mailroom uses to authenticate with the bridge API"`
+	Password  string `help:"the password mailroom uses to authenticate with the bridge API"`
+	Secret    string `help:"the secret used to sign callbacks to mailroom"`
+
+	IncomingURL string `help:"the mailroom URL incoming calls are posted to, e.g. https://mailroom/mr/ivr/c/<channel-uuid>/incoming"`
+	StatusURL   string `help:"the mailroom URL status updates for incoming calls are posted to, e.g. https://mailroom/mr/ivr/c/<channel-uuid>/status"`
+
+	ARIBaseURL  string `help:"the base URL of the Asterisk REST Interface"`
+	ARIUsername string `help:"the username for the Asterisk REST Interface"`
+	ARIPassword string `help:"the password for the Asterisk REST Interface"`
+	ARIApp      string `help:"the name of the Stasis application calls are routed to"`
+
+	TTSTemplate string `help:"URL template for spoken text with {text} and {language} placeholders, played as remote media"`
+
+	LogLevel string `help:"the logging level to use"`
+}
+
+// NewDefaultConfig returns a new default configuration
+func NewDefaultConfig() *Config {
+	return &Config{
+		Address:     ":8090",
+		PublicURL:   "http://localhost:8090",
+		ARIBaseURL:  "http://localhost:8088/ari",
+		ARIUsername: "mailroom",
+		ARIApp:      "mailroom",
+		LogLevel:    "warn",
+	}
+}
+
+const (
+	callbackTimeout  = 15 * time.Second
+	reconnectBackoff = 5 * time.Second
+)
+
+// Bridge relays calls between mailroom and an Asterisk server
+type Bridge struct {
+	config     *Config
+	ari        *ariClient
+	httpClient *http.Client
+	httpServer *http.Server
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	calls map[string]*call // by channel id, includes dial legs
+	conn  *websocket.Conn
+}
+
+// NewBridge creates a new bridge, it will need to be started after being created
+func NewBridge(config *Config) *Bridge {
+	httpClient := &http.Client{Timeout: callbackTimeout}
+
+	b := &Bridge{
+		config:     config,
+		ari:        newARIClient(httpClient, config.ARIBaseURL, config.ARIUsername, config.ARIPassword, config.ARIApp),
+		httpClient: httpClient,
+		calls:      make(map[string]*call),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	router := chi.NewRouter()
+	router.Use(b.requireAuth)
+	router.Post("/calls", b.handleCreateCall)
+	router.Delete("/calls/{id}", b.handleHangupCall)
+	router.Get("/recordings/{name}", b.handleRecording)
+
+	b.httpServer = &http.Server{
+		Addr:         config.Address,
+		Handler:      router,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 60 * time.Second,
+	}
+	return b
+}
+
+// Start connects to ARI and starts our HTTP server
+func (b *Bridge) Start() error {
+	conn, err := b.ari.Connect()
+	if err != nil {
+		return err
+	}
+
+	b.wg.Add(2)
+
+	go func() {
+		defer b.wg.Done()
+		b.readEvents(conn)
+	}()
+
+	go func() {
+		defer b.wg.Done()
+
+		if err := b.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("error listening", "comp", "bridge", "error", err)
+		}
+	}()
+
+	slog.Info("bridge started", "comp", "bridge", "address", b.config.Address, "ari", b.config.ARIBaseURL)
+	return nil
+}
+
+// Stop stops the bridge, calls in progress are left to Asterisk
+func (b *Bridge) Stop() {
+	b.cancel()
+	b.httpServer.Shutdown(context.Background())
+
+	b.mu.Lock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	slog.Info("bridge stopped", "comp", "bridge")
+}
+
+// readEvents reads events from ARI until we're stopped, reconnecting if the connection is lost
+func (b *Bridge) readEvents(conn *websocket.Conn) {
+	log := slog.With("comp", "bridge")
+
+	for {
+		b.mu.Lock()
+		b.conn = conn
+		b.mu.Unlock()
+
+		for {
+			evt := &Event{}
+			if err := websocket.JSON.Receive(conn, evt); err != nil {
+				if b.ctx.Err() == nil {
+					log.Error("error reading ARI event", "error", err)
+				}
+				break
+			}
+			b.handleEvent(evt)
+		}
+		conn.Close()
+
+		for {
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-time.After(reconnectBackoff):
+			}
+
+			var err error
+			if conn, err = b.ari.Connect(); err == nil {
+				log.Info("reconnected to ARI")
+				break
+			}
+			log.Error("error reconnecting to ARI", "error", err)
+		}
+	}
+}
+
+// handleEvent routes an ARI event to the call it belongs to
+func (b *Bridge) handleEvent(evt *Event) {
+	b.mu.Lock()
+	c := b.calls[evt.channelID()]
+	b.mu.Unlock()
+
+	if c == nil {
+		// a channel we don't know entering our app is an incoming call
+		if evt.Type == "StasisStart" && evt.Channel != nil && len(evt.Args) == 0 {
+			go b.startIncoming(evt.Channel)
+		}
+		return
+	}
+
+	c.deliver(evt)
+}
+
+// startIncoming answers a new incoming channel and hands it to mailroom
+func (b *Bridge) startIncoming(ch *Channel) {
+	log := slog.With("comp", "bridge", "channel_id", ch.ID)
+
+	if b.config.IncomingURL == "" {
+		log.Warn("rejecting incoming call as no incoming URL is configured")
+		b.ari.Hangup(b.ctx, ch.ID, "busy")
+		return
+	}
+
+	c := newCall(b, ch.ID, "inbound", ch.Caller.Number, ch.Dialplan.Exten, b.config.IncomingURL, b.config.StatusURL)
+	b.register(c.id, c)
+
+	if err := b.ari.Answer(b.ctx, ch.ID); err != nil {
+		log.Error("error answering incoming call", "error", err)
+		b.ari.Hangup(b.ctx, ch.ID, "normal")
+		return
+	}
+
+	c.answered(time.Now())
+	c.start()
+}
+
+func (b *Bridge) register(channelID string, c *call) {
+	b.mu.Lock()
+	b.calls[channelID] = c
+	b.mu.Unlock()
+}
+
+func (b *Bridge) unregister(channelID string) {
+	b.mu.Lock()
+	delete(b.calls, channelID)
+	b.mu.Unlock()
+}
+
+func (b *Bridge) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+
+		if subtle.ConstantTimeCompare([]byte(username), []byte(b.config.Username)) != 1 || subtle.ConstantTimeCompare([]byte(password), []byte(b.config.Password)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "invalid credentials"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (b *Bridge) handleCreateCall(w http.ResponseWriter, r *http.Request) {
+	callR := &pbx.CallRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(callR); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid request body"})
+		return
+	}
+	if callR.Endpoint == "" || callR.CallbackURL == "" || callR.StatusURL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "endpoint, callback_url and status_url are required"})
+		return
+	}
+
+	// register the call before originating it so that we don't miss any events
+	c := newCall(b, string(uuids.New()), "outbound", callR.CallerID, callR.Number, callR.CallbackURL, callR.StatusURL)
+	b.register(c.id, c)
+
+	if err := b.ari.Originate(r.Context(), c.id, callR.Endpoint, callR.CallerID, 0, ""); err != nil {
+		b.unregister(c.id)
+
+		slog.Error("error originating call", "comp", "bridge", "endpoint", callR.Endpoint, "error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"message": "error originating call"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &pbx.CallResponse{ID: c.id, State: "down"})
+}
+
+func (b *Bridge) handleHangupCall(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	b.mu.Lock()
+	c := b.calls[id]
+	b.mu.Unlock()
+
+	if c == nil || c.id != id {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "no such call"})
+		return
+	}
+
+	if err := b.ari.Hangup(r.Context(), id, "normal"); err != nil && !isNotFound(err) {
+		slog.Error("error hanging up call", "comp", "bridge", "call_id", id, "error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"message": "error hanging up call"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Bridge) handleRecording(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	resp, err := b.ari.RecordingFile(r.Context(), name)
+	if err != nil {
+		slog.Error("error fetching recording", "comp", "bridge", "name", name, "error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"message": "error fetching recording"})
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "no such recording"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, resp.Body)
+}
+
+// recordingURL is the URL mailroom can fetch the recording with the given name from
+func (b *Bridge) recordingURL(name string) string {
+	return strings.TrimRight(b.config.PublicURL, "/") + "/recordings/" + name
+}
+
+// post sends a signed callback to mailroom, returning the commands in its response
+func (b *Bridge) post(ctx context.Context, callbackURL string, cb *pbx.Callback) ([]*command, error) {
+	body, err := json.Marshal(cb)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid callback URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-PBX-Signature", pbx.CalculateSignature(callbackURL, body, b.config.Secret))
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error posting callback")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading callback response")
+	}
+
+	cmdResp := &commandResponse{}
+	if len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, cmdResp); err != nil {
+			return nil, errors.Errorf("invalid callback response (status %d)", resp.StatusCode)
+		}
+	}
+	if resp.StatusCode/100 != 2 {
+		return cmdResp.Commands, errors.Errorf("received status %d for callback: %s", resp.StatusCode, cmdResp.Error)
+	}
+
+	return cmdResp.Commands, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,266 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/services/ivr/pbx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// mockARI mocks the parts of the Asterisk REST Interface we use, generating the events Asterisk would
+type mockARI struct {
+	t        *testing.T
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	requests []string
+}
+
+func (m *mockARI) send(evt string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	require.NotNil(m.t, m.conn, "no websocket connection")
+	require.NoError(m.t, websocket.Message.Send(m.conn, evt))
+}
+
+func (m *mockARI) Requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.requests...)
+}
+
+func (m *mockARI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, _ := r.BasicAuth(); user != "asterisk" || pass != "ari123" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/ari/events" {
+		websocket.Handler(func(conn *websocket.Conn) {
+			m.mu.Lock()
+			m.conn = conn
+			m.mu.Unlock()
+
+			io.Copy(io.Discard, conn) // block until closed
+		}).ServeHTTP(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	m.requests = append(m.requests, r.Method+" "+r.URL.Path+" "+r.URL.RawQuery)
+	m.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/ari/"), "/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[0] == "channels": // originate
+		id := parts[1]
+		if strings.HasPrefix(query.Get("appArgs"), "dial,") {
+			m.send(fmt.Sprintf(`{"type": "StasisStart", "args": ["dial", "x"], "channel": {"id": "%s"}}`, id))
+		} else if query.Get("endpoint") == "PJSIP/+16055741111@trunk" {
+			m.send(fmt.Sprintf(`{"type": "ChannelStateChange", "channel": {"id": "%s", "state": "Ringing"}}`, id))
+			m.send(fmt.Sprintf(`{"type": "StasisStart", "args": [], "channel": {"id": "%s", "state": "Up"}}`, id))
+		} else {
+			m.send(fmt.Sprintf(`{"type": "ChannelDestroyed", "cause": 17, "channel": {"id": "%s"}}`, id))
+		}
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[2] == "play":
+		m.send(fmt.Sprintf(`{"type": "PlaybackFinished", "playback": {"id": "%s", "target_uri": "channel:%s"}}`, parts[3], parts[1]))
+		if strings.HasSuffix(query.Get("media"), "menu.wav") {
+			for _, d := range []string{"1", "2", "#"} {
+				m.send(fmt.Sprintf(`{"type": "ChannelDtmfReceived", "digit": "%s", "channel": {"id": "%s"}}`, d, parts[1]))
+			}
+		}
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "record":
+		m.send(fmt.Sprintf(`{"type": "RecordingFinished", "recording": {"name": "%s", "target_uri": "channel:%s", "duration": 3}}`, query.Get("name"), parts[1]))
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "channels":
+		m.send(fmt.Sprintf(`{"type": "ChannelDestroyed", "cause": 16, "channel": {"id": "%s"}}`, parts[1]))
+	case r.Method == http.MethodGet && parts[0] == "recordings":
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write([]byte("RIFF...."))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mockMailroom mocks mailroom's IVR handlers, responding to each callback based on its query
+type mockMailroom struct {
+	t         *testing.T
+	callbacks chan string
+}
+
+func (m *mockMailroom) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	expected := pbx.CalculateSignature("http://"+r.Host+r.URL.RequestURI(), body, "sssh")
+	assert.Equal(m.t, expected, r.Header.Get("X-PBX-Signature"))
+
+	cb := &pbx.Callback{}
+	require.NoError(m.t, json.Unmarshal(body, cb))
+
+	m.callbacks <- r.URL.RequestURI() + " " + string(body)
+
+	var commands []any
+	switch r.URL.Query().Get("step") {
+	case "start":
+		commands = []any{
+			pbx.Play{Action: "play", URL: "https://example.com/menu.wav"},
+			pbx.Gather{Action: "gather", MaxDigits: 3, Terminator: "#", Timeout: 5, CallbackURL: "http://" + r.Host + "/handle?step=gather"},
+		}
+	case "gather":
+		commands = []any{
+			pbx.Say{Action: "say", Text: "Leave a message", Language: "en-US"},
+			pbx.Record{Action: "record", MaxLength: 60, Terminator: "#", CallbackURL: "http://" + r.Host + "/handle?step=record"},
+		}
+	case "record":
+		commands = []any{
+			pbx.Dial{Action: "dial", Endpoint: "PJSIP/+12065552222@trunk", DialTimeout: 30, TimeLimit: 1, CallbackURL: "http://" + r.Host + "/handle?step=dial"},
+		}
+	case "dial":
+		commands = []any{pbx.Hangup{Action: "hangup"}}
+	case "incoming":
+		commands = []any{pbx.Reject{Action: "reject"}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&pbx.Response{Commands: commands})
+}
+
+func TestBridge(t *testing.T) {
+	ari := &mockARI{t: t}
+	ariServer := httptest.NewServer(ari)
+	defer ariServer.Close()
+
+	mr := &mockMailroom{t: t, callbacks: make(chan string, 20)}
+	mrServer := httptest.NewServer(mr)
+	defer mrServer.Close()
+
+	config := NewDefaultConfig()
+	config.Address = "localhost:0"
+	config.PublicURL = "https://bridge.example.com"
+	config.Username = "mailroom"
+	config.Password = "sesame"
+	config.Secret = "sssh"
+	config.IncomingURL = mrServer.URL + "/incoming?step=incoming"
+	config.StatusURL = mrServer.URL + "/status"
+	config.ARIBaseURL = ariServer.URL + "/ari"
+	config.ARIUsername = "asterisk"
+	config.ARIPassword = "ari123"
+	config.TTSTemplate = "https://tts.example.com/speak?lang={language}&text={text}"
+
+	b := NewBridge(config)
+	require.NoError(t, b.Start())
+	defer b.Stop()
+
+	api := httptest.NewServer(b.httpServer.Handler)
+	defer api.Close()
+
+	apiRequest := func(method, path, body string, auth bool) (int, string) {
+		req, _ := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		if auth {
+			req.SetBasicAuth("mailroom", "sesame")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBody)
+	}
+
+	nextCallback := func() string {
+		select {
+		case cb := <-mr.callbacks:
+			return cb
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for callback")
+			return ""
+		}
+	}
+
+	// wait for the events websocket to be connected
+	require.Eventually(t, func() bool { ari.mu.Lock(); defer ari.mu.Unlock(); return ari.conn != nil }, time.Second, 10*time.Millisecond)
+
+	// API requires auth
+	status, _ := apiRequest(http.MethodPost, "/calls", `{}`, false)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// and valid call requests
+	status, _ = apiRequest(http.MethodPost, "/calls", `{"number": "+16055741111"}`, true)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = apiRequest(http.MethodDelete, "/calls/123", ``, true)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// start an outgoing call that is answered
+	status, body := apiRequest(http.MethodPost, "/calls", fmt.Sprintf(`{"number": "+16055741111", "endpoint": "PJSIP/+16055741111@trunk", "caller_id": "+12065551212", "callback_url": "%s/handle?step=start", "status_url": "%s/status"}`, mrServer.URL, mrServer.URL), true)
+	require.Equal(t, http.StatusCreated, status)
+
+	callR := &pbx.CallResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), callR))
+	callID := callR.ID
+
+	cbPrefix := fmt.Sprintf(`{"call_id":"%s","direction":"outbound","from":"+12065551212","to":"+16055741111"`, callID)
+
+	// ringing status and start callback can arrive in any order
+	callbacks := []string{nextCallback(), nextCallback()}
+	assert.ElementsMatch(t, []string{
+		`/status ` + cbPrefix + `,"status":"ringing","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`,
+		`/handle?step=start ` + cbPrefix + `,"status":"in-progress","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`,
+	}, callbacks)
+
+	assert.Equal(t, `/handle?step=gather `+cbPrefix+`,"status":"in-progress","duration":0,"answered_by":"","digits":"12","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`, nextCallback())
+	assert.Equal(t, `/handle?step=record `+cbPrefix+`,"status":"in-progress","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"https://bridge.example.com/recordings/`+callID+`-rec-3","dial_status":"","dial_duration":0}`, nextCallback())
+	assert.Equal(t, `/handle?step=dial `+cbPrefix+`,"status":"in-progress","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"answered","dial_duration":1}`, nextCallback())
+	assert.Equal(t, `/status `+cbPrefix+`,"status":"completed","duration":1,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`, nextCallback())
+
+	assert.Equal(t, []string{
+		`POST /ari/channels/` + callID + ` app=mailroom&callerId=%2B12065551212&endpoint=PJSIP%2F%2B16055741111%40trunk`,
+		`POST /ari/channels/` + callID + `/play/` + callID + `-play-1 media=sound%3Ahttps%3A%2F%2Fexample.com%2Fmenu.wav`,
+		`POST /ari/channels/` + callID + `/play/` + callID + `-play-2 media=sound%3Ahttps%3A%2F%2Ftts.example.com%2Fspeak%3Flang%3Den-US%26text%3DLeave%2Ba%2Bmessage`,
+		`POST /ari/channels/` + callID + `/record beep=true&format=wav&ifExists=overwrite&maxDurationSeconds=60&name=` + callID + `-rec-3&terminateOn=%23`,
+		`POST /ari/channels/` + callID + `-dial-4 app=mailroom&appArgs=dial%2C` + callID + `&callerId=%2B12065551212&endpoint=PJSIP%2F%2B12065552222%40trunk&timeout=30`,
+		`POST /ari/bridges/` + callID + `-dial-4 type=mixing`,
+		`POST /ari/bridges/` + callID + `-dial-4/addChannel channel=` + callID + `%2C` + callID + `-dial-4`,
+		`DELETE /ari/channels/` + callID + `-dial-4 reason=normal`,
+		`DELETE /ari/bridges/` + callID + `-dial-4 `,
+		`DELETE /ari/channels/` + callID + ` reason=normal`,
+	}, ari.Requests())
+
+	// recordings can be fetched through the bridge
+	status, body = apiRequest(http.MethodGet, "/recordings/"+callID+"-rec-3", ``, true)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "RIFF....", body)
+
+	// start an outgoing call that is busy
+	status, body = apiRequest(http.MethodPost, "/calls", fmt.Sprintf(`{"number": "+16055742222", "endpoint": "PJSIP/+16055742222@trunk", "caller_id": "+12065551212", "callback_url": "%s/handle?step=start", "status_url": "%s/status"}`, mrServer.URL, mrServer.URL), true)
+	require.Equal(t, http.StatusCreated, status)
+	require.NoError(t, json.Unmarshal([]byte(body), callR))
+
+	assert.Equal(t, fmt.Sprintf(`/status {"call_id":"%s","direction":"outbound","from":"+12065551212","to":"+16055742222","status":"busy","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`, callR.ID), nextCallback())
+
+	// an incoming call is answered and posted to the incoming URL, which rejects it
+	ari.send(`{"type": "StasisStart", "args": [], "channel": {"id": "1532093456.99", "state": "Ring", "caller": {"number": "+16055743333"}, "dialplan": {"exten": "+12065551212"}}}`)
+
+	incomingPrefix := `{"call_id":"1532093456.99","direction":"inbound","from":"+16055743333","to":"+12065551212"`
+	assert.Equal(t, `/incoming?step=incoming `+incomingPrefix+`,"status":"in-progress","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`, nextCallback())
+	assert.Equal(t, `/status `+incomingPrefix+`,"status":"completed","duration":0,"answered_by":"","digits":"","timed_out":false,"recording_url":"","dial_status":"","dial_duration":0}`, nextCallback())
+
+	requests := ari.Requests()
+	assert.Equal(t, []string{
+		`POST /ari/channels/1532093456.99/answer `,
+		`DELETE /ari/channels/1532093456.99 reason=busy`,
+	}, requests[len(requests)-2:])
+}
@@ -0,0 +1,458 @@
+package bridge
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/mailroom/services/ivr/pbx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// extra time we give Asterisk to report a dial or recording outcome before we give up on it
+	eventGrace = 10 * time.Second
+
+	// max time we'll wait for a single playback to finish
+	maxPlayback = 10 * time.Minute
+)
+
+// command is any of the commands that can be returned by mailroom
+type command struct {
+	Action      string `json:"action"`
+	Text        string `json:"text"`
+	Language    string `json:"language"`
+	URL         string `json:"url"`
+	MaxDigits   int    `json:"max_digits"`
+	Terminator  string `json:"terminator"`
+	Timeout     int    `json:"timeout"`
+	MaxLength   int    `json:"max_length"`
+	Endpoint    string `json:"endpoint"`
+	DialTimeout int    `json:"dial_timeout"`
+	TimeLimit   int    `json:"time_limit"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type commandResponse struct {
+	Error    string     `json:"_error"`
+	Commands []*command `json:"commands"`
+}
+
+// call is the state of a call we're driving, identified by the id of its Asterisk channel
+type call struct {
+	b   *Bridge
+	log *slog.Logger
+
+	id          string
+	direction   string
+	from        string
+	to          string
+	callbackURL string
+	statusURL   string
+
+	events chan *Event
+	digits chan string
+	hungup chan struct{}
+
+	mu         sync.Mutex
+	answeredOn time.Time
+	seq        int
+	ended      bool
+}
+
+func newCall(b *Bridge, id, direction, from, to, callbackURL, statusURL string) *call {
+	return &call{
+		b:           b,
+		log:         slog.With("comp", "bridge", "call_id", id, "direction", direction),
+		id:          id,
+		direction:   direction,
+		from:        from,
+		to:          to,
+		callbackURL: callbackURL,
+		statusURL:   statusURL,
+		events:      make(chan *Event, 32),
+		digits:      make(chan string, 64),
+		hungup:      make(chan struct{}),
+	}
+}
+
+// deliver hands an ARI event to this call, it's called from the event loop so must never block
+func (c *call) deliver(evt *Event) {
+	ownChannel := evt.Channel != nil && evt.Channel.ID == c.id
+
+	switch {
+	case evt.Type == "StasisStart" && ownChannel:
+		if c.direction == "outbound" {
+			c.answered(time.Now())
+			c.start()
+		}
+		return
+
+	case evt.Type == "ChannelStateChange" && ownChannel:
+		if evt.Channel.State == "Ringing" && c.direction == "outbound" {
+			go c.postStatus("ringing", 0)
+		}
+		return
+
+	case evt.Type == "ChannelDestroyed" && ownChannel:
+		c.destroyed(evt.Cause)
+		return
+
+	case evt.Type == "ChannelDtmfReceived" && ownChannel:
+		select {
+		case c.digits <- evt.Digit:
+		default:
+		}
+		return
+	}
+
+	// everything else (playbacks, recordings and dial legs) is for whichever command is executing
+	select {
+	case c.events <- evt:
+	default:
+		c.log.Warn("dropping event for busy call", "type", evt.Type)
+	}
+}
+
+func (c *call) answered(t time.Time) {
+	c.mu.Lock()
+	c.answeredOn = t
+	c.mu.Unlock()
+}
+
+// destroyed is called when our channel is gone, and sends the final status of the call to mailroom
+func (c *call) destroyed(cause int) {
+	c.mu.Lock()
+	if c.ended {
+		c.mu.Unlock()
+		return
+	}
+	c.ended = true
+	answeredOn := c.answeredOn
+	c.mu.Unlock()
+
+	close(c.hungup)
+	c.b.unregister(c.id)
+
+	if !answeredOn.IsZero() {
+		go c.postStatus("completed", int(time.Since(answeredOn)/time.Second))
+	} else {
+		go c.postStatus(callStatusForCause(cause), 0)
+	}
+}
+
+// start starts posting callbacks to mailroom and executing the returned commands
+func (c *call) start() {
+	go func() {
+		if err := c.run(); err != nil {
+			c.log.Error("error running call", "error", err)
+			c.hangup("normal")
+		}
+	}()
+}
+
+func (c *call) run() error {
+	callbackURL, cb := c.callbackURL, c.newCallback()
+
+	for callbackURL != "" {
+		cmds, err := c.b.post(c.b.ctx, callbackURL, cb)
+		if err != nil {
+			// an error response can still have commands, e.g. to say an error message and hang up
+			if len(cmds) == 0 {
+				return err
+			}
+			c.log.Warn("error response from mailroom", "error", err)
+		}
+
+		if callbackURL, cb, err = c.execute(cmds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute executes the given commands, returning the callback for the command we waited on, if any
+func (c *call) execute(cmds []*command) (string, *pbx.Callback, error) {
+	for _, cmd := range cmds {
+		if c.isHungup() {
+			return "", nil, nil
+		}
+
+		switch cmd.Action {
+		case "say":
+			if c.b.config.TTSTemplate == "" {
+				c.log.Warn("ignoring say command as no TTS URL is configured", "text", cmd.Text)
+				continue
+			}
+			if err := c.play("sound:" + c.ttsURL(cmd.Text, cmd.Language)); err != nil {
+				return "", nil, err
+			}
+
+		case "play":
+			if err := c.play("sound:" + cmd.URL); err != nil {
+				return "", nil, err
+			}
+
+		case "gather":
+			digits, timedOut := c.gather(cmd.MaxDigits, cmd.Terminator, cmd.Timeout)
+
+			cb := c.newCallback()
+			cb.Digits, cb.TimedOut = digits, timedOut
+			return cmd.CallbackURL, cb, nil
+
+		case "record":
+			recordingURL, err := c.record(cmd.MaxLength, cmd.Terminator)
+			if err != nil {
+				return "", nil, err
+			}
+
+			cb := c.newCallback()
+			cb.RecordingURL = recordingURL
+			return cmd.CallbackURL, cb, nil
+
+		case "dial":
+			status, duration, err := c.dial(cmd.Endpoint, cmd.DialTimeout, cmd.TimeLimit)
+			if err != nil {
+				return "", nil, err
+			}
+
+			cb := c.newCallback()
+			cb.DialStatus, cb.DialDuration = status, duration
+			return cmd.CallbackURL, cb, nil
+
+		case "hangup":
+			c.hangup("normal")
+			return "", nil, nil
+
+		case "reject":
+			c.hangup("busy")
+			return "", nil, nil
+
+		default:
+			c.log.Warn("ignoring unknown command", "action", cmd.Action)
+		}
+	}
+
+	// nothing left to wait for so the call is over
+	c.hangup("normal")
+	return "", nil, nil
+}
+
+// play plays the given media and waits for it to finish
+func (c *call) play(media string) error {
+	playbackID := c.nextID("play")
+
+	if err := c.b.ari.Play(c.b.ctx, c.id, playbackID, media); err != nil {
+		if isNotFound(err) {
+			return nil // channel is gone
+		}
+		return errors.Wrap(err, "error starting playback")
+	}
+
+	c.waitFor(func(e *Event) bool {
+		return e.Type == "PlaybackFinished" && e.Playback != nil && e.Playback.ID == playbackID
+	}, maxPlayback)
+	return nil
+}
+
+// gather collects digits until we get the terminator, reach max digits, or timeout seconds pass without a digit
+func (c *call) gather(maxDigits int, terminator string, timeout int) (string, bool) {
+	digits := &strings.Builder{}
+
+	for {
+		select {
+		case <-c.hungup:
+			return digits.String(), false
+
+		case d := <-c.digits:
+			if d == terminator {
+				return digits.String(), false
+			}
+			digits.WriteString(d)
+			if maxDigits > 0 && digits.Len() >= maxDigits {
+				return digits.String(), false
+			}
+
+		case <-time.After(time.Duration(timeout) * time.Second):
+			return digits.String(), digits.Len() == 0
+		}
+	}
+}
+
+// record records the caller until they press the terminator or hang up, returning the URL of the recording
+func (c *call) record(maxLength int, terminator string) (string, error) {
+	name := c.nextID("rec")
+
+	if err := c.b.ari.Record(c.b.ctx, c.id, name, maxLength, terminator); err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "error starting recording")
+	}
+
+	evt := c.waitFor(func(e *Event) bool {
+		return (e.Type == "RecordingFinished" || e.Type == "RecordingFailed") && e.Recording != nil && e.Recording.Name == name
+	}, time.Duration(maxLength)*time.Second+eventGrace)
+
+	if evt == nil || evt.Type != "RecordingFinished" {
+		return "", nil
+	}
+	return c.b.recordingURL(name), nil
+}
+
+// dial calls the given endpoint and if it answers, bridges it with this call until either side hangs up
+func (c *call) dial(endpoint string, dialTimeout, timeLimit int) (string, int, error) {
+	legID := c.nextID("dial")
+
+	c.b.register(legID, c)
+	defer c.b.unregister(legID)
+
+	if err := c.b.ari.Originate(c.b.ctx, legID, endpoint, c.ourNumber(), dialTimeout, "dial,"+c.id); err != nil {
+		c.log.Error("error originating dial leg", "endpoint", endpoint, "error", err)
+		return "failed", 0, nil
+	}
+
+	// wait for the leg to answer (it enters our app) or fail
+	evt := c.waitFor(func(e *Event) bool {
+		return e.Channel != nil && e.Channel.ID == legID && (e.Type == "StasisStart" || e.Type == "ChannelDestroyed")
+	}, time.Duration(dialTimeout)*time.Second+eventGrace)
+
+	if evt == nil {
+		c.b.ari.Hangup(c.b.ctx, legID, "normal")
+
+		if c.isHungup() {
+			return "failed", 0, nil
+		}
+		return "no-answer", 0, nil
+	}
+	if evt.Type == "ChannelDestroyed" {
+		return dialStatusForCause(evt.Cause), 0, nil
+	}
+
+	if err := c.b.ari.CreateBridge(c.b.ctx, legID); err != nil {
+		c.b.ari.Hangup(c.b.ctx, legID, "normal")
+		return "", 0, errors.Wrap(err, "error creating bridge")
+	}
+	defer c.b.ari.DestroyBridge(c.b.ctx, legID)
+
+	if err := c.b.ari.AddChannels(c.b.ctx, legID, c.id, legID); err != nil {
+		c.b.ari.Hangup(c.b.ctx, legID, "normal")
+		return "", 0, errors.Wrap(err, "error adding channels to bridge")
+	}
+
+	start := time.Now()
+
+	limit := 24 * time.Hour
+	if timeLimit > 0 {
+		limit = time.Duration(timeLimit) * time.Second
+	}
+
+	// wait for either side to hang up or for the time limit
+	if c.waitFor(func(e *Event) bool {
+		return e.Type == "ChannelDestroyed" && e.Channel != nil && e.Channel.ID == legID
+	}, limit) == nil {
+		c.b.ari.Hangup(c.b.ctx, legID, "normal")
+	}
+
+	return "answered", int(time.Since(start) / time.Second), nil
+}
+
+// waitFor waits for an event matching the given function, returning nil if we timeout or the call is hung up
+func (c *call) waitFor(match func(*Event) bool, timeout time.Duration) *Event {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case evt := <-c.events:
+			if match(evt) {
+				return evt
+			}
+		case <-c.hungup:
+			return nil
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+func (c *call) hangup(reason string) {
+	if c.isHungup() {
+		return
+	}
+	if err := c.b.ari.Hangup(c.b.ctx, c.id, reason); err != nil && !isNotFound(err) {
+		c.log.Error("error hanging up call", "error", err)
+	}
+}
+
+func (c *call) postStatus(status string, duration int) {
+	cb := c.newCallback()
+	cb.Status, cb.Duration = status, duration
+
+	if _, err := c.b.post(c.b.ctx, c.statusURL, cb); err != nil {
+		c.log.Error("error posting call status", "status", status, "error", err)
+	}
+}
+
+func (c *call) newCallback() *pbx.Callback {
+	return &pbx.Callback{CallID: c.id, Direction: c.direction, From: c.from, To: c.to, Status: "in-progress"}
+}
+
+func (c *call) isHungup() bool {
+	select {
+	case <-c.hungup:
+		return true
+	default:
+		return false
+	}
+}
+
+// ourNumber is the channel address, which is the caller id for outgoing calls and the dialed number for incoming
+func (c *call) ourNumber() string {
+	if c.direction == "outbound" {
+		return c.from
+	}
+	return c.to
+}
+
+func (c *call) nextID(kind string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	return fmt.Sprintf("%s-%s-%d", c.id, kind, c.seq)
+}
+
+func (c *call) ttsURL(text, language string) string {
+	u := strings.Replace(c.b.config.TTSTemplate, "{text}", url.QueryEscape(text), -1)
+	return strings.Replace(u, "{language}", url.QueryEscape(language), -1)
+}
+
+// callStatusForCause maps the hangup cause of a call that was never answered to a call status
+func callStatusForCause(cause int) string {
+	switch cause {
+	case causeUserBusy:
+		return "busy"
+	case causeNoUserResponse, causeNoAnswer:
+		return "no-answer"
+	case causeCallRejected:
+		return "rejected"
+	default:
+		return "failed"
+	}
+}
+
+// dialStatusForCause maps the hangup cause of a dial leg that was never answered to a dial status
+func dialStatusForCause(cause int) string {
+	switch cause {
+	case causeUserBusy:
+		return "busy"
+	case causeNoUserResponse, causeNoAnswer, causeCallRejected:
+		return "no-answer"
+	default:
+		return "failed"
+	}
+}
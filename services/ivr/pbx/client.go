@@ -0,0 +1,87 @@
+package pbx
+
+// CallRequest is the request payload to originate a new call through the PBX control API
+type CallRequest struct {
+	Number           string `json:"number"`
+	Endpoint         string `json:"endpoint"`
+	CallerID         string `json:"caller_id"`
+	CallbackURL      string `json:"callback_url"`
+	StatusURL        string `json:"status_url"`
+	MachineDetection bool   `json:"machine_detection,omitempty"`
+}
+
+// CallResponse is the response from originating a new call
+//
+//	{
+//	 "id": "1532093456.12",
+//	 "state": "down"
+//	}
+type CallResponse struct {
+	ID    string `json:"id" validate:"required"`
+	State string `json:"state"`
+}
+
+// Callback is the payload the PBX bridge posts to our handle and status URLs
+type Callback struct {
+	CallID       string `json:"call_id"`
+	Direction    string `json:"direction"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Status       string `json:"status"`
+	Duration     int    `json:"duration"`
+	AnsweredBy   string `json:"answered_by"`
+	Digits       string `json:"digits"`
+	TimedOut     bool   `json:"timed_out"`
+	RecordingURL string `json:"recording_url"`
+	DialStatus   string `json:"dial_status"`
+	DialDuration int    `json:"dial_duration"`
+}
+
+// Response is the list of commands we return for the bridge to execute on the call, in order
+type Response struct {
+	Message  string `json:"_message,omitempty"`
+	Error    string `json:"_error,omitempty"`
+	Commands []any  `json:"commands"`
+}
+
+type Say struct {
+	Action   string `json:"action"`
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+}
+
+type Play struct {
+	Action string `json:"action"`
+	URL    string `json:"url"`
+}
+
+type Gather struct {
+	Action      string `json:"action"`
+	MaxDigits   int    `json:"max_digits,omitempty"`
+	Terminator  string `json:"terminator,omitempty"`
+	Timeout     int    `json:"timeout"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type Record struct {
+	Action      string `json:"action"`
+	MaxLength   int    `json:"max_length"`
+	Terminator  string `json:"terminator,omitempty"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type Dial struct {
+	Action      string `json:"action"`
+	Endpoint    string `json:"endpoint"`
+	DialTimeout int    `json:"dial_timeout,omitempty"`
+	TimeLimit   int    `json:"time_limit,omitempty"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type Hangup struct {
+	Action string `json:"action"`
+}
+
+type Reject struct {
+	Action string `json:"action"`
+}
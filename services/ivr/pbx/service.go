@@ -0,0 +1,473 @@
+package pbx
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// IgnoreSignatures sets whether we ignore signatures (for unit tests)
+var IgnoreSignatures = false
+
+var dialStatusMap = map[string]flows.DialStatus{
+	"answered":  flows.DialStatusAnswered,
+	"busy":      flows.DialStatusBusy,
+	"no-answer": flows.DialStatusNoAnswer,
+	"failed":    flows.DialStatusFailed,
+}
+
+const (
+	pbxChannelType = models.ChannelType("PBX")
+
+	callsPath  = `/calls`
+	hangupPath = `/calls/{ID}`
+
+	signatureHeader = "X-PBX-Signature"
+
+	gatherTimeout = 30
+	recordTimeout = 600
+
+	baseURLConfig  = "base_url"
+	usernameConfig = "username"
+	passwordConfig = "password"
+	secretConfig   = "secret"
+	endpointConfig = "endpoint"
+
+	// the default dial string for numbers, e.g. PJSIP/{number}@trunk for Asterisk
+	defaultEndpoint = "{number}"
+)
+
+// service drives calls on a self-hosted PBX through an HTTP bridge, such as the Asterisk ARI bridge in the bridge
+// package. We originate and hang up calls with its REST API, and it calls back our handle and status URLs with JSON
+// payloads, executing the list of commands we return in response. The protocol is described in README.md.
+type service struct {
+	httpClient *http.Client
+	channel    *models.Channel
+	baseURL    string
+	username   string
+	password   string
+	secret     string
+	endpoint   string
+}
+
+func init() {
+	ivr.RegisterServiceType(pbxChannelType, NewServiceFromChannel)
+}
+
+// NewServiceFromChannel creates a new PBX IVR service for the passed in channel
+func NewServiceFromChannel(httpClient *http.Client, channel *models.Channel) (ivr.Service, error) {
+	baseURL := channel.ConfigValue(baseURLConfig, "")
+	secret := channel.ConfigValue(secretConfig, "")
+	if baseURL == "" || secret == "" {
+		return nil, errors.Errorf("missing %s or %s on channel config", baseURLConfig, secretConfig)
+	}
+
+	return &service{
+		httpClient: httpClient,
+		channel:    channel,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		username:   channel.ConfigValue(usernameConfig, ""),
+		password:   channel.ConfigValue(passwordConfig, ""),
+		secret:     secret,
+		endpoint:   channel.ConfigValue(endpointConfig, defaultEndpoint),
+	}, nil
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
+
+func readCallback(r *http.Request) (*Callback, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading body from request")
+	}
+
+	cb := &Callback{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, cb); err != nil {
+			return nil, errors.Errorf("invalid json body")
+		}
+	}
+	return cb, nil
+}
+
+func (s *service) CallIDForRequest(r *http.Request) (string, error) {
+	cb, err := readCallback(r)
+	if err != nil {
+		return "", err
+	}
+	if cb.CallID == "" {
+		return "", errors.Errorf("no call_id set on callback")
+	}
+	return cb.CallID, nil
+}
+
+func (s *service) URNForRequest(r *http.Request) (urns.URN, error) {
+	cb, err := readCallback(r)
+	if err != nil {
+		return "", err
+	}
+
+	number := cb.From
+	if cb.Direction == "outbound" {
+		number = cb.To
+	}
+	if number == "" {
+		return "", errors.New("no caller number found in request")
+	}
+	return urns.NewTelURNForCountry(number, string(s.channel.Country()))
+}
+
+func (s *service) DownloadMedia(url string) (*http.Response, error) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (s *service) CheckStartRequest(r *http.Request) models.CallError {
+	cb, _ := readCallback(r)
+	if cb != nil && (cb.AnsweredBy == "machine" || cb.AnsweredBy == "fax") {
+		return models.CallErrorMachine
+	}
+	return ""
+}
+
+func (s *service) PreprocessStatus(ctx context.Context, rt *runtime.Runtime, r *http.Request) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *service) PreprocessResume(ctx context.Context, rt *runtime.Runtime, call *models.Call, r *http.Request) ([]byte, error) {
+	return nil, nil
+}
+
+// RequestCall asks the PBX to originate a new outgoing call
+func (s *service) RequestCall(number urns.URN, callbackURL string, statusURL string, machineDetection bool) (ivr.CallID, *httpx.Trace, error) {
+	callR := &CallRequest{
+		Number:           number.Path(),
+		Endpoint:         s.endpointForNumber(number.Path()),
+		CallerID:         s.channel.Address(),
+		CallbackURL:      callbackURL,
+		StatusURL:        statusURL,
+		MachineDetection: machineDetection,
+	}
+
+	trace, err := s.makeRequest(http.MethodPost, s.baseURL+callsPath, callR)
+	if err != nil {
+		return ivr.NilCallID, trace, errors.Wrapf(err, "error trying to start call")
+	}
+
+	if trace.Response.StatusCode != http.StatusOK && trace.Response.StatusCode != http.StatusCreated {
+		return ivr.NilCallID, trace, errors.Errorf("received non 200 status for call start: %d", trace.Response.StatusCode)
+	}
+
+	call := &CallResponse{}
+	if err := utils.UnmarshalAndValidate(trace.ResponseBody, call); err != nil {
+		return ivr.NilCallID, trace, errors.Wrap(err, "unable parse PBX response")
+	}
+
+	return ivr.CallID(call.ID), trace, nil
+}
+
+// HangupCall asks the PBX to hang up the call that is passed in
+func (s *service) HangupCall(callID string) (*httpx.Trace, error) {
+	sendURL := s.baseURL + strings.Replace(hangupPath, "{ID}", url.PathEscape(callID), -1)
+
+	trace, err := s.makeRequest(http.MethodDelete, sendURL, nil)
+	if err != nil {
+		return trace, errors.Wrapf(err, "error trying to hangup call")
+	}
+
+	if trace.Response.StatusCode != http.StatusOK && trace.Response.StatusCode != http.StatusNoContent {
+		return trace, errors.Errorf("received non 204 status for call hangup: %d", trace.Response.StatusCode)
+	}
+
+	return trace, nil
+}
+
+// ResumeForRequest returns the resume (input or dial) for the passed in request, if any
+func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
+	cb, err := readCallback(r)
+	if err != nil {
+		return nil, err
+	}
+
+	waitType := r.Form.Get("wait_type")
+	switch waitType {
+	case "gather":
+		if cb.TimedOut {
+			return ivr.InputResume{}, nil
+		}
+		return ivr.InputResume{Input: cb.Digits}, nil
+
+	case "record":
+		if cb.RecordingURL == "" {
+			return ivr.InputResume{}, nil
+		}
+		return ivr.InputResume{Attachment: utils.Attachment("audio:" + cb.RecordingURL)}, nil
+
+	case "dial":
+		status := dialStatusMap[cb.DialStatus]
+		if status == "" {
+			return nil, errors.Errorf("unknown dial_status in callback: %s", cb.DialStatus)
+		}
+		return ivr.DialResume{Status: status, Duration: cb.DialDuration}, nil
+
+	default:
+		return nil, errors.Errorf("unknown wait_type: %s", waitType)
+	}
+}
+
+// StatusForRequest returns the call status for the passed in request, and if it's an error the reason,
+// and if available, the current call duration
+func (s *service) StatusForRequest(r *http.Request) (models.CallStatus, models.CallError, int) {
+	// this is a resume, call is in progress, no need to look at the body
+	if r.Form.Get("action") == "resume" {
+		return models.CallStatusInProgress, "", 0
+	}
+
+	cb, err := readCallback(r)
+	if err != nil {
+		slog.Error("error reading status callback", "error", err)
+		return models.CallStatusErrored, models.CallErrorProvider, 0
+	}
+
+	switch cb.Status {
+	case "queued", "ringing":
+		return models.CallStatusWired, "", 0
+	case "", "answered", "in-progress":
+		return models.CallStatusInProgress, "", 0
+	case "completed":
+		return models.CallStatusCompleted, "", cb.Duration
+
+	case "busy":
+		return models.CallStatusErrored, models.CallErrorBusy, 0
+	case "no-answer", "rejected":
+		return models.CallStatusErrored, models.CallErrorNoAnswer, 0
+	case "machine":
+		return models.CallStatusErrored, models.CallErrorMachine, 0
+	case "failed", "canceled":
+		return models.CallStatusErrored, models.CallErrorProvider, 0
+
+	default:
+		slog.Error("unknown call status in status callback", "call_status", cb.Status)
+		return models.CallStatusFailed, models.CallErrorProvider, 0
+	}
+}
+
+// ValidateRequestSignature validates the signature on the passed in request, returning an error if it is invalid
+func (s *service) ValidateRequestSignature(r *http.Request) error {
+	if IgnoreSignatures {
+		return nil
+	}
+
+	actual := r.Header.Get(signatureHeader)
+	if actual == "" {
+		return errors.Errorf("missing request signature header")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return errors.Wrapf(err, "error reading body from request")
+	}
+
+	path := r.URL.RequestURI()
+	proxyPath := r.Header.Get("X-Forwarded-Path")
+	if proxyPath != "" {
+		path = proxyPath
+	}
+
+	url := fmt.Sprintf("https://%s%s", r.Host, path)
+	expected := CalculateSignature(url, body, s.secret)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return errors.Errorf("invalid request signature: %s", actual)
+	}
+
+	return nil
+}
+
+// WriteSessionResponse writes a command list response for the events in the passed in session
+func (s *service) WriteSessionResponse(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, channel *models.Channel, call *models.Call, session *models.Session, number urns.URN, resumeURL string, r *http.Request, w http.ResponseWriter) error {
+	// for errored sessions we should just output our error body
+	if session.Status() == models.SessionStatusFailed {
+		return errors.Errorf("cannot write IVR response for failed session")
+	}
+
+	// otherwise look for any say events
+	sprint := session.Sprint()
+	if sprint == nil {
+		return errors.Errorf("cannot write IVR response for session with no sprint")
+	}
+
+	response, err := s.responseForSprint(rt, oa.Env(), resumeURL, sprint.Events())
+	if err != nil {
+		return errors.Wrap(err, "unable to build response for IVR call")
+	}
+
+	return s.writeResponse(w, response)
+}
+
+func (s *service) WriteRejectResponse(w http.ResponseWriter) error {
+	return s.writeResponse(w, &Response{
+		Commands: []any{Reject{Action: "reject"}},
+	})
+}
+
+// WriteErrorResponse writes an error / unavailable response
+func (s *service) WriteErrorResponse(w http.ResponseWriter, err error) error {
+	return s.writeResponse(w, &Response{
+		Error: err.Error(),
+		Commands: []any{
+			Say{Action: "say", Text: ivr.ErrorMessage},
+			Hangup{Action: "hangup"},
+		},
+	})
+}
+
+// WriteEmptyResponse writes an empty (but valid) response
+func (s *service) WriteEmptyResponse(w http.ResponseWriter, msg string) error {
+	return s.writeResponse(w, &Response{
+		Message:  msg,
+		Commands: []any{},
+	})
+}
+
+func (s *service) writeResponse(w http.ResponseWriter, resp *Response) error {
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(jsonx.MustMarshal(resp))
+	return err
+}
+
+func (s *service) makeRequest(method string, sendURL string, body any) (*httpx.Trace, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(jsonx.MustMarshal(body))
+	}
+
+	req, _ := http.NewRequest(method, sendURL, bodyReader)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return httpx.DoTrace(s.httpClient, req, nil, nil, -1)
+}
+
+// endpointForNumber builds the dial string for the given number from the channel's endpoint template
+func (s *service) endpointForNumber(number string) string {
+	return strings.Replace(s.endpoint, "{number}", number, -1)
+}
+
+// CalculateSignature calculates the signature of a callback from the URL it was posted to and its body
+func CalculateSignature(url string, body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(url))
+	mac.Write(body)
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// command list building utilities
+
+func (s *service) responseForSprint(rt *runtime.Runtime, env envs.Environment, resumeURL string, es []flows.Event) (*Response, error) {
+	cmds, err := ivr.CommandsForSprint(rt.Config, env, es)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make([]any, 0, len(cmds))
+
+	for _, cmd := range cmds {
+		switch cmd.Type {
+		case ivr.CommandTypeSay:
+			// text is spoken by the PBX's own TTS engine, so pass on the locale for it to pick a voice
+			commands = append(commands, Say{Action: "say", Text: cmd.Text, Language: string(cmd.Locale)})
+
+		case ivr.CommandTypePlay:
+			commands = append(commands, Play{Action: "play", URL: cmd.URL})
+
+		case ivr.CommandTypeGather:
+			commands = append(commands, Gather{
+				Action:      "gather",
+				MaxDigits:   cmd.NumDigits,
+				Terminator:  cmd.FinishOnKey,
+				Timeout:     gatherTimeout,
+				CallbackURL: resumeURL + "&wait_type=gather",
+			})
+
+		case ivr.CommandTypeRecord:
+			commands = append(commands, Record{
+				Action:      "record",
+				MaxLength:   recordTimeout,
+				Terminator:  "#",
+				CallbackURL: resumeURL + "&wait_type=record",
+			})
+
+		case ivr.CommandTypeDial:
+			commands = append(commands, Dial{
+				Action:      "dial",
+				Endpoint:    s.endpointForNumber(cmd.URN.Path()),
+				DialTimeout: cmd.DialLimit,
+				TimeLimit:   cmd.CallLimit,
+				CallbackURL: resumeURL + "&wait_type=dial",
+			})
+
+		case ivr.CommandTypeHangup:
+			commands = append(commands, Hangup{Action: "hangup"})
+		}
+	}
+
+	return &Response{Commands: commands}, nil
+}
+
+func (s *service) RedactValues(ch *models.Channel) []string {
+	username, password := ch.ConfigValue(usernameConfig, ""), ch.ConfigValue(passwordConfig, "")
+
+	// skip unset values as redacting an empty string would mangle the whole log
+	values := make([]string, 0, 3)
+	if username != "" || password != "" {
+		values = append(values, httpx.BasicAuth(username, password))
+	}
+	if password != "" {
+		values = append(values, password)
+	}
+	if secret := ch.ConfigValue(secretConfig, ""); secret != "" {
+		values = append(values, secret)
+	}
+	return values
+}
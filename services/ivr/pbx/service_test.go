@@ -0,0 +1,309 @@
+package pbx
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/flows/routers/waits/hints"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mocks the control API of a PBX bridge
+func mockPBXHandler(t *testing.T, requests *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*requests = append(*requests, r.Method+" "+r.URL.Path+" "+string(body))
+
+		user, pass, _ := r.BasicAuth()
+		if user != "mailroom" || pass != "sesame" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/calls":
+			callR := &CallRequest{}
+			require.NoError(t, json.Unmarshal(body, callR))
+
+			if callR.Endpoint == "PJSIP/+16055741111@trunk" {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id": "1532093456.12", "state": "down"}`))
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"message": "invalid endpoint"}`))
+			}
+		case r.Method == http.MethodDelete && r.URL.Path == "/calls/1532093456.12":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func newTestService(t *testing.T, baseURL string) (*service, *models.Channel) {
+	ctx, rt := testsuite.Runtime()
+
+	ch := testdata.InsertChannel(rt, testdata.Org1, pbxChannelType, "PBX", "+12065551212", []string{"tel"}, "SRCA", map[string]any{
+		"base_url": baseURL,
+		"username": "mailroom",
+		"password": "sesame",
+		"secret":   "sssh",
+		"endpoint": "PJSIP/{number}@trunk",
+	})
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	channel := oa.ChannelByUUID(ch.UUID)
+	require.NotNil(t, channel)
+
+	svc, err := ivr.GetService(channel)
+	require.NoError(t, err)
+
+	return svc.(*service), channel
+}
+
+func TestRequestAndHangupCall(t *testing.T) {
+	defer testsuite.Reset(testsuite.ResetData)
+
+	var requests []string
+	ts := httptest.NewServer(mockPBXHandler(t, &requests))
+	defer ts.Close()
+
+	svc, _ := newTestService(t, ts.URL+"/")
+
+	callID, trace, err := svc.RequestCall(urns.URN("tel:+16055741111"), "https://mr.io/handle?action=start", "https://mr.io/status", true)
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.CallID("1532093456.12"), callID)
+	assert.Equal(t, 201, trace.Response.StatusCode)
+
+	assert.Equal(t, `POST /calls {"number":"+16055741111","endpoint":"PJSIP/+16055741111@trunk","caller_id":"+12065551212","callback_url":"https://mr.io/handle?action=start","status_url":"https://mr.io/status","machine_detection":true}`, requests[0])
+
+	_, _, err = svc.RequestCall(urns.URN("tel:+16055742222"), "https://mr.io/handle?action=start", "https://mr.io/status", false)
+	assert.EqualError(t, err, "received non 200 status for call start: 400")
+
+	trace, err = svc.HangupCall("1532093456.12")
+	assert.NoError(t, err)
+	assert.Equal(t, 204, trace.Response.StatusCode)
+
+	_, err = svc.HangupCall("1532093456.99")
+	assert.EqualError(t, err, "received non 204 status for call hangup: 404")
+
+	assert.Len(t, requests, 4)
+	assert.Equal(t, "DELETE /calls/1532093456.12 ", requests[2])
+}
+
+func TestResponseForSprint(t *testing.T) {
+	_, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	svc, channel := newTestService(t, "http://localhost:8088")
+
+	urn := urns.URN("tel:+12067799294")
+	expiresOn := time.Now().Add(time.Hour)
+	channelRef := channel.Reference()
+	env := envs.NewBuilder().WithAllowedLanguages("eng", "spa").WithDefaultCountry("US").Build()
+
+	resumeURL := "http://temba.io/resume?session=1"
+
+	// set our attachment domain for testing
+	rt.Config.AttachmentDomain = "mailroom.io"
+	defer func() { rt.Config.AttachmentDomain = "" }()
+
+	tcs := []struct {
+		events   []flows.Event
+		expected string
+	}{
+		{
+			events: []flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "Hi there", "", "")),
+			},
+			expected: `{"commands":[{"action":"say","text":"Hi there","language":"eng-US"},{"action":"hangup"}]}`,
+		},
+		{
+			events: []flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "Hola", "", "spa-MX")),
+			},
+			expected: `{"commands":[{"action":"say","text":"Hola","language":"spa-MX"},{"action":"hangup"}]}`,
+		},
+		{
+			events: []flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "Hi there", "/recordings/foo.wav", "eng-US")),
+			},
+			expected: `{"commands":[{"action":"play","url":"https://mailroom.io/recordings/foo.wav"},{"action":"hangup"}]}`,
+		},
+		{
+			events: []flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "enter a number", "", "")),
+				events.NewMsgWait(nil, nil, hints.NewFixedDigitsHint(1)),
+			},
+			expected: `{"commands":[{"action":"say","text":"enter a number","language":"eng-US"},{"action":"gather","max_digits":1,"timeout":30,"callback_url":"http://temba.io/resume?session=1&wait_type=gather"}]}`,
+		},
+		{
+			events: []flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "enter a number, then press #", "", "")),
+				events.NewMsgWait(nil, nil, hints.NewTerminatedDigitsHint("#")),
+			},
+			expected: `{"commands":[{"action":"say","text":"enter a number, then press #","language":"eng-US"},{"action":"gather","terminator":"#","timeout":30,"callback_url":"http://temba.io/resume?session=1&wait_type=gather"}]}`,
+		},
+		{
+			events: []flows.Event{
+				events.NewIVRCreated(flows.NewIVRMsgOut(urn, channelRef, "say something", "", "")),
+				events.NewMsgWait(nil, nil, hints.NewAudioHint()),
+			},
+			expected: `{"commands":[{"action":"say","text":"say something","language":"eng-US"},{"action":"record","max_length":600,"terminator":"#","callback_url":"http://temba.io/resume?session=1&wait_type=record"}]}`,
+		},
+		{
+			events: []flows.Event{
+				events.NewDialWait(urns.URN(`tel:+1234567890`), 60, 7200, &expiresOn),
+			},
+			expected: `{"commands":[{"action":"dial","endpoint":"PJSIP/+1234567890@trunk","dial_timeout":60,"time_limit":7200,"callback_url":"http://temba.io/resume?session=1&wait_type=dial"}]}`,
+		},
+	}
+
+	for i, tc := range tcs {
+		response, err := svc.responseForSprint(rt, env, resumeURL, tc.events)
+		assert.NoError(t, err, "%d: unexpected error", i)
+		assert.Equal(t, tc.expected, string(jsonx.MustMarshal(response)), "%d: unexpected response", i)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	defer testsuite.Reset(testsuite.ResetData)
+
+	svc, _ := newTestService(t, "http://localhost:8088")
+
+	makeRequest := func(query, body string) *http.Request {
+		r, _ := http.NewRequest("POST", "http://mr.io/ivr/c/1234/handle?"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.ParseForm()
+		return r
+	}
+
+	r := makeRequest("action=start", `{"call_id": "1532093456.12", "direction": "inbound", "from": "+12064871234", "to": "+12065551212"}`)
+	callID, err := svc.CallIDForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "1532093456.12", callID)
+
+	urn, err := svc.URNForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, urns.URN("tel:+12064871234"), urn)
+
+	r = makeRequest("action=start", `{"call_id": "1532093456.12", "direction": "outbound", "from": "+12065551212", "to": "+12064871234", "answered_by": "machine"}`)
+	urn, err = svc.URNForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, urns.URN("tel:+12064871234"), urn)
+	assert.Equal(t, models.CallErrorMachine, svc.CheckStartRequest(r))
+
+	_, err = svc.CallIDForRequest(makeRequest("action=start", `{"direction": "inbound"}`))
+	assert.EqualError(t, err, "no call_id set on callback")
+
+	// resumes
+	resume, err := svc.ResumeForRequest(makeRequest("action=resume&wait_type=gather", `{"call_id": "1532093456.12", "digits": "12"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{Input: "12"}, resume)
+
+	resume, err = svc.ResumeForRequest(makeRequest("action=resume&wait_type=gather", `{"call_id": "1532093456.12", "timed_out": true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{}, resume)
+
+	resume, err = svc.ResumeForRequest(makeRequest("action=resume&wait_type=record", `{"call_id": "1532093456.12", "recording_url": "http://pbx.io/recordings/1.wav"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.InputResume{Attachment: "audio:http://pbx.io/recordings/1.wav"}, resume)
+
+	resume, err = svc.ResumeForRequest(makeRequest("action=resume&wait_type=dial", `{"call_id": "1532093456.12", "dial_status": "answered", "dial_duration": 45}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.DialResume{Status: flows.DialStatusAnswered, Duration: 45}, resume)
+
+	_, err = svc.ResumeForRequest(makeRequest("action=resume&wait_type=dial", `{"call_id": "1532093456.12", "dial_status": "exploded"}`))
+	assert.EqualError(t, err, "unknown dial_status in callback: exploded")
+
+	// statuses
+	tcs := []struct {
+		body     string
+		status   models.CallStatus
+		errorR   models.CallError
+		duration int
+	}{
+		{`{"call_id": "1", "status": "ringing"}`, models.CallStatusWired, "", 0},
+		{`{"call_id": "1", "status": "answered"}`, models.CallStatusInProgress, "", 0},
+		{`{"call_id": "1", "status": "completed", "duration": 34}`, models.CallStatusCompleted, "", 34},
+		{`{"call_id": "1", "status": "busy"}`, models.CallStatusErrored, models.CallErrorBusy, 0},
+		{`{"call_id": "1", "status": "no-answer"}`, models.CallStatusErrored, models.CallErrorNoAnswer, 0},
+		{`{"call_id": "1", "status": "machine"}`, models.CallStatusErrored, models.CallErrorMachine, 0},
+		{`{"call_id": "1", "status": "failed"}`, models.CallStatusErrored, models.CallErrorProvider, 0},
+		{`{"call_id": "1", "status": "xxx"}`, models.CallStatusFailed, models.CallErrorProvider, 0},
+	}
+
+	for _, tc := range tcs {
+		status, errorR, duration := svc.StatusForRequest(makeRequest("action=status", tc.body))
+		assert.Equal(t, tc.status, status, "status mismatch for %s", tc.body)
+		assert.Equal(t, tc.errorR, errorR, "error reason mismatch for %s", tc.body)
+		assert.Equal(t, tc.duration, duration, "duration mismatch for %s", tc.body)
+	}
+}
+
+func TestValidateRequestSignature(t *testing.T) {
+	defer testsuite.Reset(testsuite.ResetData)
+
+	svc, _ := newTestService(t, "http://localhost:8088")
+
+	body := `{"call_id": "1532093456.12", "digits": "12"}`
+	makeRequest := func(sig string) *http.Request {
+		r, _ := http.NewRequest("POST", "https://mr.io/ivr/c/1234/handle?action=resume&wait_type=gather", strings.NewReader(body))
+		if sig != "" {
+			r.Header.Set(signatureHeader, sig)
+		}
+		return r
+	}
+
+	sig := CalculateSignature("https://mr.io/ivr/c/1234/handle?action=resume&wait_type=gather", []byte(body), "sssh")
+
+	assert.NoError(t, svc.ValidateRequestSignature(makeRequest(sig)))
+	assert.EqualError(t, svc.ValidateRequestSignature(makeRequest("")), "missing request signature header")
+	assert.EqualError(t, svc.ValidateRequestSignature(makeRequest("xyz")), "invalid request signature: xyz")
+
+	// body is still readable after validation
+	r := makeRequest(sig)
+	svc.ValidateRequestSignature(r)
+	callID, err := svc.CallIDForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "1532093456.12", callID)
+}
+
+func TestRedactValues(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	svc, channel := newTestService(t, "http://localhost:8088")
+
+	assert.Equal(t, []string{"bWFpbHJvb206c2VzYW1l", "sesame", "sssh"}, svc.RedactValues(channel))
+
+	// unset credentials aren't included
+	ch := testdata.InsertChannel(rt, testdata.Org1, pbxChannelType, "PBX", "+12065553434", []string{"tel"}, "SRCA", map[string]any{
+		"base_url": "http://localhost:8088",
+		"secret":   "sssh",
+	})
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"sssh"}, svc.RedactValues(oa.ChannelByUUID(ch.UUID)))
+}
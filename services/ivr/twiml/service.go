@@ -20,8 +20,6 @@ import (
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/goflow/flows"
-	"github.com/nyaruka/goflow/flows/events"
-	"github.com/nyaruka/goflow/flows/routers/waits/hints"
 	"github.com/nyaruka/goflow/utils"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
@@ -492,72 +490,52 @@ func twCalculateSignature(url string, form url.Values, authToken string) ([]byte
 // TWIML building utilities
 
 func ResponseForSprint(rt *runtime.Runtime, env envs.Environment, urn urns.URN, resumeURL string, es []flows.Event, indent bool) (string, error) {
+	cmds, err := ivr.CommandsForSprint(rt.Config, env, es)
+	if err != nil {
+		return "", err
+	}
+
 	r := &Response{}
 	commands := make([]any, 0)
-	hasWait := false
-
-	for _, e := range es {
-		switch event := e.(type) {
-		case *events.IVRCreatedEvent:
-			if len(event.Msg.Attachments()) == 0 {
-				var locales []i18n.Locale
-				if event.Msg.Locale() != "" {
-					locales = append(locales, event.Msg.Locale())
-				}
-				locales = append(locales, env.DefaultLocale())
-				lang := supportedSayLanguages.ForLocales(locales...)
-
-				commands = append(commands, &Say{Text: event.Msg.Text(), Language: lang})
-			} else {
-				for _, a := range event.Msg.Attachments() {
-					a = models.NormalizeAttachment(rt.Config, a)
-					commands = append(commands, Play{URL: a.URL()})
-				}
-			}
 
-		case *events.MsgWaitEvent:
-			hasWait = true
-			switch hint := event.Hint.(type) {
-			case *hints.DigitsHint:
-				resumeURL = resumeURL + "&wait_type=gather"
-				gather := &Gather{
-					Action:   resumeURL,
-					Commands: commands,
-					Timeout:  gatherTimeout,
-				}
-				if hint.Count != nil {
-					gather.NumDigits = *hint.Count
-				}
-				gather.FinishOnKey = hint.TerminatedBy
-				r.Gather = gather
-				r.Commands = append(r.Commands, Redirect{URL: resumeURL + "&timeout=true"})
-
-			case *hints.AudioHint:
-				resumeURL = resumeURL + "&wait_type=record"
-				commands = append(commands, Record{Action: resumeURL, MaxLength: recordTimeout})
-				commands = append(commands, Redirect{URL: resumeURL + "&empty=true"})
-				r.Commands = commands
-
-			default:
-				return "", errors.Errorf("unable to use hint in IVR call, unknown type: %s", event.Hint.Type())
+	for _, cmd := range cmds {
+		switch cmd.Type {
+		case ivr.CommandTypeSay:
+			lang := supportedSayLanguages.ForLocales(cmd.Locale, env.DefaultLocale())
+			commands = append(commands, &Say{Text: cmd.Text, Language: lang})
+
+		case ivr.CommandTypePlay:
+			commands = append(commands, Play{URL: cmd.URL})
+
+		case ivr.CommandTypeGather:
+			resumeURL = resumeURL + "&wait_type=gather"
+			r.Gather = &Gather{
+				Action:      resumeURL,
+				Commands:    commands,
+				Timeout:     gatherTimeout,
+				NumDigits:   cmd.NumDigits,
+				FinishOnKey: cmd.FinishOnKey,
 			}
+			r.Commands = append(r.Commands, Redirect{URL: resumeURL + "&timeout=true"})
+
+		case ivr.CommandTypeRecord:
+			resumeURL = resumeURL + "&wait_type=record"
+			commands = append(commands, Record{Action: resumeURL, MaxLength: recordTimeout})
+			commands = append(commands, Redirect{URL: resumeURL + "&empty=true"})
+			r.Commands = commands
 
-		case *events.DialWaitEvent:
-			hasWait = true
-			dial := Dial{Action: resumeURL + "&wait_type=dial", Number: event.URN.Path(), Timeout: event.DialLimitSeconds, TimeLimit: event.CallLimitSeconds}
+		case ivr.CommandTypeDial:
+			dial := Dial{Action: resumeURL + "&wait_type=dial", Number: cmd.URN.Path(), Timeout: cmd.DialLimit, TimeLimit: cmd.CallLimit}
 			commands = append(commands, dial)
 			r.Commands = commands
-		}
-	}
 
-	if !hasWait {
-		// no wait? call is over, hang up
-		commands = append(commands, Hangup{})
-		r.Commands = commands
+		case ivr.CommandTypeHangup:
+			commands = append(commands, Hangup{})
+			r.Commands = commands
+		}
 	}
 
 	var body []byte
-	var err error
 	if indent {
 		body, err = xml.MarshalIndent(r, "", "  ")
 	} else {
@@ -0,0 +1,111 @@
+-- Schema changes needed by mailroom which aren't yet in mailroom_test.dump. These mirror the RapidPro migrations which
+-- add these tables and columns, and are applied after the dump is restored. Once the dump has been regenerated from a
+-- RapidPro version which includes those migrations, the corresponding statements here should be removed.
+
+-- contact duplicate candidates
+CREATE TABLE IF NOT EXISTS contacts_contactduplicate (
+    id serial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs_org(id),
+    contact1_id integer NOT NULL REFERENCES contacts_contact(id),
+    contact2_id integer NOT NULL REFERENCES contacts_contact(id),
+    matchers character varying(32)[] NOT NULL,
+    confidence double precision NOT NULL,
+    created_on timestamp with time zone NOT NULL,
+    UNIQUE (org_id, contact1_id, contact2_id)
+);
+CREATE INDEX IF NOT EXISTS contacts_contactduplicate_org_confidence ON contacts_contactduplicate(org_id, confidence DESC);
+
+-- contact import modes, dry runs and results
+ALTER TABLE contacts_contactimport ADD COLUMN IF NOT EXISTS mode character varying(6);
+ALTER TABLE contacts_contactimport ADD COLUMN IF NOT EXISTS dry_run boolean;
+ALTER TABLE contacts_contactimport ADD COLUMN IF NOT EXISTS result_url character varying(2048);
+ALTER TABLE contacts_contactimportbatch ADD COLUMN IF NOT EXISTS num_skipped integer NOT NULL DEFAULT 0;
+ALTER TABLE contacts_contactimportbatch ADD COLUMN IF NOT EXISTS results jsonb;
+
+-- contact import sources
+CREATE TABLE IF NOT EXISTS contacts_contactimportsource (
+    id serial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs_org(id),
+    url character varying(2048) NOT NULL,
+    format character varying(4) NOT NULL,
+    mode character varying(6) NOT NULL,
+    schedule_id integer REFERENCES schedules_schedule(id),
+    last_synced_on timestamp with time zone,
+    is_active boolean NOT NULL,
+    created_on timestamp with time zone NOT NULL,
+    created_by_id integer NOT NULL REFERENCES auth_user(id),
+    modified_on timestamp with time zone NOT NULL,
+    modified_by_id integer NOT NULL REFERENCES auth_user(id)
+);
+CREATE TABLE IF NOT EXISTS contacts_contactimportsourcehash (
+    id serial PRIMARY KEY,
+    source_id integer NOT NULL REFERENCES contacts_contactimportsource(id),
+    record_key text NOT NULL,
+    hash character varying(64) NOT NULL,
+    UNIQUE (source_id, record_key)
+);
+ALTER TABLE contacts_contactimport ADD COLUMN IF NOT EXISTS source_id integer REFERENCES contacts_contactimportsource(id);
+
+-- contact field history
+CREATE TABLE IF NOT EXISTS contacts_contactfieldhistory (
+    id bigserial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs_org(id),
+    contact_id integer NOT NULL REFERENCES contacts_contact(id),
+    field_id integer NOT NULL REFERENCES contacts_contactfield(id),
+    old_value jsonb,
+    new_value jsonb,
+    flow_id integer REFERENCES flows_flow(id),
+    user_id integer REFERENCES auth_user(id),
+    created_on timestamp with time zone NOT NULL
+);
+CREATE INDEX IF NOT EXISTS contacts_contactfieldhistory_contact_field ON contacts_contactfieldhistory(contact_id, field_id, created_on DESC);
+
+-- canned responses for ticket replies
+CREATE TABLE IF NOT EXISTS tickets_cannedresponse (
+    id serial PRIMARY KEY,
+    uuid uuid NOT NULL UNIQUE,
+    org_id integer NOT NULL REFERENCES orgs_org(id),
+    topic_id integer REFERENCES tickets_topic(id),
+    name character varying(64) NOT NULL,
+    base_language character varying(3) NOT NULL,
+    translations jsonb NOT NULL,
+    is_active boolean NOT NULL,
+    created_on timestamp with time zone NOT NULL,
+    created_by_id integer NOT NULL REFERENCES auth_user(id),
+    modified_on timestamp with time zone NOT NULL,
+    modified_by_id integer NOT NULL REFERENCES auth_user(id)
+);
+CREATE INDEX IF NOT EXISTS tickets_cannedresponse_org_active ON tickets_cannedresponse(org_id) WHERE is_active;
+
+-- the ticket which a ticket was merged into
+ALTER TABLE tickets_ticketevent ADD COLUMN IF NOT EXISTS target_id integer REFERENCES tickets_ticket(id);
+
+-- contact optin statuses and their history
+CREATE TABLE IF NOT EXISTS contacts_contactoptin (
+    id serial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs_org(id),
+    contact_id integer NOT NULL REFERENCES contacts_contact(id),
+    optin_id integer NOT NULL REFERENCES msgs_optin(id),
+    status character varying(1) NOT NULL,
+    modified_on timestamp with time zone NOT NULL,
+    UNIQUE (contact_id, optin_id)
+);
+CREATE TABLE IF NOT EXISTS contacts_contactoptinevent (
+    id bigserial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs_org(id),
+    contact_id integer NOT NULL REFERENCES contacts_contact(id),
+    optin_id integer NOT NULL REFERENCES msgs_optin(id),
+    channel_id integer REFERENCES channels_channel(id),
+    status character varying(1) NOT NULL,
+    created_on timestamp with time zone NOT NULL
+);
+CREATE INDEX IF NOT EXISTS contacts_contactoptinevent_contact ON contacts_contactoptinevent(contact_id, created_on DESC);
+
+-- retry policies for IVR flow starts
+ALTER TABLE flows_flowstart ADD COLUMN IF NOT EXISTS call_retry_policy jsonb;
+
+-- delivery status of group change events
+ALTER TABLE api_webhookevent ADD COLUMN IF NOT EXISTS status character varying(1);
+ALTER TABLE api_webhookevent ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE api_webhookevent ADD COLUMN IF NOT EXISTS next_attempt timestamp with time zone;
+CREATE INDEX IF NOT EXISTS api_webhookevent_pending ON api_webhookevent(next_attempt) WHERE status IN ('P', 'Q');
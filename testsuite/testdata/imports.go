@@ -12,8 +12,8 @@ import (
 // InsertContactImport inserts a contact import
 func InsertContactImport(rt *runtime.Runtime, org *Org, createdBy *User) models.ContactImportID {
 	var importID models.ContactImportID
-	must(rt.DB.Get(&importID, `INSERT INTO contacts_contactimport(org_id, file, original_filename, mappings, num_records, group_id, started_on, status, created_on, created_by_id, modified_on, modified_by_id, is_active)
-					          VALUES($1, 'contact_imports/1234.xlsx', 'contacts.xlsx', '{}', 30, NULL, $2, 'O', $2, $3, $2, $3, TRUE) RETURNING id`, org.ID, dates.Now(), createdBy.ID,
+	must(rt.DB.Get(&importID, `INSERT INTO contacts_contactimport(org_id, file, original_filename, mappings, num_records, group_id, mode, dry_run, started_on, status, created_on, created_by_id, modified_on, modified_by_id, is_active)
+					          VALUES($1, 'contact_imports/1234.xlsx', 'contacts.xlsx', '{}', 30, NULL, 'upsert', FALSE, $2, 'O', $2, $3, $2, $3, TRUE) RETURNING id`, org.ID, dates.Now(), createdBy.ID,
 	))
 	return importID
 }
@@ -24,8 +24,17 @@ func InsertContactImportBatch(rt *runtime.Runtime, importID models.ContactImport
 	must(jsonx.Unmarshal(specs, &splitSpecs))
 
 	var batchID models.ContactImportBatchID
-	must(rt.DB.Get(&batchID, `INSERT INTO contacts_contactimportbatch(contact_import_id, status, specs, record_start, record_end, num_created, num_updated, num_errored, errors, finished_on)
-					         VALUES($1, 'P', $2, 0, $3, 0, 0, 0, '[]', NULL) RETURNING id`, importID, specs, len(splitSpecs),
+	must(rt.DB.Get(&batchID, `INSERT INTO contacts_contactimportbatch(contact_import_id, status, specs, record_start, record_end, num_created, num_updated, num_skipped, num_errored, errors, results, finished_on)
+					         VALUES($1, 'P', $2, 0, $3, 0, 0, 0, 0, '[]', NULL, NULL) RETURNING id`, importID, specs, len(splitSpecs),
 	))
 	return batchID
 }
+
+// InsertContactImportSource inserts a contact import source
+func InsertContactImportSource(rt *runtime.Runtime, org *Org, url string, format models.ContactImportSourceFormat, schedID models.ScheduleID, createdBy *User) models.ContactImportSourceID {
+	var sourceID models.ContactImportSourceID
+	must(rt.DB.Get(&sourceID, `INSERT INTO contacts_contactimportsource(org_id, url, format, mode, schedule_id, last_synced_on, created_on, created_by_id, modified_on, modified_by_id, is_active)
+					           VALUES($1, $2, $3, 'upsert', $4, NULL, $5, $6, $5, $6, TRUE) RETURNING id`, org.ID, url, format, schedID, dates.Now(), createdBy.ID,
+	))
+	return sourceID
+}
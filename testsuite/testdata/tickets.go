@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/flows"
@@ -61,3 +62,25 @@ func insertTicket(rt *runtime.Runtime, org *Org, contact *Contact, status models
 	))
 	return &Ticket{id, uuid}
 }
+
+type CannedResponse struct {
+	ID   models.CannedResponseID
+	UUID uuids.UUID
+}
+
+// InsertCannedResponse inserts a canned response, optionally restricted to the given topic
+func InsertCannedResponse(rt *runtime.Runtime, org *Org, topic *Topic, name string, baseLanguage i18n.Language, translations flows.BroadcastTranslations) *CannedResponse {
+	uuid := uuids.New()
+
+	var topicID models.TopicID
+	if topic != nil {
+		topicID = topic.ID
+	}
+
+	var id models.CannedResponseID
+	must(rt.DB.Get(&id,
+		`INSERT INTO tickets_cannedresponse(uuid, org_id, topic_id, name, base_language, translations, is_active, created_on, modified_on, created_by_id, modified_by_id)
+		VALUES($1, $2, $3, $4, $5, $6, TRUE, NOW(), NOW(), 1, 1) RETURNING id`, uuid, org.ID, topicID, name, baseLanguage, translations,
+	))
+	return &CannedResponse{id, uuid}
+}
@@ -78,7 +78,7 @@ func Runtime() (context.Context, *runtime.Runtime) {
 		ReadonlyDB:        dbx.DB,
 		RP:                getRP(),
 		ES:                es,
-		AttachmentStorage: storage.NewFS(attachmentStorageDir, 0766),
+		AttachmentStorage: runtime.NewDeletableFS(attachmentStorageDir, 0766),
 		SessionStorage:    storage.NewFS(sessionStorageDir, 0766),
 		LogStorage:        storage.NewFS(logStorageDir, 0766),
 		Config:            cfg,
@@ -154,6 +154,8 @@ func getES() *elastic.Client {
 // then copying the mailroom_test.dump file to your mailroom root directory
 //
 //	% cp mailroom_test.dump ../mailroom
+//
+// Any schema changes in testsuite/migrations.sql are applied after the dump is restored.
 func resetDB() {
 	db := getDB()
 	db.MustExec("DROP OWNED BY mailroom_test CASCADE")
@@ -174,6 +176,8 @@ func loadTestDump() {
 		panic(fmt.Sprintf("error restoring database: %s: %s", err, string(output)))
 	}
 
+	applyTestMigrations()
+
 	// force re-connection
 	if _db != nil {
 		_db.Close()
@@ -181,6 +185,21 @@ func loadTestDump() {
 	}
 }
 
+// applies the schema changes in testsuite/migrations.sql which aren't yet in the test database dump
+func applyTestMigrations() {
+	migrations, err := os.Open(absPath("./testsuite/migrations.sql"))
+	must(err)
+	defer migrations.Close()
+
+	cmd := exec.Command("docker", "exec", "-i", postgresContainerName, "psql", "-d", "mailroom_test", "-U", "mailroom_test", "-v", "ON_ERROR_STOP=1", "-q")
+	cmd.Stdin = migrations
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		panic(fmt.Sprintf("error applying test migrations: %s: %s", err, string(output)))
+	}
+}
+
 // Converts a project root relative path to an absolute path usable in any test. This is needed because go tests
 // are run with a working directory set to the current module being tested.
 func absPath(p string) string {
@@ -274,11 +293,15 @@ DELETE FROM msgs_broadcast_contacts;
 DELETE FROM msgs_broadcastmsgcount;
 DELETE FROM msgs_broadcast;
 DELETE FROM msgs_optin;
+DELETE FROM contacts_contactimportbatch;
+DELETE FROM contacts_contactimport;
+DELETE FROM contacts_contactimportsourcehash;
+DELETE FROM contacts_contactimportsource;
 DELETE FROM schedules_schedule;
 DELETE FROM campaigns_campaignevent WHERE id >= 30000;
 DELETE FROM campaigns_campaign WHERE id >= 30000;
-DELETE FROM contacts_contactimportbatch;
-DELETE FROM contacts_contactimport;
+DELETE FROM contacts_contactfieldhistory;
+DELETE FROM contacts_contactduplicate;
 DELETE FROM contacts_contacturn WHERE id >= 30000;
 DELETE FROM contacts_contactgroup_contacts WHERE contact_id >= 30000 OR contactgroup_id >= 30000;
 DELETE FROM contacts_contact WHERE id >= 30000;
@@ -296,7 +319,8 @@ ALTER SEQUENCE contacts_contact_id_seq RESTART WITH 30000;
 ALTER SEQUENCE contacts_contacturn_id_seq RESTART WITH 30000;
 ALTER SEQUENCE contacts_contactgroup_id_seq RESTART WITH 30000;
 ALTER SEQUENCE campaigns_campaign_id_seq RESTART WITH 30000;
-ALTER SEQUENCE campaigns_campaignevent_id_seq RESTART WITH 30000;`
+ALTER SEQUENCE campaigns_campaignevent_id_seq RESTART WITH 30000;
+ALTER SEQUENCE contacts_contactfieldhistory_id_seq RESTART WITH 1;`
 
 // removes contact data not in the test database dump. Note that this function can't
 // undo changes made to the contact data in the test database dump.
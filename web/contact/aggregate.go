@@ -0,0 +1,88 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/contact/aggregate", web.RequireAuthToken(web.JSONPayload(handleAggregate)))
+}
+
+// Breaks down the contacts matching a query by facets. Facets can be a field (which requires a key), group, scheme,
+// language or created_on. Datetime fields and created_on are bucketed by week. Each facet can specify a limit on the
+// number of buckets which defaults to 10 and can't be more than 100.
+//
+//	{
+//	  "org_id": 1,
+//	  "query": "age > 10",
+//	  "facets": [
+//	    {"type": "field", "key": "gender"},
+//	    {"type": "scheme"},
+//	    {"type": "created_on", "limit": 4}
+//	  ]
+//	}
+type aggregateRequest struct {
+	OrgID  models.OrgID    `json:"org_id" validate:"required"`
+	Query  string          `json:"query"`
+	Facets []*search.Facet `json:"facets" validate:"required,min=1,max=10,dive"`
+}
+
+// Response for a contact aggregation
+//
+//	{
+//	  "query": "age > 10",
+//	  "total": 23,
+//	  "facets": [
+//	    {"type": "field", "key": "gender", "buckets": [{"key": "f", "count": 12}, {"key": "m", "count": 9}]},
+//	    {"type": "scheme", "buckets": [{"key": "tel", "count": 23}, {"key": "whatsapp", "count": 4}]},
+//	    {"type": "created_on", "buckets": [{"key": "2023-04-03", "count": 2}, {"key": "2023-04-10", "count": 5}]}
+//	  ]
+//	}
+type aggregateResponse struct {
+	Query  string          `json:"query"`
+	Total  int64           `json:"total"`
+	Facets []*facetBuckets `json:"facets"`
+}
+
+type facetBuckets struct {
+	Type    search.FacetType `json:"type"`
+	Key     string           `json:"key,omitempty"`
+	Buckets []*search.Bucket `json:"buckets"`
+}
+
+// handles a contact aggregation request
+func handleAggregate(ctx context.Context, rt *runtime.Runtime, r *aggregateRequest) (any, int, error) {
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups|models.RefreshOptIns)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	parsed, total, buckets, err := search.GetContactAggregations(ctx, rt, oa, r.Query, r.Facets)
+	if err != nil {
+		isQueryError, qerr := contactql.IsQueryError(err)
+		if isQueryError {
+			return qerr, http.StatusBadRequest, nil
+		}
+		return nil, 0, err
+	}
+
+	normalized := ""
+	if parsed != nil {
+		normalized = parsed.String()
+	}
+
+	response := &aggregateResponse{Query: normalized, Total: total, Facets: make([]*facetBuckets, len(r.Facets))}
+	for i, f := range r.Facets {
+		response.Facets[i] = &facetBuckets{Type: f.Type, Key: f.Key, Buckets: buckets[i]}
+	}
+
+	return response, http.StatusOK, nil
+}
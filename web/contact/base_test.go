@@ -10,12 +10,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
 	_ "github.com/nyaruka/mailroom/core/handlers"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/nyaruka/mailroom/web"
@@ -213,6 +215,13 @@ func TestSearch(t *testing.T) {
 			expectedSchemes:      []string{},
 			expectedAllowAsGroup: true,
 		},
+		{ // 7
+			method:         "POST",
+			url:            "/mr/contact/search",
+			body:           `{"org_id": 1, "query": "tel ~ 0781 OR tel ~ 0782 OR tel ~ 0783 OR tel ~ 0784 OR tel ~ 0785 OR tel ~ 0786 OR tel ~ 0787 OR tel ~ 0788 OR tel ~ 0789"}`,
+			expectedStatus: 400,
+			expectedError:  "query is too complex to run, try removing some conditions or contains operators",
+		},
 	}
 
 	for i, tc := range tcs {
@@ -255,14 +264,95 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestAggregate(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// give our contacts predictable values to aggregate
+	rt.DB.MustExec(`UPDATE contacts_contact SET language = 'eng', created_on = '2023-04-03T12:00:00Z' WHERE id = $1`, testdata.Cathy.ID)
+	rt.DB.MustExec(`UPDATE contacts_contact SET language = 'eng', created_on = '2023-04-05T12:00:00Z' WHERE id = $1`, testdata.Bob.ID)
+	rt.DB.MustExec(`UPDATE contacts_contact SET language = 'fra', created_on = '2023-04-12T12:00:00Z' WHERE id = $1`, testdata.George.ID)
+	rt.DB.MustExec(`UPDATE contacts_contact SET fields = COALESCE(fields, '{}') || jsonb_build_object($2::text, '{"text": "M"}'::jsonb) WHERE id = ANY($1)`, pq.Array([]models.ContactID{testdata.Bob.ID, testdata.George.ID}), testdata.GenderField.UUID)
+	rt.DB.MustExec(`DELETE FROM contacts_contactgroup_contacts WHERE contact_id = ANY($1)`, pq.Array([]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID}))
+	rt.DB.MustExec(`INSERT INTO contacts_contactgroup_contacts(contactgroup_id, contact_id) VALUES($1, $2), ($1, $3)`, testdata.DoctorsGroup.ID, testdata.Cathy.ID, testdata.Bob.ID)
+	testdata.InsertContactURN(rt, testdata.Org1, testdata.Bob, "whatsapp:250788373373", 999, nil)
+
+	testsuite.ReindexElastic(ctx)
+
+	// both backends should give the same results
+	for _, backend := range []string{search.BackendElastic, search.BackendPostgres} {
+		rt.Config.SearchBackend = backend
+
+		testsuite.RunWebTests(t, ctx, rt, "testdata/aggregate.json", map[string]string{
+			"doctors_uuid": string(testdata.DoctorsGroup.UUID),
+		})
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	rt.DB.MustExec(
+		`INSERT INTO contacts_contactduplicate(org_id, contact1_id, contact2_id, matchers, confidence, created_on) VALUES
+		($1, $2, $4, '{name_field,urn}', 0.97, NOW()), ($1, $3, $4, '{urn}', 0.9, NOW()), ($1, $2, $3, '{name_field}', 0.3, NOW())`,
+		testdata.Org1.ID, testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID,
+	)
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/duplicates.json", nil)
+}
+
+func TestFieldHistory(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+	defer func() { rt.Config.MaxFieldHistory = 0 }()
+
+	rt.Config.MaxFieldHistory = 20
+
+	rt.DB.MustExec(`UPDATE contacts_contact SET fields = COALESCE(fields, '{}') || jsonb_build_object($2::text, '{"text": "31", "number": 31}'::jsonb) WHERE id = $1`, testdata.Cathy.ID, testdata.AgeField.UUID)
+	rt.DB.MustExec(
+		`INSERT INTO contacts_contactfieldhistory(org_id, contact_id, field_id, old_value, new_value, flow_id, user_id, created_on) VALUES
+		($1, $2, $4, NULL, '{"text": "30", "number": 30}', $6, NULL, '2023-01-01T00:00:00Z'),
+		($1, $2, $4, '{"text": "30", "number": 30}', '{"text": "31", "number": 31}', NULL, $7, '2023-02-01T00:00:00Z'),
+		($1, $3, $5, NULL, '{"text": "M"}', NULL, $7, '2023-01-01T00:00:00Z')`,
+		testdata.Org1.ID, testdata.Cathy.ID, testdata.Bob.ID, testdata.AgeField.ID, testdata.GenderField.ID, testdata.Favorites.ID, testdata.Admin.ID,
+	)
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/field_history.json", nil)
+	testsuite.RunWebTests(t, ctx, rt, "testdata/field_history_restore.json", nil)
+}
+
+func TestSlowSearches(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/slow_searches.json", nil)
+}
+
 func TestParseQuery(t *testing.T) {
 	ctx, rt := testsuite.Runtime()
 
 	defer testsuite.Reset(testsuite.ResetAll)
 
+	testdata.InsertOptIn(rt, testdata.Org1, "Polls")
+
 	testsuite.RunWebTests(t, ctx, rt, "testdata/parse_query.json", nil)
 }
 
+func TestParseQueryCost(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer func() { rt.Config.SearchMaxQueryCost = 0 }()
+
+	rt.Config.SearchMaxQueryCost = 10
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/parse_query_cost.json", nil)
+}
+
 func TestSpecToCreation(t *testing.T) {
 	ctx, rt := testsuite.Runtime()
 
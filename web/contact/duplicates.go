@@ -0,0 +1,63 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/contact/duplicates", web.RequireAuthToken(web.JSONPayload(handleDuplicates)))
+}
+
+const defaultDuplicatesPageSize = 50
+
+// Returns the candidate duplicate contacts found by the last scan of an org, most confident first
+//
+//	{
+//	  "org_id": 1,
+//	  "min_confidence": 0.8,
+//	  "limit": 50,
+//	  "offset": 0
+//	}
+type duplicatesRequest struct {
+	OrgID         models.OrgID `json:"org_id"         validate:"required"`
+	MinConfidence float64      `json:"min_confidence" validate:"min=0,max=1"`
+	Limit         int          `json:"limit"          validate:"omitempty,min=1,max=1000"`
+	Offset        int          `json:"offset"         validate:"min=0"`
+}
+
+// Response for a duplicates request
+//
+//	{
+//	  "duplicates": [
+//	    {
+//	      "contact1": {"id": 10000, "uuid": "6393abc0-283d-4c9b-a1b3-641a035c34bf", "name": "Cathy"},
+//	      "contact2": {"id": 30000, "uuid": "b699a406-7e44-49be-9f01-1a82893e8a10", "name": "Cathy"},
+//	      "matchers": ["name_field", "urn"],
+//	      "confidence": 0.97
+//	    }
+//	  ]
+//	}
+type duplicatesResponse struct {
+	Duplicates []*models.ContactDuplicateInfo `json:"duplicates"`
+}
+
+// handles a request for the duplicate contacts of an org
+func handleDuplicates(ctx context.Context, rt *runtime.Runtime, r *duplicatesRequest) (any, int, error) {
+	limit := r.Limit
+	if limit == 0 {
+		limit = defaultDuplicatesPageSize
+	}
+
+	dupes, err := models.GetContactDuplicates(ctx, rt.DB, r.OrgID, r.MinConfidence, limit, r.Offset)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error getting contact duplicates")
+	}
+
+	return &duplicatesResponse{Duplicates: dupes}, http.StatusOK, nil
+}
@@ -0,0 +1,79 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/contact/field_history", web.RequireAuthToken(web.JSONPayload(handleFieldHistory)))
+}
+
+const defaultFieldHistoryPageSize = 50
+
+// Returns the history of field value changes for a contact, optionally for a single field.
+//
+//	{
+//	  "org_id": 1,
+//	  "contact_id": 10000,
+//	  "field_key": "age",
+//	  "limit": 50
+//	}
+type fieldHistoryRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	ContactID models.ContactID `json:"contact_id" validate:"required"`
+	FieldKey  string           `json:"field_key"`
+	Limit     int              `json:"limit"      validate:"omitempty,min=1,max=1000"`
+}
+
+// Response for a field history request
+//
+//	{
+//	  "history": [
+//	    {
+//	      "id": 12,
+//	      "field": {"key": "age", "name": "Age"},
+//	      "old_value": {"text": "30", "number": 30},
+//	      "new_value": {"text": "31", "number": 31},
+//	      "flow": {"uuid": "9de3663f-c5c5-4c92-9f45-ecbc09abcc85", "name": "Favorites"},
+//	      "created_on": "2023-04-03T12:00:00Z"
+//	    }
+//	  ]
+//	}
+type fieldHistoryResponse struct {
+	History []*models.ContactFieldHistoryItem `json:"history"`
+}
+
+// handles a request for the field history of a contact
+func handleFieldHistory(ctx context.Context, rt *runtime.Runtime, r *fieldHistoryRequest) (any, int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	fieldID := models.FieldID(0)
+	if r.FieldKey != "" {
+		field := oa.FieldByKey(r.FieldKey)
+		if field == nil {
+			return errors.Errorf("no such field with key '%s'", r.FieldKey), http.StatusBadRequest, nil
+		}
+		fieldID = field.ID()
+	}
+
+	limit := r.Limit
+	if limit == 0 {
+		limit = defaultFieldHistoryPageSize
+	}
+
+	history, err := models.GetContactFieldHistory(ctx, rt.DB, r.OrgID, r.ContactID, fieldID, limit)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error getting contact field history")
+	}
+
+	return &fieldHistoryResponse{History: history}, http.StatusOK, nil
+}
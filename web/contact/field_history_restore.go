@@ -0,0 +1,95 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/modifiers"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/contact/field_history/restore", web.RequireAuthToken(web.JSONPayload(handleFieldHistoryRestore)))
+}
+
+// Restores the old value of a change in the field history of a contact by applying a field modifier as the given user,
+// and returns the updated history of that field.
+//
+//	{
+//	  "org_id": 1,
+//	  "contact_id": 10000,
+//	  "change_id": 12,
+//	  "user_id": 3
+//	}
+type fieldHistoryRestoreRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	ContactID models.ContactID `json:"contact_id" validate:"required"`
+	ChangeID  int64            `json:"change_id"  validate:"required"`
+	UserID    models.UserID    `json:"user_id"    validate:"required"`
+}
+
+// handles a request to restore a field value from the field history of a contact
+func handleFieldHistoryRestore(ctx context.Context, rt *runtime.Runtime, r *fieldHistoryRestoreRequest) (any, int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	item, err := models.GetContactFieldHistoryItem(ctx, rt.DB, r.OrgID, r.ContactID, r.ChangeID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error loading field change to restore")
+	}
+	if item == nil {
+		return errors.Errorf("no such field change with id %d", r.ChangeID), http.StatusBadRequest, nil
+	}
+
+	if err := restoreFieldValue(ctx, rt, oa, r.ContactID, item, r.UserID); err != nil {
+		return nil, 0, err
+	}
+
+	field := oa.FieldByKey(item.Field.Key)
+	if field == nil {
+		return nil, 0, errors.Errorf("field '%s' no longer exists", item.Field.Key)
+	}
+
+	history, err := models.GetContactFieldHistory(ctx, rt.DB, r.OrgID, r.ContactID, field.ID(), defaultFieldHistoryPageSize)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error getting contact field history")
+	}
+
+	return &fieldHistoryResponse{History: history}, http.StatusOK, nil
+}
+
+// restores the old value of the given change by applying a field modifier to the contact
+func restoreFieldValue(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, contactID models.ContactID, item *models.ContactFieldHistoryItem, userID models.UserID) error {
+	field := oa.SessionAssets().Fields().Get(item.Field.Key)
+	if field == nil {
+		return errors.Errorf("field '%s' no longer exists", item.Field.Key)
+	}
+
+	// a null old value means the field was empty
+	var oldValue struct {
+		Text string `json:"text"`
+	}
+	if len(item.OldValue) > 0 {
+		if err := jsonx.Unmarshal(item.OldValue, &oldValue); err != nil {
+			return errors.Wrap(err, "error unmarshaling old field value")
+		}
+	}
+
+	mods := []flows.Modifier{modifiers.NewField(field, oldValue.Text)}
+
+	_, skipped, err := tryToLockAndModify(ctx, rt, oa, []models.ContactID{contactID}, mods, userID)
+	if err != nil {
+		return errors.Wrap(err, "error restoring field value")
+	}
+	if len(skipped) > 0 {
+		return errors.Errorf("unable to lock contact %d to restore field value", contactID)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/contactql"
 	"github.com/nyaruka/mailroom/core/models"
@@ -24,6 +25,10 @@ func init() {
 //	  "query": "age > 10",
 //	  "group_id": 234
 //	}
+//
+// Queries can also use relative dates and ages, e.g. `last_seen_on < "30 days ago"` or `birthdate.age >= 18`, see
+// models.RelativeDateCondition. These are resolved to the current day for searching but kept in the normalized
+// query which is returned.
 type parseRequest struct {
 	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
 	Query     string           `json:"query"      validate:"required"`
@@ -52,7 +57,7 @@ type parseResponse struct {
 
 // handles a query parsing request
 func handleParseQuery(ctx context.Context, rt *runtime.Runtime, r *parseRequest) (any, int, error) {
-	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups)
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups|models.RefreshOptIns)
 	if err != nil {
 		return nil, 0, errors.Wrapf(err, "unable to load org assets")
 	}
@@ -64,13 +69,18 @@ func handleParseQuery(ctx context.Context, rt *runtime.Runtime, r *parseRequest)
 		group = oa.GroupByUUID(r.GroupUUID)
 	}
 
-	env := oa.Env()
-	var resolver contactql.Resolver
-	if !r.ParseOnly {
-		resolver = oa.SessionAssets()
+	parse := func(q string) (*contactql.ContactQuery, error) {
+		if r.ParseOnly {
+			today := dates.ExtractDate(dates.Now().In(oa.Env().Timezone()))
+			return contactql.ParseQuery(oa.Env(), models.ResolveRelativeDates(oa, q, today), nil)
+		}
+		return search.ParseQuery(oa, q)
 	}
 
-	parsed, err := contactql.ParseQuery(env, r.Query, resolver)
+	parsed, err := parse(r.Query)
+	if err == nil {
+		err = search.CheckQueryCost(rt, parsed)
+	}
 	if err != nil {
 		isQueryError, qerr := contactql.IsQueryError(err)
 		if isQueryError {
@@ -79,13 +89,33 @@ func handleParseQuery(ctx context.Context, rt *runtime.Runtime, r *parseRequest)
 		return nil, 0, err
 	}
 
-	// normalize and inspect the query
+	// normalize the query, keeping any relative dates so that it's saved with them and not the dates they resolve to
 	normalized := parsed.String()
-	metadata := contactql.Inspect(parsed)
+	if len(models.FindRelativeDateConditions(oa, r.Query)) > 0 {
+		normalized, err = models.NormalizeRelativeQuery(oa, r.Query, func(q string) (string, error) {
+			p, err := parse(q)
+			if err != nil {
+				return "", err
+			}
+			return p.String(), nil
+		})
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "error normalizing query")
+		}
+	}
+	metadata := search.InspectQuery(parsed)
 
+	// the elastic query is only built if that's where we're searching, as not all queries can be searched with elastic
 	var elasticSource any
-	if !r.ParseOnly {
-		eq := search.BuildElasticQuery(oa, group, models.NilContactStatus, nil, parsed)
+	if !r.ParseOnly && rt.Config.SearchBackend != search.BackendPostgres {
+		eq, err := search.BuildElasticQuery(oa, group, models.NilContactStatus, nil, parsed)
+		if err != nil {
+			isQueryError, qerr := contactql.IsQueryError(err)
+			if isQueryError {
+				return qerr, http.StatusBadRequest, nil
+			}
+			return nil, 0, errors.Wrap(err, "error building elastic query")
+		}
 		elasticSource, err = eq.Source()
 		if err != nil {
 			return nil, 0, errors.Wrap(err, "error getting elastic source")
@@ -61,7 +61,7 @@ type SearchResponse struct {
 
 // handles a contact search request
 func handleSearch(ctx context.Context, rt *runtime.Runtime, r *searchRequest) (any, int, error) {
-	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups)
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups|models.RefreshOptIns)
 	if err != nil {
 		return nil, 0, errors.Wrapf(err, "unable to load org assets")
 	}
@@ -85,7 +85,7 @@ func handleSearch(ctx context.Context, rt *runtime.Runtime, r *searchRequest) (a
 
 	if parsed != nil {
 		normalized = parsed.String()
-		metadata = contactql.Inspect(parsed)
+		metadata = search.InspectQuery(parsed)
 	}
 
 	// build our response
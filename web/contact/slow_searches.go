@@ -0,0 +1,46 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/contact/slow_searches", web.RequireAuthToken(web.JSONPayload(handleSlowSearches)))
+}
+
+// Returns the slowest recent contact searches, optionally for a single org
+//
+//	{
+//	  "org_id": 1
+//	}
+type slowSearchesRequest struct {
+	OrgID models.OrgID `json:"org_id"`
+}
+
+// Response for a slow searches request
+//
+//	{
+//	  "searches": [
+//	    {"org_id": 1, "type": "page", "query": "urn ~ 078", "elapsed_ms": 6210, "searched_on": "2023-04-03T12:00:00Z"}
+//	  ]
+//	}
+type slowSearchesResponse struct {
+	Searches []*search.SlowSearch `json:"searches"`
+}
+
+// handles a request for the slow searches report
+func handleSlowSearches(ctx context.Context, rt *runtime.Runtime, r *slowSearchesRequest) (any, int, error) {
+	searches, err := search.GetSlowSearches(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error getting slow searches")
+	}
+
+	return &slowSearchesResponse{Searches: searches}, http.StatusOK, nil
+}
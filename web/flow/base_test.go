@@ -11,8 +11,11 @@ func TestServer(t *testing.T) {
 
 	testsuite.RunWebTests(t, ctx, rt, "testdata/change_language.json", nil)
 	testsuite.RunWebTests(t, ctx, rt, "testdata/clone.json", nil)
+	testsuite.RunWebTests(t, ctx, rt, "testdata/diff.json", nil)
 	testsuite.RunWebTests(t, ctx, rt, "testdata/inspect.json", nil)
 	testsuite.RunWebTests(t, ctx, rt, "testdata/migrate.json", nil)
+	testsuite.RunWebTests(t, ctx, rt, "testdata/stats.json", nil)
+	testsuite.RunWebTests(t, ctx, rt, "testdata/test.json", nil)
 }
 
 func TestStartPreview(t *testing.T) {
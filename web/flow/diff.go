@@ -0,0 +1,283 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/goflow"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/flow/diff", web.RequireAuthToken(web.JSONPayload(handleDiff)))
+}
+
+// Compares two revisions of a flow, reporting which nodes were added, removed or changed, and for changed nodes,
+// which of their actions and exits were added, removed or changed. Both revisions are migrated to the latest spec
+// before they are compared. Waiting sessions which are on nodes that would be removed are reported and can be
+// interrupted by setting sessions to "interrupt". By default they are left.
+//
+//	{
+//	  "org_id": 1,
+//	  "old": {"uuid": "468621a8-32e6-4cd2-afc1-04416f7151f0", "nodes": [...]},
+//	  "new": {"uuid": "468621a8-32e6-4cd2-afc1-04416f7151f0", "nodes": [...]},
+//	  "sessions": "leave"
+//	}
+//
+//	{
+//	  "nodes": {
+//	    "added": ["b4b6d4a4-5f8e-4b2b-a5b5-0a1a4e6b8e8d"],
+//	    "removed": ["3dcccbb4-d29c-41dd-a01f-16d814c9ab82"],
+//	    "changed": [
+//	      {
+//	        "uuid": "6fde1a09-3997-47dd-aff0-92e8aff3a642",
+//	        "actions": {"added": [], "removed": [], "changed": ["05a5cb7c-bb8a-4ad9-af90-ef9887cc370e"]},
+//	        "exits": {"added": [], "removed": [], "changed": ["d3f3f024-a90e-43a5-bd5a-7056f5bea699"]},
+//	        "router_changed": false
+//	      }
+//	    ]
+//	  },
+//	  "waiting_sessions": [{"node_uuid": "3dcccbb4-d29c-41dd-a01f-16d814c9ab82", "count": 12}],
+//	  "interrupted": 0
+//	}
+type diffRequest struct {
+	OrgID    models.OrgID    `json:"org_id"   validate:"required"`
+	Old      json.RawMessage `json:"old"      validate:"required"`
+	New      json.RawMessage `json:"new"      validate:"required"`
+	Sessions string          `json:"sessions" validate:"omitempty,eq=leave|eq=interrupt"`
+}
+
+type itemChanges[K any] struct {
+	Added   []K `json:"added"`
+	Removed []K `json:"removed"`
+	Changed []K `json:"changed"`
+}
+
+func (c *itemChanges[K]) isEmpty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+type nodeChanges struct {
+	UUID          flows.NodeUUID                 `json:"uuid"`
+	Actions       *itemChanges[flows.ActionUUID] `json:"actions"`
+	Exits         *itemChanges[flows.ExitUUID]   `json:"exits"`
+	RouterChanged bool                           `json:"router_changed"`
+}
+
+type waitingOnNode struct {
+	NodeUUID flows.NodeUUID `json:"node_uuid"`
+	Count    int            `json:"count"`
+}
+
+type diffResponse struct {
+	Nodes struct {
+		Added   []flows.NodeUUID `json:"added"`
+		Removed []flows.NodeUUID `json:"removed"`
+		Changed []*nodeChanges   `json:"changed"`
+	} `json:"nodes"`
+	WaitingSessions []*waitingOnNode `json:"waiting_sessions"`
+	Interrupted     int              `json:"interrupted"`
+}
+
+func handleDiff(ctx context.Context, rt *runtime.Runtime, r *diffRequest) (any, int, error) {
+	oldFlow, err := goflow.ReadFlow(rt.Config, r.Old)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read old flow"), http.StatusUnprocessableEntity, nil
+	}
+	newFlow, err := goflow.ReadFlow(rt.Config, r.New)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read new flow"), http.StatusUnprocessableEntity, nil
+	}
+	if oldFlow.UUID() != newFlow.UUID() {
+		return errors.New("old and new flows must have the same UUID"), http.StatusBadRequest, nil
+	}
+
+	resp := &diffResponse{WaitingSessions: make([]*waitingOnNode, 0)}
+	resp.Nodes.Added, resp.Nodes.Removed, resp.Nodes.Changed, err = diffNodes(oldFlow.Nodes(), newFlow.Nodes())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	// if this flow hasn't been saved yet, it can't have any sessions
+	flow, err := oa.FlowByUUID(newFlow.UUID())
+	if err == models.ErrNotFound {
+		return resp, http.StatusOK, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load flow")
+	}
+
+	waiting, err := models.GetWaitingSessionsOnNodes(ctx, rt.DB, flow.(*models.Flow).ID(), resp.Nodes.Removed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sessionIDs := make([]models.SessionID, 0)
+	seen := make(map[models.SessionID]bool)
+
+	for _, nodeUUID := range resp.Nodes.Removed {
+		if ids := waiting[nodeUUID]; len(ids) > 0 {
+			resp.WaitingSessions = append(resp.WaitingSessions, &waitingOnNode{NodeUUID: nodeUUID, Count: len(ids)})
+
+			for _, id := range ids {
+				if !seen[id] {
+					sessionIDs = append(sessionIDs, id)
+					seen[id] = true
+				}
+			}
+		}
+	}
+
+	if r.Sessions == "interrupt" && len(sessionIDs) > 0 {
+		if err := models.ExitSessions(ctx, rt.DB, sessionIDs, models.SessionStatusInterrupted); err != nil {
+			return nil, 0, errors.Wrap(err, "error interrupting sessions")
+		}
+		resp.Interrupted = len(sessionIDs)
+	}
+
+	return resp, http.StatusOK, nil
+}
+
+func diffNodes(oldNodes, newNodes []flows.Node) ([]flows.NodeUUID, []flows.NodeUUID, []*nodeChanges, error) {
+	oldByUUID := make(map[flows.NodeUUID]flows.Node, len(oldNodes))
+	for _, n := range oldNodes {
+		oldByUUID[n.UUID()] = n
+	}
+	newByUUID := make(map[flows.NodeUUID]flows.Node, len(newNodes))
+	for _, n := range newNodes {
+		newByUUID[n.UUID()] = n
+	}
+
+	added := make([]flows.NodeUUID, 0)
+	removed := make([]flows.NodeUUID, 0)
+	changed := make([]*nodeChanges, 0)
+
+	for _, n := range oldNodes {
+		if newByUUID[n.UUID()] == nil {
+			removed = append(removed, n.UUID())
+		}
+	}
+
+	for _, newNode := range newNodes {
+		oldNode := oldByUUID[newNode.UUID()]
+		if oldNode == nil {
+			added = append(added, newNode.UUID())
+			continue
+		}
+
+		c, err := diffNode(oldNode, newNode)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if c != nil {
+			changed = append(changed, c)
+		}
+	}
+
+	return added, removed, changed, nil
+}
+
+// compares two versions of the same node, returning nil if they're the same
+func diffNode(oldNode, newNode flows.Node) (*nodeChanges, error) {
+	oldActions, newActions := make([]item[flows.ActionUUID], 0), make([]item[flows.ActionUUID], 0)
+	for _, a := range oldNode.Actions() {
+		oldActions = append(oldActions, item[flows.ActionUUID]{a.UUID(), a})
+	}
+	for _, a := range newNode.Actions() {
+		newActions = append(newActions, item[flows.ActionUUID]{a.UUID(), a})
+	}
+	actions, err := diffItems(oldActions, newActions)
+	if err != nil {
+		return nil, errors.Wrap(err, "error comparing actions")
+	}
+
+	oldExits, newExits := make([]item[flows.ExitUUID], 0), make([]item[flows.ExitUUID], 0)
+	for _, e := range oldNode.Exits() {
+		oldExits = append(oldExits, item[flows.ExitUUID]{e.UUID(), e})
+	}
+	for _, e := range newNode.Exits() {
+		newExits = append(newExits, item[flows.ExitUUID]{e.UUID(), e})
+	}
+	exits, err := diffItems(oldExits, newExits)
+	if err != nil {
+		return nil, errors.Wrap(err, "error comparing exits")
+	}
+
+	routerChanged, err := differs(oldNode.Router(), newNode.Router())
+	if err != nil {
+		return nil, errors.Wrap(err, "error comparing routers")
+	}
+
+	if actions.isEmpty() && exits.isEmpty() && !routerChanged {
+		return nil, nil
+	}
+
+	return &nodeChanges{UUID: newNode.UUID(), Actions: actions, Exits: exits, RouterChanged: routerChanged}, nil
+}
+
+// an identifiable part of a flow
+type item[K comparable] struct {
+	uuid  K
+	value any
+}
+
+func diffItems[K comparable](oldItems, newItems []item[K]) (*itemChanges[K], error) {
+	changes := &itemChanges[K]{Added: make([]K, 0), Removed: make([]K, 0), Changed: make([]K, 0)}
+
+	oldByUUID := make(map[K]any, len(oldItems))
+	for _, i := range oldItems {
+		oldByUUID[i.uuid] = i.value
+	}
+	newByUUID := make(map[K]any, len(newItems))
+	for _, i := range newItems {
+		newByUUID[i.uuid] = i.value
+	}
+
+	for _, i := range oldItems {
+		if _, exists := newByUUID[i.uuid]; !exists {
+			changes.Removed = append(changes.Removed, i.uuid)
+		}
+	}
+
+	for _, i := range newItems {
+		oldValue, exists := oldByUUID[i.uuid]
+		if !exists {
+			changes.Added = append(changes.Added, i.uuid)
+			continue
+		}
+
+		d, err := differs(oldValue, i.value)
+		if err != nil {
+			return nil, err
+		}
+		if d {
+			changes.Changed = append(changes.Changed, i.uuid)
+		}
+	}
+
+	return changes, nil
+}
+
+// parts of a flow are compared by their JSON representations
+func differs(v1, v2 any) (bool, error) {
+	j1, err := jsonx.Marshal(v1)
+	if err != nil {
+		return false, err
+	}
+	j2, err := jsonx.Marshal(v2)
+	if err != nil {
+		return false, err
+	}
+	return string(j1) != string(j2), nil
+}
@@ -19,7 +19,8 @@ func init() {
 
 // Inspects a flow, and returns metadata including the possible results generated by the flow,
 // and dependencies in the flow. If `org_id` is specified then the dependencies will be checked
-// to see if they exist in the org assets.
+// to see if they exist in the org assets, and any templates used will be validated against the
+// translations available for each channel.
 //
 //	{
 //	  "flow": { "uuid": "468621a8-32e6-4cd2-afc1-04416f7151f0", "nodes": [...]},
@@ -30,6 +31,11 @@ type inspectRequest struct {
 	OrgID models.OrgID    `json:"org_id"`
 }
 
+type inspectResponse struct {
+	*flows.Inspection
+	TemplateIssues []*models.TemplateIssue `json:"template_issues,omitempty"`
+}
+
 func handleInspect(ctx context.Context, rt *runtime.Runtime, r *inspectRequest) (any, int, error) {
 	flow, err := goflow.ReadFlow(rt.Config, r.Flow)
 	if err != nil {
@@ -37,14 +43,17 @@ func handleInspect(ctx context.Context, rt *runtime.Runtime, r *inspectRequest)
 	}
 
 	var sa flows.SessionAssets
+	var templateIssues []*models.TemplateIssue
+
 	// if we have an org ID, create session assets to look for missing dependencies
 	if r.OrgID != models.NilOrgID {
-		oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups|models.RefreshFlows)
+		oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshFields|models.RefreshGroups|models.RefreshFlows|models.RefreshTemplates)
 		if err != nil {
 			return nil, 0, err
 		}
 		sa = oa.SessionAssets()
+		templateIssues = models.ValidateFlowTemplates(oa, flow)
 	}
 
-	return flow.Inspect(sa), http.StatusOK, nil
+	return &inspectResponse{Inspection: flow.Inspect(sa), TemplateIssues: templateIssues}, http.StatusOK, nil
 }
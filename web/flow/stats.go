@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+// path stats are only kept for this many days
+const maxStatsDays = 90
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/flow/stats", web.RequireAuthToken(web.JSONPayload(handleStats)))
+}
+
+// Gets daily path analytics for a flow, i.e. for each node, how many contacts exited it, the average time they spent
+// on it, how many runs ended on it without completing, and the distribution of operand values for nodes with routers.
+// Days are UTC and default to the last 30 days.
+//
+//	{
+//	  "org_id": 1,
+//	  "flow_id": 2,
+//	  "since": "2024-01-01",
+//	  "until": "2024-01-31"
+//	}
+//
+//	{
+//	  "days": [
+//	    {
+//	      "day": "2024-01-02",
+//	      "nodes": {
+//	        "072b95b3-61c3-4e0e-8dd1-eb7481083f94": {"exits": 3, "average_dwell": 12.5, "dropoffs": 1, "operands": {"blue": 2, "red": 1}}
+//	      }
+//	    }
+//	  ]
+//	}
+type statsRequest struct {
+	OrgID  models.OrgID  `json:"org_id"  validate:"required"`
+	FlowID models.FlowID `json:"flow_id" validate:"required"`
+	Since  string        `json:"since"`
+	Until  string        `json:"until"`
+}
+
+type statsResponse struct {
+	Days []*models.FlowPathDay `json:"days"`
+}
+
+func handleStats(ctx context.Context, rt *runtime.Runtime, r *statsRequest) (any, int, error) {
+	until := dates.Now().UTC()
+	if r.Until != "" {
+		var err error
+		if until, err = time.Parse(time.DateOnly, r.Until); err != nil {
+			return errors.Errorf("invalid until date: %s", r.Until), http.StatusBadRequest, nil
+		}
+	}
+
+	since := until.AddDate(0, 0, -29)
+	if r.Since != "" {
+		var err error
+		if since, err = time.Parse(time.DateOnly, r.Since); err != nil {
+			return errors.Errorf("invalid since date: %s", r.Since), http.StatusBadRequest, nil
+		}
+	}
+
+	if since.After(until) {
+		return errors.New("since must be before until"), http.StatusBadRequest, nil
+	}
+	if until.Sub(since) >= time.Hour*24*maxStatsDays {
+		return errors.Errorf("can't request more than %d days of stats", maxStatsDays), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	flow, err := oa.FlowByID(r.FlowID)
+	if err == models.ErrNotFound {
+		return errors.Errorf("no such flow with id %d", r.FlowID), http.StatusBadRequest, nil
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load flow")
+	}
+
+	sflow, err := oa.SessionAssets().Flows().Get(flow.UUID())
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to read flow")
+	}
+
+	days, err := models.LoadFlowPathStats(ctx, rt, r.FlowID, sflow, since, until)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &statsResponse{Days: days}, http.StatusOK, nil
+}
@@ -0,0 +1,296 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/flows/resumes"
+	"github.com/nyaruka/goflow/flows/triggers"
+	"github.com/nyaruka/mailroom/core/goflow"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/nyaruka/mailroom/web/simulation"
+	"github.com/pkg/errors"
+)
+
+// test cases are run against the same channel as simulations so that messages can be sent and calls made
+var testChannelRef = assets.NewChannelReference(simulation.TestChannel.UUID(), simulation.TestChannel.Name())
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/flow/test", web.RequireAuthToken(web.JSONPayload(handleTest)))
+}
+
+// Runs a flow against a set of test cases, each of which starts a new session for a contact and resumes it with each
+// of its inputs in turn, and then checks what happened against what was expected. Expected messages must match all
+// messages sent in order, whereas expected results and fields only need to match the ones given. The expected exit is
+// the status of the session after the last input. The flow must already exist in the org but the given definition
+// is used instead of its saved one, so that unsaved changes can be tested.
+//
+//	{
+//	  "org_id": 1,
+//	  "flow": {"uuid": "468621a8-32e6-4cd2-afc1-04416f7151f0", "nodes": [...]},
+//	  "cases": [
+//	    {
+//	      "name": "likes red",
+//	      "contact": {"name": "Ann", "language": "eng", "fields": {"age": "33"}},
+//	      "inputs": ["red"],
+//	      "expect": {
+//	        "msgs": ["What is your favorite color?", "Red is a great color!"],
+//	        "results": {"color": {"value": "red", "category": "Red"}},
+//	        "fields": {"color": "red"},
+//	        "exit": "completed"
+//	      }
+//	    }
+//	  ]
+//	}
+//
+//	{
+//	  "passed": 0,
+//	  "failed": 1,
+//	  "cases": [
+//	    {
+//	      "name": "likes red",
+//	      "passed": false,
+//	      "failures": ["expected exit completed but was waiting"],
+//	      "msgs": ["What is your favorite color?", "Red is a great color!"],
+//	      "exit": "waiting"
+//	    }
+//	  ]
+//	}
+type testRequest struct {
+	OrgID models.OrgID    `json:"org_id" validate:"required"`
+	Flow  json.RawMessage `json:"flow"   validate:"required"`
+	Cases []*testCase     `json:"cases"  validate:"required,min=1,max=100,dive"`
+}
+
+type testCase struct {
+	Name    string `json:"name" validate:"required"`
+	Contact struct {
+		Name     string            `json:"name"`
+		Language i18n.Language     `json:"language"`
+		URNs     []urns.URN        `json:"urns"`
+		Fields   map[string]string `json:"fields"`
+	} `json:"contact"`
+	Inputs []string `json:"inputs"`
+	Expect struct {
+		Msgs    []string                       `json:"msgs"`
+		Results map[string]*testExpectedResult `json:"results"`
+		Fields  map[string]string              `json:"fields"`
+		Exit    flows.SessionStatus            `json:"exit"`
+	} `json:"expect"`
+}
+
+type testExpectedResult struct {
+	Value    *string `json:"value"`
+	Category *string `json:"category"`
+}
+
+type testCaseResult struct {
+	Name     string              `json:"name"`
+	Passed   bool                `json:"passed"`
+	Failures []string            `json:"failures"`
+	Msgs     []string            `json:"msgs"`
+	Exit     flows.SessionStatus `json:"exit"`
+}
+
+type testResponse struct {
+	Passed int               `json:"passed"`
+	Failed int               `json:"failed"`
+	Cases  []*testCaseResult `json:"cases"`
+}
+
+func handleTest(ctx context.Context, rt *runtime.Runtime, r *testRequest) (any, int, error) {
+	flow, err := goflow.ReadFlow(rt.Config, r.Flow)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read flow"), http.StatusUnprocessableEntity, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	oa, err = oa.CloneForSimulation(ctx, rt, map[assets.FlowUUID]json.RawMessage{flow.UUID(): r.Flow}, []assets.Channel{simulation.TestChannel})
+	if err != nil {
+		return errors.Wrapf(err, "unable to clone org"), http.StatusBadRequest, nil
+	}
+
+	resp := &testResponse{Cases: make([]*testCaseResult, len(r.Cases))}
+
+	for i, tc := range r.Cases {
+		result, err := runTestCase(rt, oa, flow, tc)
+		if err != nil {
+			return errors.Wrapf(err, "unable to run case '%s'", tc.Name), http.StatusBadRequest, nil
+		}
+
+		if result.Passed {
+			resp.Passed++
+		} else {
+			resp.Failed++
+		}
+		resp.Cases[i] = result
+	}
+
+	return resp, http.StatusOK, nil
+}
+
+// runs a single test case, only returning an error if the test case itself is invalid
+func runTestCase(rt *runtime.Runtime, oa *models.OrgAssets, flow flows.Flow, tc *testCase) (*testCaseResult, error) {
+	sa := oa.SessionAssets()
+	env := oa.Env()
+
+	contact, err := newTestContact(oa, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	// inputs come from, and calls are made to, the contact's first URN
+	urn := contact.URNs()[0].URN()
+
+	tb := triggers.NewBuilder(env, flow.Reference(false), contact).Manual()
+	if flow.Type() == flows.FlowTypeVoice {
+		tb = tb.WithCall(testChannelRef, urn)
+	}
+
+	session, sprint, err := goflow.Simulator(rt.Config).NewSession(sa, tb.Build())
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting session")
+	}
+
+	result := &testCaseResult{Name: tc.Name, Failures: make([]string, 0), Msgs: msgsForEvents(nil, sprint.Events())}
+
+	for i, input := range tc.Inputs {
+		if session.Status() != flows.SessionStatusWaiting {
+			result.Failures = append(result.Failures, fmt.Sprintf("session ended before input %d", i+1))
+			break
+		}
+
+		msg := flows.NewMsgIn(flows.MsgUUID(uuids.New()), urn, testChannelRef, input, nil)
+
+		sprint, err = session.Resume(resumes.NewMsg(env, session.Contact(), msg))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error resuming session with input %d", i+1)
+		}
+
+		result.Msgs = msgsForEvents(result.Msgs, sprint.Events())
+	}
+
+	result.Exit = session.Status()
+	result.Failures = append(result.Failures, checkTestCase(session, tc, result)...)
+	result.Passed = len(result.Failures) == 0
+
+	return result, nil
+}
+
+// creates the contact for a test case, with a default URN if the test case doesn't specify any
+func newTestContact(oa *models.OrgAssets, tc *testCase) (*flows.Contact, error) {
+	sa := oa.SessionAssets()
+
+	contact := flows.NewEmptyContact(sa, tc.Contact.Name, tc.Contact.Language, nil)
+
+	contactURNs := tc.Contact.URNs
+	if len(contactURNs) == 0 {
+		contactURNs = []urns.URN{simulation.TestURN}
+	}
+	for _, urn := range contactURNs {
+		norm := urn.Normalize(string(oa.Env().DefaultCountry()))
+		if err := norm.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid URN '%s'", urn)
+		}
+		contact.AddURN(norm, nil)
+	}
+
+	for key, value := range tc.Contact.Fields {
+		field := sa.Fields().Get(key)
+		if field == nil {
+			return nil, errors.Errorf("no such field '%s'", key)
+		}
+		contact.Fields().Set(field, contact.Fields().Parse(oa.Env(), sa.Fields(), field, value))
+	}
+
+	return contact, nil
+}
+
+// appends the text of any messages or IVR prompts in the given events
+func msgsForEvents(msgs []string, es []flows.Event) []string {
+	if msgs == nil {
+		msgs = make([]string, 0)
+	}
+
+	for _, e := range es {
+		switch typed := e.(type) {
+		case *events.MsgCreatedEvent:
+			msgs = append(msgs, typed.Msg.Text())
+		case *events.IVRCreatedEvent:
+			msgs = append(msgs, typed.Msg.Text())
+		}
+	}
+	return msgs
+}
+
+// checks the expectations of a test case against the session, returning a description of each failure
+func checkTestCase(session flows.Session, tc *testCase, result *testCaseResult) []string {
+	failures := make([]string, 0)
+
+	if tc.Expect.Msgs != nil && !slices.Equal(tc.Expect.Msgs, result.Msgs) {
+		failures = append(failures, fmt.Sprintf("expected msgs %q but got %q", tc.Expect.Msgs, result.Msgs))
+	}
+
+	// gather results from all runs, e.g. including those of subflows
+	results := make(flows.Results)
+	for _, run := range session.Runs() {
+		for key, r := range run.Results() {
+			results[key] = r
+		}
+	}
+
+	for _, key := range sortedKeys(tc.Expect.Results) {
+		expected, actual := tc.Expect.Results[key], results[key]
+		if actual == nil {
+			failures = append(failures, fmt.Sprintf("expected result '%s' but it wasn't saved", key))
+			continue
+		}
+		if expected.Value != nil && *expected.Value != actual.Value {
+			failures = append(failures, fmt.Sprintf("expected result '%s' to have value '%s' but was '%s'", key, *expected.Value, actual.Value))
+		}
+		if expected.Category != nil && *expected.Category != actual.Category {
+			failures = append(failures, fmt.Sprintf("expected result '%s' to have category '%s' but was '%s'", key, *expected.Category, actual.Category))
+		}
+	}
+
+	for _, key := range sortedKeys(tc.Expect.Fields) {
+		expected, actual := tc.Expect.Fields[key], ""
+		if v := session.Contact().Fields()[key]; v != nil {
+			actual = v.Text.Native()
+		}
+		if expected != actual {
+			failures = append(failures, fmt.Sprintf("expected field '%s' to be '%s' but was '%s'", key, expected, actual))
+		}
+	}
+
+	if tc.Expect.Exit != "" && tc.Expect.Exit != session.Status() {
+		failures = append(failures, fmt.Sprintf("expected exit %s but was %s", tc.Expect.Exit, session.Status()))
+	}
+
+	return failures
+}
+
+// so that failures are reported in a consistent order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,65 @@
+package ivr
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/ivr/calls", web.RequireAuthToken(web.JSONPayload(handleCalls)))
+}
+
+// Lists the wired or in progress calls on a channel, with where each contact currently is in their flow.
+//
+//	{
+//	  "org_id": 1,
+//	  "channel_id": 10000
+//	}
+type callsRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	ChannelID models.ChannelID `json:"channel_id" validate:"required"`
+}
+
+// Response for a calls request.
+//
+//	{
+//	  "calls": [
+//	    {
+//	      "id": 1234,
+//	      "external_id": "CA1234",
+//	      "status": "I",
+//	      "contact": {"uuid": "6393abc0-283d-4c9b-a1b3-641a035c34bf", "name": "Cathy"},
+//	      "flow": {"uuid": "2f81d0ea-4d75-4843-9371-3f7465311cce", "name": "IVR Flow"},
+//	      "current_node_uuid": "5fa8c4e4-4ae5-4a5e-8c2d-2e4b0b8b2d7c",
+//	      "started_on": "2023-10-18T12:00:00Z",
+//	      "duration": 34
+//	    }
+//	  ]
+//	}
+type callsResponse struct {
+	Calls []*models.ActiveCall `json:"calls"`
+}
+
+func handleCalls(ctx context.Context, rt *runtime.Runtime, r *callsRequest) (any, int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	if oa.ChannelByID(r.ChannelID) == nil {
+		return errors.Errorf("no such channel with id %d", r.ChannelID), http.StatusBadRequest, nil
+	}
+
+	calls, err := models.LoadActiveCalls(ctx, rt.DB, r.OrgID, r.ChannelID, dates.Now())
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error loading active calls")
+	}
+
+	return &callsResponse{Calls: calls}, http.StatusOK, nil
+}
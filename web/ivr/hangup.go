@@ -0,0 +1,96 @@
+package ivr
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/tasks"
+	ivrtasks "github.com/nyaruka/mailroom/core/tasks/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/ivr/hangup", web.RequireAuthToken(web.JSONPayload(handleHangup)))
+}
+
+// Hangs up the given calls, or all calls on the given channel. Calls are failed and their sessions interrupted
+// immediately, and hanging them up through the IVR provider is queued. When hanging up a channel, calls which are yet
+// to be wired or waiting to be retried are also failed, as are any pending or started flow starts with calls on that
+// channel so that their remaining batches are skipped.
+//
+//	{
+//	  "org_id": 1,
+//	  "channel_id": 10000,
+//	  "call_ids": [1234, 2345]
+//	}
+type hangupRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	ChannelID models.ChannelID `json:"channel_id"`
+	CallIDs   []models.CallID  `json:"call_ids"`
+}
+
+// Response for a hangup request.
+//
+//	{
+//	  "hungup": [1234, 2345],
+//	  "cancelled": 12,
+//	  "failed_starts": 1
+//	}
+type hangupResponse struct {
+	Hungup       []models.CallID `json:"hungup"`
+	Cancelled    int             `json:"cancelled"`
+	FailedStarts int             `json:"failed_starts"`
+}
+
+// max number of calls hung up at the provider by a single task
+const hangupBatchSize = 100
+
+func handleHangup(ctx context.Context, rt *runtime.Runtime, r *hangupRequest) (any, int, error) {
+	if r.ChannelID == models.NilChannelID && len(r.CallIDs) == 0 {
+		return errors.New("must provide channel_id or call_ids"), http.StatusBadRequest, nil
+	}
+
+	resp := &hangupResponse{}
+
+	// fail starts first so that batches which are still queued don't request new calls on this channel
+	if r.ChannelID != models.NilChannelID {
+		var err error
+		resp.FailedStarts, err = models.FailStartsForChannel(ctx, rt.DB, r.OrgID, r.ChannelID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	callIDs, err := models.FailActiveCalls(ctx, rt.DB, r.OrgID, r.ChannelID, r.CallIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Hungup = callIDs
+
+	if err := models.InterruptSessionsForCalls(ctx, rt.DB, callIDs); err != nil {
+		return nil, 0, errors.Wrap(err, "error interrupting sessions")
+	}
+
+	if r.ChannelID != models.NilChannelID {
+		resp.Cancelled, err = models.FailPendingCallsForChannel(ctx, rt.DB, r.OrgID, r.ChannelID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	for _, batch := range models.ChunkSlice(callIDs, hangupBatchSize) {
+		task := &ivrtasks.HangupCallsTask{CallIDs: batch}
+		if err := tasks.Queue(rc, queue.BatchQueue, r.OrgID, task, queue.DefaultPriority); err != nil {
+			return nil, 0, errors.Wrap(err, "error queuing hangup calls task")
+		}
+	}
+
+	return resp, http.StatusOK, nil
+}
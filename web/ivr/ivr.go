@@ -15,7 +15,10 @@ import (
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/tasks"
 	"github.com/nyaruka/mailroom/core/tasks/handler"
+	ivrtasks "github.com/nyaruka/mailroom/core/tasks/ivr"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/nyaruka/mailroom/web"
 	"github.com/pkg/errors"
@@ -285,13 +288,38 @@ func handleStatus(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets
 		return nil, svc.WriteErrorResponse(w, errors.Wrapf(err, "unable to load call with id: %s", externalID))
 	}
 
+	wasActive := isActiveCall(conn)
+
 	err = ivr.HandleIVRStatus(ctx, rt, oa, svc, conn, r, w)
 
 	// had an error? mark our call as errored and log it
 	if err != nil {
 		slog.Error("error while handling status", "error", err, "http_request", r)
-		return conn, ivr.HandleAsFailure(ctx, rt.DB, svc, conn, w, err)
+		err = ivr.HandleAsFailure(ctx, rt.DB, svc, conn, w, err)
 	}
 
-	return conn, nil
+	// if this call has ended, there may be a slot free for any calls queued on this channel
+	if wasActive && !isActiveCall(conn) {
+		if qerr := queueStartQueuedCalls(rt, oa, ch); qerr != nil {
+			slog.Error("error queuing start of queued calls", "error", qerr, "channel", ch.UUID())
+		}
+	}
+
+	return conn, err
+}
+
+func isActiveCall(call *models.Call) bool {
+	return call.Status() == models.CallStatusWired || call.Status() == models.CallStatusInProgress
+}
+
+func queueStartQueuedCalls(rt *runtime.Runtime, oa *models.OrgAssets, ch *models.Channel) error {
+	limit, err := ivr.CallLimit(rt, ch)
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	return tasks.Queue(rc, queue.BatchQueue, oa.OrgID(), &ivrtasks.StartQueuedCallsTask{ChannelID: ch.ID()}, queue.HighPriority)
 }
@@ -12,11 +12,15 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/test"
 	_ "github.com/nyaruka/mailroom/core/handlers"
+	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/core/tasks"
@@ -396,6 +400,13 @@ func TestVonageIVR(t *testing.T) {
 	vonage.CallURL = ts.URL
 	vonage.IgnoreSignatures = true
 
+	// register a transcription service so that the recording becomes the input to the flow
+	transcriber := &mockTranscriber{transcript: "I am happy because of the sunshine"}
+	ivr.RegisterTranscriptionServiceFactory(func(*runtime.Config) ivr.TranscriptionServiceFactory {
+		return func(*models.OrgAssets) (ivr.TranscriptionService, error) { return transcriber, nil }
+	})
+	defer ivr.RegisterTranscriptionServiceFactory(nil)
+
 	// create a flow start for cathy and george
 	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeTrigger, testdata.IVRFlow.ID).
 		WithContactIDs([]models.ContactID{testdata.Cathy.ID, testdata.George.ID}).
@@ -627,6 +638,19 @@ func TestVonageIVR(t *testing.T) {
 
 	assertdb.Query(t, rt.DB, `SELECT count(*) FROM ivr_call WHERE status = 'D' AND contact_id = $1`, testdata.George.ID).Returns(1)
 
+	// check the recording was stored without being transcribed during the call
+	assert.Equal(t, 0, transcriber.calls)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND direction = 'I' AND text = '' AND array_length(attachments, 1) = 1`, testdata.Cathy.ID).Returns(1)
+
+	// but a task was queued to transcribe it, which sets the transcript as the text of the message and on the run result
+	assert.Equal(t, map[string]int{"transcribe_recording": 1}, testsuite.FlushTasks(t, rt))
+
+	assert.Equal(t, 1, transcriber.calls)
+	assert.Equal(t, []byte{}, transcriber.audio)
+	assert.NotEqual(t, i18n.NilLanguage, transcriber.lang)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND direction = 'I' AND text = 'I am happy because of the sunshine' AND array_length(attachments, 1) = 1`, testdata.Cathy.ID).Returns(1)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM flows_flowrun WHERE contact_id = $1 AND results::text LIKE '%"transcript":%"I am happy because of the sunshine"%'`, testdata.Cathy.ID).Returns(1)
+
 	// check the generated channel logs
 	logs := getCallLogs(t, rt, testdata.VonageChannel.UUID)
 	assert.Len(t, logs, 16)
@@ -639,6 +663,20 @@ func TestVonageIVR(t *testing.T) {
 	assertdb.Query(t, rt.DB, `SELECT array_agg(log_type ORDER BY id) FROM channels_channellog WHERE channel_id = $1`, testdata.VonageChannel.ID).Returns([]byte(`{ivr_status,ivr_status}`))
 }
 
+type mockTranscriber struct {
+	transcript string
+	calls      int
+	audio      []byte
+	lang       i18n.Language
+}
+
+func (m *mockTranscriber) Transcribe(ctx context.Context, audio []byte, contentType string, lang i18n.Language) (string, error) {
+	m.calls++
+	m.audio = audio
+	m.lang = lang
+	return m.transcript, nil
+}
+
 func getCallLogs(t *testing.T, rt *runtime.Runtime, channelUUID assets.ChannelUUID) [][]byte {
 	var logUUIDs []models.ChannelLogUUID
 	err := rt.DB.Select(&logUUIDs, `SELECT unnest(log_uuids) FROM ivr_call ORDER BY id`)
@@ -653,3 +691,52 @@ func getCallLogs(t *testing.T, rt *runtime.Runtime, channelUUID assets.ChannelUU
 	}
 	return logs
 }
+
+func TestCallsAndHangup(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// cathy is in progress and waiting in the IVR flow
+	cathyCallID := testdata.InsertCall(rt, testdata.Org1, testdata.TwilioChannel, testdata.Cathy)
+	rt.DB.MustExec(`UPDATE ivr_call SET external_id = 'CA1' WHERE id = $1`, cathyCallID)
+	sessionID := testdata.InsertWaitingSession(rt, testdata.Org1, testdata.Cathy, models.FlowTypeVoice, testdata.IVRFlow, cathyCallID, time.Now(), time.Now().Add(time.Hour), false, nil)
+	runID := testdata.InsertFlowRun(rt, testdata.Org1, sessionID, testdata.Cathy, testdata.IVRFlow, models.RunStatusWaiting)
+	rt.DB.MustExec(`UPDATE flows_flowrun SET current_node_uuid = '5253c207-46ad-4b6c-a2c1-b07f4d32a8bd' WHERE id = $1`, runID)
+
+	// george's call has been wired but not answered, and bob's call is waiting to be retried
+	georgeCallID := testdata.InsertCall(rt, testdata.Org1, testdata.TwilioChannel, testdata.George)
+	rt.DB.MustExec(`UPDATE ivr_call SET external_id = 'CA2', status = 'W' WHERE id = $1`, georgeCallID)
+	bobCallID := testdata.InsertCall(rt, testdata.Org1, testdata.TwilioChannel, testdata.Bob)
+	rt.DB.MustExec(`UPDATE ivr_call SET status = 'E', next_attempt = NOW() + INTERVAL '1 hour' WHERE id = $1`, bobCallID)
+
+	// george and bob's calls belong to a start which still has batches to process
+	startID := testdata.InsertFlowStart(rt, testdata.Org1, testdata.IVRFlow, []*testdata.Contact{testdata.George, testdata.Bob})
+	rt.DB.MustExec(`UPDATE flows_flowstart SET status = 'S' WHERE id = $1`, startID)
+	rt.DB.MustExec(`INSERT INTO flows_flowstart_calls(flowstart_id, call_id) VALUES($1, $2), ($1, $3)`, startID, georgeCallID, bobCallID)
+
+	subs := map[string]string{
+		"cathy_call_id":  fmt.Sprint(cathyCallID),
+		"george_call_id": fmt.Sprint(georgeCallID),
+		"start_id":       fmt.Sprint(startID),
+	}
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/calls.json", subs)
+	testsuite.RunWebTests(t, ctx, rt, "testdata/hangup.json", subs)
+
+	// hanging up the calls at the provider happens in queued tasks
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.twilio.com/2010-04-01/Accounts/SID123456789/Calls/CA1.json": {
+			httpx.NewMockResponse(200, nil, []byte(`{"sid": "CA1", "status": "completed"}`)),
+		},
+		"https://api.twilio.com/2010-04-01/Accounts/SID123456789/Calls/CA2.json": {
+			httpx.NewMockResponse(404, nil, []byte(`{"message": "not found"}`)),
+		},
+	}))
+
+	assert.Equal(t, map[string]int{"hangup_calls": 2}, testsuite.FlushTasks(t, rt))
+
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM channels_channellog WHERE log_type = 'ivr_hangup' AND channel_id = $1`, testdata.TwilioChannel.ID).Returns(2)
+	assertdb.Query(t, rt.DB, `SELECT count(*) FROM ivr_call WHERE status = 'F'`).Returns(3)
+}
@@ -0,0 +1,118 @@
+package simulation
+
+import (
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/resumes"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+// these match the limits used by our real IVR services
+const (
+	ivrGatherTimeout = 30
+	ivrRecordTimeout = 600
+)
+
+// ivrCommand is an instruction to the simulated call, much like the TwiML or NCCO that a real IVR service would
+// write in response to a sprint, e.g.
+//
+//	{"type": "say", "text": "Press 1 for sales", "locale": "eng-US"}
+//	{"type": "gather", "num_digits": 1, "timeout": 30}
+type ivrCommand struct {
+	Type string `json:"type"`
+
+	// say
+	Text   string      `json:"text,omitempty"`
+	Locale i18n.Locale `json:"locale,omitempty"`
+
+	// play
+	URL string `json:"url,omitempty"`
+
+	// gather
+	NumDigits   int    `json:"num_digits,omitempty"`
+	FinishOnKey string `json:"finish_on_key,omitempty"`
+
+	// gather and record
+	Timeout   int `json:"timeout,omitempty"`
+	MaxLength int `json:"max_length,omitempty"`
+
+	// dial
+	Number    string `json:"number,omitempty"`
+	DialLimit int    `json:"dial_limit,omitempty"`
+	CallLimit int    `json:"call_limit,omitempty"`
+}
+
+// ivrCommandsForSprint converts the events of a voice sprint into the commands a real IVR service would send to the
+// provider, ending with a hangup if the flow isn't waiting for anything from the caller
+func ivrCommandsForSprint(cfg *runtime.Config, session flows.Session, es []flows.Event) ([]*ivrCommand, error) {
+	cmds, err := ivr.CommandsForSprint(cfg, session.Environment(), es)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make([]*ivrCommand, len(cmds))
+	for i, cmd := range cmds {
+		c := &ivrCommand{Type: string(cmd.Type)}
+
+		switch cmd.Type {
+		case ivr.CommandTypeSay:
+			c.Text, c.Locale = cmd.Text, cmd.Locale
+		case ivr.CommandTypePlay:
+			c.URL = cmd.URL
+		case ivr.CommandTypeGather:
+			c.NumDigits, c.FinishOnKey, c.Timeout = cmd.NumDigits, cmd.FinishOnKey, ivrGatherTimeout
+		case ivr.CommandTypeRecord:
+			c.MaxLength = ivrRecordTimeout
+		case ivr.CommandTypeDial:
+			c.Number, c.DialLimit, c.CallLimit = cmd.URN.Path(), cmd.DialLimit, cmd.CallLimit
+		}
+
+		commands[i] = c
+	}
+
+	return commands, nil
+}
+
+// ivrInput is what the caller did in response to the last commands of a simulated call, e.g.
+//
+//	{"digits": "1"}
+//	{"recording": "audio/wav:https://example.com/recording.wav"}
+//	{"timed_out": true}
+//	{"dial": {"status": "answered", "duration": 20}}
+type ivrInput struct {
+	Digits    string           `json:"digits,omitempty"`
+	Recording utils.Attachment `json:"recording,omitempty"`
+	TimedOut  bool             `json:"timed_out,omitempty"`
+	Dial      *flows.Dial      `json:"dial,omitempty"`
+}
+
+// resume builds the resume for this input in the same way as we do for real calls
+func (i *ivrInput) resume(session flows.Session) (flows.Resume, error) {
+	env, contact := session.Environment(), session.Contact()
+
+	if i.Dial != nil {
+		return resumes.NewDial(env, contact, i.Dial), nil
+	}
+
+	// timeouts are resumed with an empty message, like real calls are
+	text := i.Digits
+	if i.TimedOut {
+		text = ""
+	}
+
+	var attachments []utils.Attachment
+	if i.Recording != "" {
+		if i.Recording.ContentType() == "" || i.Recording.URL() == "" {
+			return nil, errors.Errorf("invalid recording attachment: %s", i.Recording)
+		}
+		attachments = []utils.Attachment{i.Recording}
+	}
+
+	msg := flows.NewMsgIn(flows.MsgUUID(uuids.New()), TestURN, testChannel, text, attachments)
+
+	return resumes.NewMsg(env, contact, msg), nil
+}
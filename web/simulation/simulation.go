@@ -21,8 +21,13 @@ import (
 	"github.com/pkg/errors"
 )
 
-var testChannel = assets.NewChannelReference("440099cf-200c-4d45-a8e7-4a564f4a0e8b", "Test Channel")
-var testURN = urns.URN("tel:+12065551212")
+// TestChannel is the channel used by simulated sessions and flow tests so that messages can be sent and calls made
+var TestChannel = static.NewChannel("440099cf-200c-4d45-a8e7-4a564f4a0e8b", "Test Channel", "+18005551212", []string{"tel"}, []assets.ChannelRole{assets.ChannelRoleSend, assets.ChannelRoleReceive, assets.ChannelRoleCall}, nil)
+
+// TestURN is the URN of the contact in simulated sessions and flow tests
+var TestURN = urns.URN("tel:+12065551212")
+
+var testChannel = assets.NewChannelReference(TestChannel.UUID(), TestChannel.Name())
 
 func init() {
 	web.RegisterRoute(http.MethodPost, "/mr/sim/start", web.RequireAuthToken(web.JSONPayload(handleStart)))
@@ -59,15 +64,28 @@ func (r *sessionRequest) channels() []assets.Channel {
 }
 
 type simulationResponse struct {
-	Session  flows.Session   `json:"session"`
-	Events   []flows.Event   `json:"events"`
-	Segments []flows.Segment `json:"segments"`
-	Context  *types.XObject  `json:"context,omitempty"`
+	Session    flows.Session   `json:"session"`
+	Events     []flows.Event   `json:"events"`
+	Segments   []flows.Segment `json:"segments"`
+	Context    *types.XObject  `json:"context,omitempty"`
+	IVR        []*ivrCommand   `json:"ivr,omitempty"`
+	Transcript *transcript     `json:"transcript,omitempty"`
 }
 
-func newSimulationResponse(session flows.Session, sprint flows.Sprint) *simulationResponse {
+func newSimulationResponse(cfg *runtime.Config, session flows.Session, sprint flows.Sprint) (*simulationResponse, error) {
 	var context *types.XObject
+	var ivr []*ivrCommand
+
 	if session != nil {
+		// voice sessions also get the commands a real IVR service would have sent to the call
+		if session.Type() == flows.FlowTypeVoice {
+			var err error
+			ivr, err = ivrCommandsForSprint(cfg, session, sprint.Events())
+			if err != nil {
+				return nil, errors.Wrapf(err, "error creating IVR commands")
+			}
+		}
+
 		context = session.CurrentContext()
 
 		// include object defaults which are not marshaled by default
@@ -77,7 +95,7 @@ func newSimulationResponse(session flows.Session, sprint flows.Sprint) *simulati
 			})
 		}
 	}
-	return &simulationResponse{Session: session, Events: sprint.Events(), Segments: sprint.Segments(), Context: context}
+	return &simulationResponse{Session: session, Events: sprint.Events(), Segments: sprint.Segments(), Context: context, IVR: ivr}, nil
 }
 
 // Starts a new engine session
@@ -89,11 +107,16 @@ func newSimulationResponse(session flows.Session, sprint flows.Sprint) *simulati
 //	     "definition": {...},
 //	  },.. ],
 //	  "trigger": {...},
-//	  "assets": {...}
+//	  "assets": {...},
+//	  "transcript": true
 //	}
+//
+// If transcript is true, the simulation is recorded and the response will include a transcript which can be passed
+// to each resume and later replayed.
 type startRequest struct {
 	sessionRequest
-	Trigger json.RawMessage `json:"trigger" validate:"required"`
+	Trigger    json.RawMessage `json:"trigger" validate:"required"`
+	Transcript bool            `json:"transcript"`
 }
 
 // handleSimulationEvents takes care of updating our db with any events needed during simulation
@@ -135,23 +158,40 @@ func handleStart(ctx context.Context, rt *runtime.Runtime, r *startRequest) (any
 		return nil, http.StatusBadRequest, errors.Wrapf(err, "unable to read trigger")
 	}
 
-	return triggerFlow(ctx, rt, oa, trigger)
+	session, sprint, err := triggerFlow(ctx, rt, oa, trigger)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := newSimulationResponse(rt.Config, session, sprint)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if r.Transcript {
+		resp.Transcript = &transcript{}
+		if err := resp.Transcript.record(&transcriptStep{Trigger: r.Trigger}, sprint); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return resp, http.StatusOK, nil
 }
 
-// triggerFlow creates a new session with the passed in trigger, returning our standard response
-func triggerFlow(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, trigger flows.Trigger) (any, int, error) {
+// triggerFlow creates a new session with the passed in trigger
+func triggerFlow(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, trigger flows.Trigger) (flows.Session, flows.Sprint, error) {
 	// start our flow session
 	session, sprint, err := goflow.Simulator(rt.Config).NewSession(oa.SessionAssets(), trigger)
 	if err != nil {
-		return nil, 0, errors.Wrapf(err, "error starting session")
+		return nil, nil, errors.Wrapf(err, "error starting session")
 	}
 
 	err = handleSimulationEvents(ctx, rt.DB, oa, sprint.Events())
 	if err != nil {
-		return nil, 0, errors.Wrapf(err, "error handling simulation events")
+		return nil, nil, errors.Wrapf(err, "error handling simulation events")
 	}
 
-	return newSimulationResponse(session, sprint), http.StatusOK, nil
+	return session, sprint, nil
 }
 
 // Resumes an existing engine session
@@ -166,11 +206,24 @@ func triggerFlow(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets,
 //	  "resume": {...},
 //	  "assets": {...}
 //	}
+//
+// Voice sessions can instead be resumed with what the caller did on the simulated call, e.g.
+//
+//	{
+//	  "org_id": 1,
+//	  "session": {...},
+//	  "ivr": {"digits": "1"}
+//	}
+//
+// If the simulation is being recorded, the transcript from the previous response should be included and will be
+// returned with this resume added to it.
 type resumeRequest struct {
 	sessionRequest
 
-	Session json.RawMessage `json:"session" validate:"required"`
-	Resume  json.RawMessage `json:"resume" validate:"required"`
+	Session    json.RawMessage `json:"session" validate:"required"`
+	Resume     json.RawMessage `json:"resume"  validate:"required_without=IVR"`
+	IVR        *ivrInput       `json:"ivr"`
+	Transcript *transcript     `json:"transcript"`
 }
 
 func handleResume(ctx context.Context, rt *runtime.Runtime, r *resumeRequest) (any, int, error) {
@@ -190,14 +243,54 @@ func handleResume(ctx context.Context, rt *runtime.Runtime, r *resumeRequest) (a
 		return nil, http.StatusBadRequest, err
 	}
 
-	// read our resume
-	resume, err := resumes.ReadResume(oa.SessionAssets(), r.Resume, assets.IgnoreMissing)
+	resume, err := readResume(oa, session, r.Resume, r.IVR)
 	if err != nil {
 		return nil, http.StatusBadRequest, err
 	}
 
-	// if this is a msg resume we want to check whether it might be caught by a trigger
-	if resume.Type() == resumes.TypeMsg {
+	session, sprint, err := resumeFlow(ctx, rt, oa, session, resume, r.IVR == nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// if our session was already complete, then this was a no-op, return the session unchanged
+	resp := &simulationResponse{Session: session, Events: nil}
+	if sprint != nil {
+		resp, err = newSimulationResponse(rt.Config, session, sprint)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if r.Transcript != nil {
+		resp.Transcript = r.Transcript
+		if err := resp.Transcript.record(&transcriptStep{Resume: r.Resume, IVR: r.IVR}, sprint); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return resp, http.StatusOK, nil
+}
+
+// readResume reads a resume for the given session from either engine JSON or the input on a simulated call
+func readResume(oa *models.OrgAssets, session flows.Session, data json.RawMessage, ivr *ivrInput) (flows.Resume, error) {
+	if ivr != nil {
+		if session.Type() != flows.FlowTypeVoice {
+			return nil, errors.New("can only resume voice sessions with IVR input")
+		}
+
+		return ivr.resume(session)
+	}
+
+	return resumes.ReadResume(oa.SessionAssets(), data, assets.IgnoreMissing)
+}
+
+// resumeFlow resumes the given session, unless the resume is a message which is caught by a trigger, in which case a
+// new session is started. If the session isn't waiting, it's returned unchanged with a nil sprint.
+func resumeFlow(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, session flows.Session, resume flows.Resume, checkTriggers bool) (flows.Session, flows.Sprint, error) {
+	// if this is a msg resume we want to check whether it might be caught by a trigger, but like real calls, input
+	// on a simulated call is never checked against triggers
+	if resume.Type() == resumes.TypeMsg && checkTriggers {
 		msgResume := resume.(*resumes.MsgResume)
 		trigger, keyword := models.FindMatchingMsgTrigger(oa, nil, msgResume.Contact(), msgResume.Msg().Text())
 		if trigger != nil {
@@ -216,7 +309,7 @@ func handleResume(ctx context.Context, rt *runtime.Runtime, r *resumeRequest) (a
 			if flow == nil || (!flow.IgnoreTriggers() && trigger.TriggerType() == models.KeywordTriggerType) {
 				triggeredFlow, err := oa.FlowByID(trigger.FlowID())
 				if err != nil && err != models.ErrNotFound {
-					return nil, 0, errors.Wrapf(err, "unable to load triggered flow")
+					return nil, nil, errors.Wrapf(err, "unable to load triggered flow")
 				}
 
 				if triggeredFlow != nil {
@@ -226,7 +319,7 @@ func handleResume(ctx context.Context, rt *runtime.Runtime, r *resumeRequest) (a
 					if triggeredFlow.FlowType() == models.FlowTypeVoice {
 						// TODO this should trigger a msg trigger with a call but first we need to rework
 						// non-simulation IVR triggers to use that so that this is consistent.
-						sessionTrigger = tb.Manual().WithCall(testChannel, testURN).Build()
+						sessionTrigger = tb.Manual().WithCall(testChannel, TestURN).Build()
 					} else {
 						mtb := tb.Msg(msgResume.Msg())
 						if keyword != "" {
@@ -241,21 +334,20 @@ func handleResume(ctx context.Context, rt *runtime.Runtime, r *resumeRequest) (a
 		}
 	}
 
-	// if our session is already complete, then this is a no-op, return the session unchanged
 	if session.Status() != flows.SessionStatusWaiting {
-		return &simulationResponse{Session: session, Events: nil}, http.StatusOK, nil
+		return session, nil, nil
 	}
 
 	// resume our session
 	sprint, err := session.Resume(resume)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 
 	err = handleSimulationEvents(ctx, rt.DB, oa, sprint.Events())
 	if err != nil {
-		return nil, 0, errors.Wrapf(err, "error handling simulation events")
+		return nil, nil, errors.Wrapf(err, "error handling simulation events")
 	}
 
-	return newSimulationResponse(session, sprint), http.StatusOK, nil
+	return session, sprint, nil
 }
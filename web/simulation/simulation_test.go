@@ -17,6 +17,7 @@ import (
 	"github.com/nyaruka/mailroom/web"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -108,6 +109,25 @@ const (
 		"session": $$SESSION$$
 	}`
 
+	ivrResumeBody = `
+	{
+		"org_id": 1,
+		"ivr": {"digits": "$$MESSAGE$$"},
+		"assets": {
+			"channels": [
+				{
+					"uuid": "440099cf-200c-4d45-a8e7-4a564f4a0e8b",
+					"name": "Test Channel",
+					"address": "+18005551212",
+					"schemes": ["tel"],
+					"roles": ["send", "receive", "call"],
+					"country": "US"
+				}
+			]
+		},
+		"session": $$SESSION$$
+	}`
+
 	customStartBody = `
 	{
 		"org_id": 1,
@@ -245,6 +265,18 @@ func TestServer(t *testing.T) {
 		// start favorties again but this time resume with a message that matches the IVR flow trigger
 		{"/mr/sim/start", "POST", startBody, "", 200, "What is your favorite color?"},
 		{"/mr/sim/resume", "POST", resumeBody, "ivr", 200, "Hello there. Please enter one or two."},
+
+		// and again to check the commands for the simulated call
+		{"/mr/sim/start", "POST", startBody, "", 200, "What is your favorite color?"},
+		{"/mr/sim/resume", "POST", resumeBody, "ivr", 200, `"ivr":[{"type":"say","text":"Hello there. Please enter one or two.`},
+
+		// continue the voice flow by pressing digits on the simulated call
+		{"/mr/sim/resume", "POST", ivrResumeBody, "3", 200, `{"type":"gather","num_digits":1,"timeout":30}]`},
+		{"/mr/sim/resume", "POST", ivrResumeBody, "1", 200, `"text":"Great! You said One.`},
+
+		// can't use IVR input with a messaging session
+		{"/mr/sim/start", "POST", startBody, "", 200, "What is your favorite color?"},
+		{"/mr/sim/resume", "POST", ivrResumeBody, "1", 400, "can only resume voice sessions with IVR input"},
 	}
 
 	for i, tc := range tcs {
@@ -286,3 +318,79 @@ func TestServer(t *testing.T) {
 		assert.Contains(t, string(content), tc.ExpectedResponse, "%d: did not find expected response content")
 	}
 }
+
+func TestTranscripts(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	wg := &sync.WaitGroup{}
+
+	server := web.NewServer(ctx, rt, wg)
+	server.Start()
+
+	// give our server time to start
+	time.Sleep(time.Second)
+
+	defer server.Stop()
+
+	post := func(url string, body []byte) (int, map[string]any) {
+		resp, err := http.Post("http://localhost:8090"+url, "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		parsed := make(map[string]any)
+		jsonx.MustUnmarshal(content, &parsed)
+		return resp.StatusCode, parsed
+	}
+
+	// start a recorded simulation
+	start := make(map[string]any)
+	jsonx.MustUnmarshal([]byte(startBody), &start)
+	start["transcript"] = true
+
+	status, parsed := post("/mr/sim/start", jsonx.MustMarshal(start))
+	require.Equal(t, 200, status)
+	assert.Len(t, parsed["transcript"].(map[string]any)["steps"], 1)
+
+	// resume it passing back the transcript
+	resume := make(map[string]any)
+	jsonx.MustUnmarshal([]byte(strings.Replace(strings.Replace(resumeBody, "$$MESSAGE$$", "I like blue!", -1), "$$SESSION$$", "{}", -1)), &resume)
+	resume["session"] = parsed["session"]
+	resume["transcript"] = parsed["transcript"]
+
+	status, parsed = post("/mr/sim/resume", jsonx.MustMarshal(resume))
+	require.Equal(t, 200, status)
+
+	transcript := parsed["transcript"]
+	steps := transcript.(map[string]any)["steps"].([]any)
+	assert.Len(t, steps, 2)
+	assert.NotNil(t, steps[0].(map[string]any)["trigger"])
+	assert.NotNil(t, steps[1].(map[string]any)["resume"])
+
+	// replaying against the same flows finds no differences
+	status, parsed = post("/mr/sim/replay", jsonx.MustMarshal(map[string]any{"org_id": 1, "transcript": transcript}))
+	require.Equal(t, 200, status)
+	assert.Equal(t, map[string]any{"passed": true, "diffs": []any{}}, parsed)
+
+	// replaying against a modified flow reports what changed
+	custom := make(map[string]any)
+	jsonx.MustUnmarshal([]byte(customStartBody), &custom)
+
+	status, parsed = post("/mr/sim/replay", jsonx.MustMarshal(map[string]any{"org_id": 1, "flows": custom["flows"], "assets": custom["assets"], "transcript": transcript}))
+	require.Equal(t, 200, status)
+	assert.Equal(t, false, parsed["passed"])
+
+	diffs := parsed["diffs"].([]any)
+	require.Len(t, diffs, 2)
+	assert.Equal(t, float64(0), diffs[0].(map[string]any)["step"])
+	assert.Equal(t, []any{"What is your favorite color?"}, diffs[0].(map[string]any)["msgs"].(map[string]any)["expected"])
+	assert.Equal(t, []any{"Your channel is Test Channel"}, diffs[0].(map[string]any)["msgs"].(map[string]any)["actual"])
+
+	// transcripts must start with a trigger
+	status, parsed = post("/mr/sim/replay", []byte(`{"org_id": 1, "transcript": {"steps": [{"events": [], "path": []}]}}`))
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "first step of transcript must have a trigger", parsed["error"])
+}
@@ -0,0 +1,229 @@
+package simulation
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/flows/triggers"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/sim/replay", web.RequireAuthToken(web.JSONPayload(handleReplay)))
+}
+
+// transcript is a record of a simulation, i.e. the trigger that started it, each resume and what happened as a
+// consequence of each. It's held by the caller and can be replayed against later versions of the flows.
+type transcript struct {
+	Steps []*transcriptStep `json:"steps" validate:"required,min=1,dive"`
+}
+
+// transcriptStep is one sprint of a simulation. The first step has a trigger and subsequent steps have either an
+// engine resume or input on a simulated call.
+type transcriptStep struct {
+	Trigger json.RawMessage   `json:"trigger,omitempty"`
+	Resume  json.RawMessage   `json:"resume,omitempty"`
+	IVR     *ivrInput         `json:"ivr,omitempty"`
+	Events  []json.RawMessage `json:"events"`
+	Path    []flows.NodeUUID  `json:"path"`
+}
+
+// record adds the given step to this transcript along with the outcome of its sprint, which is nil if the step
+// was a no-op because the session had already ended
+func (t *transcript) record(step *transcriptStep, sprint flows.Sprint) error {
+	step.Events = make([]json.RawMessage, 0)
+	step.Path = make([]flows.NodeUUID, 0)
+
+	if sprint != nil {
+		for _, e := range sprint.Events() {
+			data, err := jsonx.Marshal(e)
+			if err != nil {
+				return errors.Wrap(err, "error marshaling event")
+			}
+			step.Events = append(step.Events, data)
+		}
+
+		step.Path = pathForSprint(sprint)
+	}
+
+	t.Steps = append(t.Steps, step)
+	return nil
+}
+
+// the nodes visited in a sprint, in order
+func pathForSprint(sprint flows.Sprint) []flows.NodeUUID {
+	path := make([]flows.NodeUUID, 0, len(sprint.Segments())+1)
+	for _, seg := range sprint.Segments() {
+		if len(path) == 0 || path[len(path)-1] != seg.Node().UUID() {
+			path = append(path, seg.Node().UUID())
+		}
+		path = append(path, seg.Destination().UUID())
+	}
+	return path
+}
+
+type transcriptResult struct {
+	Value    string `json:"value"`
+	Category string `json:"category"`
+}
+
+// stepOutcome is what we compare when replaying a step
+type stepOutcome struct {
+	Msgs    []string                    `json:"msgs"`
+	Results map[string]transcriptResult `json:"results"`
+	Path    []flows.NodeUUID            `json:"path"`
+}
+
+func newStepOutcome(es []flows.Event, path []flows.NodeUUID) *stepOutcome {
+	o := &stepOutcome{Msgs: make([]string, 0), Results: make(map[string]transcriptResult), Path: path}
+
+	for _, e := range es {
+		switch typed := e.(type) {
+		case *events.MsgCreatedEvent:
+			o.Msgs = append(o.Msgs, typed.Msg.Text())
+		case *events.IVRCreatedEvent:
+			o.Msgs = append(o.Msgs, typed.Msg.Text())
+		case *events.RunResultChangedEvent:
+			o.Results[typed.Name] = transcriptResult{Value: typed.Value, Category: typed.Category}
+		}
+	}
+	return o
+}
+
+// outcome reads the recorded outcome of this step
+func (s *transcriptStep) outcome() (*stepOutcome, error) {
+	es := make([]flows.Event, len(s.Events))
+	for i, data := range s.Events {
+		e, err := events.ReadEvent(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading event")
+		}
+		es[i] = e
+	}
+	return newStepOutcome(es, s.Path), nil
+}
+
+type valueDiff struct {
+	Expected any `json:"expected"`
+	Actual   any `json:"actual"`
+}
+
+type stepDiff struct {
+	Step    int        `json:"step"`
+	Msgs    *valueDiff `json:"msgs,omitempty"`
+	Results *valueDiff `json:"results,omitempty"`
+	Path    *valueDiff `json:"path,omitempty"`
+}
+
+func diffOutcomes(step int, expected, actual *stepOutcome) *stepDiff {
+	d := &stepDiff{Step: step}
+	if !slices.Equal(expected.Msgs, actual.Msgs) {
+		d.Msgs = &valueDiff{Expected: expected.Msgs, Actual: actual.Msgs}
+	}
+	if !maps.Equal(expected.Results, actual.Results) {
+		d.Results = &valueDiff{Expected: expected.Results, Actual: actual.Results}
+	}
+	if !slices.Equal(expected.Path, actual.Path) {
+		d.Path = &valueDiff{Expected: expected.Path, Actual: actual.Path}
+	}
+	if d.Msgs == nil && d.Results == nil && d.Path == nil {
+		return nil
+	}
+	return d
+}
+
+// Replays a transcript of a previous simulation against the current flows, reporting any differences in the
+// messages sent, results saved or path taken at each step.
+//
+//	{
+//	  "org_id": 1,
+//	  "flows": [{
+//	     "uuid": uuidv4,
+//	     "definition": {...},
+//	  },.. ],
+//	  "transcript": {"steps": [...]},
+//	  "assets": {...}
+//	}
+type replayRequest struct {
+	sessionRequest
+
+	Transcript *transcript `json:"transcript" validate:"required"`
+}
+
+type replayResponse struct {
+	Passed bool        `json:"passed"`
+	Diffs  []*stepDiff `json:"diffs"`
+}
+
+func handleReplay(ctx context.Context, rt *runtime.Runtime, r *replayRequest) (any, int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	// create clone of assets for simulation
+	oa, err = oa.CloneForSimulation(ctx, rt, r.flows(), r.channels())
+	if err != nil {
+		return errors.Wrapf(err, "unable to clone org"), http.StatusBadRequest, nil
+	}
+
+	if len(r.Transcript.Steps[0].Trigger) == 0 {
+		return errors.New("first step of transcript must have a trigger"), http.StatusBadRequest, nil
+	}
+
+	resp := &replayResponse{Passed: true, Diffs: make([]*stepDiff, 0)}
+	var session flows.Session
+
+	for i, step := range r.Transcript.Steps {
+		expected, err := step.outcome()
+		if err != nil {
+			return errors.Wrapf(err, "invalid transcript step %d", i), http.StatusBadRequest, nil
+		}
+
+		var sprint flows.Sprint
+
+		if i == 0 {
+			trigger, err := triggers.ReadTrigger(oa.SessionAssets(), step.Trigger, assets.IgnoreMissing)
+			if err != nil {
+				return errors.Wrapf(err, "unable to read trigger"), http.StatusBadRequest, nil
+			}
+
+			session, sprint, err = triggerFlow(ctx, rt, oa, trigger)
+			if err != nil {
+				return nil, 0, err
+			}
+		} else {
+			resume, err := readResume(oa, session, step.Resume, step.IVR)
+			if err != nil {
+				return errors.Wrapf(err, "unable to read resume for step %d", i), http.StatusBadRequest, nil
+			}
+
+			session, sprint, err = resumeFlow(ctx, rt, oa, session, resume, step.IVR == nil)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		actual := newStepOutcome(nil, make([]flows.NodeUUID, 0))
+		if sprint != nil {
+			actual = newStepOutcome(sprint.Events(), pathForSprint(sprint))
+		}
+
+		if diff := diffOutcomes(i, expected, actual); diff != nil {
+			resp.Passed = false
+			resp.Diffs = append(resp.Diffs, diff)
+		}
+	}
+
+	return resp, http.StatusOK, nil
+}
@@ -1,11 +1,14 @@
 package ticket
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
 )
 
 func TestTicketAssign(t *testing.T) {
@@ -74,3 +77,46 @@ func TestTicketReopen(t *testing.T) {
 
 	testsuite.RunWebTests(t, ctx, rt, "testdata/reopen.json", nil)
 }
+
+func TestTicketMerge(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	// create 2 open tickets and 1 closed ticket for Cathy and 1 open ticket for Bob
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Have you seen my cookies?", time.Now(), testdata.Admin)
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Where are my cookies?", time.Now(), nil)
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Bob, testdata.DefaultTopic, "Have you seen my cookies?", time.Now(), nil)
+	testdata.InsertClosedTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "I found my cookies", nil)
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/merge.json", nil)
+
+	// closed event of the source ticket was queued for handling
+	assertredis.LLen(t, rt.RP, fmt.Sprintf("c:%d:%d", testdata.Org1.ID, testdata.Cathy.ID), 1)
+}
+
+func TestTicketTransfer(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// create 3 open tickets and 1 closed one assigned to the admin user
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Have you seen my cookies?", time.Now(), testdata.Admin)
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Bob, testdata.SalesTopic, "Have you seen my cookies?", time.Now(), testdata.Admin)
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.George, testdata.DefaultTopic, "Have you seen my cookies?", time.Now(), testdata.Admin)
+	testdata.InsertClosedTicket(rt, testdata.Org1, testdata.Alexandria, testdata.DefaultTopic, "Have you seen my cookies?", testdata.Admin)
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/transfer.json", nil)
+}
+
+func TestTicketSendCanned(t *testing.T) {
+	ctx, rt := testsuite.Runtime()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	testdata.InsertOpenTicket(rt, testdata.Org1, testdata.Cathy, testdata.DefaultTopic, "Have you seen my cookies?", time.Now(), testdata.Admin)
+	testdata.InsertCannedResponse(rt, testdata.Org1, nil, "Thanks", "eng", flows.BroadcastTranslations{"eng": {Text: "Thanks @contact.first_name"}})
+	testdata.InsertCannedResponse(rt, testdata.Org1, testdata.SalesTopic, "Sales", "eng", flows.BroadcastTranslations{"eng": {Text: "Sales here"}})
+
+	testsuite.RunWebTests(t, ctx, rt, "testdata/send_canned.json", nil)
+}
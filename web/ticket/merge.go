@@ -0,0 +1,83 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/handler"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/ticket/merge", web.RequireAuthToken(web.JSONPayload(handleMerge)))
+}
+
+type mergeRequest struct {
+	OrgID    models.OrgID    `json:"org_id"    validate:"required"`
+	UserID   models.UserID   `json:"user_id"   validate:"required"`
+	SourceID models.TicketID `json:"source_id" validate:"required"`
+	TargetID models.TicketID `json:"target_id" validate:"required"`
+}
+
+// Merges the source ticket into the target ticket. Messages and events are moved to the target ticket and the source
+// ticket is closed. Both tickets must belong to the same contact and the target ticket must be open.
+//
+//	{
+//	  "org_id": 123,
+//	  "user_id": 234,
+//	  "source_id": 1234,
+//	  "target_id": 2345
+//	}
+func handleMerge(ctx context.Context, rt *runtime.Runtime, r *mergeRequest) (any, int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	tickets, err := models.LoadTickets(ctx, rt.DB, []models.TicketID{r.SourceID, r.TargetID})
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error loading tickets for org: %d", r.OrgID)
+	}
+
+	var source, target *models.Ticket
+	for _, t := range tickets {
+		if t.OrgID() != r.OrgID {
+			continue
+		}
+		if t.ID() == r.SourceID {
+			source = t
+		} else if t.ID() == r.TargetID {
+			target = t
+		}
+	}
+
+	if source == nil || target == nil {
+		return errors.New("no such source or target ticket"), http.StatusBadRequest, nil
+	}
+	if source.ContactID() != target.ContactID() {
+		return errors.New("can't merge tickets belonging to different contacts"), http.StatusBadRequest, nil
+	}
+	if target.Status() != models.TicketStatusOpen {
+		return errors.New("can't merge ticket into a closed ticket"), http.StatusBadRequest, nil
+	}
+
+	evt, closedEvt, err := models.MergeTickets(ctx, rt, oa, r.UserID, source, target)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error merging tickets")
+	}
+
+	// queue the closed event so that ticket closed triggers can fire
+	if closedEvt != nil {
+		rc := rt.RP.Get()
+		defer rc.Close()
+
+		if err := handler.QueueTicketEvent(rc, source.ContactID(), closedEvt); err != nil {
+			return nil, 0, errors.Wrapf(err, "error queueing ticket event for ticket %d", source.ID())
+		}
+	}
+
+	return newBulkResponse(map[*models.Ticket]*models.TicketEvent{source: evt}), http.StatusOK, nil
+}
@@ -0,0 +1,99 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/ticket/send_canned", web.RequireAuthToken(web.JSONPayload(handleSendCanned)))
+}
+
+type sendCannedRequest struct {
+	OrgID            models.OrgID            `json:"org_id"             validate:"required"`
+	UserID           models.UserID           `json:"user_id"            validate:"required"`
+	TicketID         models.TicketID         `json:"ticket_id"          validate:"required"`
+	CannedResponseID models.CannedResponseID `json:"canned_response_id" validate:"required"`
+}
+
+// Sends a canned response to the contact of the given ticket. The response is translated into the contact's
+// language if possible and evaluated against the contact and ticket.
+//
+//	{
+//	  "org_id": 123,
+//	  "user_id": 234,
+//	  "ticket_id": 1234,
+//	  "canned_response_id": 345
+//	}
+func handleSendCanned(ctx context.Context, rt *runtime.Runtime, r *sendCannedRequest) (any, int, error) {
+	// canned responses are edited by users so make sure we're using the latest versions
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, r.OrgID, models.RefreshCannedResponses)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	tickets, err := models.LoadTickets(ctx, rt.DB, []models.TicketID{r.TicketID})
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error loading tickets for org: %d", r.OrgID)
+	}
+	if len(tickets) == 0 || tickets[0].OrgID() != r.OrgID {
+		return errors.Errorf("no such ticket with id %d", r.TicketID), http.StatusBadRequest, nil
+	}
+	ticket := tickets[0]
+
+	response := oa.CannedResponseByID(r.CannedResponseID)
+	if response == nil || !response.AppliesTo(ticket) {
+		return errors.Errorf("no such canned response with id %d", r.CannedResponseID), http.StatusBadRequest, nil
+	}
+
+	c, err := models.LoadContact(ctx, rt.DB, oa, ticket.ContactID())
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error loading contact")
+	}
+
+	contact, err := c.FlowContact(oa)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error creating flow contact")
+	}
+
+	text, attachments, quickReplies, locale, err := response.Render(oa, contact, ticket)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error rendering canned response")
+	}
+
+	out, ch := models.NewMsgOut(oa, contact, text, attachments, quickReplies, locale)
+
+	msg, err := models.NewOutgoingTicketMsg(rt, oa.Org(), ch, contact, out, dates.Now(), ticket.ID(), r.UserID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error creating outgoing message")
+	}
+
+	if err := models.InsertMessages(ctx, rt.DB, []*models.Msg{msg}); err != nil {
+		return nil, 0, errors.Wrap(err, "error inserting outgoing message")
+	}
+
+	if err := models.RecordTicketReply(ctx, rt.DB, oa, ticket.ID(), r.UserID); err != nil {
+		return nil, 0, errors.Wrap(err, "error recording ticket reply")
+	}
+
+	msgio.QueueMessages(ctx, rt, rt.DB, nil, []*models.Msg{msg})
+
+	return map[string]any{
+		"id":          msg.ID(),
+		"channel":     out.Channel(),
+		"contact":     contact.Reference(),
+		"urn":         out.URN(),
+		"text":        msg.Text(),
+		"attachments": msg.Attachments(),
+		"status":      msg.Status(),
+		"created_on":  msg.CreatedOn(),
+		"modified_on": msg.ModifiedOn(),
+	}, http.StatusOK, nil
+}
@@ -0,0 +1,106 @@
+package ticket
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/maps"
+)
+
+func init() {
+	web.RegisterRoute(http.MethodPost, "/mr/ticket/transfer", web.RequireAuthToken(web.JSONPayload(handleTransfer)))
+}
+
+type transferRequest struct {
+	OrgID      models.OrgID   `json:"org_id"      validate:"required"`
+	UserID     models.UserID  `json:"user_id"     validate:"required"`
+	FromID     models.UserID  `json:"from_id"     validate:"required"`
+	TopicID    models.TopicID `json:"topic_id"`
+	AssigneeID models.UserID  `json:"assignee_id" validate:"required_without=TeamID"`
+	TeamID     models.TeamID  `json:"team_id"     validate:"required_without=AssigneeID"`
+}
+
+// Transfers all open tickets assigned to one user, optionally filtered by topic, to either another user or spread
+// evenly across the members of a team.
+//
+//	{
+//	  "org_id": 123,
+//	  "user_id": 234,
+//	  "from_id": 345,
+//	  "topic_id": 456,
+//	  "assignee_id": 567
+//	}
+func handleTransfer(ctx context.Context, rt *runtime.Runtime, r *transferRequest) (any, int, error) {
+	oa, err := models.GetOrgAssets(ctx, rt, r.OrgID)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to load org assets")
+	}
+
+	var assignees []*models.User
+
+	if r.AssigneeID != models.NilUserID {
+		assignee := oa.UserByID(r.AssigneeID)
+		if assignee == nil {
+			return errors.Errorf("no such user with id %d", r.AssigneeID), http.StatusBadRequest, nil
+		}
+		assignees = []*models.User{assignee}
+	} else {
+		assignees, err = teamMembers(oa, r.TeamID, r.FromID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(assignees) == 0 {
+			return errors.Errorf("no users in team with id %d", r.TeamID), http.StatusBadRequest, nil
+		}
+	}
+
+	tickets, err := models.LoadOpenTicketsForAssignee(ctx, rt.DB, r.OrgID, r.FromID, r.TopicID)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error loading tickets for org: %d", r.OrgID)
+	}
+
+	// round robin the tickets across the assignees
+	byAssignee := make(map[*models.User][]*models.Ticket, len(assignees))
+	for i, t := range tickets {
+		assignee := assignees[i%len(assignees)]
+		byAssignee[assignee] = append(byAssignee[assignee], t)
+	}
+
+	results := make(map[*models.Ticket]*models.TicketEvent, len(tickets))
+
+	for assignee, assigneeTickets := range byAssignee {
+		evts, err := models.TicketsAssign(ctx, rt.DB, oa, r.UserID, assigneeTickets, assignee.ID())
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "error assigning tickets")
+		}
+
+		maps.Copy(results, evts)
+	}
+
+	return newBulkResponse(results), http.StatusOK, nil
+}
+
+// gets the members of the given team, excluding the given user, ordered by id
+func teamMembers(oa *models.OrgAssets, teamID models.TeamID, excludeID models.UserID) ([]*models.User, error) {
+	users, err := oa.Users()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading users")
+	}
+
+	members := make([]*models.User, 0, len(users))
+	for _, u := range users {
+		user := u.(*models.User)
+		if user.Team() != nil && user.Team().ID == teamID && user.ID() != excludeID {
+			members = append(members, user)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].ID() < members[j].ID() })
+
+	return members, nil
+}